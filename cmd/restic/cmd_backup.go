@@ -10,6 +10,7 @@ import (
 	"path"
 	"path/filepath"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -18,6 +19,7 @@ import (
 	"golang.org/x/sync/errgroup"
 
 	"github.com/chanhpng/vlbe/internal/archiver"
+	"github.com/chanhpng/vlbe/internal/backend"
 	"github.com/chanhpng/vlbe/internal/debug"
 	"github.com/chanhpng/vlbe/internal/errors"
 	"github.com/chanhpng/vlbe/internal/fs"
@@ -35,6 +37,58 @@ var cmdBackup = &cobra.Command{
 The "backup" command creates a new snapshot and saves the files and directories
 given as the arguments.
 
+With --checkpoint-interval, a partial snapshot of all files saved so far is
+stored periodically. If the backup is interrupted, the next backup of the same
+paths on the same host uses the last checkpoint as parent and does not read
+the files saved before again. Checkpoints are not shown by the other commands
+and are replaced by the snapshot once the backup has completed. Checkpoints of
+backups which were completed later on are removed by "prune". This includes
+checkpoints which the backup could not remove itself, e.g. because the
+credentials only allow to add files to the repository.
+
+With --changes-from, the paths which changed since the parent snapshot are read
+from a file, one path per line. The list can for example be written by a
+filesystem change journal or by "find -newer". Directories which contain no
+listed path are taken from the parent snapshot without reading them. A listed
+directory is read again, its unlisted subdirectories are still taken from the
+parent snapshot. The list must be complete, changes missing from it are not
+backed up. Without a parent snapshot, the list is ignored. The same applies if
+the --exclude patterns differ from those of the parent snapshot, as the
+directories taken from it would still reflect the old patterns, and when
+resuming from a checkpoint, whose unfinished directories are taken from an
+older snapshot than the one the list refers to. The other
+exclude options are not recorded in snapshots, do not use --changes-from for
+the first backup after changing them.
+
+With --use-fs-snapshot, the files are read from a snapshot of each filesystem
+containing a backup target, which is deleted once the backup has finished. On
+Windows, VSS snapshots are used. On Linux, btrfs, LVM and ZFS snapshots are
+supported and selected by the filesystem type. Only one of them is used with
+"-o fs-snapshot.provider=btrfs|lvm|zfs". btrfs snapshots do not contain nested
+subvolumes, those which are not mounted separately are read directly, e.g.
+/var/lib/docker. LVM snapshots are mounted
+read-only below "-o fs-snapshot.mount-dir" and use "-o fs-snapshot.lvm-size"
+(default: 10%ORIGIN) for changed data. Filesystems which cannot be snapshotted
+are read directly. The snapshot stores the original paths.
+
+With --read-special, the content of block devices, for example LVM volumes, is
+read and stored like a regular file, instead of only storing the device node.
+The device is read completely in each backup, unchanged data is deduplicated.
+Symbolic links are not followed, use the path of the device node itself, e.g.
+"/dev/dm-0". Independent of this option, holes in sparse files are detected
+on Linux, macOS and FreeBSD and stored without reading them. Use "restore
+--target /dev/..." to write a backed up file or device back to a block device.
+
+With --stdin-from-command, the arguments are executed as a command and its
+output is stored as the file given by --stdin-filename. To store the output of
+several commands, use "--stdin-from-command=name=command" once per command,
+e.g. "--stdin-from-command=pg.sql=pg_dumpall". The "=" after the option name is
+required, otherwise the arguments are interpreted as a single unnamed command.
+Each output is stored as a file with the given name in the virtual directory
+given by --stdin-filename, e.g. "/stdin/pg.sql", next to the files and
+directories given as arguments. This directory must not be the root directory.
+The snapshot is not created if any of the commands fails.
+
 EXIT STATUS
 ===========
 
@@ -76,20 +130,26 @@ type BackupOptions struct {
 	Stdin             bool
 	StdinFilename     string
 	StdinCommand      bool
+	StdinCommands     []string
 	Tags              restic.TagLists
 	Host              string
 	FilesFrom         []string
 	FilesFromVerbatim []string
 	FilesFromRaw      []string
+	ChangesFrom       string
 	TimeStamp         string
 	WithAtime         bool
 	IgnoreInode       bool
 	IgnoreCtime       bool
 	UseFsSnapshot     bool
+	ReadSpecial       bool
 	DryRun            bool
 	ReadConcurrency   uint
 	NoScan            bool
 	SkipIfUnchanged   bool
+	MetricsFile       string
+
+	CheckpointInterval time.Duration
 }
 
 var backupOptions BackupOptions
@@ -113,8 +173,10 @@ func init() {
 	f.BoolVar(&backupOptions.ExcludeCaches, "exclude-caches", false, `excludes cache directories that are marked with a CACHEDIR.TAG file. See https://bford.info/cachedir/ for the Cache Directory Tagging Standard`)
 	f.StringVar(&backupOptions.ExcludeLargerThan, "exclude-larger-than", "", "max `size` of the files to be backed up (allowed suffixes: k/K, m/M, g/G, t/T)")
 	f.BoolVar(&backupOptions.Stdin, "stdin", false, "read backup from stdin")
-	f.StringVar(&backupOptions.StdinFilename, "stdin-filename", "stdin", "`filename` to use when reading from stdin")
-	f.BoolVar(&backupOptions.StdinCommand, "stdin-from-command", false, "interpret arguments as command to execute and store its stdout")
+	f.StringVar(&backupOptions.StdinFilename, "stdin-filename", "stdin", "`filename` to use when reading from stdin, or directory for the output of named commands")
+	f.Var(&stdinCommandValue{enabled: &backupOptions.StdinCommand, named: &backupOptions.StdinCommands}, "stdin-from-command",
+		"interpret arguments as command to execute and store its stdout, or with a value of the form name=command, store the stdout of command as file name; the value must be attached with '=' as in --stdin-from-command=name=command (can be specified multiple times)")
+	f.Lookup("stdin-from-command").NoOptDefVal = "true"
 	f.Var(&backupOptions.Tags, "tag", "add `tags` for the new snapshot in the format `tag[,tag,...]` (can be specified multiple times)")
 	f.UintVar(&backupOptions.ReadConcurrency, "read-concurrency", 0, "read `n` files concurrently (default: $RESTIC_READ_CONCURRENCY or 2)")
 	f.StringVarP(&backupOptions.Host, "host", "H", "", "set the `hostname` for the snapshot manually (default: $RESTIC_HOST). To prevent an expensive rescan use the \"parent\" flag")
@@ -127,16 +189,20 @@ func init() {
 	f.StringArrayVar(&backupOptions.FilesFrom, "files-from", nil, "read the files to backup from `file` (can be combined with file args; can be specified multiple times)")
 	f.StringArrayVar(&backupOptions.FilesFromVerbatim, "files-from-verbatim", nil, "read the files to backup from `file` (can be combined with file args; can be specified multiple times)")
 	f.StringArrayVar(&backupOptions.FilesFromRaw, "files-from-raw", nil, "read the files to backup from `file` (can be combined with file args; can be specified multiple times)")
+	f.StringVar(&backupOptions.ChangesFrom, "changes-from", "", "read the paths which changed since the parent snapshot from `file`, directories without changes are not read")
 	f.StringVar(&backupOptions.TimeStamp, "time", "", "`time` of the backup (ex. '2012-11-01 22:08:41') (default: now)")
 	f.BoolVar(&backupOptions.WithAtime, "with-atime", false, "store the atime for all files and directories")
 	f.BoolVar(&backupOptions.IgnoreInode, "ignore-inode", false, "ignore inode number and ctime changes when checking for modified files")
 	f.BoolVar(&backupOptions.IgnoreCtime, "ignore-ctime", false, "ignore ctime changes when checking for modified files")
 	f.BoolVarP(&backupOptions.DryRun, "dry-run", "n", false, "do not upload or write any data, just show what would be done")
 	f.BoolVar(&backupOptions.NoScan, "no-scan", false, "do not run scanner to estimate size of backup")
-	if runtime.GOOS == "windows" {
-		f.BoolVar(&backupOptions.UseFsSnapshot, "use-fs-snapshot", false, "use filesystem snapshot where possible (currently only Windows VSS)")
+	if runtime.GOOS == "windows" || runtime.GOOS == "linux" {
+		f.BoolVar(&backupOptions.UseFsSnapshot, "use-fs-snapshot", false, "use filesystem snapshot where possible (Windows VSS, Linux btrfs, LVM or ZFS)")
 	}
+	f.BoolVar(&backupOptions.ReadSpecial, "read-special", false, "read the content of block devices and store them as regular files")
 	f.BoolVar(&backupOptions.SkipIfUnchanged, "skip-if-unchanged", false, "skip snapshot creation if identical to parent snapshot")
+	f.DurationVar(&backupOptions.CheckpointInterval, "checkpoint-interval", 0, "save a checkpoint of the backup every `duration`, an interrupted backup resumes from its last checkpoint (default: disabled)")
+	initMetricsFileFlag(f, &backupOptions.MetricsFile)
 
 	// parse read concurrency from env, on error the default value will be used
 	readConcurrency, _ := strconv.ParseUint(os.Getenv("RESTIC_READ_CONCURRENCY"), 10, 32)
@@ -259,6 +325,47 @@ func readFilenamesRaw(r io.Reader) (names []string, err error) {
 	}
 }
 
+// stdinCommandValue is the value of the --stdin-from-command flag. Without a
+// value, the arguments are executed as command. Each value of the form
+// "name=command" adds a named command, whose output is stored as a file.
+type stdinCommandValue struct {
+	enabled *bool
+	named   *[]string
+}
+
+func (v *stdinCommandValue) String() string {
+	if v.named != nil && len(*v.named) > 0 {
+		return strings.Join(*v.named, ",")
+	}
+	return strconv.FormatBool(v.enabled != nil && *v.enabled)
+}
+
+func (v *stdinCommandValue) Set(s string) error {
+	if enabled, err := strconv.ParseBool(s); err == nil {
+		*v.enabled = enabled
+		return nil
+	}
+
+	name, command, found := strings.Cut(s, "=")
+	if !found || name == "" || strings.TrimSpace(command) == "" {
+		return fmt.Errorf("invalid command %q, must be of the form name=command", s)
+	}
+	if strings.ContainsAny(name, `/\`) || name == "." || name == ".." {
+		return fmt.Errorf("invalid name %q for command %q", name, command)
+	}
+	for _, other := range *v.named {
+		if strings.HasPrefix(other, name+"=") {
+			return fmt.Errorf("duplicate command name %q", name)
+		}
+	}
+	*v.named = append(*v.named, s)
+	return nil
+}
+
+func (v *stdinCommandValue) Type() string {
+	return "name=command"
+}
+
 // Check returns an error when an invalid combination of options was set.
 func (opts BackupOptions) Check(gopts GlobalOptions, args []string) error {
 	if gopts.password == "" && !gopts.InsecureNoPassword {
@@ -266,7 +373,11 @@ func (opts BackupOptions) Check(gopts GlobalOptions, args []string) error {
 			return errors.Fatal("cannot read both password and data from stdin")
 		}
 
-		filesFrom := append(append(opts.FilesFrom, opts.FilesFromVerbatim...), opts.FilesFromRaw...)
+		// build a new slice to avoid modifying the backing array of opts.FilesFrom
+		filesFrom := append([]string(nil), opts.FilesFrom...)
+		filesFrom = append(filesFrom, opts.FilesFromVerbatim...)
+		filesFrom = append(filesFrom, opts.FilesFromRaw...)
+		filesFrom = append(filesFrom, opts.ChangesFrom)
 		for _, filename := range filesFrom {
 			if filename == "-" {
 				return errors.Fatal("unable to read password from stdin when data is to be read from stdin, use --password-file or $RESTIC_PASSWORD")
@@ -288,6 +399,31 @@ func (opts BackupOptions) Check(gopts GlobalOptions, args []string) error {
 		if len(args) > 0 && !opts.StdinCommand {
 			return errors.Fatal("--stdin was specified and files/dirs were listed as arguments")
 		}
+		if opts.CheckpointInterval != 0 {
+			return errors.Fatal("--stdin and --checkpoint-interval cannot be used together")
+		}
+		if opts.ChangesFrom != "" {
+			return errors.Fatal("--stdin and --changes-from cannot be used together")
+		}
+	}
+
+	if len(opts.StdinCommands) > 0 {
+		if opts.Stdin || opts.StdinCommand {
+			return errors.Fatal("named commands of --stdin-from-command cannot be combined with --stdin or an unnamed command")
+		}
+		if opts.ChangesFrom != "" {
+			return errors.Fatal("--stdin-from-command and --changes-from cannot be used together")
+		}
+		if path.Join("/", opts.StdinFilename) == "/" {
+			return errors.Fatal("--stdin-filename must name a directory for the output of named commands, not the root directory")
+		}
+	}
+	if opts.StdinCommand && len(args) > 0 && strings.Contains(args[0], "=") {
+		return errors.Fatalf("command %q contains '=', use --stdin-from-command=name=command to store the output of a named command", args[0])
+	}
+
+	if opts.CheckpointInterval < 0 {
+		return errors.Fatal("--checkpoint-interval must not be negative")
 	}
 
 	return nil
@@ -406,7 +542,7 @@ func collectTargets(opts BackupOptions, args []string) (targets []string, err er
 	// Merge args into files-from so we can reuse the normal args checks
 	// and have the ability to use both files-from and args at the same time.
 	targets = append(targets, args...)
-	if len(targets) == 0 && !opts.Stdin {
+	if len(targets) == 0 && !opts.Stdin && len(opts.StdinCommands) == 0 {
 		return nil, errors.Fatal("nothing to backup, please specify source files/dirs")
 	}
 
@@ -418,17 +554,64 @@ func collectTargets(opts BackupOptions, args []string) (targets []string, err er
 	return targets, nil
 }
 
+// startStdinCommands starts the named commands, each given in the form
+// "name=command". Their output is added as a file to dir.
+func startStdinCommands(ctx context.Context, dir *fs.ReaderDir, commands []string) error {
+	for _, command := range commands {
+		name, cmdline, _ := strings.Cut(command, "=")
+		args, err := backend.SplitShellStrings(cmdline)
+		if err != nil {
+			return errors.Fatalf("invalid command for %v: %v", name, err)
+		}
+		if len(args) == 0 {
+			return errors.Fatalf("empty command for %v", name)
+		}
+
+		rd, err := fs.NewCommandReader(ctx, args, globalOptions.stderr)
+		if err != nil {
+			return errors.Fatalf("command for %v: %v", name, err)
+		}
+		dir.Files[name] = rd
+	}
+	return nil
+}
+
+// readChangeSet reads the paths which changed since the parent snapshot from
+// the file, one path per line.
+func readChangeSet(filesys fs.FS, filename string) (*archiver.ChangeSet, error) {
+	paths, err := readLines(filename)
+	if err != nil {
+		return nil, err
+	}
+	return archiver.NewChangeSet(filesys, paths)
+}
+
+// excludesChanged reports whether the exclude patterns differ from those of the
+// parent snapshot, regardless of their order.
+func excludesChanged(excludes, parentExcludes []string) bool {
+	if len(excludes) != len(parentExcludes) {
+		return true
+	}
+	a := append([]string(nil), excludes...)
+	b := append([]string(nil), parentExcludes...)
+	sort.Strings(a)
+	sort.Strings(b)
+	for i := range a {
+		if a[i] != b[i] {
+			return true
+		}
+	}
+	return false
+}
+
 // parent returns the ID of the parent snapshot. If there is none, nil is
-// returned.
+// returned. If a backup of the targets was interrupted, its last checkpoint
+// is returned.
 func findParentSnapshot(ctx context.Context, repo restic.ListerLoaderUnpacked, opts BackupOptions, targets []string, timeStampLimit time.Time) (*restic.Snapshot, error) {
 	if opts.Force {
 		return nil, nil
 	}
 
-	snName := opts.Parent
-	if snName == "" {
-		snName = "latest"
-	}
 	f := restic.SnapshotFilter{TimestampLimit: timeStampLimit}
 	if opts.GroupBy.Host {
 		f.Hosts = []string{opts.Host}
@@ -440,23 +623,55 @@ func findParentSnapshot(ctx context.Context, repo restic.ListerLoaderUnpacked, o
 		f.Tags = []restic.TagList{opts.Tags.Flatten()}
 	}
 
-	sn, _, err := f.FindLatest(ctx, repo, repo, snName)
+	if opts.Parent != "" {
+		snapshotLister, err := restic.MemorizeList(ctx, repo, restic.SnapshotFile)
+		if err != nil {
+			return nil, err
+		}
+		sn, _, err := f.FindLatest(ctx, snapshotLister, repo, opts.Parent)
+		return sn, err
+	}
+
+	// the snapshots are loaded once to search for both checkpoints and the
+	// latest snapshot
+	var snapshots restic.Snapshots
+	err := restic.ForAllSnapshots(ctx, repo, repo, nil, func(id restic.ID, sn *restic.Snapshot, err error) error {
+		if err != nil {
+			return errors.Errorf("Error loading snapshot %v: %v", id.Str(), err)
+		}
+		snapshots = append(snapshots, sn)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if sn := restic.LatestCheckpoint(snapshots, opts.Host, targets); sn != nil {
+		return sn, nil
+	}
+
+	sn, err := f.FindLatestOf(snapshots)
 	// Snapshot not found is ok if no explicit parent was set
-	if opts.Parent == "" && errors.Is(err, restic.ErrNoSnapshotFound) {
+	if errors.Is(err, restic.ErrNoSnapshotFound) {
 		err = nil
 	}
 	return sn, err
 }
 
-func runBackup(ctx context.Context, opts BackupOptions, gopts GlobalOptions, term *termstatus.Terminal, args []string) error {
+func runBackup(ctx context.Context, opts BackupOptions, gopts GlobalOptions, term *termstatus.Terminal, args []string) (err error) {
 	var vsscfg fs.VSSConfig
-	var err error
+	var snapshotcfg fs.SnapshotConfig
 
 	if runtime.GOOS == "windows" {
 		if vsscfg, err = fs.ParseVSSConfig(gopts.extended); err != nil {
 			return err
 		}
 	}
+	if runtime.GOOS == "linux" {
+		if snapshotcfg, err = fs.ParseSnapshotConfig(gopts.extended); err != nil {
+			return err
+		}
+	}
 
 	err = opts.Check(gopts, args)
 	if err != nil {
@@ -470,6 +685,16 @@ func runBackup(ctx context.Context, opts BackupOptions, gopts GlobalOptions, ter
 
 	timeStamp := time.Now()
 	backupStart := timeStamp
+	var summary *archiver.Summary
+	var errorCount uint
+	if opts.MetricsFile != "" && !opts.DryRun {
+		defer func() {
+			// a snapshot is also saved if some files could not be read
+			completed := err == nil || errors.Is(err, ErrInvalidSourceData)
+			saveMetricsFile(opts.MetricsFile, newBackupMetrics(opts.Host, targets, backupStart, summary, errorCount, completed))
+		}()
+	}
+
 	if opts.TimeStamp != "" {
 		timeStamp, err = time.ParseInLocation(TimeFormat, opts.TimeStamp, time.Local)
 		if err != nil {
@@ -487,6 +712,12 @@ func runBackup(ctx context.Context, opts BackupOptions, gopts GlobalOptions, ter
 	}
 	defer unlock()
 
+	if !opts.DryRun {
+		if err := repo.CheckPermission(repository.PermWrite); err != nil {
+			return err
+		}
+	}
+
 	var progressPrinter backup.ProgressPrinter
 	if gopts.JSON {
 		progressPrinter = backup.NewJSONProgress(term, gopts.verbosity)
@@ -517,7 +748,9 @@ func runBackup(ctx context.Context, opts BackupOptions, gopts GlobalOptions, ter
 		}
 
 		if !gopts.JSON {
-			if parentSnapshot != nil {
+			if parentSnapshot != nil && parentSnapshot.Checkpoint {
+				progressPrinter.P("resuming from checkpoint %v\n", parentSnapshot.ID().Str())
+			} else if parentSnapshot != nil {
 				progressPrinter.P("using parent snapshot %v\n", parentSnapshot.ID().Str())
 			} else {
 				progressPrinter.P("no parent snapshot found, will read all files\n")
@@ -555,9 +788,11 @@ func runBackup(ctx context.Context, opts BackupOptions, gopts GlobalOptions, ter
 	}
 
 	var targetFS fs.FS = fs.Local{}
-	if runtime.GOOS == "windows" && opts.UseFsSnapshot {
-		if err = fs.HasSufficientPrivilegesForVSS(); err != nil {
-			return err
+	if (runtime.GOOS == "windows" || runtime.GOOS == "linux") && opts.UseFsSnapshot {
+		if runtime.GOOS == "windows" {
+			if err = fs.HasSufficientPrivilegesForVSS(); err != nil {
+				return err
+			}
 		}
 
 		errorHandler := func(item string, err error) {
@@ -570,9 +805,15 @@ func runBackup(ctx context.Context, opts BackupOptions, gopts GlobalOptions, ter
 			}
 		}
 
-		localVss := fs.NewLocalVss(errorHandler, messageHandler, vsscfg)
-		defer localVss.DeleteSnapshots()
-		targetFS = localVss
+		if runtime.GOOS == "windows" {
+			localVss := fs.NewLocalVss(errorHandler, messageHandler, vsscfg)
+			defer localVss.DeleteSnapshots()
+			targetFS = localVss
+		} else {
+			localSnapshot := fs.NewLocalSnapshot(errorHandler, messageHandler, snapshotcfg)
+			defer localSnapshot.DeleteSnapshots()
+			targetFS = localSnapshot
+		}
 	}
 
 	if opts.Stdin || opts.StdinCommand {
@@ -596,6 +837,47 @@ func runBackup(ctx context.Context, opts BackupOptions, gopts GlobalOptions, ter
 		targets = []string{filename}
 	}
 
+	if len(opts.StdinCommands) > 0 {
+		if !gopts.JSON {
+			progressPrinter.V("read data from %d commands", len(opts.StdinCommands))
+		}
+		commandCtx, cancelCommands := context.WithCancel(ctx)
+		readerDir := &fs.ReaderDir{
+			FS:      targetFS,
+			Path:    path.Join("/", opts.StdinFilename),
+			Files:   make(map[string]io.ReadCloser),
+			ModTime: timeStamp,
+		}
+		// stop the commands whose output was not read, e.g. due to an exclude
+		defer func() {
+			cancelCommands()
+			_ = readerDir.Close()
+		}()
+
+		err = startStdinCommands(commandCtx, readerDir, opts.StdinCommands)
+		if err != nil {
+			return err
+		}
+		targetFS = readerDir
+		targets = append(targets, readerDir.Path)
+	}
+
+	var changes *archiver.ChangeSet
+	if opts.ChangesFrom != "" && parentSnapshot != nil {
+		if parentSnapshot.Checkpoint {
+			// the unfinished directories of a checkpoint are taken from an
+			// older snapshot, changes made before the checkpoint would be lost
+			Warnf("resuming from a checkpoint, ignoring --changes-from\n")
+		} else if excludesChanged(opts.Excludes, parentSnapshot.Excludes) {
+			Warnf("exclude patterns differ from the parent snapshot, ignoring --changes-from\n")
+		} else {
+			changes, err = readChangeSet(targetFS, opts.ChangesFrom)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
 	wg, wgCtx := errgroup.WithContext(ctx)
 	cancelCtx, cancel := context.WithCancel(wgCtx)
 	defer cancel()
@@ -604,6 +886,7 @@ func runBackup(ctx context.Context, opts BackupOptions, gopts GlobalOptions, ter
 		sc := archiver.NewScanner(targetFS)
 		sc.SelectByName = selectByNameFilter
 		sc.Select = selectFilter
+		sc.Changes = changes
 		sc.Error = progressPrinter.ScannerError
 		sc.Result = progressReporter.ReportTotal
 
@@ -613,13 +896,15 @@ func runBackup(ctx context.Context, opts BackupOptions, gopts GlobalOptions, ter
 		wg.Go(func() error { return sc.Scan(cancelCtx, targets) })
 	}
 
-	arch := archiver.New(repo, targetFS, archiver.Options{ReadConcurrency: opts.ReadConcurrency})
+	arch := archiver.New(repo, targetFS, archiver.Options{ReadConcurrency: opts.ReadConcurrency, ReadSpecial: opts.ReadSpecial})
 	arch.SelectByName = selectByNameFilter
 	arch.Select = selectFilter
+	arch.Changes = changes
 	arch.WithAtime = opts.WithAtime
 	success := true
 	arch.Error = func(item string, err error) error {
 		success = false
+		errorCount++
 		reterr := progressReporter.Error(item, err)
 		// If we receive a fatal error during the execution of the snapshot,
 		// we abort the snapshot.
@@ -631,6 +916,7 @@ func runBackup(ctx context.Context, opts BackupOptions, gopts GlobalOptions, ter
 	arch.CompleteItem = progressReporter.CompleteItem
 	arch.StartFile = progressReporter.StartFile
 	arch.CompleteBlob = progressReporter.CompleteBlob
+	arch.Warn = Warnf
 
 	if opts.IgnoreInode {
 		// --ignore-inode implies --ignore-ctime: on FUSE, the ctime is not
@@ -651,6 +937,9 @@ func runBackup(ctx context.Context, opts BackupOptions, gopts GlobalOptions, ter
 		ProgramVersion:  "restic " + version,
 		SkipIfUnchanged: opts.SkipIfUnchanged,
 	}
+	if !opts.DryRun {
+		snapshotOpts.CheckpointInterval = opts.CheckpointInterval
+	}
 
 	if !gopts.JSON {
 		progressPrinter.V("start backup on %v", targets)
@@ -677,3 +966,34 @@ func runBackup(ctx context.Context, opts BackupOptions, gopts GlobalOptions, ter
 	// Return error if any
 	return werr
 }
+
+// newBackupMetrics returns the metrics describing a backup run.
+func newBackupMetrics(host string, targets []string, start time.Time, summary *archiver.Summary, errorCount uint, completed bool) *metricSet {
+	paths := make([]string, 0, len(targets))
+	for _, target := range targets {
+		if abs, err := filepath.Abs(target); err == nil {
+			target = abs
+		}
+		paths = append(paths, target)
+	}
+	sort.Strings(paths)
+	labels := []string{"host", host, "paths", strings.Join(paths, ",")}
+
+	m := newMetricSet()
+	m.Timestamp("restic_backup_start_timestamp_seconds", "Start time of the backup", start, labels...)
+	m.Gauge("restic_backup_duration_seconds", "Duration of the backup", time.Since(start).Seconds(), labels...)
+	m.Gauge("restic_backup_success", "Whether the backup completed without errors", boolMetric(completed && errorCount == 0), labels...)
+	m.Gauge("restic_backup_errors", "Number of files or directories which could not be read", float64(errorCount), labels...)
+	if completed && summary != nil {
+		m.Gauge("restic_backup_files", "Number of files by change status", float64(summary.Files.New), append(labels, "state", "new")...)
+		m.Gauge("restic_backup_files", "Number of files by change status", float64(summary.Files.Changed), append(labels, "state", "changed")...)
+		m.Gauge("restic_backup_files", "Number of files by change status", float64(summary.Files.Unchanged), append(labels, "state", "unmodified")...)
+		m.Gauge("restic_backup_dirs", "Number of directories by change status", float64(summary.Dirs.New), append(labels, "state", "new")...)
+		m.Gauge("restic_backup_dirs", "Number of directories by change status", float64(summary.Dirs.Changed), append(labels, "state", "changed")...)
+		m.Gauge("restic_backup_dirs", "Number of directories by change status", float64(summary.Dirs.Unchanged), append(labels, "state", "unmodified")...)
+		m.Gauge("restic_backup_processed_bytes", "Size of the processed files", float64(summary.ProcessedBytes), labels...)
+		m.Gauge("restic_backup_data_added_bytes", "Uncompressed data added to the repository", float64(summary.ItemStats.DataSize+summary.ItemStats.TreeSize), labels...)
+		m.Gauge("restic_backup_data_added_packed_bytes", "Stored data added to the repository", float64(summary.ItemStats.DataSizeInRepo+summary.ItemStats.TreeSizeInRepo), labels...)
+	}
+	return m
+}
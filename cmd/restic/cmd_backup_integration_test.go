@@ -7,8 +7,11 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/chanhpng/vlbe/internal/backend/location"
 	"github.com/chanhpng/vlbe/internal/fs"
 	"github.com/chanhpng/vlbe/internal/restic"
 	rtest "github.com/chanhpng/vlbe/internal/test"
@@ -628,6 +631,50 @@ func TestStdinFromCommandFailNoOutputAndExitCode(t *testing.T) {
 	testRunCheck(t, env.gopts)
 }
 
+func TestStdinFromNamedCommands(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testSetupBackupData(t, env)
+	opts := BackupOptions{
+		StdinCommands: []string{
+			`first=python -c "print('first')"`,
+			`second=python -c "print('second')"`,
+		},
+		StdinFilename: "dumps",
+	}
+
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, opts, env.gopts)
+	snapshotIDs := testListSnapshots(t, env.gopts, 1)
+
+	restoredir := filepath.Join(env.base, "restore")
+	testRunRestore(t, env.gopts, restoredir, snapshotIDs[0])
+	for _, name := range []string{"first", "second"} {
+		data, err := os.ReadFile(filepath.Join(restoredir, "dumps", name))
+		rtest.OK(t, err)
+		rtest.Equals(t, name, strings.TrimSpace(string(data)))
+	}
+	_, err := os.Stat(filepath.Join(restoredir, "testdata"))
+	rtest.OK(t, err)
+
+	// the snapshot is not saved if one of the commands fails
+	opts.StdinCommands = append(opts.StdinCommands, `third=python -c "import sys; print('third'); sys.exit(1)"`)
+	err = testRunBackupAssumeFailure(t, filepath.Dir(env.testdata), []string{"testdata"}, opts, env.gopts)
+	rtest.Assert(t, err != nil, "Expected error while backing up")
+	testListSnapshots(t, env.gopts, 1)
+
+	// the output cannot be stored in the root directory
+	opts.StdinCommands = opts.StdinCommands[:2]
+	for _, filename := range []string{"", "/", "//"} {
+		opts.StdinFilename = filename
+		err = testRunBackupAssumeFailure(t, filepath.Dir(env.testdata), []string{"testdata"}, opts, env.gopts)
+		rtest.Assert(t, err != nil, "backup with --stdin-filename %q did not fail", filename)
+	}
+	testListSnapshots(t, env.gopts, 1)
+
+	testRunCheck(t, env.gopts)
+}
+
 func TestBackupEmptyPassword(t *testing.T) {
 	// basic sanity test that empty passwords work
 	env, cleanup := withTestEnvironment(t)
@@ -656,3 +703,102 @@ func TestBackupSkipIfUnchanged(t *testing.T) {
 
 	testRunCheck(t, env.gopts)
 }
+
+func TestBackupMirror(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	repos := []string{filepath.Join(env.base, "mirror-a"), filepath.Join(env.base, "mirror-b")}
+	env.gopts.Repo = "mirror:" + strings.Join(repos, location.MirrorSeparator)
+
+	testSetupBackupData(t, env)
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, BackupOptions{}, env.gopts)
+	testListSnapshots(t, env.gopts, 1)
+	testRunCheck(t, env.gopts)
+
+	// both mirrored repositories must be complete
+	for _, repo := range repos {
+		gopts := env.gopts
+		gopts.Repo = repo
+		testListSnapshots(t, gopts, 1)
+		testRunCheck(t, gopts)
+	}
+
+	// files missing in one repository are read from the other one
+	rtest.OK(t, os.RemoveAll(filepath.Join(repos[0], "snapshots")))
+	testListSnapshots(t, env.gopts, 1)
+}
+
+func testSaveCheckpoint(t testing.TB, gopts GlobalOptions, id restic.ID, offset time.Duration) restic.ID {
+	ctx, repo, unlock, err := openWithAppendLock(context.TODO(), gopts, false)
+	rtest.OK(t, err)
+	defer unlock()
+
+	sn, err := restic.LoadSnapshot(ctx, repo, id)
+	rtest.OK(t, err)
+	sn.Time = sn.Time.Add(offset)
+	sn.Parent = &id
+	sn.Checkpoint = true
+	checkpointID, err := restic.SaveSnapshot(ctx, repo, sn)
+	rtest.OK(t, err)
+	return checkpointID
+}
+
+func TestBackupCheckpoint(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testSetupBackupData(t, env)
+	opts := BackupOptions{CheckpointInterval: time.Minute}
+
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, opts, env.gopts)
+	firstID := testListSnapshots(t, env.gopts, 1)[0]
+
+	// checkpoints are hidden
+	checkpointID := testSaveCheckpoint(t, env.gopts, firstID, time.Minute)
+	testListSnapshots(t, env.gopts, 2)
+	_, snapshots := testRunSnapshots(t, env.gopts)
+	rtest.Equals(t, 1, len(snapshots))
+
+	// the backup resumes from the checkpoint and replaces it
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, opts, env.gopts)
+	ids := restic.NewIDSet(testListSnapshots(t, env.gopts, 2)...)
+	rtest.Assert(t, !ids.Has(checkpointID), "checkpoint %v was not removed", checkpointID)
+	latest, _ := testRunSnapshots(t, env.gopts)
+	rtest.Assert(t, latest.Parent != nil && latest.Parent.Equal(firstID), "unexpected parent %v instead of %v", latest.Parent, firstID)
+
+	// prune removes checkpoints of completed backups only
+	stale := testSaveCheckpoint(t, env.gopts, firstID, -time.Second)
+	pending := testSaveCheckpoint(t, env.gopts, *latest.ID, time.Minute)
+	testRunPrune(t, env.gopts, PruneOptions{MaxUnused: "5%"})
+	ids = restic.NewIDSet(testListSnapshots(t, env.gopts, 3)...)
+	rtest.Assert(t, !ids.Has(stale), "stale checkpoint %v was not removed", stale)
+	rtest.Assert(t, ids.Has(pending), "checkpoint %v was removed", pending)
+	testRunCheck(t, env.gopts)
+}
+
+func TestBackupChangesFromCheckpoint(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testSetupBackupData(t, env)
+	changed := filepath.Join(env.testdata, "changed", "file")
+	rtest.OK(t, os.MkdirAll(filepath.Dir(changed), 0o700))
+	rtest.OK(t, os.WriteFile(changed, []byte("before"), 0o600))
+
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, BackupOptions{}, env.gopts)
+	firstID := testListSnapshots(t, env.gopts, 1)[0]
+	first, _ := testRunSnapshots(t, env.gopts)
+
+	// the file is modified before the interrupted backup, so that the change
+	// list written afterwards does not contain it
+	rtest.OK(t, os.WriteFile(changed, []byte("modified"), 0o600))
+	testSaveCheckpoint(t, env.gopts, firstID, time.Minute)
+	changesFrom := filepath.Join(env.base, "changes")
+	rtest.OK(t, os.WriteFile(changesFrom, nil, 0o600))
+
+	// the change list is ignored when resuming from the checkpoint
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, BackupOptions{ChangesFrom: changesFrom}, env.gopts)
+	latest, _ := testRunSnapshots(t, env.gopts)
+	rtest.Assert(t, !latest.Tree.Equal(*first.Tree), "modified file was not backed up")
+}
@@ -110,3 +110,29 @@ func TestReadFilenamesRaw(t *testing.T) {
 	rtest.Assert(t, strings.Contains(err.Error(), "zero byte"),
 		"wrong error message: %v", err.Error())
 }
+
+func TestExcludesChanged(t *testing.T) {
+	for _, test := range []struct {
+		excludes, parent []string
+		changed          bool
+	}{
+		{nil, nil, false},
+		{[]string{"*.tmp", "/cache"}, []string{"/cache", "*.tmp"}, false},
+		{[]string{"*.tmp"}, nil, true},
+		{nil, []string{"*.tmp"}, true},
+		{[]string{"*.tmp"}, []string{"*.bak"}, true},
+	} {
+		rtest.Equals(t, test.changed, excludesChanged(test.excludes, test.parent))
+	}
+}
+
+func TestBackupOptionsCheckFilesFrom(t *testing.T) {
+	filesFrom := make([]string, 1, 3)
+	filesFrom[0] = "files"
+	opts := BackupOptions{FilesFrom: filesFrom, ChangesFrom: "-"}
+
+	err := opts.Check(GlobalOptions{}, nil)
+	rtest.Assert(t, err != nil, "expected error for reading both password and changes from stdin")
+	// the spare capacity of the slice passed in must not be modified
+	rtest.Equals(t, []string{"files", "", ""}, filesFrom[:3])
+}
@@ -57,6 +57,7 @@ type CheckOptions struct {
 	ReadDataSubset string
 	CheckUnused    bool
 	WithCache      bool
+	MetricsFile    string
 }
 
 var checkOptions CheckOptions
@@ -75,6 +76,7 @@ func init() {
 		panic(err)
 	}
 	f.BoolVar(&checkOptions.WithCache, "with-cache", false, "use existing cache, only read uncached data from repository")
+	initMetricsFileFlag(f, &checkOptions.MetricsFile)
 }
 
 func checkFlags(opts CheckOptions) error {
@@ -209,11 +211,20 @@ func prepareCheckCache(opts CheckOptions, gopts *GlobalOptions, printer progress
 	return cleanup
 }
 
-func runCheck(ctx context.Context, opts CheckOptions, gopts GlobalOptions, args []string, term *termstatus.Terminal) error {
+func runCheck(ctx context.Context, opts CheckOptions, gopts GlobalOptions, args []string, term *termstatus.Terminal) (err error) {
 	if len(args) != 0 {
 		return errors.Fatal("the check command expects no arguments, only options - please see `restic help check` for usage and flags")
 	}
 
+	start := time.Now()
+	errorCount := 0
+	orphanedPacks := 0
+	if opts.MetricsFile != "" {
+		defer func() {
+			saveMetricsFile(opts.MetricsFile, newCheckMetrics(start, errorCount, orphanedPacks, err == nil))
+		}()
+	}
+
 	printer := newTerminalProgressPrinter(gopts.verbosity, term)
 
 	cleanup := prepareCheckCache(opts, &gopts, printer)
@@ -241,10 +252,10 @@ func runCheck(ctx context.Context, opts CheckOptions, gopts GlobalOptions, args
 		return ctx.Err()
 	}
 
-	errorsFound := false
 	suggestIndexRebuild := false
 	suggestLegacyIndexRebuild := false
 	mixedFound := false
+	compressedFound := false
 	for _, hint := range hints {
 		switch hint.(type) {
 		case *checker.ErrDuplicatePacks:
@@ -253,13 +264,17 @@ func runCheck(ctx context.Context, opts CheckOptions, gopts GlobalOptions, args
 		case *checker.ErrOldIndexFormat:
 			printer.E("error: %v\n", hint)
 			suggestLegacyIndexRebuild = true
-			errorsFound = true
+			errorCount++
 		case *checker.ErrMixedPack:
 			term.Print(hint.Error())
 			mixedFound = true
+		case *checker.ErrCompressedPack:
+			printer.E("error: %v\n", hint)
+			compressedFound = true
+			errorCount++
 		default:
 			printer.E("error: %v\n", hint)
-			errorsFound = true
+			errorCount++
 		}
 	}
 
@@ -272,8 +287,12 @@ func runCheck(ctx context.Context, opts CheckOptions, gopts GlobalOptions, args
 	if mixedFound {
 		term.Print("Mixed packs with tree and data blobs are non-critical, you can run `restic prune` to correct this.\n")
 	}
+	if compressedFound {
+		printer.E("error: Found compressed data in a repository using format version 1, run `restic migrate upgrade_repo_v2` to upgrade the repository.\n")
+	}
 
 	if len(errs) > 0 {
+		errorCount += len(errs)
 		for _, err := range errs {
 			printer.E("error: %v\n", err)
 		}
@@ -282,7 +301,6 @@ func runCheck(ctx context.Context, opts CheckOptions, gopts GlobalOptions, args
 		return errors.Fatal("repository contains errors")
 	}
 
-	orphanedPacks := 0
 	errChan := make(chan error)
 	salvagePacks := restic.NewIDSet()
 
@@ -299,19 +317,19 @@ func runCheck(ctx context.Context, opts CheckOptions, gopts GlobalOptions, args
 				if packErr.Truncated {
 					salvagePacks.Insert(packErr.ID)
 				}
-				errorsFound = true
+				errorCount++
 				printer.E("%v\n", err)
 			}
 		} else if err == checker.ErrLegacyLayout {
-			errorsFound = true
+			errorCount++
 			printer.E("error: repository still uses the S3 legacy layout\nYou must run `restic migrate s3legacy` to correct this.\n")
 		} else {
-			errorsFound = true
+			errorCount++
 			printer.E("%v\n", err)
 		}
 	}
 
-	if orphanedPacks > 0 && !errorsFound {
+	if orphanedPacks > 0 && errorCount == 0 {
 		// hide notice if repository is damaged
 		printer.P("%d additional files were found in the repo, which likely contain duplicate data.\nThis is non-critical, you can run `restic prune` to correct this.\n", orphanedPacks)
 	}
@@ -332,7 +350,7 @@ func runCheck(ctx context.Context, opts CheckOptions, gopts GlobalOptions, args
 	}()
 
 	for err := range errChan {
-		errorsFound = true
+		errorCount++
 		if e, ok := err.(*checker.TreeError); ok {
 			printer.E("error for tree %v:\n", e.ID.Str())
 			for _, treeErr := range e.Errors {
@@ -358,7 +376,7 @@ func runCheck(ctx context.Context, opts CheckOptions, gopts GlobalOptions, args
 		}
 		for _, id := range unused {
 			printer.P("unused blob %v\n", id)
-			errorsFound = true
+			errorCount++
 		}
 	}
 
@@ -371,7 +389,7 @@ func runCheck(ctx context.Context, opts CheckOptions, gopts GlobalOptions, args
 		go chkr.ReadPacks(ctx, packs, p, errChan)
 
 		for err := range errChan {
-			errorsFound = true
+			errorCount++
 			printer.E("%v\n", err)
 			if err, ok := err.(*repository.ErrPackData); ok {
 				salvagePacks.Insert(err.PackID)
@@ -435,7 +453,7 @@ func runCheck(ctx context.Context, opts CheckOptions, gopts GlobalOptions, args
 		return ctx.Err()
 	}
 
-	if errorsFound {
+	if errorCount > 0 {
 		if len(salvagePacks) == 0 {
 			printer.E("\nThe repository is damaged and must be repaired. Please follow the troubleshooting guide at https://restic.readthedocs.io/en/stable/077_troubleshooting.html .\n\n")
 		}
@@ -446,6 +464,17 @@ func runCheck(ctx context.Context, opts CheckOptions, gopts GlobalOptions, args
 	return nil
 }
 
+// newCheckMetrics returns the metrics describing a check run.
+func newCheckMetrics(start time.Time, errorCount, orphanedPacks int, success bool) *metricSet {
+	m := newMetricSet()
+	m.Timestamp("restic_check_start_timestamp_seconds", "Start time of the check", start)
+	m.Gauge("restic_check_duration_seconds", "Duration of the check", time.Since(start).Seconds())
+	m.Gauge("restic_check_success", "Whether the check found no errors", boolMetric(success))
+	m.Gauge("restic_check_errors", "Number of errors found by the check", float64(errorCount))
+	m.Gauge("restic_check_orphaned_packs", "Number of pack files not referenced by the index", float64(orphanedPacks))
+	return m
+}
+
 // selectPacksByBucket selects subsets of packs by ranges of buckets.
 func selectPacksByBucket(allPacks map[restic.ID]int64, bucket, totalBuckets uint) map[restic.ID]int64 {
 	packs := make(map[restic.ID]int64)
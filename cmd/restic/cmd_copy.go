@@ -29,7 +29,9 @@ between the files copied and files already stored in the destination repository.
 This means that copied files, which existed in both the source and destination
 repository, /may occupy up to twice their space/ in the destination repository.
 This can be mitigated by the "--copy-chunker-params" option when initializing a
-new destination repository using the "init" command.
+new destination repository using the "init" command. If the repositories use
+different chunk sizes, a warning is printed. In this case all-zero regions of
+copied files are not restored as holes from the destination repository.
 
 EXIT STATUS
 ===========
@@ -82,6 +84,19 @@ func runCopy(ctx context.Context, opts CopyOptions, gopts GlobalOptions, args []
 	}
 	defer unlock()
 
+	if err := srcRepo.CheckPermission(repository.PermRead); err != nil {
+		return err
+	}
+	if err := dstRepo.CheckPermission(repository.PermWrite); err != nil {
+		return err
+	}
+
+	srcChunker, dstChunker := srcRepo.Config().ChunkerParams(), dstRepo.Config().ChunkerParams()
+	if srcChunker.MinSize != dstChunker.MinSize || srcChunker.AvgSize != dstChunker.AvgSize || srcChunker.MaxSize != dstChunker.MaxSize {
+		Warnf("warning: the chunk sizes of the source (%d/%d/%d) and destination (%d/%d/%d) repository differ, copied files will not deduplicate with new backups\n",
+			srcChunker.MinSize, srcChunker.AvgSize, srcChunker.MaxSize, dstChunker.MinSize, dstChunker.AvgSize, dstChunker.MaxSize)
+	}
+
 	srcSnapshotLister, err := restic.MemorizeList(ctx, srcRepo, restic.SnapshotFile)
 	if err != nil {
 		return err
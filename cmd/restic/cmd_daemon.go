@@ -0,0 +1,500 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/chanhpng/vlbe/internal/errors"
+	"github.com/chanhpng/vlbe/internal/restic"
+	"github.com/chanhpng/vlbe/internal/ui/termstatus"
+)
+
+var cmdDaemon = &cobra.Command{
+	Use:   "daemon --config file [flags]",
+	Short: "Run scheduled backup jobs",
+	Long: `
+The "daemon" command runs the backup jobs defined in a YAML configuration file
+on schedule. Each job creates a backup of the listed paths, optionally applies
+a retention policy using "forget" (and "prune"), and optionally checks the
+repository. The jobs are executed one at a time using the repository and
+global options passed to the daemon. Use "--retry-lock" to wait for locks held
+by other processes.
+
+The configuration file is read the same way as the profile file and has the
+following structure:
+
+    jobs:
+      - name: home
+        interval: 24h
+        paths: [/home]
+        excludes: ["*.tmp"]
+        exclude-files: [/etc/restic/excludes]
+        tags: [daily]
+        host: ""
+        retry-lock: 30m
+        retention:
+          last: 3
+          daily: 7
+          weekly: 4
+          monthly: 12
+          within: ""
+          tags: [keep]
+          prune: true
+        check:
+          interval: 168h
+          read-data-subset: 5%
+
+The retention policy additionally accepts "hourly", "yearly" as well as
+"within-hourly", "within-daily", "within-weekly", "within-monthly" and
+"within-yearly", which correspond to the options of "forget".
+
+Intervals are durations like "30m" or "24h". The retention policy only applies
+to snapshots with the host, paths and tags of the job. After each run, the
+status of the job is written to "<name>.json" in the status directory, which
+defaults to the directory containing the configuration file. The status files
+are also used to determine when jobs are due after restarting the daemon.
+
+With "--once", all jobs are run once immediately and the daemon exits
+afterwards.
+
+EXIT STATUS
+===========
+
+Exit status is 0 if the command was successful.
+Exit status is 1 if there was any error.
+Exit status is 10 if the repository does not exist.
+`,
+	DisableAutoGenTag: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		term, cancel := setupTermstatus()
+		defer cancel()
+		return runDaemon(cmd.Context(), daemonOptions, globalOptions, term, args)
+	},
+}
+
+// DaemonOptions bundles all options for the daemon command.
+type DaemonOptions struct {
+	ConfigFile string
+	StatusDir  string
+	Once       bool
+}
+
+var daemonOptions DaemonOptions
+
+func init() {
+	cmdRoot.AddCommand(cmdDaemon)
+
+	f := cmdDaemon.Flags()
+	f.StringVar(&daemonOptions.ConfigFile, "config", "", "read the job definitions from `file`")
+	f.StringVar(&daemonOptions.StatusDir, "status-dir", "", "write job status files to `dir` (default: directory of the config file)")
+	f.BoolVar(&daemonOptions.Once, "once", false, "run all jobs once and exit")
+}
+
+// daemonConfig is the content of the daemon configuration file, it is
+// read using the same loader as the profile file.
+type daemonConfig struct {
+	Jobs []daemonJob `yaml:"jobs"`
+}
+
+type daemonJob struct {
+	Name         string           `yaml:"name"`
+	Interval     string           `yaml:"interval"`
+	Paths        []string         `yaml:"paths"`
+	Excludes     []string         `yaml:"excludes"`
+	ExcludeFiles []string         `yaml:"exclude-files"`
+	Tags         []string         `yaml:"tags"`
+	Host         string           `yaml:"host"`
+	RetryLock    string           `yaml:"retry-lock"`
+	Retention    *daemonRetention `yaml:"retention"`
+	Check        *daemonCheck     `yaml:"check"`
+
+	interval  time.Duration
+	retryLock time.Duration
+}
+
+type daemonRetention struct {
+	Last          int      `yaml:"last"`
+	Hourly        int      `yaml:"hourly"`
+	Daily         int      `yaml:"daily"`
+	Weekly        int      `yaml:"weekly"`
+	Monthly       int      `yaml:"monthly"`
+	Yearly        int      `yaml:"yearly"`
+	Within        string   `yaml:"within"`
+	WithinHourly  string   `yaml:"within-hourly"`
+	WithinDaily   string   `yaml:"within-daily"`
+	WithinWeekly  string   `yaml:"within-weekly"`
+	WithinMonthly string   `yaml:"within-monthly"`
+	WithinYearly  string   `yaml:"within-yearly"`
+	Tags          []string `yaml:"tags"`
+	Prune         bool     `yaml:"prune"`
+
+	policy restic.ExpirePolicy
+}
+
+type daemonCheck struct {
+	Interval       string `yaml:"interval"`
+	ReadDataSubset string `yaml:"read-data-subset"`
+
+	interval time.Duration
+}
+
+// daemonJobStatus is written to the status file of a job after each run.
+type daemonJobStatus struct {
+	Job          string    `json:"job"`
+	LastRunStart time.Time `json:"last_run_start"`
+	LastRunEnd   time.Time `json:"last_run_end"`
+	Success      bool      `json:"success"`
+	Error        string    `json:"error,omitempty"`
+	LastSuccess  time.Time `json:"last_success,omitempty"`
+	LastCheck    time.Time `json:"last_check,omitempty"`
+	NextRun      time.Time `json:"next_run"`
+}
+
+var daemonJobName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+func loadDaemonConfig(filename string) (*daemonConfig, error) {
+	var cfg daemonConfig
+	if err := loadYAMLFile(filename, "daemon config", &cfg); err != nil {
+		return nil, err
+	}
+
+	if len(cfg.Jobs) == 0 {
+		return nil, errors.Fatalf("daemon config %v contains no jobs", filename)
+	}
+
+	names := make(map[string]struct{})
+	for i := range cfg.Jobs {
+		job := &cfg.Jobs[i]
+		if err := job.prepare(); err != nil {
+			return nil, errors.Fatalf("invalid job %q in %v: %v", job.Name, filename, err)
+		}
+		if _, ok := names[job.Name]; ok {
+			return nil, errors.Fatalf("duplicate job name %q in %v", job.Name, filename)
+		}
+		names[job.Name] = struct{}{}
+	}
+
+	return &cfg, nil
+}
+
+// prepare validates the job and parses its durations.
+func (job *daemonJob) prepare() error {
+	if !daemonJobName.MatchString(job.Name) {
+		return errors.New("name must only contain letters, digits, '.', '_' and '-'")
+	}
+	if len(job.Paths) == 0 {
+		return errors.New("no paths specified")
+	}
+
+	var err error
+	job.interval, err = time.ParseDuration(job.Interval)
+	if err != nil || job.interval <= 0 {
+		return errors.Errorf("invalid interval %q", job.Interval)
+	}
+
+	if job.RetryLock != "" {
+		job.retryLock, err = time.ParseDuration(job.RetryLock)
+		if err != nil || job.retryLock < 0 {
+			return errors.Errorf("invalid retry-lock %q", job.RetryLock)
+		}
+	}
+
+	if job.Retention != nil {
+		if err := job.Retention.prepare(); err != nil {
+			return err
+		}
+	}
+
+	if job.Check != nil {
+		job.Check.interval, err = time.ParseDuration(job.Check.Interval)
+		if err != nil || job.Check.interval < 0 {
+			return errors.Errorf("invalid check interval %q", job.Check.Interval)
+		}
+		if err := checkFlags(job.Check.checkOptions()); err != nil {
+			return errors.Errorf("invalid read-data-subset %q: %v", job.Check.ReadDataSubset, err)
+		}
+	}
+
+	return nil
+}
+
+func (r *daemonRetention) prepare() error {
+	r.policy = restic.ExpirePolicy{
+		Last:    r.Last,
+		Hourly:  r.Hourly,
+		Daily:   r.Daily,
+		Weekly:  r.Weekly,
+		Monthly: r.Monthly,
+		Yearly:  r.Yearly,
+	}
+
+	for _, d := range []struct {
+		name   string
+		value  string
+		target *restic.Duration
+	}{
+		{"within", r.Within, &r.policy.Within},
+		{"within-hourly", r.WithinHourly, &r.policy.WithinHourly},
+		{"within-daily", r.WithinDaily, &r.policy.WithinDaily},
+		{"within-weekly", r.WithinWeekly, &r.policy.WithinWeekly},
+		{"within-monthly", r.WithinMonthly, &r.policy.WithinMonthly},
+		{"within-yearly", r.WithinYearly, &r.policy.WithinYearly},
+	} {
+		if d.value == "" {
+			continue
+		}
+		var err error
+		*d.target, err = restic.ParseDuration(d.value)
+		if err != nil {
+			return errors.Errorf("invalid retention %v %q: %v", d.name, d.value, err)
+		}
+	}
+
+	if len(r.Tags) > 0 {
+		r.policy.Tags = restic.TagLists{restic.TagList(r.Tags)}
+	}
+
+	if r.policy.Empty() {
+		return errors.New("retention policy is empty")
+	}
+	return nil
+}
+
+// checkOptions returns the options of the check run after the job.
+func (c *daemonCheck) checkOptions() CheckOptions {
+	return CheckOptions{ReadDataSubset: c.ReadDataSubset}
+}
+
+// forgetOptions returns the options to apply the retention policy to the
+// snapshots created by the job.
+func (job *daemonJob) forgetOptions(host string, paths []string) ForgetOptions {
+	p := job.Retention.policy
+	opts := ForgetOptions{
+		Last:          ForgetPolicyCount(p.Last),
+		Hourly:        ForgetPolicyCount(p.Hourly),
+		Daily:         ForgetPolicyCount(p.Daily),
+		Weekly:        ForgetPolicyCount(p.Weekly),
+		Monthly:       ForgetPolicyCount(p.Monthly),
+		Yearly:        ForgetPolicyCount(p.Yearly),
+		Within:        p.Within,
+		WithinHourly:  p.WithinHourly,
+		WithinDaily:   p.WithinDaily,
+		WithinWeekly:  p.WithinWeekly,
+		WithinMonthly: p.WithinMonthly,
+		WithinYearly:  p.WithinYearly,
+		KeepTags:      p.Tags,
+		SnapshotFilter: restic.SnapshotFilter{
+			Hosts: []string{host},
+			Paths: paths,
+		},
+		GroupBy: restic.SnapshotGroupByOptions{Host: true, Path: true},
+		Prune:   job.Retention.Prune,
+	}
+	if len(job.Tags) > 0 {
+		opts.SnapshotFilter.Tags = restic.TagLists{restic.TagList(job.Tags)}
+	}
+	return opts
+}
+
+func daemonStatusFile(dir, name string) string {
+	return filepath.Join(dir, name+".json")
+}
+
+func loadDaemonJobStatus(dir, name string) (*daemonJobStatus, error) {
+	buf, err := os.ReadFile(daemonStatusFile(dir, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return &daemonJobStatus{Job: name}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var st daemonJobStatus
+	if err := json.Unmarshal(buf, &st); err != nil {
+		return nil, errors.Wrapf(err, "status file of job %v", name)
+	}
+	st.Job = name
+	return &st, nil
+}
+
+// saveDaemonJobStatus atomically replaces the status file of the job.
+func saveDaemonJobStatus(dir string, st *daemonJobStatus) error {
+	buf, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(dir, "."+st.Job+"-*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(buf, '\n'))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), daemonStatusFile(dir, st.Job))
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+	}
+	return err
+}
+
+func runDaemon(ctx context.Context, opts DaemonOptions, gopts GlobalOptions, term *termstatus.Terminal, args []string) error {
+	if len(args) > 0 {
+		return errors.Fatal("the daemon command expects no arguments, only options - please see `restic help daemon` for usage and flags")
+	}
+	if opts.ConfigFile == "" {
+		return errors.Fatal("please specify the job definitions using --config")
+	}
+
+	cfg, err := loadDaemonConfig(opts.ConfigFile)
+	if err != nil {
+		return err
+	}
+
+	statusDir := opts.StatusDir
+	if statusDir == "" {
+		statusDir = filepath.Dir(opts.ConfigFile)
+	}
+	if err := os.MkdirAll(statusDir, 0700); err != nil {
+		return errors.Fatalf("unable to create status directory: %v", err)
+	}
+
+	status := make([]*daemonJobStatus, len(cfg.Jobs))
+	for i, job := range cfg.Jobs {
+		status[i], err = loadDaemonJobStatus(statusDir, job.Name)
+		if err != nil {
+			return err
+		}
+	}
+
+	for {
+		failed := 0
+		for i := range cfg.Jobs {
+			job, st := &cfg.Jobs[i], status[i]
+			if !opts.Once && st.NextRun.After(time.Now()) {
+				continue
+			}
+
+			runDaemonJob(ctx, job, st, gopts, term)
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if !st.Success {
+				failed++
+			}
+
+			if err := saveDaemonJobStatus(statusDir, st); err != nil {
+				Warnf("unable to save status of job %v: %v\n", job.Name, err)
+			}
+		}
+
+		if opts.Once {
+			if failed > 0 {
+				return errors.Fatalf("%d of %d jobs failed", failed, len(cfg.Jobs))
+			}
+			return nil
+		}
+
+		next := status[0].NextRun
+		for _, st := range status[1:] {
+			if st.NextRun.Before(next) {
+				next = st.NextRun
+			}
+		}
+		Verbosef("next job scheduled at %v\n", next.Format(TimeFormat))
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// runDaemonJob runs the backup, forget and check steps of job and updates st.
+func runDaemonJob(ctx context.Context, job *daemonJob, st *daemonJobStatus, gopts GlobalOptions, term *termstatus.Terminal) {
+	st.LastRunStart = time.Now()
+	Verbosef("running job %v\n", job.Name)
+
+	err := runDaemonJobSteps(ctx, job, st, gopts, term)
+
+	st.LastRunEnd = time.Now()
+	st.NextRun = st.LastRunStart.Add(job.interval)
+	st.Success = err == nil
+	st.Error = ""
+	if err != nil {
+		st.Error = err.Error()
+		Warnf("job %v failed: %v\n", job.Name, err)
+	} else {
+		st.LastSuccess = st.LastRunEnd
+		Verbosef("job %v finished successfully\n", job.Name)
+	}
+}
+
+func runDaemonJobSteps(ctx context.Context, job *daemonJob, st *daemonJobStatus, gopts GlobalOptions, term *termstatus.Terminal) error {
+	if job.RetryLock != "" {
+		gopts.RetryLock = job.retryLock
+	}
+
+	host := job.Host
+	if host == "" {
+		var err error
+		host, err = os.Hostname()
+		if err != nil {
+			return fmt.Errorf("unable to determine hostname: %w", err)
+		}
+	}
+
+	// snapshots store absolute paths, which are required to apply the retention policy
+	paths := make([]string, 0, len(job.Paths))
+	for _, p := range job.Paths {
+		abs, err := filepath.Abs(p)
+		if err != nil {
+			return err
+		}
+		paths = append(paths, abs)
+	}
+
+	backupOpts := BackupOptions{
+		excludePatternOptions: excludePatternOptions{
+			Excludes:     job.Excludes,
+			ExcludeFiles: job.ExcludeFiles,
+		},
+		Host:    host,
+		GroupBy: restic.SnapshotGroupByOptions{Host: true, Path: true},
+	}
+	if len(job.Tags) > 0 {
+		backupOpts.Tags = restic.TagLists{restic.TagList(job.Tags)}
+	}
+	if err := runBackup(ctx, backupOpts, gopts, term, paths); err != nil {
+		return fmt.Errorf("backup failed: %w", err)
+	}
+
+	if job.Retention != nil {
+		pruneOpts := PruneOptions{MaxUnused: "5%"}
+		if err := runForget(ctx, job.forgetOptions(host, paths), pruneOpts, gopts, term, nil); err != nil {
+			return fmt.Errorf("forget failed: %w", err)
+		}
+	}
+
+	if job.Check != nil && !time.Now().Before(st.LastCheck.Add(job.Check.interval)) {
+		if err := runCheck(ctx, job.Check.checkOptions(), gopts, nil, term); err != nil {
+			return fmt.Errorf("check failed: %w", err)
+		}
+		st.LastCheck = time.Now()
+	}
+
+	return nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/chanhpng/vlbe/internal/restic"
+	rtest "github.com/chanhpng/vlbe/internal/test"
+	"github.com/chanhpng/vlbe/internal/ui/termstatus"
+)
+
+func testRunDaemonOnce(t testing.TB, gopts GlobalOptions, config string) error {
+	return withTermStatus(gopts, func(ctx context.Context, term *termstatus.Terminal) error {
+		return runDaemon(ctx, DaemonOptions{ConfigFile: config, Once: true}, gopts, term, nil)
+	})
+}
+
+func writeDaemonConfig(t testing.TB, dir, content string) string {
+	filename := filepath.Join(dir, "daemon.yaml")
+	rtest.OK(t, os.WriteFile(filename, []byte(content), 0600))
+	return filename
+}
+
+func loadTestDaemonStatus(t testing.TB, dir, name string) daemonJobStatus {
+	buf, err := os.ReadFile(filepath.Join(dir, name+".json"))
+	rtest.OK(t, err)
+	var st daemonJobStatus
+	rtest.OK(t, json.Unmarshal(buf, &st))
+	return st
+}
+
+func TestDaemonOnce(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+	rtest.SetupTarTestFixture(t, env.testdata, filepath.Join("testdata", "backup-data.tar.gz"))
+
+	config := writeDaemonConfig(t, env.base, fmt.Sprintf(`
+jobs:
+  - name: testdata
+    interval: 1h
+    paths: [%q]
+    tags: [daemon]
+    host: example
+    retention:
+      last: 1
+    check:
+      interval: 24h
+      read-data-subset: 1/2
+`, env.testdata))
+
+	for i := 0; i < 2; i++ {
+		rtest.OK(t, testRunDaemonOnce(t, env.gopts, config))
+	}
+
+	// the retention policy only keeps the latest snapshot
+	testListSnapshots(t, env.gopts, 1)
+
+	st := loadTestDaemonStatus(t, env.base, "testdata")
+	rtest.Equals(t, "testdata", st.Job)
+	rtest.Assert(t, st.Success, "expected job to succeed, got error %q", st.Error)
+	rtest.Assert(t, !st.LastCheck.IsZero(), "expected check to be run")
+	rtest.Equals(t, st.LastRunStart.Add(time.Hour), st.NextRun)
+}
+
+func TestDaemonJobFailure(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+
+	config := writeDaemonConfig(t, env.base, fmt.Sprintf(`
+jobs:
+  - {name: missing, interval: 1h, paths: [%q]}
+`, filepath.Join(env.base, "does-not-exist")))
+
+	err := testRunDaemonOnce(t, env.gopts, config)
+	rtest.Assert(t, err != nil, "expected daemon to report the failed job")
+
+	st := loadTestDaemonStatus(t, env.base, "missing")
+	rtest.Assert(t, !st.Success, "expected job to fail")
+	rtest.Assert(t, st.Error != "", "expected error message in status file")
+}
+
+func TestDaemonConfig(t *testing.T) {
+	cfg, err := loadDaemonConfig(writeDaemonConfig(t, rtest.TempDir(t), `
+jobs:
+  - name: home
+    interval: 24h
+    paths: [/home]
+    exclude-files: [/etc/restic/excludes]
+    retry-lock: 30m
+    retention:
+      within-daily: 7d
+      prune: true
+`))
+	rtest.OK(t, err)
+	rtest.Equals(t, 1, len(cfg.Jobs))
+	job := cfg.Jobs[0]
+	rtest.Equals(t, []string{"/etc/restic/excludes"}, job.ExcludeFiles)
+	rtest.Equals(t, 24*time.Hour, job.interval)
+	rtest.Equals(t, 30*time.Minute, job.retryLock)
+	rtest.Equals(t, restic.Duration{Days: 7}, job.Retention.policy.WithinDaily)
+	rtest.Assert(t, job.Retention.Prune, "expected prune to be set")
+}
+
+func TestDaemonConfigInvalid(t *testing.T) {
+	dir := rtest.TempDir(t)
+	for _, config := range []string{
+		``,
+		`jobs: []`,
+		`jobs: [{name: a, interval: 1h}]`,
+		`jobs: [{name: a/b, interval: 1h, paths: [/]}]`,
+		`jobs: [{name: a, interval: 0s, paths: [/]}]`,
+		`jobs: [{name: a, interval: 1h, paths: [/], retention: {}}]`,
+		`jobs: [{name: a, interval: 1h, paths: [/], unknown: true}]`,
+		`jobs: [{name: a, interval: 1h, paths: [/], retry_lock: 1m}]`,
+		`jobs: [{name: a, interval: 1h, paths: [/], check: {interval: 1h, read-data-subset: 3/2}}]`,
+		`jobs: [{name: a, interval: 1h, paths: [/], check: {interval: 1h, read-data-subset: foo}}]`,
+		`jobs: [{name: a, interval: 1h, paths: [/]}, {name: a, interval: 1h, paths: [/]}]`,
+	} {
+		_, err := loadDaemonConfig(writeDaemonConfig(t, dir, config))
+		rtest.Assert(t, err != nil, "expected error for config %v", config)
+	}
+}
@@ -6,22 +6,28 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"strings"
 
 	"github.com/chanhpng/vlbe/internal/debug"
 	"github.com/chanhpng/vlbe/internal/dump"
 	"github.com/chanhpng/vlbe/internal/errors"
+	"github.com/chanhpng/vlbe/internal/repository"
 	"github.com/chanhpng/vlbe/internal/restic"
 
 	"github.com/spf13/cobra"
 )
 
 var cmdDump = &cobra.Command{
-	Use:   "dump [flags] snapshotID file",
+	Use:   "dump [flags] snapshotID file...",
 	Short: "Print a backed-up file to stdout",
 	Long: `
 The "dump" command extracts files from a snapshot from the repository. If a
 single file is selected, it prints its contents to stdout. Folders are output
-as a tar (default) or zip file containing the contents of the specified folder.
+as an archive file containing the contents of the specified folder. The
+supported archive formats are tar (default), tar.gz, tar.zst, zip and cpio
+(in the "newc" format). Hard links are preserved in all formats except zip.
+Extended attributes and ACLs are only stored in the tar formats, as PAX
+records, zip and cpio archives do not contain them.
 Pass "/" as file name to dump the whole snapshot as an archive file.
 
 The special snapshotID "latest" can be used to use the latest snapshot in the
@@ -31,6 +37,14 @@ To include the folder content at the root of the archive, you can use the
 "snapshotID:subfolder" syntax, where "subfolder" is a path within the
 snapshot.
 
+If multiple files or folders are specified, they are all written to a single
+archive, using their path within the snapshot.
+
+The items within folders which are written to the archive can be filtered
+using "--include" and "--exclude", which work the same way as for the
+"restore" command. With "--sparse", regions of files which only contain zeros
+are stored as holes of sparse files in tar archives, including compressed ones.
+
 EXIT STATUS
 ===========
 
@@ -47,9 +61,12 @@ Exit status is 11 if the repository is already locked.
 
 // DumpOptions collects all options for the dump command.
 type DumpOptions struct {
+	excludePatternOptions
+	includePatternOptions
 	restic.SnapshotFilter
 	Archive string
 	Target  string
+	Sparse  bool
 }
 
 var dumpOptions DumpOptions
@@ -59,8 +76,12 @@ func init() {
 
 	flags := cmdDump.Flags()
 	initSingleSnapshotFilter(flags, &dumpOptions.SnapshotFilter)
-	flags.StringVarP(&dumpOptions.Archive, "archive", "a", "tar", "set archive `format` as \"tar\" or \"zip\"")
+	flags.StringVarP(&dumpOptions.Archive, "archive", "a", "tar", "set archive `format` as \"tar\", \"tar.gz\", \"tar.zst\", \"zip\" or \"cpio\"")
 	flags.StringVarP(&dumpOptions.Target, "target", "t", "", "write the output to target `path`")
+	flags.BoolVar(&dumpOptions.Sparse, "sparse", false, "write files with all-zero regions as sparse files (tar, tar.gz and tar.zst only)")
+
+	initExcludePatternOptions(flags, &dumpOptions.excludePatternOptions)
+	initIncludePatternOptions(flags, &dumpOptions.includePatternOptions)
 }
 
 func splitPath(p string) []string {
@@ -116,23 +137,115 @@ func printFromTree(ctx context.Context, tree *restic.Tree, repo restic.BlobLoade
 	return fmt.Errorf("path %q not found in snapshot", item)
 }
 
+// findNodes returns the nodes for the path within tree, with their Path set.
+// If the path is "/", all nodes of the tree are returned.
+func findNodes(ctx context.Context, tree *restic.Tree, repo restic.BlobLoader, prefix string, pathComponents []string) ([]*restic.Node, error) {
+	if pathComponents[0] == "" {
+		for _, node := range tree.Nodes {
+			node.Path = path.Join(prefix, node.Name)
+		}
+		return tree.Nodes, nil
+	}
+
+	item := path.Join(prefix, pathComponents[0])
+	for _, node := range tree.Nodes {
+		if node.Name != pathComponents[0] {
+			continue
+		}
+
+		switch {
+		case len(pathComponents) == 1:
+			node.Path = item
+			return []*restic.Node{node}, nil
+		case dump.IsDir(node):
+			subtree, err := restic.LoadTree(ctx, repo, *node.Subtree)
+			if err != nil {
+				return nil, errors.Wrapf(err, "cannot load subtree for %q", item)
+			}
+			return findNodes(ctx, subtree, repo, item, pathComponents[1:])
+		default:
+			return nil, fmt.Errorf("%q should be a dir, but is a %q", item, node.Type)
+		}
+	}
+	return nil, fmt.Errorf("path %q not found in snapshot", item)
+}
+
+// removeNestedPaths cleans paths and removes all paths which are equal to or
+// within another path, as they are already contained in the archive.
+func removeNestedPaths(paths []string) []string {
+	cleaned := make([]string, 0, len(paths))
+	for _, p := range paths {
+		cleaned = append(cleaned, path.Join("/", p))
+	}
+
+	contains := func(dir, p string) bool {
+		return dir == "/" || p == dir || strings.HasPrefix(p, dir+"/")
+	}
+
+	var result []string
+	for i, p := range cleaned {
+		nested := false
+		for j, other := range cleaned {
+			// of two equal paths, the first one is kept
+			if i != j && contains(other, p) && (other != p || j < i) {
+				nested = true
+				break
+			}
+		}
+		if !nested {
+			result = append(result, p)
+		}
+	}
+	return result
+}
+
+// dumpPaths writes all paths within tree to a single archive. Paths within
+// another path are only written once.
+func dumpPaths(ctx context.Context, tree *restic.Tree, repo restic.BlobLoader, paths []string, d *dump.Dumper, canWriteArchiveFunc func() error) error {
+	var nodes []*restic.Node
+	for _, p := range removeNestedPaths(paths) {
+		found, err := findNodes(ctx, tree, repo, "/", splitPath(p))
+		if err != nil {
+			return err
+		}
+		nodes = append(nodes, found...)
+	}
+
+	if err := canWriteArchiveFunc(); err != nil {
+		return err
+	}
+	return d.DumpNodes(ctx, nodes)
+}
+
 func runDump(ctx context.Context, opts DumpOptions, gopts GlobalOptions, args []string) error {
-	if len(args) != 2 {
+	if len(args) < 2 {
 		return errors.Fatal("no file and no snapshot ID specified")
 	}
 
 	switch opts.Archive {
-	case "tar", "zip":
+	case "tar", "tar.gz", "tar.zst", "zip", "cpio":
 	default:
 		return fmt.Errorf("unknown archive format %q", opts.Archive)
 	}
 
-	snapshotIDString := args[0]
-	pathToPrint := args[1]
+	excludePatternFns, err := opts.excludePatternOptions.CollectPatterns()
+	if err != nil {
+		return err
+	}
 
-	debug.Log("dump file %q from %q", pathToPrint, snapshotIDString)
+	includePatternFns, err := opts.includePatternOptions.CollectPatterns()
+	if err != nil {
+		return err
+	}
+
+	if len(excludePatternFns) > 0 && len(includePatternFns) > 0 {
+		return errors.Fatal("exclude and include patterns are mutually exclusive")
+	}
+
+	snapshotIDString := args[0]
+	pathsToPrint := args[1:]
 
-	splittedPath := splitPath(path.Clean(pathToPrint))
+	debug.Log("dump files %q from %q", pathsToPrint, snapshotIDString)
 
 	ctx, repo, unlock, err := openWithReadLock(ctx, gopts, gopts.NoLock)
 	if err != nil {
@@ -140,6 +253,10 @@ func runDump(ctx context.Context, opts DumpOptions, gopts GlobalOptions, args []
 	}
 	defer unlock()
 
+	if err := repo.CheckPermission(repository.PermRead); err != nil {
+		return err
+	}
+
 	sn, subfolder, err := (&restic.SnapshotFilter{
 		Hosts: opts.Hosts,
 		Paths: opts.Paths,
@@ -182,7 +299,18 @@ func runDump(ctx context.Context, opts DumpOptions, gopts GlobalOptions, args []
 	}
 
 	d := dump.New(opts.Archive, repo, outputFileWriter)
-	err = printFromTree(ctx, tree, repo, "/", splittedPath, d, canWriteArchiveFunc)
+	d.Sparse = opts.Sparse
+	if len(excludePatternFns) > 0 {
+		d.SelectFilter = selectExcludeFilter(excludePatternFns)
+	} else if len(includePatternFns) > 0 {
+		d.SelectFilter = selectIncludeFilter(includePatternFns)
+	}
+
+	if len(pathsToPrint) == 1 {
+		err = printFromTree(ctx, tree, repo, "/", splitPath(path.Clean(pathsToPrint[0])), d, canWriteArchiveFunc)
+	} else {
+		err = dumpPaths(ctx, tree, repo, pathsToPrint, d, canWriteArchiveFunc)
+	}
 	if err != nil {
 		return errors.Fatalf("cannot dump file: %v", err)
 	}
@@ -25,3 +25,20 @@ func TestDumpSplitPath(t *testing.T) {
 		rtest.Equals(t, path.result, parts)
 	}
 }
+
+func TestDumpRemoveNestedPaths(t *testing.T) {
+	for _, test := range []struct {
+		paths  []string
+		result []string
+	}{
+		{[]string{"/a", "/b"}, []string{"/a", "/b"}},
+		{[]string{"/a", "/a/b"}, []string{"/a"}},
+		{[]string{"a/b/c", "/a/"}, []string{"/a"}},
+		{[]string{"/a", "/a"}, []string{"/a"}},
+		{[]string{"/ab", "/a"}, []string{"/ab", "/a"}},
+		{[]string{"/a/b", "/a/../a/b", "/c"}, []string{"/a/b", "/c"}},
+		{[]string{"/a", "/"}, []string{"/"}},
+	} {
+		rtest.Equals(t, test.result, removeNestedPaths(test.paths))
+	}
+}
@@ -22,9 +22,14 @@ var cmdFind = &cobra.Command{
 	Long: `
 The "find" command searches for files or directories in snapshots stored in the
 repo.
-It can also be used to search for restic blobs or trees for troubleshooting.`,
+It can also be used to search for restic blobs or trees for troubleshooting.
+
+With --versions, the matching files are listed once with each of their
+distinct versions across all snapshots. A version is shown with the oldest
+snapshot containing it and the number of snapshots it is part of.`,
 	Example: `restic find config.json
 restic find --json "*.yml" "*.json"
+restic find --versions /home/user/work/report.odt
 restic find --json --blob 420f620f b46ebe8a ddd38656
 restic find --show-pack-id --blob 420f620f
 restic find --tree 577c2bc9 f81f2e22 a62827a9
@@ -54,6 +59,7 @@ type FindOptions struct {
 	CaseInsensitive    bool
 	ListLong           bool
 	HumanReadable      bool
+	Versions           bool
 	restic.SnapshotFilter
 }
 
@@ -73,6 +79,7 @@ func init() {
 	f.BoolVarP(&findOptions.CaseInsensitive, "ignore-case", "i", false, "ignore case for pattern")
 	f.BoolVarP(&findOptions.ListLong, "long", "l", false, "use a long listing format showing size and mode")
 	f.BoolVar(&findOptions.HumanReadable, "human-readable", false, "print sizes in human readable format")
+	f.BoolVar(&findOptions.Versions, "versions", false, "list the distinct versions of each matching file across all snapshots")
 
 	initMultiSnapshotFilter(f, &findOptions.SnapshotFilter, true)
 }
@@ -253,6 +260,7 @@ type Finder struct {
 	out        statefulOutput
 	blobIDs    map[string]struct{}
 	treeIDs    map[string]struct{}
+	versions   *fileVersions
 	itemsFound int
 }
 
@@ -329,6 +337,12 @@ func (f *Finder) findInSnapshot(ctx context.Context, sn *restic.Snapshot) error
 		}
 
 		debug.Log("    found match\n")
+		if f.versions != nil {
+			if node.Type != "dir" {
+				f.versions.add(nodepath, node, sn)
+			}
+			return nil
+		}
 		f.out.PrintPattern(nodepath, node)
 		return nil
 	}})
@@ -570,6 +584,10 @@ func runFind(ctx context.Context, opts FindOptions, gopts GlobalOptions, args []
 		return errors.Fatal("cannot have several ID types")
 	}
 
+	if opts.Versions && (opts.BlobID || opts.TreeID || opts.PackID) {
+		return errors.Fatal("--versions cannot be used to search for IDs")
+	}
+
 	ctx, repo, unlock, err := openWithReadLock(ctx, gopts, gopts.NoLock)
 	if err != nil {
 		return err
@@ -590,6 +608,9 @@ func runFind(ctx context.Context, opts FindOptions, gopts GlobalOptions, args []
 		pat:  pat,
 		out:  statefulOutput{ListLong: opts.ListLong, HumanReadable: opts.HumanReadable, JSON: gopts.JSON},
 	}
+	if opts.Versions {
+		f.versions = newFileVersions()
+	}
 
 	if opts.BlobID {
 		f.blobIDs = make(map[string]struct{})
@@ -634,7 +655,11 @@ func runFind(ctx context.Context, opts FindOptions, gopts GlobalOptions, args []
 			return err
 		}
 	}
-	f.out.Finish()
+	if f.versions != nil {
+		f.versions.Print(gopts.JSON, opts.HumanReadable)
+	} else {
+		f.out.Finish()
+	}
 
 	if opts.ShowPackID && (f.blobIDs != nil || f.treeIDs != nil) {
 		f.findObjectsPacks()
@@ -3,6 +3,8 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
@@ -85,3 +87,32 @@ func TestFindJSON(t *testing.T) {
 	rtest.Assert(t, len(matches[0].Matches) == 3, "expected 3 files to match (%v)", datafile)
 	rtest.Assert(t, matches[0].Hits == 3, "expected hits to show 3 matches (%v)", datafile)
 }
+
+func TestFindVersions(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+	rtest.OK(t, os.MkdirAll(env.testdata, 0755))
+	filename := filepath.Join(env.testdata, "versioned")
+	for _, content := range []string{"first", "second", "second"} {
+		rtest.OK(t, os.WriteFile(filename, []byte(content), 0644))
+		testRunBackup(t, "", []string{env.testdata}, BackupOptions{}, env.gopts)
+	}
+
+	buf, err := withCaptureStdout(func() error {
+		gopts := env.gopts
+		gopts.JSON = true
+		return runFind(context.TODO(), FindOptions{Versions: true}, gopts, []string{"versioned"})
+	})
+	rtest.OK(t, err)
+
+	var results []findVersionsJSON
+	rtest.OK(t, json.Unmarshal(buf.Bytes(), &results))
+	rtest.Equals(t, 1, len(results))
+	rtest.Equals(t, 2, len(results[0].Versions))
+	rtest.Equals(t, uint64(len("first")), results[0].Versions[0].Size)
+	rtest.Equals(t, 1, len(results[0].Versions[0].Snapshots))
+	rtest.Equals(t, uint64(len("second")), results[0].Versions[1].Size)
+	rtest.Equals(t, 2, len(results[0].Versions[1].Snapshots))
+}
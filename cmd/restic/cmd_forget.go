@@ -6,9 +6,11 @@ import (
 	"fmt"
 	"io"
 	"strconv"
+	"time"
 
 	"github.com/chanhpng/vlbe/internal/errors"
 	"github.com/chanhpng/vlbe/internal/feature"
+	"github.com/chanhpng/vlbe/internal/repository"
 	"github.com/chanhpng/vlbe/internal/restic"
 	"github.com/chanhpng/vlbe/internal/ui/termstatus"
 	"github.com/spf13/cobra"
@@ -25,6 +27,13 @@ If there are not enough snapshots to keep one for each duration related
 "--keep-{within-,}*" option, the oldest snapshot in the group is kept
 additionally.
 
+With "--simulate-days" nothing is removed. Instead, the policy is applied
+once per day for the given number of days and the number of snapshots retained
+each day as well as the size of the data referenced by them is reported. Use
+"--simulate-backup-interval" to include snapshots created in the future, which
+are assumed to contain the same data as the latest snapshot. The size is
+reported per group, data shared by several groups is counted in each of them.
+
 Please note that this command really only deletes the snapshot object in the
 repository, which is a reference to data stored there. In order to remove the
 unreferenced data after "forget" was run successfully, see the "prune" command.
@@ -108,6 +117,9 @@ type ForgetOptions struct {
 	GroupBy restic.SnapshotGroupByOptions
 	DryRun  bool
 	Prune   bool
+
+	SimulateDays     int
+	SimulateInterval restic.Duration
 }
 
 var forgetOptions ForgetOptions
@@ -145,6 +157,8 @@ func init() {
 	f.VarP(&forgetOptions.GroupBy, "group-by", "g", "`group` snapshots by host, paths and/or tags, separated by comma (disable grouping with '')")
 	f.BoolVarP(&forgetOptions.DryRun, "dry-run", "n", false, "do not delete anything, just print what would be done")
 	f.BoolVar(&forgetOptions.Prune, "prune", false, "automatically run the 'prune' command if snapshots have been removed")
+	f.IntVar(&forgetOptions.SimulateDays, "simulate-days", 0, "simulate the policy day by day for `n` days and report the retained snapshots and data, without removing anything")
+	f.Var(&forgetOptions.SimulateInterval, "simulate-backup-interval", "assume that a new snapshot is created every `duration` (eg. 1d or 12h) during the simulation")
 
 	f.SortFlags = false
 	addPruneOptions(cmdForget, &forgetPruneOptions)
@@ -163,6 +177,20 @@ func verifyForgetOptions(opts *ForgetOptions) error {
 		}
 	}
 
+	if opts.SimulateDays < 0 {
+		return errors.Fatal("negative values are not allowed for --simulate-days")
+	}
+	d := opts.SimulateInterval
+	if d.Hours < 0 || d.Days < 0 || d.Months < 0 || d.Years < 0 {
+		return errors.Fatal("durations containing negative values are not allowed for --simulate-backup-interval")
+	}
+	if opts.SimulateDays == 0 && !d.Zero() {
+		return errors.Fatal("--simulate-backup-interval can only be used together with --simulate-days")
+	}
+	if opts.SimulateDays > 0 && opts.Prune {
+		return errors.Fatal("--simulate-days and --prune cannot be used together")
+	}
+
 	return nil
 }
 
@@ -177,6 +205,14 @@ func runForget(ctx context.Context, opts ForgetOptions, pruneOptions PruneOption
 		return err
 	}
 
+	if opts.SimulateDays > 0 {
+		if len(args) > 0 {
+			return errors.Fatal("--simulate-days cannot be used with explicit snapshot IDs")
+		}
+		// a simulation never modifies the repository
+		opts.DryRun = true
+	}
+
 	if gopts.NoLock && !opts.DryRun {
 		return errors.Fatal("--no-lock is only applicable in combination with --dry-run for forget command")
 	}
@@ -187,6 +223,12 @@ func runForget(ctx context.Context, opts ForgetOptions, pruneOptions PruneOption
 	}
 	defer unlock()
 
+	if !opts.DryRun {
+		if err := repo.CheckPermission(repository.PermRemove); err != nil {
+			return err
+		}
+	}
+
 	verbosity := gopts.verbosity
 	if gopts.JSON {
 		verbosity = 0
@@ -207,7 +249,11 @@ func runForget(ctx context.Context, opts ForgetOptions, pruneOptions PruneOption
 
 	if len(args) > 0 {
 		// When explicit snapshots args are given, remove them immediately.
+		now := time.Now()
 		for _, sn := range snapshots {
+			if sn.IsHeld(now) {
+				return errors.Fatalf("refusing to remove snapshot %v, it is %v", sn.ID().Str(), sn.Hold)
+			}
 			removeSnIDs.Insert(*sn.ID())
 		}
 	} else {
@@ -245,6 +291,10 @@ func runForget(ctx context.Context, opts ForgetOptions, pruneOptions PruneOption
 
 		printer.P("Applying Policy: %v\n", policy)
 
+		if opts.SimulateDays > 0 {
+			return simulateForget(ctx, repo, snapshotGroups, policy, opts, gopts)
+		}
+
 		for k, snapshotGroup := range snapshotGroups {
 			if gopts.Verbose >= 1 && !gopts.JSON {
 				err = PrintSnapshotGroupHeader(globalOptions.stdout, k)
@@ -2,10 +2,13 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"path/filepath"
 	"strings"
 	"testing"
 
+	"github.com/chanhpng/vlbe/internal/errors"
+	"github.com/chanhpng/vlbe/internal/repository"
 	"github.com/chanhpng/vlbe/internal/restic"
 	rtest "github.com/chanhpng/vlbe/internal/test"
 	"github.com/chanhpng/vlbe/internal/ui/termstatus"
@@ -64,3 +67,75 @@ func TestRunForgetSafetyNet(t *testing.T) {
 	})
 	testListSnapshots(t, env.gopts, 0)
 }
+
+func TestForgetAppendOnly(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	// must list keys more than once
+	env.gopts.backendTestHook = nil
+	defer cleanup()
+
+	rtest.OK(t, runInit(context.TODO(), InitOptions{AppendOnly: true}, env.gopts, nil))
+	rtest.SetupTarTestFixture(t, env.testdata, filepath.Join("testdata", "backup-data.tar.gz"))
+	testRunBackup(t, "", []string{filepath.Join(env.testdata, "0", "0", "9")}, BackupOptions{}, env.gopts)
+	testRunBackup(t, "", []string{filepath.Join(env.testdata, "0", "0", "9")}, BackupOptions{}, env.gopts)
+	snapshotIDs := testListSnapshots(t, env.gopts, 2)
+
+	// a key without admin permissions cannot remove snapshots
+	userOpts := env.gopts
+	userOpts.password = "user password"
+	testRunKeyAddNewKey(t, userOpts.password, env.gopts)
+
+	err := testRunForgetMayFail(userOpts, ForgetOptions{}, snapshotIDs[0].String())
+	rtest.Assert(t, errors.Is(err, repository.ErrAppendOnly), "unexpected error %v", err)
+	rtest.OK(t, testRunForgetMayFail(userOpts, ForgetOptions{DryRun: true}, snapshotIDs[0].String()))
+	testListSnapshots(t, userOpts, 2)
+
+	// the key created by init is an admin key
+	testRunForget(t, env.gopts, ForgetOptions{}, snapshotIDs[0].String())
+	testListSnapshots(t, env.gopts, 1)
+}
+
+func TestForgetSimulate(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testSetupBackupData(t, env)
+	for i := 0; i < 3; i++ {
+		testRunBackup(t, "", []string{filepath.Join(env.testdata, "0", "0", "9")}, BackupOptions{}, env.gopts)
+	}
+	testListSnapshots(t, env.gopts, 3)
+
+	opts := ForgetOptions{
+		Last:             2,
+		GroupBy:          restic.SnapshotGroupByOptions{Host: true, Path: true},
+		SimulateDays:     3,
+		SimulateInterval: restic.ParseDurationOrPanic("1d"),
+	}
+	gopts := env.gopts
+	gopts.JSON = true
+	buf, err := withCaptureStdout(func() error {
+		return testRunForgetMayFail(gopts, opts)
+	})
+	rtest.OK(t, err)
+
+	var groups []ForgetSimulationGroup
+	rtest.OK(t, json.Unmarshal(buf.Bytes(), &groups))
+	rtest.Equals(t, 1, len(groups))
+	days := groups[0].Days
+	rtest.Equals(t, 4, len(days))
+	rtest.Equals(t, 2, days[0].Snapshots)
+	rtest.Equals(t, 1, days[0].Removed)
+	for _, d := range days[1:] {
+		// one synthetic snapshot is added and one removed each day
+		rtest.Equals(t, 2, d.Snapshots)
+		rtest.Equals(t, 1, d.Removed)
+		rtest.Equals(t, days[1].Size, d.Size)
+	}
+	rtest.Assert(t, days[1].Size > 0 && days[1].Size <= days[0].Size, "unexpected retained size %v, %v", days[0].Size, days[1].Size)
+
+	// the simulation must not remove anything
+	testListSnapshots(t, env.gopts, 3)
+
+	err = testRunForgetMayFail(env.gopts, ForgetOptions{Last: 1, SimulateDays: 1, Prune: true})
+	rtest.Assert(t, err != nil, "expected --simulate-days with --prune to fail")
+}
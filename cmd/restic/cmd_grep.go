@@ -0,0 +1,497 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"regexp"
+	"sort"
+
+	"github.com/hashicorp/golang-lru/v2/simplelru"
+	"github.com/spf13/cobra"
+
+	"github.com/chanhpng/vlbe/internal/debug"
+	"github.com/chanhpng/vlbe/internal/errors"
+	"github.com/chanhpng/vlbe/internal/repository"
+	"github.com/chanhpng/vlbe/internal/restic"
+	"github.com/chanhpng/vlbe/internal/ui"
+	"github.com/chanhpng/vlbe/internal/walker"
+)
+
+var cmdGrep = &cobra.Command{
+	Use:   "grep [flags] PATTERN",
+	Short: "Search the content of files in snapshots",
+	Long: `
+The "grep" command searches the content of the files in snapshots for lines
+matching a regular expression. PATTERN uses the syntax of Go regular
+expressions, which is similar to the extended syntax of grep. With
+--fixed-strings, PATTERN is searched as a plain string instead.
+
+Each matching line is printed as "snapshot:path:line number:line". Lines of
+context around the matches are printed with "-" instead of ":". Files
+containing NUL bytes are considered to be binary, for them only a notice is
+printed.
+
+All snapshots matching the "--host", "--tag" and "--path" options are
+searched, or only those given with "--snapshot". Files with identical content
+are usually only read once, even if they are part of several snapshots.
+
+EXIT STATUS
+===========
+
+Exit status is 0 if the command was successful.
+Exit status is 1 if there was any error.
+Exit status is 10 if the repository does not exist.
+Exit status is 11 if the repository is already locked.
+`,
+	Example: `restic grep "connection refused" --include "/var/log"
+restic grep --ignore-case --fixed-strings "TODO" --snapshot latest
+restic grep -C 2 --max-size 1M "^password ="`,
+	DisableAutoGenTag: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runGrep(cmd.Context(), grepOptions, globalOptions, args)
+	},
+}
+
+// GrepOptions bundles all options for the grep command.
+type GrepOptions struct {
+	excludePatternOptions
+	includePatternOptions
+	FixedStrings     bool
+	IgnoreCase       bool
+	FilesWithMatches bool
+	BeforeContext    int
+	AfterContext     int
+	Context          int
+	MinSize          string
+	MaxSize          string
+	Snapshots        []string
+	restic.SnapshotFilter
+}
+
+var grepOptions GrepOptions
+
+func init() {
+	cmdRoot.AddCommand(cmdGrep)
+
+	f := cmdGrep.Flags()
+	f.BoolVarP(&grepOptions.FixedStrings, "fixed-strings", "F", false, "interpret pattern as a plain string")
+	f.BoolVarP(&grepOptions.IgnoreCase, "ignore-case", "i", false, "ignore case for pattern")
+	f.BoolVarP(&grepOptions.FilesWithMatches, "files-with-matches", "l", false, "only print the paths of matching files")
+	f.IntVarP(&grepOptions.BeforeContext, "before-context", "B", 0, "print `n` lines of context before matches")
+	f.IntVarP(&grepOptions.AfterContext, "after-context", "A", 0, "print `n` lines of context after matches")
+	f.IntVarP(&grepOptions.Context, "context", "C", 0, "print `n` lines of context before and after matches")
+	f.StringVar(&grepOptions.MinSize, "min-size", "", "only search files of at least `size` (allowed suffixes: k/K, m/M, g/G, t/T)")
+	f.StringVar(&grepOptions.MaxSize, "max-size", "", "only search files of at most `size` (allowed suffixes: k/K, m/M, g/G, t/T)")
+	f.StringArrayVarP(&grepOptions.Snapshots, "snapshot", "s", nil, "snapshot `id` to search in (can be given multiple times)")
+
+	initExcludePatternOptions(f, &grepOptions.excludePatternOptions)
+	// -i is the shorthand for --ignore-case as in grep, not for --include
+	initIncludePatternOptionsShorthand(f, &grepOptions.includePatternOptions, "")
+	initMultiSnapshotFilter(f, &grepOptions.SnapshotFilter, true)
+}
+
+// maxGrepLineLength is the maximum length of a line which is searched.
+// Longer lines are truncated and the file is considered to be binary.
+const maxGrepLineLength = 1 << 20
+
+// maxGrepResults is the number of results for distinct file contents which are
+// kept to avoid searching the same content again.
+const maxGrepResults = 64 * 1024
+
+// grepLine is a matching line of a file or a line of context.
+type grepLine struct {
+	Number int    `json:"line_number"`
+	Text   string `json:"text"`
+	Match  bool   `json:"match"`
+}
+
+// grepResult contains the lines found in a file. Lines are only collected
+// for text files.
+type grepResult struct {
+	Lines  []grepLine
+	Binary bool
+}
+
+// grepper searches the content of files.
+type grepper struct {
+	repo          restic.BlobLoader
+	re            *regexp.Regexp
+	before, after int
+	// filesOnly stops searching a file after the first match
+	filesOnly bool
+
+	// results contains the results for the content of the files searched
+	// most recently, nil for files without matches
+	results *simplelru.LRU[restic.ID, *grepResult]
+	// searched is the number of distinct contents searched
+	searched int
+}
+
+func newGrepper(repo restic.BlobLoader, re *regexp.Regexp) *grepper {
+	results, err := simplelru.NewLRU[restic.ID, *grepResult](maxGrepResults, nil)
+	if err != nil {
+		panic(err) // only happens for a size <= 0
+	}
+	return &grepper{
+		repo:    repo,
+		re:      re,
+		results: results,
+	}
+}
+
+// searchNode returns the lines of the file node which match. Files with the
+// same content are only searched once, unless the result has been evicted
+// from the cache in the meantime.
+func (g *grepper) searchNode(ctx context.Context, node *restic.Node) (*grepResult, error) {
+	key := restic.Hash([]byte(node.ContentKey()))
+	if res, ok := g.results.Get(key); ok {
+		return res, nil
+	}
+
+	res, err := g.search(&contentReader{ctx: ctx, repo: g.repo, content: node.Content})
+	if err != nil {
+		return nil, err
+	}
+	g.searched++
+	g.results.Add(key, res)
+	return res, nil
+}
+
+// search returns the lines read from rd which match, including the lines of
+// context. It returns nil if no line matches.
+func (g *grepper) search(rd io.Reader) (*grepResult, error) {
+	br := bufio.NewReaderSize(rd, 64*1024)
+	res := &grepResult{}
+	matched := false
+	// before contains the lines preceding the current one which are not part
+	// of the result yet
+	var before []grepLine
+	after := 0
+
+	for number := 1; ; number++ {
+		line, err := readLine(br)
+		if err == io.EOF {
+			break
+		}
+		if err == errLineTooLong {
+			res.Binary = true
+		} else if err != nil {
+			return nil, err
+		}
+		if bytes.IndexByte(line, 0) >= 0 {
+			res.Binary = true
+		}
+
+		switch {
+		case g.re.Match(line):
+			matched = true
+			res.Lines = append(res.Lines, before...)
+			res.Lines = append(res.Lines, grepLine{Number: number, Text: string(line), Match: true})
+			before = before[:0]
+			after = g.after
+		case after > 0:
+			res.Lines = append(res.Lines, grepLine{Number: number, Text: string(line)})
+			after--
+		case g.before > 0:
+			if len(before) == g.before {
+				before = append(before[:0], before[1:]...)
+			}
+			before = append(before, grepLine{Number: number, Text: string(line)})
+		}
+
+		if matched && (res.Binary || g.filesOnly) {
+			break
+		}
+	}
+
+	if !matched {
+		return nil, nil
+	}
+	if res.Binary {
+		res.Lines = nil
+	}
+	return res, nil
+}
+
+var errLineTooLong = errors.New("line too long")
+
+// readLine returns the next line without the line break. Lines longer than
+// maxGrepLineLength are truncated and returned with errLineTooLong.
+func readLine(br *bufio.Reader) ([]byte, error) {
+	var line []byte
+	tooLong := false
+	for {
+		buf, err := br.ReadSlice('\n')
+		if len(line)+len(buf) <= maxGrepLineLength {
+			line = append(line, buf...)
+		} else {
+			tooLong = true
+		}
+
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		// the last line may not end with a line break
+		if err != nil && (err != io.EOF || len(line) == 0) {
+			return nil, err
+		}
+
+		line = bytes.TrimSuffix(line, []byte("\n"))
+		if tooLong {
+			return line, errLineTooLong
+		}
+		return line, nil
+	}
+}
+
+// contentReader reads the content of a file blob by blob.
+type contentReader struct {
+	ctx     context.Context
+	repo    restic.BlobLoader
+	content restic.IDs
+	blob    []byte
+	pos     int
+}
+
+func (r *contentReader) Read(p []byte) (int, error) {
+	for r.pos >= len(r.blob) {
+		if len(r.content) == 0 {
+			return 0, io.EOF
+		}
+
+		var err error
+		r.blob, err = r.repo.LoadBlob(r.ctx, restic.DataBlob, r.content[0], r.blob)
+		if err != nil {
+			return 0, err
+		}
+		r.content = r.content[1:]
+		r.pos = 0
+	}
+
+	n := copy(p, r.blob[r.pos:])
+	r.pos += n
+	return n, nil
+}
+
+// grepOutput prints the results of the grep command.
+type grepOutput struct {
+	JSON      bool
+	filesOnly bool
+	context   bool
+	// separate is set once the first group of lines has been printed
+	separate bool
+}
+
+func (out *grepOutput) Print(sn *restic.Snapshot, path string, res *grepResult) {
+	if out.JSON {
+		b, err := json.Marshal(struct {
+			Snapshot string     `json:"snapshot"`
+			Path     string     `json:"path"`
+			Binary   bool       `json:"binary,omitempty"`
+			Lines    []grepLine `json:"lines,omitempty"`
+		}{
+			Snapshot: sn.ID().String(),
+			Path:     path,
+			Binary:   res.Binary,
+			Lines:    res.Lines,
+		})
+		if err != nil {
+			Warnf("Marshall failed: %v\n", err)
+			return
+		}
+		Println(string(b))
+		return
+	}
+
+	prefix := sn.ID().Str() + ":" + path
+	switch {
+	case out.filesOnly:
+		Println(prefix)
+		return
+	case res.Binary:
+		Printf("Binary file %s matches\n", prefix)
+		return
+	}
+
+	// groups of lines which are not adjacent are separated by "--", this
+	// includes the groups of different files
+	last := -1
+	for _, line := range res.Lines {
+		if out.context && out.separate && line.Number != last+1 {
+			Println("--")
+		}
+		out.separate = true
+		last = line.Number
+
+		sep := "-"
+		if line.Match {
+			sep = ":"
+		}
+		Printf("%s%s%d%s%s\n", prefix, sep, line.Number, sep, line.Text)
+	}
+}
+
+// grepPattern compiles the pattern according to the options.
+func grepPattern(pattern string, opts GrepOptions) (*regexp.Regexp, error) {
+	if opts.FixedStrings {
+		pattern = regexp.QuoteMeta(pattern)
+	}
+	if opts.IgnoreCase {
+		pattern = "(?i)" + pattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, errors.Fatalf("invalid pattern: %v", err)
+	}
+	return re, nil
+}
+
+func parseSizeLimit(s string) (uint64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	size, err := ui.ParseBytes(s)
+	if err != nil {
+		return 0, errors.Fatalf("invalid size %q: %v", s, err)
+	}
+	return uint64(size), nil
+}
+
+func runGrep(ctx context.Context, opts GrepOptions, gopts GlobalOptions, args []string) error {
+	if len(args) != 1 {
+		return errors.Fatal("wrong number of arguments")
+	}
+
+	re, err := grepPattern(args[0], opts)
+	if err != nil {
+		return err
+	}
+
+	minSize, err := parseSizeLimit(opts.MinSize)
+	if err != nil {
+		return err
+	}
+	maxSize, err := parseSizeLimit(opts.MaxSize)
+	if err != nil {
+		return err
+	}
+
+	excludePatternFns, err := opts.excludePatternOptions.CollectPatterns()
+	if err != nil {
+		return err
+	}
+	includePatternFns, err := opts.includePatternOptions.CollectPatterns()
+	if err != nil {
+		return err
+	}
+	if len(excludePatternFns) > 0 && len(includePatternFns) > 0 {
+		return errors.Fatal("exclude and include patterns are mutually exclusive")
+	}
+	selectFilter := func(string, bool) (bool, bool) { return true, true }
+	if len(excludePatternFns) > 0 {
+		selectFilter = selectExcludeFilter(excludePatternFns)
+	} else if len(includePatternFns) > 0 {
+		selectFilter = selectIncludeFilter(includePatternFns)
+	}
+
+	ctx, repo, unlock, err := openWithReadLock(ctx, gopts, gopts.NoLock)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := repo.CheckPermission(repository.PermRead); err != nil {
+		return err
+	}
+
+	snapshotLister, err := restic.MemorizeList(ctx, repo, restic.SnapshotFile)
+	if err != nil {
+		return err
+	}
+	bar := newIndexProgress(gopts.Quiet, gopts.JSON)
+	if err = repo.LoadIndex(ctx, bar); err != nil {
+		return err
+	}
+
+	var snapshots []*restic.Snapshot
+	for sn := range FindFilteredSnapshots(ctx, snapshotLister, repo, &opts.SnapshotFilter, opts.Snapshots) {
+		snapshots = append(snapshots, sn)
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].Time.Before(snapshots[j].Time)
+	})
+
+	g := newGrepper(repo, re)
+	g.before, g.after = opts.BeforeContext, opts.AfterContext
+	if g.before == 0 {
+		g.before = opts.Context
+	}
+	if g.after == 0 {
+		g.after = opts.Context
+	}
+	g.filesOnly = opts.FilesWithMatches
+	out := &grepOutput{
+		JSON:      gopts.JSON,
+		filesOnly: opts.FilesWithMatches,
+		context:   g.before > 0 || g.after > 0,
+	}
+
+	files, matches, errorCount := 0, 0, 0
+	for _, sn := range snapshots {
+		if sn.Tree == nil {
+			return errors.Errorf("snapshot %v has no tree", sn.ID().Str())
+		}
+
+		debug.Log("searching in snapshot %s", sn.ID())
+		err := walker.Walk(ctx, repo, *sn.Tree, walker.WalkVisitor{ProcessNode: func(parentTreeID restic.ID, nodepath string, node *restic.Node, err error) error {
+			if err != nil {
+				Warnf("Unable to load tree %s\n ... which belongs to snapshot %s\n", parentTreeID, sn.ID())
+				errorCount++
+				return walker.ErrSkipNode
+			}
+			if node == nil {
+				return nil
+			}
+
+			selected, childMayBeSelected := selectFilter(nodepath, node.Type == "dir")
+			if node.Type == "dir" && !childMayBeSelected {
+				return walker.ErrSkipNode
+			}
+			if !selected || node.Type != "file" || node.Size == 0 {
+				return nil
+			}
+			if node.Size < minSize || (maxSize > 0 && node.Size > maxSize) {
+				return nil
+			}
+
+			files++
+			res, err := g.searchNode(ctx, node)
+			if err != nil {
+				Warnf("unable to search %v in snapshot %v: %v\n", nodepath, sn.ID().Str(), err)
+				errorCount++
+				return nil
+			}
+			if res != nil {
+				matches++
+				out.Print(sn, nodepath, res)
+			}
+			return nil
+		}})
+		if err != nil {
+			return err
+		}
+	}
+
+	Verbosef("searched %d files with %d distinct contents in %d snapshots, %d files matched\n",
+		files, g.searched, len(snapshots), matches)
+	if errorCount > 0 {
+		return errors.Fatalf("There were %d errors, not all files were searched\n", errorCount)
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/chanhpng/vlbe/internal/restic"
+	rtest "github.com/chanhpng/vlbe/internal/test"
+)
+
+func testRunGrep(t testing.TB, gopts GlobalOptions, opts GrepOptions, pattern string) string {
+	buf, err := withCaptureStdout(func() error {
+		return runGrep(context.TODO(), opts, gopts, []string{pattern})
+	})
+	rtest.OK(t, err)
+	return buf.String()
+}
+
+func TestGrep(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+
+	files := map[string]string{
+		"etc/hosts":    "127.0.0.1 localhost\n::1 localhost\n",
+		"etc/passwd":   "root:x:0:0:root:/root:/bin/sh\n",
+		"copy/hosts":   "127.0.0.1 localhost\n::1 localhost\n",
+		"var/log/big":  strings.Repeat("localhost\n", 1000),
+		"var/data.bin": "localhost\x00\n",
+	}
+	for name, data := range files {
+		p := filepath.Join(env.testdata, filepath.FromSlash(name))
+		rtest.OK(t, os.MkdirAll(filepath.Dir(p), 0755))
+		rtest.OK(t, os.WriteFile(p, []byte(data), 0644))
+	}
+	testRunBackup(t, env.testdata, []string{"."}, BackupOptions{}, env.gopts)
+	snapshotIDs := testListSnapshots(t, env.gopts, 1)
+	prefix := snapshotIDs[0].Str() + ":"
+
+	out := testRunGrep(t, env.gopts, GrepOptions{MaxSize: "1K"}, `^127\.`)
+	rtest.Equals(t, prefix+"/copy/hosts:1:127.0.0.1 localhost\n"+
+		prefix+"/etc/hosts:1:127.0.0.1 localhost\n", out)
+
+	out = testRunGrep(t, env.gopts, GrepOptions{MaxSize: "1K", FilesWithMatches: true}, "LOCALHOST")
+	rtest.Equals(t, "", out)
+
+	opts := GrepOptions{MaxSize: "1K", FilesWithMatches: true, IgnoreCase: true}
+	opts.Excludes = []string{"/copy"}
+	out = testRunGrep(t, env.gopts, opts, "LOCALHOST")
+	rtest.Equals(t, prefix+"/etc/hosts\n"+prefix+"/var/data.bin\n", out)
+
+	out = testRunGrep(t, env.gopts, GrepOptions{MaxSize: "1K"}, "^localhost")
+	rtest.Assert(t, strings.Contains(out, "Binary file "+prefix+"/var/data.bin matches\n"), "missing binary file in %q", out)
+
+	out = testRunGrep(t, env.gopts, GrepOptions{FixedStrings: true, Context: 1}, "::1")
+	rtest.Equals(t, prefix+"/copy/hosts-1-127.0.0.1 localhost\n"+
+		prefix+"/copy/hosts:2:::1 localhost\n"+
+		"--\n"+
+		prefix+"/etc/hosts-1-127.0.0.1 localhost\n"+
+		prefix+"/etc/hosts:2:::1 localhost\n", out)
+
+	opts = GrepOptions{MinSize: "1K"}
+	opts.Includes = []string{"/var/log"}
+	gopts := env.gopts
+	gopts.JSON = true
+	out = testRunGrep(t, gopts, opts, "localhost")
+	var result struct {
+		Path  string
+		Lines []grepLine
+	}
+	rtest.OK(t, json.Unmarshal([]byte(out), &result))
+	rtest.Equals(t, "/var/log/big", result.Path)
+	rtest.Equals(t, 1000, len(result.Lines))
+}
+
+func TestGrepErrors(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testSetupBackupData(t, env)
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, BackupOptions{}, env.gopts)
+	// only keep the packs containing trees
+	removePacksExcept(env.gopts, t, restic.NewIDSet(), false)
+
+	// the search is incomplete, which must be reported
+	_, err := withCaptureStdout(func() error {
+		return runGrep(context.TODO(), GrepOptions{}, env.gopts, []string{"foo"})
+	})
+	rtest.Assert(t, err != nil && strings.Contains(err.Error(), "errors"), "expected error, got %v", err)
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/chanhpng/vlbe/internal/restic"
+	rtest "github.com/chanhpng/vlbe/internal/test"
+)
+
+func TestGrepSearch(t *testing.T) {
+	const text = "one\ntwo\nthree\nfour\nfive\nsix\nseven\neight\nnine"
+
+	for _, test := range []struct {
+		pattern       string
+		before, after int
+		filesOnly     bool
+		input         string
+		want          *grepResult
+	}{
+		{
+			pattern: "nomatch",
+			input:   text,
+		},
+		{
+			pattern: "^t",
+			input:   text,
+			want: &grepResult{Lines: []grepLine{
+				{Number: 2, Text: "two", Match: true},
+				{Number: 3, Text: "three", Match: true},
+			}},
+		},
+		{
+			pattern: "f|nine",
+			before:  1,
+			after:   1,
+			input:   text,
+			want: &grepResult{Lines: []grepLine{
+				{Number: 3, Text: "three"},
+				{Number: 4, Text: "four", Match: true},
+				{Number: 5, Text: "five", Match: true},
+				{Number: 6, Text: "six"},
+				{Number: 8, Text: "eight"},
+				{Number: 9, Text: "nine", Match: true},
+			}},
+		},
+		{
+			pattern:   "e",
+			filesOnly: true,
+			input:     text,
+			want: &grepResult{Lines: []grepLine{
+				{Number: 1, Text: "one", Match: true},
+			}},
+		},
+		{
+			pattern: "two",
+			input:   "one\ntwo\x00\nthree\n",
+			want:    &grepResult{Binary: true},
+		},
+		{
+			pattern: "^$",
+			input:   "\n\nfoo\n",
+			want: &grepResult{Lines: []grepLine{
+				{Number: 1, Text: "", Match: true},
+				{Number: 2, Text: "", Match: true},
+			}},
+		},
+		{
+			pattern: "foo",
+			input:   strings.Repeat("x", maxGrepLineLength+1) + "\nfoo\n",
+			want:    &grepResult{Binary: true},
+		},
+	} {
+		t.Run(test.pattern, func(t *testing.T) {
+			g := newGrepper(nil, regexp.MustCompile(test.pattern))
+			g.before, g.after, g.filesOnly = test.before, test.after, test.filesOnly
+
+			res, err := g.search(strings.NewReader(test.input))
+			rtest.OK(t, err)
+			rtest.Equals(t, test.want, res)
+		})
+	}
+}
+
+func TestGrepPattern(t *testing.T) {
+	re, err := grepPattern("a.b", GrepOptions{FixedStrings: true, IgnoreCase: true})
+	rtest.OK(t, err)
+	rtest.Assert(t, re.MatchString("xA.By"), "fixed string did not match")
+	rtest.Assert(t, !re.MatchString("axb"), "fixed string matched as regular expression")
+
+	_, err = grepPattern("(", GrepOptions{})
+	rtest.Assert(t, err != nil, "invalid pattern was accepted")
+}
+
+func TestGrepIgnoreCaseShorthand(t *testing.T) {
+	rtest.Equals(t, "ignore-case", cmdGrep.Flags().ShorthandLookup("i").Name)
+	rtest.Equals(t, "", cmdGrep.Flags().Lookup("include").Shorthand)
+}
+
+// countingLoader returns the blobs of a map and counts the blobs loaded.
+type countingLoader struct {
+	blobs map[restic.ID][]byte
+	loads int
+}
+
+func (l *countingLoader) LoadBlob(_ context.Context, _ restic.BlobType, id restic.ID, buf []byte) ([]byte, error) {
+	l.loads++
+	return append(buf[:0], l.blobs[id]...), nil
+}
+
+func TestGrepSearchNodeCache(t *testing.T) {
+	data := []byte("foo\nbar\n")
+	id := restic.Hash(data)
+	loader := &countingLoader{blobs: map[restic.ID][]byte{id: data}}
+	g := newGrepper(loader, regexp.MustCompile("bar"))
+
+	node := &restic.Node{Type: "file", Content: restic.IDs{id}}
+	for i := 0; i < 3; i++ {
+		res, err := g.searchNode(context.TODO(), node)
+		rtest.OK(t, err)
+		rtest.Equals(t, &grepResult{Lines: []grepLine{{Number: 2, Text: "bar", Match: true}}}, res)
+	}
+	rtest.Equals(t, 1, loader.loads)
+	rtest.Equals(t, 1, g.searched)
+
+	// results are evicted once too many distinct contents have been searched
+	for i := 0; i < maxGrepResults; i++ {
+		g.results.Add(restic.NewRandomID(), nil)
+	}
+	_, err := g.searchNode(context.TODO(), node)
+	rtest.OK(t, err)
+	rtest.Equals(t, 2, loader.loads)
+	rtest.Equals(t, maxGrepResults, g.results.Len())
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/chanhpng/vlbe/internal/errors"
+	"github.com/chanhpng/vlbe/internal/repository"
+	"github.com/chanhpng/vlbe/internal/restic"
+)
+
+var cmdHold = &cobra.Command{
+	Use:   "hold",
+	Short: "Manage holds on snapshots",
+	Long: `
+The "hold" command allows you to protect snapshots from being removed.
+
+Snapshots which are on hold are kept by "forget" regardless of the policy,
+cannot be removed by "forget" when given explicitly and are not removed by
+"rewrite --forget" or "repair snapshots --forget". These commands save the
+modified snapshot without a hold next to the held one instead. A hold either
+lasts until it is cleared or until the given expiry time.
+`,
+}
+
+func init() {
+	cmdRoot.AddCommand(cmdHold)
+}
+
+// parseHoldTime parses the expiry time of a hold, which is either a date or
+// a timestamp in the local time zone.
+func parseHoldTime(s string) (time.Time, error) {
+	for _, layout := range []string{TimeFormat, "2006-01-02"} {
+		t, err := time.ParseInLocation(layout, s, time.Local)
+		if err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, errors.Fatalf("invalid time %q, expected format %q or %q", s, TimeFormat, "2006-01-02")
+}
+
+// changeHolds runs change for all snapshots selected by filter and args and
+// replaces the snapshots for which change returns true. It returns the number
+// of modified snapshots.
+func changeHolds(ctx context.Context, gopts GlobalOptions, filter *restic.SnapshotFilter, args []string, change func(sn *restic.Snapshot) bool) (int, error) {
+	Verbosef("create exclusive lock for repository\n")
+	ctx, repo, unlock, err := openWithExclusiveLock(ctx, gopts, false)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	// changing holds replaces the snapshot files
+	if err := repo.CheckPermission(repository.PermRemove); err != nil {
+		return 0, err
+	}
+
+	changeCnt := 0
+	for sn := range FindFilteredSnapshots(ctx, repo, repo, filter, args) {
+		if !change(sn) {
+			continue
+		}
+		if err := replaceSnapshot(ctx, repo, sn); err != nil {
+			Warnf("unable to modify the hold for snapshot ID %q, ignoring: %v\n", sn.ID(), err)
+			continue
+		}
+		changeCnt++
+	}
+	return changeCnt, ctx.Err()
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+
+	"github.com/spf13/cobra"
+
+	"github.com/chanhpng/vlbe/internal/restic"
+)
+
+var cmdHoldClear = &cobra.Command{
+	Use:   "clear [flags] [snapshotID ...]",
+	Short: "Remove the hold from snapshots",
+	Long: `
+The "clear" sub-command removes the hold from snapshots, so that they can be
+removed by "forget" again.
+
+When no snapshotID is given, all snapshots matching the host, tag and path filter criteria are modified.
+
+EXIT STATUS
+===========
+
+Exit status is 0 if the command was successful.
+Exit status is 1 if there was any error.
+Exit status is 10 if the repository does not exist.
+Exit status is 11 if the repository is already locked.
+`,
+	DisableAutoGenTag: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runHoldClear(cmd.Context(), holdClearOptions, globalOptions, args)
+	},
+}
+
+// HoldClearOptions bundles all options for the 'hold clear' command.
+type HoldClearOptions struct {
+	restic.SnapshotFilter
+}
+
+var holdClearOptions HoldClearOptions
+
+func init() {
+	cmdHold.AddCommand(cmdHoldClear)
+
+	initMultiSnapshotFilter(cmdHoldClear.Flags(), &holdClearOptions.SnapshotFilter, true)
+}
+
+func runHoldClear(ctx context.Context, opts HoldClearOptions, gopts GlobalOptions, args []string) error {
+	changeCnt, err := changeHolds(ctx, gopts, &opts.SnapshotFilter, args, func(sn *restic.Snapshot) bool {
+		if sn.Hold == nil {
+			return false
+		}
+		sn.Hold = nil
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	if changeCnt == 0 {
+		Verbosef("no snapshots were modified\n")
+	} else {
+		Verbosef("removed the hold from %v snapshots\n", changeCnt)
+	}
+	return nil
+}
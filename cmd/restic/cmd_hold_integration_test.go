@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/chanhpng/vlbe/internal/restic"
+	rtest "github.com/chanhpng/vlbe/internal/test"
+)
+
+func testRunHoldSet(t testing.TB, opts HoldSetOptions, gopts GlobalOptions, args ...string) {
+	rtest.OK(t, runHoldSet(context.TODO(), opts, gopts, args))
+}
+
+func testRunHoldClear(t testing.TB, gopts GlobalOptions, args ...string) {
+	rtest.OK(t, runHoldClear(context.TODO(), HoldClearOptions{}, gopts, args))
+}
+
+func TestHold(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testSetupBackupData(t, env)
+	opts := BackupOptions{Host: "example"}
+	for i := 0; i < 3; i++ {
+		testRunBackup(t, "", []string{filepath.Join(env.testdata, "0", "0", "9")}, opts, env.gopts)
+	}
+	// put the oldest snapshot on hold
+	var oldest *restic.Snapshot
+	for _, id := range testListSnapshots(t, env.gopts, 3) {
+		sn := getSnapshot(t, id, env)
+		if oldest == nil || sn.Time.Before(oldest.Time) {
+			oldest = sn
+		}
+	}
+
+	testRunHoldSet(t, HoldSetOptions{Reason: "case 42"}, env.gopts, oldest.ID().String())
+	ids := testListSnapshots(t, env.gopts, 3)
+	var heldID restic.ID
+	for _, id := range ids {
+		sn := getSnapshot(t, id, env)
+		if sn.Hold != nil {
+			rtest.Equals(t, "case 42", sn.Hold.Reason)
+			rtest.Equals(t, *oldest.ID(), *sn.Original)
+			heldID = id
+		}
+	}
+	rtest.Assert(t, !heldID.IsNull(), "no snapshot is on hold")
+
+	// forget refuses to remove the held snapshot explicitly
+	err := testRunForgetMayFail(env.gopts, ForgetOptions{}, heldID.String())
+	rtest.Assert(t, err != nil && strings.Contains(err.Error(), "on hold (case 42)"), "wrong error message got %v", err)
+	testListSnapshots(t, env.gopts, 3)
+
+	// rewrite --forget keeps the held snapshot next to the rewritten one
+	rewriteOpts := RewriteOptions{
+		Forget:                true,
+		DryRun:                true,
+		excludePatternOptions: excludePatternOptions{Excludes: []string{"*"}},
+	}
+	rtest.OK(t, runRewrite(context.TODO(), rewriteOpts, env.gopts, []string{heldID.String()}))
+	testListSnapshots(t, env.gopts, 3)
+	rewriteOpts.DryRun = false
+	rtest.OK(t, runRewrite(context.TODO(), rewriteOpts, env.gopts, []string{heldID.String()}))
+	ids = testListSnapshots(t, env.gopts, 4)
+	for _, id := range ids {
+		sn := getSnapshot(t, id, env)
+		if sn.Original != nil && sn.Original.Equal(heldID) {
+			rtest.Assert(t, sn.Hold == nil, "rewritten snapshot %v is on hold", id)
+		}
+	}
+	rtest.Assert(t, getSnapshot(t, heldID, env).Hold != nil, "held snapshot lost its hold")
+
+	// the policy keeps the held snapshot in addition to the latest one
+	testRunForget(t, env.gopts, ForgetOptions{
+		Last:    1,
+		GroupBy: restic.SnapshotGroupByOptions{Host: true, Path: true},
+	})
+	ids = testListSnapshots(t, env.gopts, 2)
+	rtest.Assert(t, heldID.Equal(ids[0]) || heldID.Equal(ids[1]), "held snapshot %v was removed", heldID)
+
+	// the held snapshot can be removed after clearing the hold
+	testRunHoldClear(t, env.gopts)
+	ids = testListSnapshots(t, env.gopts, 2)
+	for _, id := range ids {
+		rtest.Assert(t, getSnapshot(t, id, env).Hold == nil, "snapshot %v is still on hold", id)
+	}
+	testRunForget(t, env.gopts, ForgetOptions{
+		Last:    1,
+		GroupBy: restic.SnapshotGroupByOptions{Host: true, Path: true},
+	})
+	testListSnapshots(t, env.gopts, 1)
+}
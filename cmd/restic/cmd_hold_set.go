@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/chanhpng/vlbe/internal/errors"
+	"github.com/chanhpng/vlbe/internal/restic"
+)
+
+var cmdHoldSet = &cobra.Command{
+	Use:   "set [flags] [snapshotID ...]",
+	Short: "Put snapshots on hold",
+	Long: `
+The "set" sub-command puts snapshots on hold, optionally until the time given
+with --until. An existing hold is replaced.
+
+When no snapshotID is given, all snapshots matching the host, tag and path filter criteria are modified.
+
+EXIT STATUS
+===========
+
+Exit status is 0 if the command was successful.
+Exit status is 1 if there was any error.
+Exit status is 10 if the repository does not exist.
+Exit status is 11 if the repository is already locked.
+`,
+	DisableAutoGenTag: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runHoldSet(cmd.Context(), holdSetOptions, globalOptions, args)
+	},
+}
+
+// HoldSetOptions bundles all options for the 'hold set' command.
+type HoldSetOptions struct {
+	restic.SnapshotFilter
+	Until  string
+	Reason string
+}
+
+var holdSetOptions HoldSetOptions
+
+func init() {
+	cmdHold.AddCommand(cmdHoldSet)
+
+	f := cmdHoldSet.Flags()
+	f.StringVar(&holdSetOptions.Until, "until", "", "keep the hold until `time` (format \"2006-01-02\" or \"2006-01-02 15:04:05\"), default: indefinitely")
+	f.StringVar(&holdSetOptions.Reason, "reason", "", "`text` describing the reason for the hold")
+	initMultiSnapshotFilter(f, &holdSetOptions.SnapshotFilter, true)
+}
+
+func runHoldSet(ctx context.Context, opts HoldSetOptions, gopts GlobalOptions, args []string) error {
+	hold := restic.SnapshotHold{Reason: opts.Reason}
+	if opts.Until != "" {
+		until, err := parseHoldTime(opts.Until)
+		if err != nil {
+			return err
+		}
+		if !until.After(time.Now()) {
+			return errors.Fatalf("hold expiry time %v is in the past", opts.Until)
+		}
+		hold.Until = &until
+	}
+
+	changeCnt, err := changeHolds(ctx, gopts, &opts.SnapshotFilter, args, func(sn *restic.Snapshot) bool {
+		if sn.Hold != nil && reflect.DeepEqual(*sn.Hold, hold) {
+			return false
+		}
+		h := hold
+		sn.Hold = &h
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	if changeCnt == 0 {
+		Verbosef("no snapshots were modified\n")
+	} else {
+		Verbosef("put %v snapshots %v\n", changeCnt, hold)
+	}
+	return nil
+}
@@ -9,7 +9,7 @@ import (
 	"github.com/chanhpng/vlbe/internal/errors"
 	"github.com/chanhpng/vlbe/internal/repository"
 	"github.com/chanhpng/vlbe/internal/restic"
-	"github.com/restic/chunker"
+	"github.com/chanhpng/vlbe/internal/ui"
 
 	"github.com/spf13/cobra"
 )
@@ -20,6 +20,37 @@ var cmdInit = &cobra.Command{
 	Long: `
 The "init" command initializes a new repository.
 
+With --append-only, data can only be removed from the repository using an
+admin key. This affects the commands forget, prune, repair, tag, and rewrite
+with --forget. The key created by "init" is an admin key, further admin keys
+can be added using "key add --role admin".
+
+The append-only mode is advisory only: it is enforced by this version of
+restic, but older versions ignore it and can still remove data using any key.
+It does not protect against an attacker who has access to the storage. Use
+the append-only mode of the storage, for example "serve rest --append-only",
+for that.
+
+The sizes of the chunks files are split into can be configured using
+"--chunk-min", "--chunk-avg" and "--chunk-max". Larger chunks reduce the size of
+the metadata for large files such as VM images or database dumps, at the cost
+of less deduplication. The chunker parameters cannot be changed afterwards.
+Use "--copy-chunker-params" to copy them from another repository, which is
+required for deduplication of data copied between repositories.
+
+Repository format version 3, which is selected by "--repository-version latest",
+only stores device IDs for hardlinked files. This avoids creating new metadata
+when device IDs change, for example for btrfs subvolumes. Existing repositories
+can be upgraded using "migrate upgrade_repo_v3".
+
+The KDF used to derive the master key from the password can be selected using
+"--kdf", either "scrypt" (the default) or "argon2id".
+
+A repository can be mirrored to several locations using a location like
+"mirror:/srv/restic|sftp:host:/srv/restic". All files are written to each of
+the locations, and read from the first one which contains them. All commands
+accept such a location for "--repo".
+
 EXIT STATUS
 ===========
 
@@ -37,6 +68,11 @@ type InitOptions struct {
 	secondaryRepoOptions
 	CopyChunkerParameters bool
 	RepositoryVersion     string
+	AppendOnly            bool
+	KDF                   string
+	ChunkMinSize          string
+	ChunkAvgSize          string
+	ChunkMaxSize          string
 }
 
 var initOptions InitOptions
@@ -48,6 +84,11 @@ func init() {
 	initSecondaryRepoOptions(f, &initOptions.secondaryRepoOptions, "secondary", "to copy chunker parameters from")
 	f.BoolVar(&initOptions.CopyChunkerParameters, "copy-chunker-params", false, "copy chunker parameters from the secondary repository (useful with the copy command)")
 	f.StringVar(&initOptions.RepositoryVersion, "repository-version", "stable", "repository format version to use, allowed values are a format version, 'latest' and 'stable'")
+	f.BoolVar(&initOptions.AppendOnly, "append-only", false, "only allow admin keys to remove data from the repository (advisory, not enforced by older clients)")
+	f.StringVar(&initOptions.ChunkMinSize, "chunk-min", "", "minimum `size` of data chunks (allowed suffixes: k/K, m/M) (default: 512K)")
+	f.StringVar(&initOptions.ChunkAvgSize, "chunk-avg", "", "average `size` of data chunks, must be a power of two (allowed suffixes: k/K, m/M) (default: 1M)")
+	f.StringVar(&initOptions.ChunkMaxSize, "chunk-max", "", "maximum `size` of data chunks (allowed suffixes: k/K, m/M) (default: 8M)")
+	f.StringVar(&initOptions.KDF, "kdf", "", "`kdf` used to derive the master key from the password (scrypt or argon2id) (default: scrypt)")
 }
 
 func runInit(ctx context.Context, opts InitOptions, gopts GlobalOptions, args []string) error {
@@ -71,11 +112,30 @@ func runInit(ctx context.Context, opts InitOptions, gopts GlobalOptions, args []
 		return errors.Fatalf("only repository versions between %v and %v are allowed", restic.MinRepoVersion, restic.MaxRepoVersion)
 	}
 
-	chunkerPolynomial, err := maybeReadChunkerPolynomial(ctx, opts, gopts)
+	kdf, err := repository.ParseKDF(opts.KDF)
 	if err != nil {
 		return err
 	}
 
+	initOpts := repository.InitOptions{
+		AppendOnly: opts.AppendOnly,
+		KDF:        kdf,
+	}
+	if err := parseChunkSizes(opts, &initOpts); err != nil {
+		return err
+	}
+
+	otherCfg, err := maybeReadChunkerParams(ctx, opts, gopts)
+	if err != nil {
+		return err
+	}
+	if otherCfg != nil {
+		initOpts.ChunkerPolynomial = &otherCfg.ChunkerPolynomial
+		initOpts.ChunkerMinSize = otherCfg.ChunkerMinSize
+		initOpts.ChunkerAvgSize = otherCfg.ChunkerAvgSize
+		initOpts.ChunkerMaxSize = otherCfg.ChunkerMaxSize
+	}
+
 	gopts.Repo, err = ReadRepo(gopts)
 	if err != nil {
 		return err
@@ -101,14 +161,14 @@ func runInit(ctx context.Context, opts InitOptions, gopts GlobalOptions, args []
 		return errors.Fatal(err.Error())
 	}
 
-	err = s.Init(ctx, version, gopts.password, chunkerPolynomial)
+	err = s.Init(ctx, version, gopts.password, initOpts)
 	if err != nil {
 		return errors.Fatalf("create key in repository at %s failed: %v\n", location.StripPassword(gopts.backends, gopts.Repo), err)
 	}
 
 	if !gopts.JSON {
 		Verbosef("created restic repository %v at %s", s.Config().ID[:10], location.StripPassword(gopts.backends, gopts.Repo))
-		if opts.CopyChunkerParameters && chunkerPolynomial != nil {
+		if otherCfg != nil {
 			Verbosef(" with chunker parameters copied from secondary repository\n")
 		} else {
 			Verbosef("\n")
@@ -130,7 +190,46 @@ func runInit(ctx context.Context, opts InitOptions, gopts GlobalOptions, args []
 	return nil
 }
 
-func maybeReadChunkerPolynomial(ctx context.Context, opts InitOptions, gopts GlobalOptions) (*chunker.Pol, error) {
+// parseChunkSizes parses and validates the chunk sizes passed on the command
+// line and stores them in initOpts.
+func parseChunkSizes(opts InitOptions, initOpts *repository.InitOptions) error {
+	for _, size := range []struct {
+		name   string
+		value  string
+		target *uint
+	}{
+		{"--chunk-min", opts.ChunkMinSize, &initOpts.ChunkerMinSize},
+		{"--chunk-avg", opts.ChunkAvgSize, &initOpts.ChunkerAvgSize},
+		{"--chunk-max", opts.ChunkMaxSize, &initOpts.ChunkerMaxSize},
+	} {
+		if size.value == "" {
+			continue
+		}
+		if opts.CopyChunkerParameters {
+			return errors.Fatalf("%v cannot be used together with --copy-chunker-params", size.name)
+		}
+
+		bytes, err := ui.ParseBytes(size.value)
+		if err != nil || bytes <= 0 {
+			return errors.Fatalf("invalid size for %v: %q", size.name, size.value)
+		}
+		*size.target = uint(bytes)
+	}
+
+	cfg := restic.Config{
+		ChunkerMinSize: initOpts.ChunkerMinSize,
+		ChunkerAvgSize: initOpts.ChunkerAvgSize,
+		ChunkerMaxSize: initOpts.ChunkerMaxSize,
+	}
+	if err := cfg.ChunkerParams().Check(); err != nil {
+		return errors.Fatal(err.Error())
+	}
+	return nil
+}
+
+// maybeReadChunkerParams returns the config of the secondary repository if
+// the chunker parameters should be copied from it.
+func maybeReadChunkerParams(ctx context.Context, opts InitOptions, gopts GlobalOptions) (*restic.Config, error) {
 	if opts.CopyChunkerParameters {
 		otherGopts, _, err := fillSecondaryGlobalOpts(ctx, opts.secondaryRepoOptions, gopts, "secondary")
 		if err != nil {
@@ -142,8 +241,8 @@ func maybeReadChunkerPolynomial(ctx context.Context, opts InitOptions, gopts Glo
 			return nil, err
 		}
 
-		pol := otherRepo.Config().ChunkerPolynomial
-		return &pol, nil
+		cfg := otherRepo.Config()
+		return &cfg, nil
 	}
 
 	if opts.Repo != "" || opts.RepositoryFile != "" || opts.LegacyRepo != "" || opts.LegacyRepositoryFile != "" {
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"path/filepath"
 	"testing"
 
 	"github.com/chanhpng/vlbe/internal/repository"
@@ -24,7 +25,7 @@ func TestInitCopyChunkerParams(t *testing.T) {
 	env2, cleanup2 := withTestEnvironment(t)
 	defer cleanup2()
 
-	testRunInit(t, env2.gopts)
+	rtest.OK(t, runInit(context.TODO(), InitOptions{ChunkAvgSize: "2M", ChunkMaxSize: "16M"}, env2.gopts, nil))
 
 	initOpts := InitOptions{
 		secondaryRepoOptions: secondaryRepoOptions{
@@ -46,4 +47,40 @@ func TestInitCopyChunkerParams(t *testing.T) {
 	rtest.Assert(t, repo.Config().ChunkerPolynomial == otherRepo.Config().ChunkerPolynomial,
 		"expected equal chunker polynomials, got %v expected %v", repo.Config().ChunkerPolynomial,
 		otherRepo.Config().ChunkerPolynomial)
+	rtest.Equals(t, otherRepo.Config().ChunkerParams(), repo.Config().ChunkerParams())
+}
+
+func TestInitChunkSizes(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	for _, opts := range []InitOptions{
+		{ChunkMinSize: "1K"},
+		{ChunkAvgSize: "3M"},
+		{ChunkMinSize: "2M", ChunkAvgSize: "1M"},
+		{ChunkMaxSize: "invalid"},
+		{ChunkAvgSize: "2M", CopyChunkerParameters: true},
+	} {
+		rtest.Assert(t, runInit(context.TODO(), opts, env.gopts, nil) != nil, "expected invalid init options %+v to fail", opts)
+	}
+
+	rtest.OK(t, runInit(context.TODO(), InitOptions{ChunkMinSize: "64K", ChunkAvgSize: "128K", ChunkMaxSize: "256K"}, env.gopts, nil))
+
+	repo, err := OpenRepository(context.TODO(), env.gopts)
+	rtest.OK(t, err)
+	params := repo.Config().ChunkerParams()
+	rtest.Equals(t, []uint{64 << 10, 128 << 10, 256 << 10}, []uint{params.MinSize, params.AvgSize, params.MaxSize})
+
+	rtest.SetupTarTestFixture(t, env.testdata, filepath.Join("testdata", "backup-data.tar.gz"))
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, BackupOptions{}, env.gopts)
+	testRunCheck(t, env.gopts)
+
+	repo, err = OpenRepository(context.TODO(), env.gopts)
+	rtest.OK(t, err)
+	rtest.OK(t, repo.LoadIndex(context.TODO(), nil))
+	rtest.OK(t, repo.ListBlobs(context.TODO(), func(pb restic.PackedBlob) {
+		if pb.Type == restic.DataBlob {
+			rtest.Assert(t, pb.DataLength() <= params.MaxSize, "blob %v is larger than the maximum chunk size", pb.ID)
+		}
+	}))
 }
@@ -16,6 +16,24 @@ var cmdKeyAdd = &cobra.Command{
 	Long: `
 The "add" sub-command creates a new key and validates the key. Returns the new key ID.
 
+The operations permitted by the new key can be restricted using "--role":
+
+ * "read-only" keys can read, but not modify the repository.
+ * "backup-only" keys can create snapshots and list them, but cannot read file
+   contents (e.g. using "restore" or "dump") or remove data (e.g. using
+   "forget" or "prune").
+ * "admin" keys permit all operations, including removing data from
+   append-only repositories.
+
+Keys without a role permit all operations, except removing data from
+append-only repositories. Read-only and backup-only keys cannot add keys.
+The role is stored unencrypted in the key file and is enforced by restic, it
+does not protect the repository against modified clients.
+
+The KDF used to derive the key from the password can be selected using
+"--kdf". Besides the default "scrypt", "argon2id" is supported. The KDF
+parameters are calibrated for the current hardware.
+
 EXIT STATUS
 ===========
 
@@ -32,6 +50,8 @@ type KeyAddOptions struct {
 	InsecureNoPassword bool
 	Username           string
 	Hostname           string
+	Role               string
+	KDF                string
 }
 
 func (opts *KeyAddOptions) Add(flags *pflag.FlagSet) {
@@ -39,6 +59,8 @@ func (opts *KeyAddOptions) Add(flags *pflag.FlagSet) {
 	flags.BoolVar(&opts.InsecureNoPassword, "new-insecure-no-password", false, "add an empty password for the repository (insecure)")
 	flags.StringVarP(&opts.Username, "user", "", "", "the username for new key")
 	flags.StringVarP(&opts.Hostname, "host", "", "", "the hostname for new key")
+	flags.StringVar(&opts.Role, "role", "", "restrict the new key to `role` (read-only, backup-only or admin)")
+	flags.StringVar(&opts.KDF, "kdf", "", "`kdf` used to derive the new key from the password (scrypt or argon2id) (default: scrypt)")
 }
 
 func init() {
@@ -66,12 +88,29 @@ func runKeyAdd(ctx context.Context, gopts GlobalOptions, opts KeyAddOptions, arg
 }
 
 func addKey(ctx context.Context, repo *repository.Repository, gopts GlobalOptions, opts KeyAddOptions) error {
+	role, err := repository.ParseKeyRole(opts.Role)
+	if err != nil {
+		return err
+	}
+	kdf, err := repository.ParseKDF(opts.KDF)
+	if err != nil {
+		return err
+	}
+	if err := repo.CheckPermission(repository.PermManageKeys); err != nil {
+		return err
+	}
+	if role == repository.RoleAdmin {
+		if err := repo.CheckPermission(repository.PermRemove); err != nil {
+			return err
+		}
+	}
+
 	pw, err := getNewPassword(ctx, gopts, opts.NewPasswordFile, opts.InsecureNoPassword)
 	if err != nil {
 		return err
 	}
 
-	id, err := repository.AddKey(ctx, repo, pw, opts.Username, opts.Hostname, repo.Key())
+	id, err := repository.AddKey(ctx, repo, pw, opts.Username, opts.Hostname, role, kdf, repo.Key())
 	if err != nil {
 		return errors.Fatalf("creating new key failed: %v\n", err)
 	}
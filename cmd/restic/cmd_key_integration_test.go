@@ -10,6 +10,7 @@ import (
 	"testing"
 
 	"github.com/chanhpng/vlbe/internal/backend"
+	"github.com/chanhpng/vlbe/internal/errors"
 	"github.com/chanhpng/vlbe/internal/repository"
 	rtest "github.com/chanhpng/vlbe/internal/test"
 )
@@ -212,3 +213,88 @@ func TestKeyCommandInvalidArguments(t *testing.T) {
 	t.Log(err)
 	rtest.Assert(t, err != nil && strings.Contains(err.Error(), "one argument"), "unexpected error for key remove: %v", err)
 }
+
+func TestKeyRoles(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	// must list keys more than once
+	env.gopts.backendTestHook = nil
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+	rtest.SetupTarTestFixture(t, env.testdata, filepath.Join("testdata", "backup-data.tar.gz"))
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, BackupOptions{}, env.gopts)
+	snapshotID := testListSnapshots(t, env.gopts, 1)[0]
+
+	addKey := func(password, role string) GlobalOptions {
+		testKeyNewPassword = password
+		defer func() {
+			testKeyNewPassword = ""
+		}()
+		rtest.OK(t, runKeyAdd(context.TODO(), env.gopts, KeyAddOptions{Role: role}, []string{}))
+
+		gopts := env.gopts
+		gopts.password = password
+		return gopts
+	}
+
+	// backup-only keys can create and list snapshots
+	backupOnly := addKey("backup-only password", "backup-only")
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, BackupOptions{}, backupOnly)
+	testListSnapshots(t, backupOnly, 2)
+
+	err := testRunRestoreAssumeFailure(snapshotID.String(), RestoreOptions{Target: filepath.Join(env.base, "restore")}, backupOnly)
+	rtest.Assert(t, errors.Is(err, repository.ErrReadNotPermitted), "unexpected error %v", err)
+	err = testRunForgetMayFail(backupOnly, ForgetOptions{}, snapshotID.String())
+	rtest.Assert(t, errors.Is(err, repository.ErrRemoveNotPermitted), "unexpected error %v", err)
+
+	// read-only keys can restore, but not modify the repository
+	readOnly := addKey("read-only password", "read-only")
+	testRunRestore(t, readOnly, filepath.Join(env.base, "restore"), snapshotID)
+	err = testRunBackupAssumeFailure(t, filepath.Dir(env.testdata), []string{"testdata"}, BackupOptions{}, readOnly)
+	rtest.Assert(t, errors.Is(err, repository.ErrWriteNotPermitted), "unexpected error %v", err)
+
+	testKeyNewPassword = "other password"
+	defer func() {
+		testKeyNewPassword = ""
+	}()
+	err = runKeyAdd(context.TODO(), readOnly, KeyAddOptions{}, []string{})
+	rtest.Assert(t, errors.Is(err, repository.ErrManageKeysNotPermitted), "unexpected error %v", err)
+	err = runKeyAdd(context.TODO(), env.gopts, KeyAddOptions{Role: "invalid"}, []string{})
+	rtest.Assert(t, err != nil && strings.Contains(err.Error(), "invalid key role"), "unexpected error %v", err)
+
+	testListSnapshots(t, env.gopts, 2)
+}
+
+func TestKeyKDF(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	// must list keys more than once
+	env.gopts.backendTestHook = nil
+	defer cleanup()
+
+	rtest.OK(t, runInit(context.TODO(), InitOptions{KDF: "argon2id"}, env.gopts, nil))
+
+	keyKDF := func(password string) string {
+		repo, err := OpenRepository(context.TODO(), env.gopts)
+		rtest.OK(t, err)
+		key, err := repository.SearchKey(context.TODO(), repo, password, 0, "")
+		rtest.OK(t, err)
+		return key.KDF
+	}
+	rtest.Equals(t, repository.KDFArgon2id, keyKDF(env.gopts.password))
+
+	// changing the password keeps the KDF
+	testRunKeyPasswd(t, "geheim2", env.gopts)
+	env.gopts.password = "geheim2"
+	rtest.Equals(t, repository.KDFArgon2id, keyKDF(env.gopts.password))
+
+	testKeyNewPassword = "scrypt password"
+	defer func() {
+		testKeyNewPassword = ""
+	}()
+	rtest.OK(t, runKeyAdd(context.TODO(), env.gopts, KeyAddOptions{KDF: "scrypt"}, []string{}))
+	rtest.Equals(t, repository.KDFScrypt, keyKDF("scrypt password"))
+
+	err := runKeyAdd(context.TODO(), env.gopts, KeyAddOptions{KDF: "invalid"}, []string{})
+	rtest.Assert(t, err != nil && strings.Contains(err.Error(), "invalid KDF"), "unexpected error %v", err)
+	testRunCheck(t, env.gopts)
+}
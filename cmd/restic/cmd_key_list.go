@@ -59,6 +59,8 @@ func listKeys(ctx context.Context, s *repository.Repository, gopts GlobalOptions
 		ShortID  string `json:"-"`
 		UserName string `json:"userName"`
 		HostName string `json:"hostName"`
+		Role     string `json:"role,omitempty"`
+		KDF      string `json:"kdf"`
 		Created  string `json:"created"`
 	}
 
@@ -78,6 +80,8 @@ func listKeys(ctx context.Context, s *repository.Repository, gopts GlobalOptions
 			ShortID:  id.Str(),
 			UserName: k.Username,
 			HostName: k.Hostname,
+			Role:     string(k.Role),
+			KDF:      k.KDF,
 			Created:  k.Created.Local().Format(TimeFormat),
 		}
 
@@ -99,6 +103,7 @@ func listKeys(ctx context.Context, s *repository.Repository, gopts GlobalOptions
 	tab.AddColumn(" ID", "{{if .Current}}*{{else}} {{end}}{{ .ShortID }}")
 	tab.AddColumn("User", "{{ .UserName }}")
 	tab.AddColumn("Host", "{{ .HostName }}")
+	tab.AddColumn("Role", "{{ .Role }}")
 	tab.AddColumn("Created", "{{ .Created }}")
 
 	for _, key := range keys {
@@ -16,6 +16,9 @@ var cmdKeyPasswd = &cobra.Command{
 The "passwd" sub-command creates a new key, validates the key and remove the old key ID.
 Returns the new key ID. 
 
+The new key keeps the role and KDF of the old key, unless "--role" or "--kdf"
+are specified.
+
 EXIT STATUS
 ===========
 
@@ -56,12 +59,35 @@ func runKeyPasswd(ctx context.Context, gopts GlobalOptions, opts KeyPasswdOption
 }
 
 func changePassword(ctx context.Context, repo *repository.Repository, gopts GlobalOptions, opts KeyPasswdOptions) error {
+	if err := repo.CheckPermission(repository.PermManageKeys); err != nil {
+		return err
+	}
+	// the old key cannot be removed afterwards
+	if err := repo.CheckPermission(repository.PermRemove); err != nil {
+		return err
+	}
+
+	var err error
+	role, kdf := repo.KeyRole(), repo.KeyKDF()
+	if opts.Role != "" {
+		role, err = repository.ParseKeyRole(opts.Role)
+		if err != nil {
+			return err
+		}
+	}
+	if opts.KDF != "" {
+		kdf, err = repository.ParseKDF(opts.KDF)
+		if err != nil {
+			return err
+		}
+	}
+
 	pw, err := getNewPassword(ctx, gopts, opts.NewPasswordFile, opts.InsecureNoPassword)
 	if err != nil {
 		return err
 	}
 
-	id, err := repository.AddKey(ctx, repo, pw, "", "", repo.Key())
+	id, err := repository.AddKey(ctx, repo, pw, "", "", role, kdf, repo.Key())
 	if err != nil {
 		return errors.Fatalf("creating new key failed: %v\n", err)
 	}
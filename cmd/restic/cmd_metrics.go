@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/chanhpng/vlbe/internal/restic"
+)
+
+var cmdMetrics = &cobra.Command{
+	Use:   "metrics [flags] [snapshotID ...]",
+	Short: "Export snapshot and repository statistics as OpenMetrics",
+	Long: `
+The "metrics" command prints statistics about the snapshots and the repository
+in the OpenMetrics text format. The output can be used with the textfile
+collector of the Prometheus node exporter, for example to alert on stale
+backups.
+
+For every group of snapshots (see "--group-by"), the number of snapshots, the
+time and age of the latest snapshot and the statistics recorded for the latest
+backup are reported. In addition, the number and size of blobs and pack files
+in the repository are reported.
+
+Use "--metrics-file" to atomically replace a file with the metrics instead of
+printing them. The "backup", "check" and "prune" commands accept the same
+option to report statistics about their run.
+
+EXIT STATUS
+===========
+
+Exit status is 0 if the command was successful.
+Exit status is 1 if there was any error.
+Exit status is 10 if the repository does not exist.
+Exit status is 11 if the repository is already locked.
+`,
+	DisableAutoGenTag: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runMetrics(cmd.Context(), metricsOptions, globalOptions, args)
+	},
+}
+
+// MetricsOptions bundles all options for the metrics command.
+type MetricsOptions struct {
+	restic.SnapshotFilter
+	GroupBy     restic.SnapshotGroupByOptions
+	MetricsFile string
+}
+
+var metricsOptions MetricsOptions
+
+func init() {
+	cmdRoot.AddCommand(cmdMetrics)
+
+	f := cmdMetrics.Flags()
+	initMultiSnapshotFilter(f, &metricsOptions.SnapshotFilter, true)
+	metricsOptions.GroupBy = restic.SnapshotGroupByOptions{Host: true, Path: true}
+	f.VarP(&metricsOptions.GroupBy, "group-by", "g", "`group` snapshots by host, paths and/or tags, separated by comma")
+	initMetricsFileFlag(f, &metricsOptions.MetricsFile)
+}
+
+func runMetrics(ctx context.Context, opts MetricsOptions, gopts GlobalOptions, args []string) error {
+	ctx, repo, unlock, err := openWithReadLock(ctx, gopts, gopts.NoLock)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	var snapshots restic.Snapshots
+	for sn := range FindFilteredSnapshots(ctx, repo, repo, &opts.SnapshotFilter, args) {
+		snapshots = append(snapshots, sn)
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	bar := newIndexProgress(true, gopts.JSON)
+	if err = repo.LoadIndex(ctx, bar); err != nil {
+		return err
+	}
+
+	m := newMetricSet()
+	if err := addSnapshotMetrics(m, snapshots, opts.GroupBy, time.Now()); err != nil {
+		return err
+	}
+
+	var blobs [restic.NumBlobTypes]struct {
+		count            uint64
+		size, sizeInRepo uint64
+	}
+	err = repo.ListBlobs(ctx, func(pb restic.PackedBlob) {
+		b := &blobs[pb.Type]
+		b.count++
+		b.size += uint64(pb.DataLength())
+		b.sizeInRepo += uint64(pb.Length)
+	})
+	if err != nil {
+		return err
+	}
+
+	var packCount, packSize uint64
+	err = repo.List(ctx, restic.PackFile, func(_ restic.ID, size int64) error {
+		packCount++
+		packSize += uint64(size)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	m.Gauge("restic_repository_info", "Information about the repository", 1,
+		"id", repo.Config().ID, "version", strconv.FormatUint(uint64(repo.Config().Version), 10))
+	m.Gauge("restic_repository_snapshots", "Number of snapshots matching the filter", float64(len(snapshots)))
+	for _, t := range []restic.BlobType{restic.DataBlob, restic.TreeBlob} {
+		b := blobs[t]
+		m.Gauge("restic_repository_blobs", "Number of blobs in the repository", float64(b.count), "type", t.String())
+		m.Gauge("restic_repository_blob_size_bytes", "Uncompressed size of the blobs in the repository", float64(b.size), "type", t.String())
+		m.Gauge("restic_repository_blob_stored_size_bytes", "Stored size of the blobs in the repository", float64(b.sizeInRepo), "type", t.String())
+	}
+	m.Gauge("restic_repository_packs", "Number of pack files in the repository", float64(packCount))
+	m.Gauge("restic_repository_pack_size_bytes", "Total size of the pack files in the repository", float64(packSize))
+
+	if opts.MetricsFile != "" {
+		return writeMetricsFile(opts.MetricsFile, m)
+	}
+	_, err = m.WriteTo(gopts.stdout)
+	return err
+}
+
+// addSnapshotMetrics adds statistics about the latest snapshot of each group
+// to m.
+func addSnapshotMetrics(m *metricSet, snapshots restic.Snapshots, groupBy restic.SnapshotGroupByOptions, now time.Time) error {
+	groups, _, err := restic.GroupSnapshots(snapshots, groupBy)
+	if err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(groups))
+	for k := range groups {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		list := groups[k]
+		var key restic.SnapshotGroupKey
+		if err := json.Unmarshal([]byte(k), &key); err != nil {
+			return err
+		}
+		labels := []string{
+			"host", key.Hostname,
+			"paths", strings.Join(key.Paths, ","),
+			"tags", strings.Join(key.Tags, ","),
+		}
+
+		latest := list[0]
+		for _, sn := range list[1:] {
+			if sn.Time.After(latest.Time) {
+				latest = sn
+			}
+		}
+
+		m.Gauge("restic_snapshots", "Number of snapshots in the group", float64(len(list)), labels...)
+		m.Timestamp("restic_snapshot_latest_timestamp_seconds", "Time of the latest snapshot in the group", latest.Time, labels...)
+		m.Gauge("restic_snapshot_latest_age_seconds", "Age of the latest snapshot in the group", now.Sub(latest.Time).Seconds(), labels...)
+
+		if s := latest.Summary; s != nil {
+			m.Gauge("restic_snapshot_latest_duration_seconds", "Duration of the backup which created the latest snapshot", s.BackupEnd.Sub(s.BackupStart).Seconds(), labels...)
+			m.Gauge("restic_snapshot_latest_files_new", "Number of new files in the latest snapshot", float64(s.FilesNew), labels...)
+			m.Gauge("restic_snapshot_latest_files_changed", "Number of changed files in the latest snapshot", float64(s.FilesChanged), labels...)
+			m.Gauge("restic_snapshot_latest_files_processed", "Number of files in the latest snapshot", float64(s.TotalFilesProcessed), labels...)
+			m.Gauge("restic_snapshot_latest_size_bytes", "Size of the files in the latest snapshot", float64(s.TotalBytesProcessed), labels...)
+			m.Gauge("restic_snapshot_latest_data_added_bytes", "Uncompressed data added by the latest snapshot", float64(s.DataAdded), labels...)
+			m.Gauge("restic_snapshot_latest_data_added_packed_bytes", "Stored data added by the latest snapshot", float64(s.DataAddedPacked), labels...)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/chanhpng/vlbe/internal/restic"
+	rtest "github.com/chanhpng/vlbe/internal/test"
+	"github.com/chanhpng/vlbe/internal/ui/termstatus"
+)
+
+func testRunMetrics(t testing.TB, gopts GlobalOptions, opts MetricsOptions) string {
+	buf := bytes.NewBuffer(nil)
+	gopts.stdout = buf
+	rtest.OK(t, runMetrics(context.TODO(), opts, gopts, nil))
+	return buf.String()
+}
+
+func readMetricsFile(t testing.TB, filename string) string {
+	buf, err := os.ReadFile(filename)
+	rtest.OK(t, err)
+	rtest.Assert(t, strings.HasSuffix(string(buf), "# EOF\n"), "metrics file %v is not terminated by EOF marker", filename)
+	return string(buf)
+}
+
+func assertMetric(t testing.TB, metrics, prefix string) {
+	t.Helper()
+	for _, line := range strings.Split(metrics, "\n") {
+		if strings.HasPrefix(line, prefix) {
+			return
+		}
+	}
+	t.Errorf("metric %q not found in\n%v", prefix, metrics)
+}
+
+func TestMetrics(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+	rtest.SetupTarTestFixture(t, env.testdata, filepath.Join("testdata", "backup-data.tar.gz"))
+
+	backupMetrics := filepath.Join(env.base, "backup.prom")
+	opts := BackupOptions{Host: "example", MetricsFile: backupMetrics}
+	testRunBackup(t, "", []string{env.testdata}, opts, env.gopts)
+
+	metrics := readMetricsFile(t, backupMetrics)
+	assertMetric(t, metrics, `restic_backup_success{host="example",paths="`+env.testdata+`"} 1`)
+	assertMetric(t, metrics, `restic_backup_errors{host="example",paths="`+env.testdata+`"} 0`)
+	assertMetric(t, metrics, `restic_backup_files{host="example",paths="`+env.testdata+`",state="new"} `)
+
+	checkMetrics := filepath.Join(env.base, "check.prom")
+	rtest.OK(t, withTermStatus(env.gopts, func(ctx context.Context, term *termstatus.Terminal) error {
+		return runCheck(ctx, CheckOptions{MetricsFile: checkMetrics}, env.gopts, nil, term)
+	}))
+	metrics = readMetricsFile(t, checkMetrics)
+	assertMetric(t, metrics, "restic_check_success 1")
+	assertMetric(t, metrics, "restic_check_errors 0")
+
+	metrics = testRunMetrics(t, env.gopts, MetricsOptions{GroupBy: restic.SnapshotGroupByOptions{Host: true, Path: true}})
+	labels := `{host="example",paths="` + env.testdata + `",tags=""}`
+	assertMetric(t, metrics, "restic_snapshots"+labels+" 1")
+	assertMetric(t, metrics, "restic_snapshot_latest_age_seconds"+labels+" ")
+	assertMetric(t, metrics, "restic_snapshot_latest_data_added_bytes"+labels+" ")
+	assertMetric(t, metrics, `restic_repository_blobs{type="data"} `)
+	assertMetric(t, metrics, "restic_repository_packs ")
+
+	pruneMetrics := filepath.Join(env.base, "prune.prom")
+	testRunForget(t, env.gopts, ForgetOptions{UnsafeAllowRemoveAll: true, SnapshotFilter: restic.SnapshotFilter{Hosts: []string{"example"}}})
+	testRunPrune(t, env.gopts, PruneOptions{MaxUnused: "0", MetricsFile: pruneMetrics})
+	metrics = readMetricsFile(t, pruneMetrics)
+	assertMetric(t, metrics, "restic_prune_success 1")
+	assertMetric(t, metrics, "restic_prune_remaining_bytes 0")
+
+	// runs which fail before saving a snapshot or pruning also report a failure
+	missingRepo := env.gopts
+	missingRepo.Repo = filepath.Join(env.base, "missing")
+	err := testRunBackupAssumeFailure(t, "", []string{env.testdata}, opts, missingRepo)
+	rtest.Assert(t, err != nil, "backup to a missing repository should fail")
+	metrics = readMetricsFile(t, backupMetrics)
+	assertMetric(t, metrics, `restic_backup_success{host="example",paths="`+env.testdata+`"} 0`)
+
+	err = withTermStatus(missingRepo, func(ctx context.Context, term *termstatus.Terminal) error {
+		return runPrune(ctx, PruneOptions{MaxUnused: "0", MetricsFile: pruneMetrics}, missingRepo, term)
+	})
+	rtest.Assert(t, err != nil, "prune of a missing repository should fail")
+	metrics = readMetricsFile(t, pruneMetrics)
+	assertMetric(t, metrics, "restic_prune_success 0")
+}
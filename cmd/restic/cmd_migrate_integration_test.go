@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	rtest "github.com/chanhpng/vlbe/internal/test"
+	"github.com/chanhpng/vlbe/internal/ui/termstatus"
+)
+
+func testRunMigrate(t testing.TB, gopts GlobalOptions, migrations ...string) {
+	rtest.OK(t, withTermStatus(gopts, func(ctx context.Context, term *termstatus.Terminal) error {
+		return runMigrate(ctx, MigrateOptions{}, gopts, migrations, term)
+	}))
+}
+
+func TestMigrateUpgradeRepoV3(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	rtest.OK(t, runInit(context.TODO(), InitOptions{RepositoryVersion: "1"}, env.gopts, nil))
+	rtest.SetupTarTestFixture(t, env.testdata, filepath.Join("testdata", "backup-data.tar.gz"))
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, BackupOptions{}, env.gopts)
+
+	testRunMigrate(t, env.gopts, "upgrade_repo_v2")
+	testRunMigrate(t, env.gopts, "upgrade_repo_v3")
+
+	repo, err := OpenRepository(context.TODO(), env.gopts)
+	rtest.OK(t, err)
+	rtest.Equals(t, uint(3), repo.Config().Version)
+
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, BackupOptions{}, env.gopts)
+	testListSnapshots(t, env.gopts, 2)
+	testRunCheck(t, env.gopts)
+}
+
+func TestInitRepoV3(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	rtest.OK(t, runInit(context.TODO(), InitOptions{RepositoryVersion: "latest"}, env.gopts, nil))
+	rtest.SetupTarTestFixture(t, env.testdata, filepath.Join("testdata", "backup-data.tar.gz"))
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, BackupOptions{}, env.gopts)
+	testRunCheck(t, env.gopts)
+
+	repo, err := OpenRepository(context.TODO(), env.gopts)
+	rtest.OK(t, err)
+	rtest.Equals(t, uint(3), repo.Config().Version)
+}
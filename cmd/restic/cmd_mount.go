@@ -6,6 +6,7 @@ package main
 import (
 	"context"
 	"os"
+	"sort"
 	"strings"
 	"time"
 
@@ -13,6 +14,7 @@ import (
 
 	"github.com/chanhpng/vlbe/internal/debug"
 	"github.com/chanhpng/vlbe/internal/errors"
+	"github.com/chanhpng/vlbe/internal/repository"
 	"github.com/chanhpng/vlbe/internal/restic"
 
 	resticfs "github.com/chanhpng/vlbe/internal/fs"
@@ -27,7 +29,19 @@ var cmdMount = &cobra.Command{
 	Short: "Mount the repository",
 	Long: `
 The "mount" command mounts the repository via fuse to a directory. This is a
-read-only mount.
+read-only mount, unless --writable is specified.
+
+Writable Mounts
+===============
+
+With --writable, files and directories within the snapshots can be created,
+modified, renamed and removed. The changes are stored in a local overlay
+directory (see --overlay-dir) and are not written to the repository while the
+repository is mounted. When the mountpoint is unmounted, the changes of each
+modified snapshot are saved as a new snapshot, whose parent is the modified
+snapshot. Only the content of new and modified files is read and uploaded.
+If the changes cannot be saved, the modified files are kept in the overlay
+directory, the file "manifest.txt" lists the snapshot and path of each of them.
 
 Snapshot Directories
 ====================
@@ -61,6 +75,14 @@ The default path templates are:
     "hosts/%h/%T"
     "tags/%t/%T"
 
+File Versions
+=============
+
+The "versions" directory contains the files and directories of all snapshots
+merged into a single tree. Each file is shown as a directory which contains
+the distinct versions of the file, named by the time of the oldest snapshot
+containing the version as formatted by --time-template.
+
 EXIT STATUS
 ===========
 
@@ -83,6 +105,8 @@ type MountOptions struct {
 	restic.SnapshotFilter
 	TimeTemplate  string
 	PathTemplates []string
+	Writable      bool
+	OverlayDir    string
 }
 
 var mountOptions MountOptions
@@ -101,6 +125,9 @@ func init() {
 	mountFlags.StringVar(&mountOptions.TimeTemplate, "snapshot-template", time.RFC3339, "set `template` to use for snapshot dirs")
 	mountFlags.StringVar(&mountOptions.TimeTemplate, "time-template", time.RFC3339, "set `template` to use for times")
 	_ = mountFlags.MarkDeprecated("snapshot-template", "use --time-template")
+
+	mountFlags.BoolVar(&mountOptions.Writable, "writable", false, "allow modifying snapshots, changes are saved as new snapshots when unmounting")
+	mountFlags.StringVar(&mountOptions.OverlayDir, "overlay-dir", "", "store modified files in `dir` until they are saved (default: a temporary directory)")
 }
 
 func runMount(ctx context.Context, opts MountOptions, gopts GlobalOptions, args []string) error {
@@ -116,6 +143,10 @@ func runMount(ctx context.Context, opts MountOptions, gopts GlobalOptions, args
 		return errors.Fatal("wrong number of parameters")
 	}
 
+	if opts.OverlayDir != "" && !opts.Writable {
+		return errors.Fatal("--overlay-dir requires --writable")
+	}
+
 	mountpoint := args[0]
 
 	// Check the existence of the mount point at the earliest stage to
@@ -128,12 +159,56 @@ func runMount(ctx context.Context, opts MountOptions, gopts GlobalOptions, args
 	debug.Log("start mount")
 	defer debug.Log("finish mount")
 
-	ctx, repo, unlock, err := openWithReadLock(ctx, gopts, gopts.NoLock)
+	var repo *repository.Repository
+	var unlock func()
+	var err error
+	// lockCtx is canceled if the lock is lost. The changes of a writable mount
+	// are saved after an interrupt, thus its lock must outlive ctx.
+	var lockCtx context.Context
+	if opts.Writable {
+		var cancel context.CancelFunc
+		lockCtx, cancel = context.WithCancel(context.Background())
+		defer cancel()
+		lockCtx, repo, unlock, err = openWithAppendLock(lockCtx, gopts, false)
+	} else {
+		ctx, repo, unlock, err = openWithReadLock(ctx, gopts, gopts.NoLock)
+		lockCtx = ctx
+	}
 	if err != nil {
 		return err
 	}
 	defer unlock()
 
+	var overlay *fuse.Overlay
+	if opts.Writable {
+		if err := repo.CheckPermission(repository.PermWrite); err != nil {
+			return err
+		}
+
+		overlayDir := opts.OverlayDir
+		if overlayDir == "" {
+			overlayDir, err = os.MkdirTemp("", "restic-mount-")
+			if err != nil {
+				return errors.Fatalf("unable to create overlay directory: %v", err)
+			}
+		} else if err := os.MkdirAll(overlayDir, 0700); err != nil {
+			return errors.Fatalf("unable to create overlay directory: %v", err)
+		}
+		overlay = fuse.NewOverlay(overlayDir)
+		defer func() {
+			if overlay.Changed() {
+				manifest, err := overlay.WriteManifest()
+				if err != nil {
+					Warnf("the changes were not saved, modified files are kept in %v, unable to write the list of their paths: %v\n", overlayDir, err)
+				} else {
+					Warnf("the changes were not saved, modified files are kept in %v, their paths are listed in %v\n", overlayDir, manifest)
+				}
+			} else if opts.OverlayDir == "" {
+				_ = os.RemoveAll(overlayDir)
+			}
+		}()
+	}
+
 	bar := newIndexProgress(gopts.Quiet, gopts.JSON)
 	err = repo.LoadIndex(ctx, bar)
 	if err != nil {
@@ -141,10 +216,12 @@ func runMount(ctx context.Context, opts MountOptions, gopts GlobalOptions, args
 	}
 
 	mountOptions := []systemFuse.MountOption{
-		systemFuse.ReadOnly(),
 		systemFuse.FSName("restic"),
 		systemFuse.MaxReadahead(128 * 1024),
 	}
+	if !opts.Writable {
+		mountOptions = append(mountOptions, systemFuse.ReadOnly())
+	}
 
 	if opts.AllowOther {
 		mountOptions = append(mountOptions, systemFuse.AllowOther())
@@ -169,6 +246,7 @@ func runMount(ctx context.Context, opts MountOptions, gopts GlobalOptions, args
 		Filter:        opts.SnapshotFilter,
 		TimeTemplate:  opts.TimeTemplate,
 		PathTemplates: opts.PathTemplates,
+		Overlay:       overlay,
 	}
 	root := fuse.NewRoot(repo, cfg)
 
@@ -187,16 +265,52 @@ func runMount(ctx context.Context, opts MountOptions, gopts GlobalOptions, args
 
 	select {
 	case <-ctx.Done():
-		debug.Log("running umount cleanup handler for mount at %v", mountpoint)
-		err := systemFuse.Unmount(mountpoint)
-		if err != nil {
-			Warnf("unable to umount (maybe already umounted or still in use?): %v\n", err)
+	case <-lockCtx.Done():
+	case <-done:
+		// clean shutdown
+		if err != nil || overlay == nil {
+			return err
 		}
+		return commitOverlay(lockCtx, repo, overlay)
+	}
 
+	debug.Log("running umount cleanup handler for mount at %v", mountpoint)
+	if err := systemFuse.Unmount(mountpoint); err != nil {
+		Warnf("unable to umount (maybe already umounted or still in use?): %v\n", err)
+		if overlay != nil {
+			return err
+		}
+	}
+
+	if overlay == nil {
 		return ErrOK
-	case <-done:
-		// clean shutdown, nothing to do
+	}
+	<-done
+	// the changes are saved while the repository is still locked
+	return commitOverlay(lockCtx, repo, overlay)
+}
+
+// commitOverlay saves the changes made in a writable mount as new snapshots.
+func commitOverlay(ctx context.Context, repo *repository.Repository, overlay *fuse.Overlay) error {
+	if !overlay.Changed() {
+		Verbosef("no snapshots were modified\n")
+		return nil
 	}
 
-	return err
+	Verbosef("saving modified snapshots\n")
+	ids, err := overlay.Commit(ctx, repo, "restic "+version)
+	if err != nil {
+		return errors.Fatalf("unable to save modified snapshots: %v", err)
+	}
+
+	parents := make(restic.IDs, 0, len(ids))
+	for parent := range ids {
+		parents = append(parents, parent)
+	}
+	sort.Sort(parents)
+	for _, parent := range parents {
+		id := ids[parent]
+		Printf("saved snapshot %v (parent %v)\n", id.Str(), parent.Str())
+	}
+	return nil
 }
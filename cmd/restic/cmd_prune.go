@@ -6,6 +6,7 @@ import (
 	"runtime"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/chanhpng/vlbe/internal/debug"
 	"github.com/chanhpng/vlbe/internal/errors"
@@ -25,6 +26,9 @@ var cmdPrune = &cobra.Command{
 The "prune" command checks the repository and removes data that is not
 referenced and therefore not needed any more.
 
+Checkpoints of interrupted backups are removed once a later backup of the same
+paths on the same host has completed.
+
 EXIT STATUS
 ===========
 
@@ -57,6 +61,8 @@ type PruneOptions struct {
 	RepackCacheableOnly bool
 	RepackSmall         bool
 	RepackUncompressed  bool
+
+	MetricsFile string
 }
 
 var pruneOptions PruneOptions
@@ -67,6 +73,7 @@ func init() {
 	f.BoolVarP(&pruneOptions.DryRun, "dry-run", "n", false, "do not modify the repository, just print what would be done")
 	f.StringVarP(&pruneOptions.UnsafeNoSpaceRecovery, "unsafe-recover-no-free-space", "", "", "UNSAFE, READ THE DOCUMENTATION BEFORE USING! Try to recover a repository stuck with no free space. Do not use without trying out 'prune --max-repack-size 0' first.")
 	addPruneOptions(cmdPrune, &pruneOptions)
+	initMetricsFileFlag(f, &pruneOptions.MetricsFile)
 }
 
 func addPruneOptions(c *cobra.Command, pruneOptions *PruneOptions) {
@@ -147,8 +154,13 @@ func runPrune(ctx context.Context, opts PruneOptions, gopts GlobalOptions, term
 		return errors.Fatal("disabled compression and `--repack-uncompressed` are mutually exclusive")
 	}
 
+	start := time.Now()
 	ctx, repo, unlock, err := openWithExclusiveLock(ctx, gopts, false)
 	if err != nil {
+		if !opts.DryRun {
+			// runPruneWithRepo is not reached, report the failure here
+			saveMetricsFile(opts.MetricsFile, newPruneMetrics(nil, start, false))
+		}
 		return err
 	}
 	defer unlock()
@@ -164,7 +176,21 @@ func runPrune(ctx context.Context, opts PruneOptions, gopts GlobalOptions, term
 	return runPruneWithRepo(ctx, opts, gopts, repo, restic.NewIDSet(), term)
 }
 
-func runPruneWithRepo(ctx context.Context, opts PruneOptions, gopts GlobalOptions, repo *repository.Repository, ignoreSnapshots restic.IDSet, term *termstatus.Terminal) error {
+func runPruneWithRepo(ctx context.Context, opts PruneOptions, gopts GlobalOptions, repo *repository.Repository, ignoreSnapshots restic.IDSet, term *termstatus.Terminal) (err error) {
+	start := time.Now()
+	var stats *repository.PruneStats
+	if opts.MetricsFile != "" && !opts.DryRun {
+		defer func() {
+			saveMetricsFile(opts.MetricsFile, newPruneMetrics(stats, start, err == nil))
+		}()
+	}
+
+	if !opts.DryRun {
+		if err := repo.CheckPermission(repository.PermRemove); err != nil {
+			return err
+		}
+	}
+
 	if repo.Cache == nil {
 		Print("warning: running prune without a cache, this may be very slow!\n")
 	}
@@ -174,7 +200,7 @@ func runPruneWithRepo(ctx context.Context, opts PruneOptions, gopts GlobalOption
 	printer.P("loading indexes...\n")
 	// loading the index before the snapshots is ok, as we use an exclusive lock here
 	bar := newIndexTerminalProgress(gopts.Quiet, gopts.JSON, term)
-	err := repo.LoadIndex(ctx, bar)
+	err = repo.LoadIndex(ctx, bar)
 	if err != nil {
 		return err
 	}
@@ -191,8 +217,10 @@ func runPruneWithRepo(ctx context.Context, opts PruneOptions, gopts GlobalOption
 		RepackUncompressed:  opts.RepackUncompressed,
 	}
 
-	plan, err := repository.PlanPrune(ctx, popts, repo, func(ctx context.Context, repo restic.Repository, usedBlobs restic.FindBlobSet) error {
-		return getUsedBlobs(ctx, repo, usedBlobs, ignoreSnapshots, printer)
+	var staleCheckpoints restic.IDSet
+	plan, err := repository.PlanPrune(ctx, popts, repo, func(ctx context.Context, repo restic.Repository, usedBlobs restic.FindBlobSet) (err error) {
+		staleCheckpoints, err = getUsedBlobs(ctx, repo, usedBlobs, ignoreSnapshots, printer)
+		return err
 	}, printer)
 	if err != nil {
 		return err
@@ -200,12 +228,20 @@ func runPruneWithRepo(ctx context.Context, opts PruneOptions, gopts GlobalOption
 	if ctx.Err() != nil {
 		return ctx.Err()
 	}
+	planStats := plan.Stats()
+	stats = &planStats
+
+	// the checkpoints must be removed before the data referenced by them
+	err = removeStaleCheckpoints(ctx, repo, opts.DryRun, staleCheckpoints, printer)
+	if err != nil {
+		return err
+	}
 
 	if popts.DryRun {
 		printer.P("\nWould have made the following changes:")
 	}
 
-	err = printPruneStats(printer, plan.Stats())
+	err = printPruneStats(printer, planStats)
 	if err != nil {
 		return err
 	}
@@ -216,6 +252,26 @@ func runPruneWithRepo(ctx context.Context, opts PruneOptions, gopts GlobalOption
 	return plan.Execute(ctx, printer)
 }
 
+// newPruneMetrics returns the metrics describing a prune run. stats is nil if
+// prune failed before the plan was created.
+func newPruneMetrics(stats *repository.PruneStats, start time.Time, success bool) *metricSet {
+	m := newMetricSet()
+	m.Timestamp("restic_prune_start_timestamp_seconds", "Start time of the prune run", start)
+	m.Gauge("restic_prune_duration_seconds", "Duration of the prune run", time.Since(start).Seconds())
+	m.Gauge("restic_prune_success", "Whether prune completed successfully", boolMetric(success))
+	if stats == nil {
+		return m
+	}
+	m.Gauge("restic_prune_removed_blobs", "Number of blobs removed from the repository", float64(stats.Blobs.Remove+stats.Blobs.Repackrm))
+	m.Gauge("restic_prune_removed_bytes", "Size of the data removed from the repository", float64(stats.Size.Remove+stats.Size.Repackrm+stats.Size.Unref))
+	m.Gauge("restic_prune_repacked_bytes", "Size of the data which was repacked", float64(stats.Size.Repack))
+	m.Gauge("restic_prune_packs", "Number of pack files by action", float64(stats.Packs.Keep), "action", "keep")
+	m.Gauge("restic_prune_packs", "Number of pack files by action", float64(stats.Packs.Repack), "action", "repack")
+	m.Gauge("restic_prune_packs", "Number of pack files by action", float64(stats.Packs.Remove+stats.Packs.Unref), "action", "remove")
+	m.Gauge("restic_prune_remaining_bytes", "Size of the data remaining in the repository", float64(stats.Size.Used+stats.Size.Duplicate+stats.Size.Unused-stats.Size.Remove-stats.Size.Repackrm))
+	return m
+}
+
 // printPruneStats prints out the statistics
 func printPruneStats(printer progress.Printer, stats repository.PruneStats) error {
 	printer.V("\nused:         %10d blobs / %s\n", stats.Blobs.Used, ui.FormatBytes(stats.Size.Used))
@@ -258,8 +314,35 @@ func printPruneStats(printer progress.Printer, stats repository.PruneStats) erro
 	return nil
 }
 
-func getUsedBlobs(ctx context.Context, repo restic.Repository, usedBlobs restic.FindBlobSet, ignoreSnapshots restic.IDSet, printer progress.Printer) error {
-	var snapshotTrees restic.IDs
+// removeStaleCheckpoints removes the checkpoints of backups which have been
+// completed later on.
+func removeStaleCheckpoints(ctx context.Context, repo restic.Repository, dryRun bool, stale restic.IDSet, printer progress.Printer) error {
+	if len(stale) == 0 {
+		return nil
+	}
+
+	if dryRun {
+		printer.P("would remove %d checkpoints of completed backups\n", len(stale))
+		return nil
+	}
+
+	printer.P("removing %d checkpoints of completed backups\n", len(stale))
+	bar := printer.NewCounter("files deleted")
+	defer bar.Done()
+	return restic.ParallelRemove(ctx, repo, stale, restic.SnapshotFile, func(id restic.ID, err error) error {
+		if err != nil {
+			return errors.Fatalf("unable to remove %v/%v from the repository: %v", restic.SnapshotFile, id, err)
+		}
+		printer.VV("removed %v/%v\n", restic.SnapshotFile, id)
+		return nil
+	}, bar)
+}
+
+// getUsedBlobs adds the blobs referenced by all snapshots to usedBlobs. The
+// checkpoints of backups which have been completed later on are not taken
+// into account, their IDs are returned.
+func getUsedBlobs(ctx context.Context, repo restic.Repository, usedBlobs restic.FindBlobSet, ignoreSnapshots restic.IDSet, printer progress.Printer) (restic.IDSet, error) {
+	var snapshots restic.Snapshots
 	printer.P("loading all snapshots...\n")
 	err := restic.ForAllSnapshots(ctx, repo, repo, ignoreSnapshots,
 		func(id restic.ID, sn *restic.Snapshot, err error) error {
@@ -267,12 +350,25 @@ func getUsedBlobs(ctx context.Context, repo restic.Repository, usedBlobs restic.
 				debug.Log("failed to load snapshot %v (error %v)", id, err)
 				return err
 			}
-			debug.Log("add snapshot %v (tree %v)", id, *sn.Tree)
-			snapshotTrees = append(snapshotTrees, *sn.Tree)
+			snapshots = append(snapshots, sn)
 			return nil
 		})
 	if err != nil {
-		return errors.Fatalf("failed loading snapshot: %v", err)
+		return nil, errors.Fatalf("failed loading snapshot: %v", err)
+	}
+
+	stale := restic.NewIDSet()
+	for _, sn := range restic.StaleCheckpoints(snapshots) {
+		stale.Insert(*sn.ID())
+	}
+
+	var snapshotTrees restic.IDs
+	for _, sn := range snapshots {
+		if stale.Has(*sn.ID()) {
+			continue
+		}
+		debug.Log("add snapshot %v (tree %v)", sn.ID(), *sn.Tree)
+		snapshotTrees = append(snapshotTrees, *sn.Tree)
 	}
 
 	printer.P("finding data that is still in use for %d snapshots\n", len(snapshotTrees))
@@ -281,5 +377,5 @@ func getUsedBlobs(ctx context.Context, repo restic.Repository, usedBlobs restic.
 	bar.SetMax(uint64(len(snapshotTrees)))
 	defer bar.Done()
 
-	return restic.FindUsedBlobs(ctx, repo, snapshotTrees, usedBlobs, bar)
+	return stale, restic.FindUsedBlobs(ctx, repo, snapshotTrees, usedBlobs, bar)
 }
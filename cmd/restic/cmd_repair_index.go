@@ -65,6 +65,10 @@ func runRebuildIndex(ctx context.Context, opts RepairIndexOptions, gopts GlobalO
 	}
 	defer unlock()
 
+	if err := repo.CheckPermission(repository.PermRemove); err != nil {
+		return err
+	}
+
 	printer := newTerminalProgressPrinter(gopts.verbosity, term)
 
 	err = repository.RepairIndex(ctx, repo, repository.RepairIndexOptions{
@@ -59,6 +59,10 @@ func runRepairPacks(ctx context.Context, gopts GlobalOptions, term *termstatus.T
 	}
 	defer unlock()
 
+	if err := repo.CheckPermission(repository.PermRemove); err != nil {
+		return err
+	}
+
 	printer := newTerminalProgressPrinter(gopts.verbosity, term)
 
 	bar := newIndexTerminalProgress(gopts.Quiet, gopts.JSON, term)
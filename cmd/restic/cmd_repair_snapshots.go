@@ -4,6 +4,7 @@ import (
 	"context"
 
 	"github.com/chanhpng/vlbe/internal/errors"
+	"github.com/chanhpng/vlbe/internal/repository"
 	"github.com/chanhpng/vlbe/internal/restic"
 	"github.com/chanhpng/vlbe/internal/walker"
 
@@ -75,6 +76,12 @@ func runRepairSnapshots(ctx context.Context, gopts GlobalOptions, opts RepairOpt
 	}
 	defer unlock()
 
+	if opts.Forget && !opts.DryRun {
+		if err := repo.CheckPermission(repository.PermRemove); err != nil {
+			return err
+		}
+	}
+
 	snapshotLister, err := restic.MemorizeList(ctx, repo, restic.SnapshotFile)
 	if err != nil {
 		return err
@@ -3,10 +3,12 @@ package main
 import (
 	"context"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/chanhpng/vlbe/internal/debug"
 	"github.com/chanhpng/vlbe/internal/errors"
+	"github.com/chanhpng/vlbe/internal/repository"
 	"github.com/chanhpng/vlbe/internal/restic"
 	"github.com/chanhpng/vlbe/internal/restorer"
 	"github.com/chanhpng/vlbe/internal/ui"
@@ -17,7 +19,7 @@ import (
 )
 
 var cmdRestore = &cobra.Command{
-	Use:   "restore [flags] snapshotID",
+	Use:   "restore [flags] snapshotID...",
 	Short: "Extract the data from a snapshot",
 	Long: `
 The "restore" command extracts the data from a snapshot from the repository to
@@ -29,6 +31,32 @@ repository.
 To only restore a specific subfolder, you can use the "snapshotID:subfolder"
 syntax, where "subfolder" is a path within the snapshot.
 
+Multiple snapshots or subfolders can be restored into the same target
+directory at once. Blobs shared between them are only downloaded once. Each
+one is restored to the path of its subfolder within the target directory,
+which can be changed using the "snapshotID:subfolder=path" syntax. This syntax
+is only recognized if more than one snapshot is given. For
+example, "restore latest:/etc 4bba301e:/var/lib --target /mnt" restores
+"/etc" from the latest snapshot to "/mnt/etc" and "/var/lib" from snapshot
+4bba301e to "/mnt/var/lib". The paths must not overlap. The include and
+exclude patterns match the paths within the target directory.
+
+The "--path-history" option restores the newest version of each file from
+several snapshots, including files which were deleted in later snapshots. It
+considers all snapshots matching the "--host", "--tag" and "--path" options,
+or the snapshots given as arguments. With "--as-of", only snapshots created
+at or before the given time are used. For example, "restore --path-history
+--as-of '2024-03-01 12:00' --host srv --include /srv --target /mnt" restores
+the newest version of each file below "/srv" which was backed up on host
+"srv" before noon on March 1st, 2024.
+
+If the target is a block device, the content of a single file selected using
+the "snapshotID:subfolder" syntax and the include and exclude patterns is
+written to the device. The device must be at least as large as the file. With
+"--sparse", zero regions of the file are discarded on the device where
+possible, for example on thinly provisioned LVM volumes, instead of writing
+zeros.
+
 EXIT STATUS
 ===========
 
@@ -56,6 +84,9 @@ type RestoreOptions struct {
 	Verify    bool
 	Overwrite restorer.OverwriteBehavior
 	Delete    bool
+
+	PathHistory bool
+	AsOf        string
 }
 
 var restoreOptions RestoreOptions
@@ -75,6 +106,8 @@ func init() {
 	flags.BoolVar(&restoreOptions.Verify, "verify", false, "verify restored files content")
 	flags.Var(&restoreOptions.Overwrite, "overwrite", "overwrite behavior, one of (always|if-changed|if-newer|never) (default: always)")
 	flags.BoolVar(&restoreOptions.Delete, "delete", false, "delete files from target directory if they do not exist in snapshot. Use '--dry-run -vv' to check what would be deleted")
+	flags.BoolVar(&restoreOptions.PathHistory, "path-history", false, "restore the newest version of each file from all matching snapshots")
+	flags.StringVar(&restoreOptions.AsOf, "as-of", "", "only use snapshots created at or before `time` for --path-history")
 }
 
 func runRestore(ctx context.Context, opts RestoreOptions, gopts GlobalOptions,
@@ -93,11 +126,18 @@ func runRestore(ctx context.Context, opts RestoreOptions, gopts GlobalOptions,
 	hasExcludes := len(excludePatternFns) > 0
 	hasIncludes := len(includePatternFns) > 0
 
-	switch {
-	case len(args) == 0:
+	if len(args) == 0 && !opts.PathHistory {
 		return errors.Fatal("no snapshot ID specified")
-	case len(args) > 1:
-		return errors.Fatalf("more than one snapshot ID specified: %v", args)
+	}
+
+	asOf := time.Now()
+	if opts.AsOf != "" {
+		if !opts.PathHistory {
+			return errors.Fatal("--as-of requires --path-history")
+		}
+		if asOf, err = parseTime(opts.AsOf); err != nil {
+			return err
+		}
 	}
 
 	if opts.Target == "" {
@@ -111,13 +151,16 @@ func runRestore(ctx context.Context, opts RestoreOptions, gopts GlobalOptions,
 		return errors.Fatal("--dry-run and --verify are mutually exclusive")
 	}
 
+	device := restorer.IsBlockDevice(opts.Target)
+	if device && (opts.Delete || opts.Verify) {
+		return errors.Fatal("--delete and --verify cannot be used when restoring to a block device")
+	}
+
 	if opts.Delete && filepath.Clean(opts.Target) == "/" && !hasExcludes && !hasIncludes {
 		return errors.Fatal("'--target / --delete' must be combined with an include or exclude filter")
 	}
 
-	snapshotIDString := args[0]
-
-	debug.Log("restore %v to %v", snapshotIDString, opts.Target)
+	debug.Log("restore %v to %v", args, opts.Target)
 
 	ctx, repo, unlock, err := openWithReadLock(ctx, gopts, gopts.NoLock)
 	if err != nil {
@@ -125,13 +168,48 @@ func runRestore(ctx context.Context, opts RestoreOptions, gopts GlobalOptions,
 	}
 	defer unlock()
 
-	sn, subfolder, err := (&restic.SnapshotFilter{
-		Hosts: opts.Hosts,
-		Paths: opts.Paths,
-		Tags:  opts.Tags,
-	}).FindLatest(ctx, repo, repo, snapshotIDString)
-	if err != nil {
-		return errors.Fatalf("failed to find snapshot: %v", err)
+	if err := repo.CheckPermission(repository.PermRead); err != nil {
+		return err
+	}
+
+	// with --path-history, the arguments select the snapshots of the history
+	sourceArgs := args
+	var history restic.Snapshots
+	if opts.PathHistory {
+		history, err = findHistorySnapshots(ctx, repo, &opts.SnapshotFilter, args, asOf)
+		if err != nil {
+			return err
+		}
+		sourceArgs = nil
+	}
+
+	sources := make([]restorer.Source, 0, len(sourceArgs))
+	subfolders := make([]string, 0, len(sourceArgs))
+	for _, arg := range sourceArgs {
+		// a single snapshot is always restored to the target directory, so
+		// its subfolder may contain "="
+		snapshotIDString, path, hasPath := arg, "", false
+		if len(sourceArgs) > 1 {
+			snapshotIDString, path, hasPath = splitRestoreSource(arg)
+		}
+		sn, subfolder, err := (&restic.SnapshotFilter{
+			Hosts: opts.Hosts,
+			Paths: opts.Paths,
+			Tags:  opts.Tags,
+		}).FindLatest(ctx, repo, repo, snapshotIDString)
+		if err != nil {
+			return errors.Fatalf("failed to find snapshot: %v", err)
+		}
+
+		if !hasPath {
+			// a single snapshot is restored to the target directory itself
+			path = string(filepath.Separator)
+			if len(args) > 1 {
+				path = filepath.FromSlash(subfolder)
+			}
+		}
+		sources = append(sources, restorer.Source{Snapshot: sn, Path: path})
+		subfolders = append(subfolders, subfolder)
 	}
 
 	bar := newIndexTerminalProgress(gopts.Quiet, gopts.JSON, term)
@@ -140,9 +218,19 @@ func runRestore(ctx context.Context, opts RestoreOptions, gopts GlobalOptions,
 		return err
 	}
 
-	sn.Tree, err = restic.FindTreeDirectory(ctx, repo, sn.Tree, subfolder)
-	if err != nil {
-		return err
+	for i := range sources {
+		node, err := restic.FindTreeDirectoryNode(ctx, repo, sources[i].Snapshot.Tree, subfolders[i])
+		if err != nil {
+			return err
+		}
+		sources[i].Tree = *sources[i].Snapshot.Tree
+		if node != nil {
+			sources[i].Tree = *node.Subtree
+		}
+		// the metadata of the target directory itself is not changed
+		if filepath.Join(string(filepath.Separator), sources[i].Path) != string(filepath.Separator) {
+			sources[i].Node = node
+		}
 	}
 
 	msg := ui.NewMessage(term, gopts.verbosity)
@@ -154,13 +242,23 @@ func runRestore(ctx context.Context, opts RestoreOptions, gopts GlobalOptions,
 	}
 
 	progress := restoreui.NewProgress(printer, calculateProgressInterval(!gopts.Quiet, gopts.JSON))
-	res := restorer.NewRestorer(repo, sn, restorer.Options{
+	restoreOpts := restorer.Options{
 		DryRun:    opts.DryRun,
 		Sparse:    opts.Sparse,
 		Progress:  progress,
 		Overwrite: opts.Overwrite,
 		Delete:    opts.Delete,
-	})
+	}
+
+	var res *restorer.Restorer
+	if opts.PathHistory {
+		res, err = restorer.NewHistoryRestorer(ctx, repo, history, restoreOpts)
+		if err != nil {
+			return err
+		}
+	} else {
+		res = restorer.NewMultiRestorer(repo, sources, restoreOpts)
+	}
 
 	totalErrors := 0
 	res.Error = func(location string, err error) error {
@@ -172,56 +270,30 @@ func runRestore(ctx context.Context, opts RestoreOptions, gopts GlobalOptions,
 		msg.E("Warning: %s\n", message)
 	}
 
-	selectExcludeFilter := func(item string, isDir bool) (selectedForRestore bool, childMayBeSelected bool) {
-		matched := false
-		for _, rejectFn := range excludePatternFns {
-			matched = matched || rejectFn(item)
-
-			// implementing a short-circuit here to improve the performance
-			// to prevent additional pattern matching once the first pattern
-			// matches.
-			if matched {
-				break
-			}
-		}
-		// An exclude filter is basically a 'wildcard but foo',
-		// so even if a childMayMatch, other children of a dir may not,
-		// therefore childMayMatch does not matter, but we should not go down
-		// unless the dir is selected for restore
-		selectedForRestore = !matched
-		childMayBeSelected = selectedForRestore && isDir
-
-		return selectedForRestore, childMayBeSelected
+	if hasExcludes {
+		res.SelectFilter = selectExcludeFilter(excludePatternFns)
+	} else if hasIncludes {
+		res.SelectFilter = selectIncludeFilter(includePatternFns)
 	}
 
-	selectIncludeFilter := func(item string, isDir bool) (selectedForRestore bool, childMayBeSelected bool) {
-		selectedForRestore = false
-		childMayBeSelected = false
-		for _, includeFn := range includePatternFns {
-			matched, childMayMatch := includeFn(item)
-			selectedForRestore = selectedForRestore || matched
-			childMayBeSelected = childMayBeSelected || childMayMatch
-
-			if selectedForRestore && childMayBeSelected {
-				break
+	if !gopts.JSON && opts.PathHistory {
+		msg.P("restoring history of %d snapshots up to %s to %s\n", len(history),
+			res.Snapshot().Time.Local().Format(TimeFormat), opts.Target)
+	} else if !gopts.JSON {
+		for _, src := range res.Sources() {
+			target := opts.Target
+			if filepath.Clean(src.Path) != string(filepath.Separator) {
+				target = filepath.Join(opts.Target, src.Path)
 			}
+			msg.P("restoring %s to %s\n", src.Snapshot, target)
 		}
-		childMayBeSelected = childMayBeSelected && isDir
-
-		return selectedForRestore, childMayBeSelected
 	}
 
-	if hasExcludes {
-		res.SelectFilter = selectExcludeFilter
-	} else if hasIncludes {
-		res.SelectFilter = selectIncludeFilter
-	}
-
-	if !gopts.JSON {
-		msg.P("restoring %s to %s\n", res.Snapshot(), opts.Target)
+	if device {
+		err = res.RestoreToDevice(ctx, opts.Target)
+	} else {
+		err = res.RestoreTo(ctx, opts.Target)
 	}
-
-	err = res.RestoreTo(ctx, opts.Target)
 	if err != nil {
 		return err
 	}
@@ -254,3 +326,85 @@ func runRestore(ctx context.Context, opts RestoreOptions, gopts GlobalOptions,
 
 	return nil
 }
+
+// findHistorySnapshots returns the snapshots matching the filter or given as
+// arguments which were created at or before asOf. All snapshots must belong
+// to the same host.
+func findHistorySnapshots(ctx context.Context, repo restic.ListerLoaderUnpacked, filter *restic.SnapshotFilter, args []string, asOf time.Time) (restic.Snapshots, error) {
+	var snapshots restic.Snapshots
+	for sn := range FindFilteredSnapshots(ctx, repo, repo, filter, args) {
+		if sn.Time.After(asOf) {
+			continue
+		}
+		if len(snapshots) > 0 && sn.Hostname != snapshots[0].Hostname {
+			return nil, errors.Fatalf("snapshots of hosts %q and %q match, please select one with --host",
+				snapshots[0].Hostname, sn.Hostname)
+		}
+		snapshots = append(snapshots, sn)
+	}
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if len(snapshots) == 0 {
+		return nil, errors.Fatalf("no snapshot created at or before %s found", asOf.Format(TimeFormat))
+	}
+	return snapshots, nil
+}
+
+// splitRestoreSource splits an argument of the form
+// "snapshotID[:subfolder][=path]" into the snapshot ID including the subfolder
+// and the path within the target directory.
+func splitRestoreSource(arg string) (snapshotID string, path string, hasPath bool) {
+	i := strings.LastIndex(arg, "=")
+	if i < 0 {
+		return arg, "", false
+	}
+	return arg[:i], arg[i+1:], true
+}
+
+// selectExcludeFilter returns a SelectFilter for the restorer, which selects
+// all items not matching any of the exclude patterns.
+func selectExcludeFilter(excludePatternFns []RejectByNameFunc) func(item string, isDir bool) (selectedForRestore bool, childMayBeSelected bool) {
+	return func(item string, isDir bool) (selectedForRestore bool, childMayBeSelected bool) {
+		matched := false
+		for _, rejectFn := range excludePatternFns {
+			matched = matched || rejectFn(item)
+
+			// implementing a short-circuit here to improve the performance
+			// to prevent additional pattern matching once the first pattern
+			// matches.
+			if matched {
+				break
+			}
+		}
+		// An exclude filter is basically a 'wildcard but foo',
+		// so even if a childMayMatch, other children of a dir may not,
+		// therefore childMayMatch does not matter, but we should not go down
+		// unless the dir is selected for restore
+		selectedForRestore = !matched
+		childMayBeSelected = selectedForRestore && isDir
+
+		return selectedForRestore, childMayBeSelected
+	}
+}
+
+// selectIncludeFilter returns a SelectFilter for the restorer, which selects
+// all items matching any of the include patterns.
+func selectIncludeFilter(includePatternFns []IncludeByNameFunc) func(item string, isDir bool) (selectedForRestore bool, childMayBeSelected bool) {
+	return func(item string, isDir bool) (selectedForRestore bool, childMayBeSelected bool) {
+		selectedForRestore = false
+		childMayBeSelected = false
+		for _, includeFn := range includePatternFns {
+			matched, childMayMatch := includeFn(item)
+			selectedForRestore = selectedForRestore || matched
+			childMayBeSelected = childMayBeSelected || childMayMatch
+
+			if selectedForRestore && childMayBeSelected {
+				break
+			}
+		}
+		childMayBeSelected = childMayBeSelected && isDir
+
+		return selectedForRestore, childMayBeSelected
+	}
+}
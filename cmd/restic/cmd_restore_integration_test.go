@@ -436,3 +436,112 @@ func TestRestoreLocalLayout(t *testing.T) {
 		rtest.RemoveAll(t, target)
 	}
 }
+
+func TestRestoreMultipleSources(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+	// the snapshots are searched once per source
+	env.gopts.backendTestHook = nil
+
+	testRunInit(t, env.gopts)
+
+	for _, p := range []string{"etc/hosts", "lib/db", "srv/a=b/c"} {
+		p = filepath.Join(env.testdata, filepath.FromSlash(p))
+		rtest.OK(t, os.MkdirAll(filepath.Dir(p), 0755))
+		rtest.OK(t, os.WriteFile(p, []byte(filepath.Base(p)), 0644))
+	}
+
+	testRunBackup(t, env.testdata, []string{"etc"}, BackupOptions{}, env.gopts)
+	rtest.OK(t, os.WriteFile(filepath.Join(env.testdata, "etc", "hosts"), []byte("modified"), 0644))
+	testRunBackup(t, env.testdata, []string{"etc", "lib", "srv"}, BackupOptions{}, env.gopts)
+	snapshotIDs := testListSnapshots(t, env.gopts, 2)
+
+	repo, err := OpenRepository(context.TODO(), env.gopts)
+	rtest.OK(t, err)
+	var first restic.ID
+	for _, id := range snapshotIDs {
+		sn, err := restic.LoadSnapshot(context.TODO(), repo, id)
+		rtest.OK(t, err)
+		if len(sn.Paths) == 1 {
+			first = id
+		}
+	}
+
+	restoredir := filepath.Join(env.base, "restore")
+	err = withTermStatus(env.gopts, func(ctx context.Context, term *termstatus.Terminal) error {
+		return runRestore(ctx, RestoreOptions{Target: restoredir}, env.gopts, term,
+			[]string{first.String() + ":/etc", "latest:/lib=var/lib"})
+	})
+	rtest.OK(t, err)
+
+	for p, want := range map[string]string{"etc/hosts": "hosts", "var/lib/db": "db"} {
+		data, err := os.ReadFile(filepath.Join(restoredir, filepath.FromSlash(p)))
+		rtest.OK(t, err)
+		rtest.Equals(t, want, string(data))
+	}
+
+	// overlapping paths are rejected
+	err = withTermStatus(env.gopts, func(ctx context.Context, term *termstatus.Terminal) error {
+		return runRestore(ctx, RestoreOptions{Target: restoredir}, env.gopts, term,
+			[]string{"latest:/etc=data", "latest:/lib=data/lib"})
+	})
+	rtest.Assert(t, err != nil, "restoring overlapping paths did not fail")
+
+	// a single source is not split at "="
+	restoredir = filepath.Join(env.base, "restore-single")
+	err = withTermStatus(env.gopts, func(ctx context.Context, term *termstatus.Terminal) error {
+		return runRestore(ctx, RestoreOptions{Target: restoredir}, env.gopts, term,
+			[]string{"latest:/srv/a=b"})
+	})
+	rtest.OK(t, err)
+	data, err := os.ReadFile(filepath.Join(restoredir, "c"))
+	rtest.OK(t, err)
+	rtest.Equals(t, "c", string(data))
+}
+
+func TestRestorePathHistory(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+
+	for _, p := range []string{"srv/changed", "srv/deleted"} {
+		p = filepath.Join(env.testdata, filepath.FromSlash(p))
+		rtest.OK(t, os.MkdirAll(filepath.Dir(p), 0755))
+		rtest.OK(t, os.WriteFile(p, []byte("old"), 0644))
+	}
+	testRunBackup(t, env.testdata, []string{"srv"}, BackupOptions{TimeStamp: "2024-01-01 10:00:00"}, env.gopts)
+
+	rtest.OK(t, os.Remove(filepath.Join(env.testdata, "srv", "deleted")))
+	rtest.OK(t, os.WriteFile(filepath.Join(env.testdata, "srv", "changed"), []byte("new"), 0644))
+	testRunBackup(t, env.testdata, []string{"srv"}, BackupOptions{TimeStamp: "2024-01-02 10:00:00"}, env.gopts)
+
+	rtest.OK(t, os.Remove(filepath.Join(env.testdata, "srv", "changed")))
+	testRunBackup(t, env.testdata, []string{"srv"}, BackupOptions{TimeStamp: "2024-01-03 10:00:00"}, env.gopts)
+
+	for _, test := range []struct {
+		asOf string
+		want map[string]string
+	}{
+		{"", map[string]string{"changed": "new", "deleted": "old"}},
+		{"2024-01-02 12:00", map[string]string{"changed": "new", "deleted": "old"}},
+		{"2024-01-01 12:00", map[string]string{"changed": "old", "deleted": "old"}},
+	} {
+		restoredir := filepath.Join(env.base, "restore-"+test.asOf)
+		err := withTermStatus(env.gopts, func(ctx context.Context, term *termstatus.Terminal) error {
+			return runRestore(ctx, RestoreOptions{Target: restoredir, PathHistory: true, AsOf: test.asOf}, env.gopts, term, nil)
+		})
+		rtest.OK(t, err)
+
+		for name, want := range test.want {
+			data, err := os.ReadFile(filepath.Join(restoredir, "srv", name))
+			rtest.OK(t, err)
+			rtest.Equals(t, want, string(data), "as of %q", test.asOf)
+		}
+	}
+
+	err := withTermStatus(env.gopts, func(ctx context.Context, term *termstatus.Terminal) error {
+		return runRestore(ctx, RestoreOptions{Target: env.base, PathHistory: true, AsOf: "2023-12-31"}, env.gopts, term, nil)
+	})
+	rtest.Assert(t, err != nil, "restoring without matching snapshots did not fail")
+}
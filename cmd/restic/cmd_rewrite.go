@@ -188,7 +188,12 @@ func filterAndReplaceSnapshot(ctx context.Context, repo restic.Repository, sn *r
 		return false, err
 	}
 
+	held := sn.IsHeld(time.Now())
 	if filteredTree.IsNull() {
+		if held {
+			Warnf("not removing empty snapshot %v, it is %v\n", sn.ID().Str(), sn.Hold)
+			return false, nil
+		}
 		if dryRun {
 			Verbosef("would delete empty snapshot\n")
 		} else {
@@ -210,7 +215,9 @@ func filterAndReplaceSnapshot(ctx context.Context, repo restic.Repository, sn *r
 	if dryRun {
 		Verbosef("would save new snapshot\n")
 
-		if forget {
+		if forget && held {
+			Verbosef("would keep old snapshot, it is %v\n", sn.Hold)
+		} else if forget {
 			Verbosef("would remove old snapshot\n")
 		}
 
@@ -225,9 +232,20 @@ func filterAndReplaceSnapshot(ctx context.Context, repo restic.Repository, sn *r
 		return true, nil
 	}
 
+	// a held snapshot must not be removed, the rewritten snapshot is saved
+	// next to it
+	if forget && held {
+		Warnf("keeping old snapshot %v, it is %v\n", sn.ID().Str(), sn.Hold)
+		forget = false
+	}
+
 	// Always set the original snapshot id as this essentially a new snapshot.
 	sn.Original = sn.ID()
 	sn.Tree = &filteredTree
+	// the hold only protects the original snapshot
+	if held {
+		sn.Hold = nil
+	}
 
 	if !forget {
 		sn.AddTags([]string{addTag})
@@ -282,6 +300,12 @@ func runRewrite(ctx context.Context, opts RewriteOptions, gopts GlobalOptions, a
 	}
 	defer unlock()
 
+	if opts.Forget && !opts.DryRun {
+		if err := repo.CheckPermission(repository.PermRemove); err != nil {
+			return err
+		}
+	}
+
 	snapshotLister, err := restic.MemorizeList(ctx, repo, restic.SnapshotFile)
 	if err != nil {
 		return err
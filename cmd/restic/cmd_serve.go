@@ -0,0 +1,14 @@
+package main
+
+import (
+	"github.com/spf13/cobra"
+)
+
+var cmdServe = &cobra.Command{
+	Use:   "serve",
+	Short: "Serve the repository via network protocols",
+}
+
+func init() {
+	cmdRoot.AddCommand(cmdServe)
+}
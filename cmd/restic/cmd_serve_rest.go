@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/chanhpng/vlbe/internal/backend"
+	"github.com/chanhpng/vlbe/internal/debug"
+	"github.com/chanhpng/vlbe/internal/errors"
+	"github.com/chanhpng/vlbe/internal/restserver"
+)
+
+var cmdServeREST = &cobra.Command{
+	Use:   "rest [flags]",
+	Short: "Serve repositories via the REST protocol",
+	Long: `
+The "rest" sub-command serves the repository location given via --repo (or
+$RESTIC_REPOSITORY) using the REST protocol, so that it can be accessed by the
+"rest:" backend of other restic instances. The location can use any backend
+supported by restic. The repository password is not required.
+
+Clients can create and access further repositories below the location. Their
+path in the URL is appended to the location, for example the URL
+"rest:http://host:8000/alice/" maps to the location "/srv/restic/alice" if the
+server was started with "--repo /srv/restic".
+
+With --append-only, clients can only add new files, but not remove or
+overwrite existing ones. Lock files are exempt from this rule.
+
+Users are authenticated using an htpasswd file passed via --htpasswd-file.
+The password hashes in the file must use bcrypt or SHA1. With --private-repos,
+users can only access repositories below a path starting with their user name.
+
+The server listens on a TCP address or, if the address has the prefix "unix:",
+on a unix socket.
+
+EXIT STATUS
+===========
+
+Exit status is 0 if the command was successful.
+Exit status is 1 if there was any error.
+`,
+	DisableAutoGenTag: true,
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		return runServeREST(cmd.Context(), serveRESTOptions, globalOptions)
+	},
+}
+
+// ServeRESTOptions collects all options for the serve rest command.
+type ServeRESTOptions struct {
+	Listen         string
+	AppendOnly     bool
+	PrivateRepos   bool
+	HtpasswdFile   string
+	NoVerifyUpload bool
+	TLSCert        string
+	TLSKey         string
+}
+
+var serveRESTOptions ServeRESTOptions
+
+func init() {
+	cmdServe.AddCommand(cmdServeREST)
+
+	f := cmdServeREST.Flags()
+	f.StringVar(&serveRESTOptions.Listen, "listen", ":8000", "listen on this `address`, use the prefix unix: for a unix socket")
+	f.BoolVar(&serveRESTOptions.AppendOnly, "append-only", false, "only allow adding files, except for lock files")
+	f.BoolVar(&serveRESTOptions.PrivateRepos, "private-repos", false, "users can only access repositories below a path starting with their user name")
+	f.StringVar(&serveRESTOptions.HtpasswdFile, "htpasswd-file", "", "authenticate users with the credentials from the htpasswd `file`")
+	f.BoolVar(&serveRESTOptions.NoVerifyUpload, "no-verify-upload", false, "do not verify that uploaded files match their name")
+	f.StringVar(&serveRESTOptions.TLSCert, "tls-cert", "", "serve via TLS using the certificate from `file`")
+	f.StringVar(&serveRESTOptions.TLSKey, "tls-key", "", "serve via TLS using the private key from `file`")
+}
+
+func runServeREST(ctx context.Context, opts ServeRESTOptions, gopts GlobalOptions) error {
+	if (opts.TLSCert == "") != (opts.TLSKey == "") {
+		return errors.Fatal("--tls-cert and --tls-key must be specified together")
+	}
+	if opts.PrivateRepos && opts.HtpasswdFile == "" {
+		return errors.Fatal("--private-repos requires --htpasswd-file")
+	}
+
+	repo, err := ReadRepo(gopts)
+	if err != nil {
+		return err
+	}
+	repo = strings.TrimSuffix(repo, "/")
+
+	cfg := restserver.Config{
+		AppendOnly:     opts.AppendOnly,
+		PrivateRepos:   opts.PrivateRepos,
+		NoVerifyUpload: opts.NoVerifyUpload,
+		Open: func(ctx context.Context, path string, create bool) (backend.Backend, error) {
+			loc := repo
+			if path != "" {
+				loc += "/" + path
+			}
+			return innerOpen(ctx, loc, gopts, gopts.extended, create)
+		},
+	}
+
+	if opts.HtpasswdFile != "" {
+		cfg.Users, err = restserver.ReadHtpasswdFile(opts.HtpasswdFile)
+		if err != nil {
+			return errors.Fatal(err.Error())
+		}
+	}
+
+	srv, err := restserver.New(cfg)
+	if err != nil {
+		return errors.Fatal(err.Error())
+	}
+	defer func() {
+		if err := srv.Close(); err != nil {
+			Warnf("closing backends failed: %v\n", err)
+		}
+	}()
+
+	return serveHTTP(ctx, opts.Listen, opts.TLSCert, opts.TLSKey, srv)
+}
+
+// serveHTTP serves handler on the listen address until ctx is canceled.
+func serveHTTP(ctx context.Context, listen, tlsCert, tlsKey string, handler http.Handler) error {
+	var ln net.Listener
+	var err error
+	if strings.HasPrefix(listen, "unix:") {
+		ln, err = net.Listen("unix", strings.TrimPrefix(listen, "unix:"))
+	} else {
+		ln, err = net.Listen("tcp", listen)
+	}
+	if err != nil {
+		return errors.Fatalf("unable to listen on %v: %v", listen, err)
+	}
+
+	srv := &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: time.Minute,
+	}
+
+	scheme := "http"
+	if tlsCert != "" {
+		scheme = "https"
+	}
+	Verbosef("serving on %v://%v\n", scheme, ln.Addr())
+
+	done := make(chan error, 1)
+	go func() {
+		if tlsCert != "" {
+			done <- srv.ServeTLS(ln, tlsCert, tlsKey)
+		} else {
+			done <- srv.Serve(ln)
+		}
+	}()
+
+	select {
+	case <-ctx.Done():
+		debug.Log("shutting down server on %v", ln.Addr())
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			Warnf("server shutdown failed: %v\n", err)
+		}
+		return ErrOK
+	case err := <-done:
+		return err
+	}
+}
@@ -0,0 +1,75 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	rtest "github.com/chanhpng/vlbe/internal/test"
+)
+
+// testRunServeREST starts a REST server for the repository of gopts on a unix
+// socket and returns the URL of the repository at path.
+func testRunServeREST(t testing.TB, gopts GlobalOptions, opts ServeRESTOptions, path string) string {
+	socket := filepath.Join(filepath.Dir(gopts.Repo), "rest.sock")
+	opts.Listen = "unix:" + socket
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- runServeREST(ctx, opts, gopts)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		rtest.Equals(t, ErrOK, <-done)
+	})
+
+	for i := 0; i < 100; i++ {
+		if _, err := os.Stat(socket); err == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	return "rest:http+unix://" + socket + ":/" + path
+}
+
+func TestServeREST(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	clientOpts := env.gopts
+	clientOpts.Repo = testRunServeREST(t, env.gopts, ServeRESTOptions{}, "sub/")
+
+	testRunInit(t, clientOpts)
+	rtest.SetupTarTestFixture(t, env.testdata, filepath.Join("testdata", "backup-data.tar.gz"))
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, BackupOptions{}, clientOpts)
+	testListSnapshots(t, clientOpts, 1)
+	testRunCheck(t, clientOpts)
+
+	// the repository is stored below the served location
+	_, err := os.Stat(filepath.Join(env.repo, "sub", "config"))
+	rtest.OK(t, err)
+}
+
+func TestServeRESTAppendOnly(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	clientOpts := env.gopts
+	clientOpts.Repo = testRunServeREST(t, env.gopts, ServeRESTOptions{AppendOnly: true}, "")
+
+	testRunInit(t, clientOpts)
+	rtest.SetupTarTestFixture(t, env.testdata, filepath.Join("testdata", "backup-data.tar.gz"))
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, BackupOptions{}, clientOpts)
+	snapshotIDs := testListSnapshots(t, clientOpts, 1)
+
+	// the server refuses to remove the snapshot
+	_ = testRunForgetMayFail(clientOpts, ForgetOptions{}, snapshotIDs[0].String())
+	testListSnapshots(t, clientOpts, 1)
+}
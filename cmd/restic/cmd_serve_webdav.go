@@ -0,0 +1,125 @@
+//go:build darwin || freebsd || linux
+// +build darwin freebsd linux
+
+package main
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/chanhpng/vlbe/internal/davserver"
+	"github.com/chanhpng/vlbe/internal/errors"
+	"github.com/chanhpng/vlbe/internal/fuse"
+	"github.com/chanhpng/vlbe/internal/restic"
+	"github.com/chanhpng/vlbe/internal/restserver"
+)
+
+var cmdServeWebDAV = &cobra.Command{
+	Use:   "webdav [flags]",
+	Short: "Serve the snapshots via WebDAV and HTTP",
+	Long: `
+The "webdav" sub-command serves the snapshots of the repository read-only via
+WebDAV and plain HTTP. It does not mount a fuse filesystem, the content of the
+snapshots can be browsed and downloaded using a web browser, curl or a WebDAV
+client. As the command shares its implementation with the "mount" command, it
+is only available on Linux, macOS and FreeBSD.
+
+The directory structure is the same as for the "mount" command and can be
+configured using --path-template and --time-template. Directories are listed
+as HTML, or as JSON if the query parameter "format=json" is given or the
+"Accept" header contains "application/json". Files support range requests.
+
+Users are authenticated using an htpasswd file passed via --htpasswd-file.
+Without it, everybody who can connect to the server can read all snapshots.
+
+The server listens on a TCP address or, if the address has the prefix "unix:",
+on a unix socket.
+
+EXIT STATUS
+===========
+
+Exit status is 0 if the command was successful.
+Exit status is 1 if there was any error.
+Exit status is 10 if the repository does not exist.
+Exit status is 11 if the repository is already locked.
+`,
+	DisableAutoGenTag: true,
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		return runServeWebDAV(cmd.Context(), serveWebDAVOptions, globalOptions)
+	},
+}
+
+// ServeWebDAVOptions collects all options for the serve webdav command.
+type ServeWebDAVOptions struct {
+	Listen       string
+	HtpasswdFile string
+	TLSCert      string
+	TLSKey       string
+	restic.SnapshotFilter
+	TimeTemplate  string
+	PathTemplates []string
+}
+
+var serveWebDAVOptions ServeWebDAVOptions
+
+func init() {
+	cmdServe.AddCommand(cmdServeWebDAV)
+
+	f := cmdServeWebDAV.Flags()
+	f.StringVar(&serveWebDAVOptions.Listen, "listen", "localhost:8000", "listen on this `address`, use the prefix unix: for a unix socket")
+	f.StringVar(&serveWebDAVOptions.HtpasswdFile, "htpasswd-file", "", "authenticate users with the credentials from the htpasswd `file`")
+	f.StringVar(&serveWebDAVOptions.TLSCert, "tls-cert", "", "serve via TLS using the certificate from `file`")
+	f.StringVar(&serveWebDAVOptions.TLSKey, "tls-key", "", "serve via TLS using the private key from `file`")
+
+	initMultiSnapshotFilter(f, &serveWebDAVOptions.SnapshotFilter, true)
+
+	f.StringArrayVar(&serveWebDAVOptions.PathTemplates, "path-template", nil, "set `template` for path names (can be specified multiple times)")
+	f.StringVar(&serveWebDAVOptions.TimeTemplate, "time-template", time.RFC3339, "set `template` to use for times")
+}
+
+func runServeWebDAV(ctx context.Context, opts ServeWebDAVOptions, gopts GlobalOptions) error {
+	if (opts.TLSCert == "") != (opts.TLSKey == "") {
+		return errors.Fatal("--tls-cert and --tls-key must be specified together")
+	}
+	if opts.TimeTemplate == "" {
+		return errors.Fatal("time template string cannot be empty")
+	}
+	if strings.HasPrefix(opts.TimeTemplate, "/") || strings.HasSuffix(opts.TimeTemplate, "/") {
+		return errors.Fatal("time template string cannot start or end with '/'")
+	}
+
+	cfg := davserver.Config{
+		Mount: fuse.Config{
+			Filter:        opts.SnapshotFilter,
+			TimeTemplate:  opts.TimeTemplate,
+			PathTemplates: opts.PathTemplates,
+		},
+	}
+	if opts.HtpasswdFile != "" {
+		var err error
+		cfg.Users, err = restserver.ReadHtpasswdFile(opts.HtpasswdFile)
+		if err != nil {
+			return errors.Fatal(err.Error())
+		}
+	}
+
+	ctx, repo, unlock, err := openWithReadLock(ctx, gopts, gopts.NoLock)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	bar := newIndexProgress(gopts.Quiet, gopts.JSON)
+	if err := repo.LoadIndex(ctx, bar); err != nil {
+		return err
+	}
+
+	srv, err := davserver.New(repo, cfg)
+	if err != nil {
+		return errors.Fatal(err.Error())
+	}
+	return serveHTTP(ctx, opts.Listen, opts.TLSCert, opts.TLSKey, srv)
+}
@@ -0,0 +1,65 @@
+//go:build darwin || freebsd || linux
+// +build darwin freebsd linux
+
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	rtest "github.com/chanhpng/vlbe/internal/test"
+)
+
+func TestServeWebDAV(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	// must list snapshots more than once
+	env.gopts.backendTestHook = nil
+	defer cleanup()
+
+	testSetupBackupData(t, env)
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, BackupOptions{}, env.gopts)
+	snapshotID := testListSnapshots(t, env.gopts, 1)[0]
+
+	socket := filepath.Join(env.base, "webdav.sock")
+	opts := serveWebDAVOptions
+	opts.Listen = "unix:" + socket
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- runServeWebDAV(ctx, opts, env.gopts)
+	}()
+	defer func() {
+		cancel()
+		rtest.Equals(t, ErrOK, <-done)
+	}()
+
+	for i := 0; i < 100; i++ {
+		if _, err := os.Stat(socket); err == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+		},
+	}}
+	resp, err := client.Get("http://restic/ids/" + snapshotID.Str() + "/testdata/0/0/9/37")
+	rtest.OK(t, err)
+	data, err := io.ReadAll(resp.Body)
+	rtest.OK(t, err)
+	rtest.OK(t, resp.Body.Close())
+	rtest.Equals(t, http.StatusOK, resp.StatusCode)
+
+	expected, err := os.ReadFile(filepath.Join(env.testdata, "0", "0", "9", "37"))
+	rtest.OK(t, err)
+	rtest.Equals(t, expected, data)
+}
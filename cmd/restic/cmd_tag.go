@@ -74,27 +74,36 @@ func changeTags(ctx context.Context, repo *repository.Repository, sn *restic.Sna
 	}
 
 	if changed {
-		// Retain the original snapshot id over all tag changes.
-		if sn.Original == nil {
-			sn.Original = sn.ID()
-		}
-
-		// Save the new snapshot.
-		id, err := restic.SaveSnapshot(ctx, repo, sn)
-		if err != nil {
+		if err := replaceSnapshot(ctx, repo, sn); err != nil {
 			return false, err
 		}
+	}
+	return changed, nil
+}
 
-		debug.Log("new snapshot saved as %v", id)
+// replaceSnapshot saves the modified snapshot sn and removes the file it was
+// loaded from.
+func replaceSnapshot(ctx context.Context, repo *repository.Repository, sn *restic.Snapshot) error {
+	// Retain the original snapshot id over all changes.
+	if sn.Original == nil {
+		sn.Original = sn.ID()
+	}
 
-		// Remove the old snapshot.
-		if err = repo.RemoveUnpacked(ctx, restic.SnapshotFile, *sn.ID()); err != nil {
-			return false, err
-		}
+	// Save the new snapshot.
+	id, err := restic.SaveSnapshot(ctx, repo, sn)
+	if err != nil {
+		return err
+	}
 
-		debug.Log("old snapshot %v removed", sn.ID())
+	debug.Log("new snapshot saved as %v", id)
+
+	// Remove the old snapshot.
+	if err = repo.RemoveUnpacked(ctx, restic.SnapshotFile, *sn.ID()); err != nil {
+		return err
 	}
-	return changed, nil
+
+	debug.Log("old snapshot %v removed", sn.ID())
+	return nil
 }
 
 func runTag(ctx context.Context, opts TagOptions, gopts GlobalOptions, args []string) error {
@@ -112,6 +121,11 @@ func runTag(ctx context.Context, opts TagOptions, gopts GlobalOptions, args []st
 	}
 	defer unlock()
 
+	// changing tags replaces the snapshot files
+	if err := repo.CheckPermission(repository.PermRemove); err != nil {
+		return err
+	}
+
 	changeCnt := 0
 	for sn := range FindFilteredSnapshots(ctx, repo, repo, &opts.SnapshotFilter, args) {
 		changed, err := changeTags(ctx, repo, sn, opts.SetTags.Flatten(), opts.AddTags.Flatten(), opts.RemoveTags.Flatten())
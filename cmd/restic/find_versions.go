@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/chanhpng/vlbe/internal/restic"
+	"github.com/chanhpng/vlbe/internal/ui"
+)
+
+// fileVersion is a distinct content of a file found by "find --versions".
+type fileVersion struct {
+	node *restic.Node
+	// snapshots contains all snapshots with this version, the first one
+	// is the oldest
+	snapshots restic.Snapshots
+}
+
+// fileVersions collects the distinct versions of the files matched by find.
+type fileVersions struct {
+	paths map[string][]*fileVersion
+}
+
+func newFileVersions() *fileVersions {
+	return &fileVersions{paths: make(map[string][]*fileVersion)}
+}
+
+// add records node found at nodepath in sn. The snapshots must be added
+// ascending by time.
+func (v *fileVersions) add(nodepath string, node *restic.Node, sn *restic.Snapshot) {
+	key := node.ContentKey()
+	for _, version := range v.paths[nodepath] {
+		if version.node.ContentKey() == key {
+			version.snapshots = append(version.snapshots, sn)
+			return
+		}
+	}
+	v.paths[nodepath] = append(v.paths[nodepath], &fileVersion{node: node, snapshots: restic.Snapshots{sn}})
+}
+
+func (v *fileVersions) sortedPaths() []string {
+	paths := make([]string, 0, len(v.paths))
+	for p := range v.paths {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// findVersionJSON is the JSON representation of a version of a file.
+type findVersionJSON struct {
+	Snapshot  string    `json:"snapshot"`
+	Time      time.Time `json:"time"`
+	Snapshots []string  `json:"snapshots"`
+	Type      string    `json:"type"`
+	Size      uint64    `json:"size"`
+	ModTime   time.Time `json:"mtime"`
+}
+
+type findVersionsJSON struct {
+	Path     string            `json:"path"`
+	Versions []findVersionJSON `json:"versions"`
+}
+
+// Print prints the versions of all files.
+func (v *fileVersions) Print(jsonOutput, humanReadable bool) {
+	if jsonOutput {
+		result := make([]findVersionsJSON, 0, len(v.paths))
+		for _, p := range v.sortedPaths() {
+			item := findVersionsJSON{Path: p}
+			for _, version := range v.paths[p] {
+				first := version.snapshots[0]
+				ids := make([]string, 0, len(version.snapshots))
+				for _, sn := range version.snapshots {
+					ids = append(ids, sn.ID().String())
+				}
+				item.Versions = append(item.Versions, findVersionJSON{
+					Snapshot:  first.ID().String(),
+					Time:      first.Time,
+					Snapshots: ids,
+					Type:      version.node.Type,
+					Size:      version.node.Size,
+					ModTime:   version.node.ModTime,
+				})
+			}
+			result = append(result, item)
+		}
+
+		b, err := json.Marshal(result)
+		if err != nil {
+			Warnf("Marshall failed: %v\n", err)
+			return
+		}
+		Println(string(b))
+		return
+	}
+
+	for i, p := range v.sortedPaths() {
+		if i > 0 {
+			Printf("\n")
+		}
+		versions := v.paths[p]
+		Printf("%s: %d version(s)\n", p, len(versions))
+		for _, version := range versions {
+			first := version.snapshots[0]
+			size := fmt.Sprintf("%d", version.node.Size)
+			if humanReadable {
+				size = ui.FormatBytes(version.node.Size)
+			}
+			Printf("  %s  snapshot %s  size %s  modified %s  in %d snapshot(s)\n",
+				first.Time.Local().Format(TimeFormat), first.ID().Str(), size,
+				version.node.ModTime.Local().Format(TimeFormat), len(version.snapshots))
+		}
+	}
+}
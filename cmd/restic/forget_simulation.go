@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/chanhpng/vlbe/internal/repository"
+	"github.com/chanhpng/vlbe/internal/restic"
+	"github.com/chanhpng/vlbe/internal/ui"
+	"github.com/chanhpng/vlbe/internal/ui/table"
+)
+
+// ForgetSimulationGroup helps to print the result of a policy simulation for
+// one snapshot group in JSON.
+type ForgetSimulationGroup struct {
+	Tags  []string              `json:"tags"`
+	Host  string                `json:"host"`
+	Paths []string              `json:"paths"`
+	Days  []ForgetSimulationDay `json:"days"`
+}
+
+// ForgetSimulationDay contains the snapshots retained on one day of a policy
+// simulation.
+type ForgetSimulationDay struct {
+	Day       int       `json:"day"`
+	Date      time.Time `json:"date"`
+	Snapshots int       `json:"snapshots"`
+	Removed   int       `json:"removed"`
+	// Size is the size of all blobs referenced by the retained snapshots.
+	// Blobs which are shared with other groups are counted in each group.
+	Size uint64 `json:"size"`
+}
+
+// daySets interns sets of simulated days, which are stored as bitsets. As
+// most blobs are referenced on the same days, only few distinct sets exist.
+type daySets struct {
+	ids    map[string]uint32
+	sets   []string
+	unions map[[2]uint32]uint32
+}
+
+func newDaySets() *daySets {
+	return &daySets{
+		ids:    make(map[string]uint32),
+		unions: make(map[[2]uint32]uint32),
+	}
+}
+
+func (d *daySets) id(set string) uint32 {
+	id, ok := d.ids[set]
+	if !ok {
+		id = uint32(len(d.sets))
+		d.ids[set] = id
+		d.sets = append(d.sets, set)
+	}
+	return id
+}
+
+func (d *daySets) union(a, b uint32) uint32 {
+	if a == b {
+		return a
+	}
+	if a > b {
+		a, b = b, a
+	}
+	if id, ok := d.unions[[2]uint32{a, b}]; ok {
+		return id
+	}
+
+	set := []byte(d.sets[a])
+	for i := range set {
+		set[i] |= d.sets[b][i]
+	}
+	id := d.id(string(set))
+	d.unions[[2]uint32{a, b}] = id
+	return id
+}
+
+// blobDays records for each blob the set of days on which it is referenced
+// by a retained snapshot. It is used as the restic.FindBlobSet when walking a
+// tree retained on the days cur, so that subtrees which are already known to
+// be referenced on these days are not walked again.
+type blobDays struct {
+	sets  *daySets
+	blobs map[restic.BlobHandle]uint32
+	cur   uint32
+}
+
+func (b *blobDays) Has(h restic.BlobHandle) bool {
+	id, ok := b.blobs[h]
+	return ok && b.sets.union(id, b.cur) == id
+}
+
+func (b *blobDays) Insert(h restic.BlobHandle) {
+	id, ok := b.blobs[h]
+	if ok {
+		b.blobs[h] = b.sets.union(id, b.cur)
+	} else {
+		b.blobs[h] = b.cur
+	}
+}
+
+// retainedSizes returns the size of all blobs referenced on each day by the
+// trees in retained, which maps each tree to the bitset of days on which it is
+// retained. Each tree is walked once, only the set of days is kept per blob.
+func retainedSizes(ctx context.Context, repo restic.Loader, retained map[restic.ID][]byte, days int) ([]uint64, error) {
+	trees := make(restic.IDs, 0, len(retained))
+	for id := range retained {
+		trees = append(trees, id)
+	}
+	sort.Sort(trees)
+
+	blobs := &blobDays{sets: newDaySets(), blobs: make(map[restic.BlobHandle]uint32)}
+	for _, tree := range trees {
+		blobs.cur = blobs.sets.id(string(retained[tree]))
+		if err := restic.FindUsedBlobs(ctx, repo, restic.IDs{tree}, blobs, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	setSizes := make([]uint64, len(blobs.sets.sets))
+	for h, id := range blobs.blobs {
+		size, _ := repo.LookupBlobSize(h.Type, h.ID)
+		setSizes[id] += uint64(size)
+	}
+
+	sizes := make([]uint64, days+1)
+	for id, set := range blobs.sets.sets {
+		for day := range sizes {
+			if set[day/8]&(1<<(day%8)) != 0 {
+				sizes[day] += setSizes[id]
+			}
+		}
+	}
+	return sizes, nil
+}
+
+func addDuration(t time.Time, d restic.Duration) time.Time {
+	return t.AddDate(d.Years, d.Months, d.Days).Add(time.Duration(d.Hours) * time.Hour)
+}
+
+// simulateForgetGroup applies the policy to the snapshots in list once per
+// day, starting at start, and returns the snapshots retained on each day.
+// Snapshots removed on one day are no longer present on the following days.
+// If interval is not zero, a snapshot of the same data as the latest snapshot
+// is added every interval.
+func simulateForgetGroup(ctx context.Context, repo restic.Loader, list restic.Snapshots, policy restic.ExpirePolicy,
+	start time.Time, days int, interval restic.Duration) ([]ForgetSimulationDay, error) {
+
+	current := append(restic.Snapshots{}, list...)
+	sort.Stable(current)
+
+	// the days on which each tree is retained, the sizes are calculated
+	// afterwards
+	retained := make(map[restic.ID][]byte)
+
+	latest := current[0]
+	next := addDuration(latest.Time, interval)
+	for !interval.Zero() && !next.After(start) {
+		next = addDuration(next, interval)
+	}
+
+	var result []ForgetSimulationDay
+	for day := 0; day <= days; day++ {
+		now := start.AddDate(0, 0, day)
+
+		for !interval.Zero() && !next.After(now) {
+			sn := &restic.Snapshot{
+				Time:     next,
+				Tree:     latest.Tree,
+				Paths:    latest.Paths,
+				Hostname: latest.Hostname,
+				Tags:     latest.Tags,
+			}
+			current = append(current, sn)
+			next = addDuration(next, interval)
+		}
+
+		keep, remove, _ := restic.ApplyPolicyAt(current, policy, now)
+		for _, sn := range keep {
+			set, ok := retained[*sn.Tree]
+			if !ok {
+				set = make([]byte, days/8+1)
+				retained[*sn.Tree] = set
+			}
+			set[day/8] |= 1 << (day % 8)
+		}
+		current = keep
+
+		result = append(result, ForgetSimulationDay{
+			Day:       day,
+			Date:      now,
+			Snapshots: len(keep),
+			Removed:   len(remove),
+		})
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+	}
+
+	sizes, err := retainedSizes(ctx, repo, retained, days)
+	if err != nil {
+		return nil, err
+	}
+	for i := range result {
+		result[i].Size = sizes[i]
+	}
+	return result, nil
+}
+
+// simulateForget simulates the policy for each snapshot group and prints how
+// many snapshots and how much data would be retained on each day.
+func simulateForget(ctx context.Context, repo *repository.Repository, snapshotGroups map[string]restic.Snapshots,
+	policy restic.ExpirePolicy, opts ForgetOptions, gopts GlobalOptions) error {
+
+	bar := newIndexProgress(gopts.Quiet, gopts.JSON)
+	if err := repo.LoadIndex(ctx, bar); err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(snapshotGroups))
+	for k := range snapshotGroups {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	start := time.Now()
+	var groups []*ForgetSimulationGroup
+	for _, k := range keys {
+		var key restic.SnapshotGroupKey
+		if err := json.Unmarshal([]byte(k), &key); err != nil {
+			return err
+		}
+
+		days, err := simulateForgetGroup(ctx, repo, snapshotGroups[k], policy,
+			start, opts.SimulateDays, opts.SimulateInterval)
+		if err != nil {
+			return err
+		}
+		groups = append(groups, &ForgetSimulationGroup{
+			Tags:  key.Tags,
+			Host:  key.Hostname,
+			Paths: key.Paths,
+			Days:  days,
+		})
+	}
+
+	if gopts.JSON {
+		return json.NewEncoder(globalOptions.stdout).Encode(groups)
+	}
+
+	for i, g := range groups {
+		if err := PrintSnapshotGroupHeader(globalOptions.stdout, keys[i]); err != nil {
+			return err
+		}
+
+		tab := table.New()
+		tab.AddColumn("Day", "{{ .Day }}")
+		tab.AddColumn("Date", "{{ .Date }}")
+		tab.AddColumn("Snapshots", "{{ .Snapshots }}")
+		tab.AddColumn("Removed", "{{ .Removed }}")
+		tab.AddColumn("Size", "{{ .Size }}")
+		for _, d := range g.Days {
+			tab.AddRow(struct {
+				Day, Snapshots, Removed int
+				Date, Size              string
+			}{d.Day, d.Snapshots, d.Removed, d.Date.Format("2006-01-02"), ui.FormatBytes(d.Size)})
+		}
+		if err := tab.Write(globalOptions.stdout); err != nil {
+			return err
+		}
+		Printf("\n")
+	}
+	Printf("The size includes all data referenced by the snapshots of a group, data shared with other groups is counted in each of them.\n")
+	return nil
+}
@@ -73,6 +73,8 @@ type GlobalOptions struct {
 	PackSize           uint
 	NoExtraVerify      bool
 	InsecureNoPassword bool
+	Profile            string
+	ProfileFile        string
 
 	backend.TransportOptions
 	limiter.Limits
@@ -93,7 +95,8 @@ type GlobalOptions struct {
 
 	Options []string
 
-	extended options.Options
+	extended       options.Options
+	profileOptions options.Options
 }
 
 var globalOptions = GlobalOptions{
@@ -112,6 +115,7 @@ func init() {
 	backends.Register(s3.NewFactory())
 	backends.Register(sftp.NewFactory())
 	backends.Register(swift.NewFactory())
+	backends.Register(location.NewMirrorFactory(backends, Warnf))
 	globalOptions.backends = backends
 
 	f := cmdRoot.PersistentFlags()
@@ -140,6 +144,8 @@ func init() {
 	f.UintVar(&globalOptions.PackSize, "pack-size", 0, "set target pack `size` in MiB, created pack files may be larger (default: $RESTIC_PACK_SIZE)")
 	f.StringSliceVarP(&globalOptions.Options, "option", "o", []string{}, "set extended option (`key=value`, can be specified multiple times)")
 	f.StringVar(&globalOptions.HTTPUserAgent, "http-user-agent", "", "set a http user agent for outgoing http requests")
+	f.StringVar(&globalOptions.Profile, "profile", "", "use the options of profile `name` for options not set on the command line or by environment variables (default: $RESTIC_PROFILE)")
+	f.StringVar(&globalOptions.ProfileFile, "profile-file", "", "read profiles from `file` (default: $RESTIC_PROFILE_FILE or restic/profiles.yaml in the user config directory)")
 	// Use our "generate" command instead of the cobra provided "completion" command
 	cmdRoot.CompletionOptions.DisableDefaultCmd = true
 
@@ -148,6 +154,8 @@ func init() {
 	globalOptions.PasswordFile = os.Getenv("RESTIC_PASSWORD_FILE")
 	globalOptions.KeyHint = os.Getenv("RESTIC_KEY_HINT")
 	globalOptions.PasswordCommand = os.Getenv("RESTIC_PASSWORD_COMMAND")
+	globalOptions.Profile = os.Getenv("RESTIC_PROFILE")
+	globalOptions.ProfileFile = os.Getenv("RESTIC_PROFILE_FILE")
 	if os.Getenv("RESTIC_CACERT") != "" {
 		globalOptions.RootCertFilenames = strings.Split(os.Getenv("RESTIC_CACERT"), ",")
 	}
@@ -564,6 +572,18 @@ func OpenRepository(ctx context.Context, opts GlobalOptions) (*repository.Reposi
 
 func parseConfig(loc location.Location, opts options.Options) (interface{}, error) {
 	cfg := loc.Config
+	if cfg, ok := cfg.(*location.MirrorConfig); ok {
+		// the environment and options apply to each mirrored location
+		for i := range cfg.Locations {
+			var err error
+			cfg.Locations[i].Config, err = parseConfig(cfg.Locations[i], opts)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return cfg, nil
+	}
+
 	if cfg, ok := cfg.(backend.ApplyEnvironmenter); ok {
 		cfg.ApplyEnvironment("")
 	}
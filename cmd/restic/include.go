@@ -20,7 +20,13 @@ type includePatternOptions struct {
 }
 
 func initIncludePatternOptions(f *pflag.FlagSet, opts *includePatternOptions) {
-	f.StringArrayVarP(&opts.Includes, "include", "i", nil, "include a `pattern` (can be specified multiple times)")
+	initIncludePatternOptionsShorthand(f, opts, "i")
+}
+
+// initIncludePatternOptionsShorthand adds the include flags with the given
+// shorthand for --include, which may be empty.
+func initIncludePatternOptionsShorthand(f *pflag.FlagSet, opts *includePatternOptions, shorthand string) {
+	f.StringArrayVarP(&opts.Includes, "include", shorthand, nil, "include a `pattern` (can be specified multiple times)")
 	f.StringArrayVar(&opts.InsensitiveIncludes, "iinclude", nil, "same as --include `pattern` but ignores the casing of filenames")
 	f.StringArrayVar(&opts.IncludeFiles, "include-file", nil, "read include patterns from a `file` (can be specified multiple times)")
 	f.StringArrayVar(&opts.InsensitiveIncludeFiles, "iinclude-file", nil, "same as --include-file but ignores casing of `file`names in patterns")
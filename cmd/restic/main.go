@@ -42,6 +42,12 @@ The full documentation can be found at https://restic.readthedocs.io/ .
 	DisableAutoGenTag: true,
 
 	PersistentPreRunE: func(c *cobra.Command, _ []string) error {
+		if globalOptions.Profile != "" {
+			if err := useProfile(c, &globalOptions); err != nil {
+				return err
+			}
+		}
+
 		// set verbosity, default is one
 		globalOptions.verbosity = 1
 		if globalOptions.Quiet && globalOptions.Verbose > 0 {
@@ -62,6 +68,7 @@ The full documentation can be found at https://restic.readthedocs.io/ .
 		if err != nil {
 			return err
 		}
+		opts.Merge(globalOptions.profileOptions)
 		globalOptions.extended = opts
 		if !needsPassword(c.Name()) {
 			return nil
@@ -87,7 +94,7 @@ The full documentation can be found at https://restic.readthedocs.io/ .
 // user for authentication).
 func needsPassword(cmd string) bool {
 	switch cmd {
-	case "cache", "generate", "help", "options", "self-update", "version", "__complete":
+	case "cache", "generate", "help", "options", "rest", "self-update", "version", "__complete":
 		return false
 	default:
 		return true
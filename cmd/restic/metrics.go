@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+// metricFamily is a set of samples of one OpenMetrics metric.
+type metricFamily struct {
+	name    string
+	typ     string
+	help    string
+	samples []metricSample
+}
+
+type metricSample struct {
+	labels []string // alternating label names and values
+	value  float64
+}
+
+// metricSet collects metrics which can be written in the OpenMetrics text
+// format, for example for the textfile collector of the Prometheus node
+// exporter.
+type metricSet struct {
+	families []*metricFamily
+	byName   map[string]*metricFamily
+}
+
+func newMetricSet() *metricSet {
+	return &metricSet{byName: make(map[string]*metricFamily)}
+}
+
+// Gauge adds a sample of the gauge name. Labels are passed as alternating
+// names and values.
+func (m *metricSet) Gauge(name, help string, value float64, labels ...string) {
+	m.add(name, "gauge", help, value, labels)
+}
+
+// Timestamp adds a gauge sample for name containing t as Unix timestamp in
+// seconds.
+func (m *metricSet) Timestamp(name, help string, t time.Time, labels ...string) {
+	m.add(name, "gauge", help, float64(t.UnixNano())/1e9, labels)
+}
+
+func (m *metricSet) add(name, typ, help string, value float64, labels []string) {
+	if len(labels)%2 != 0 {
+		panic("odd number of label names and values")
+	}
+
+	f, ok := m.byName[name]
+	if !ok {
+		f = &metricFamily{name: name, typ: typ, help: help}
+		m.families = append(m.families, f)
+		m.byName[name] = f
+	}
+	f.samples = append(f.samples, metricSample{labels: labels, value: value})
+}
+
+// WriteTo writes all metrics in the OpenMetrics text format to w.
+func (m *metricSet) WriteTo(w io.Writer) (int64, error) {
+	wr := &countingWriter{w: bufio.NewWriter(w)}
+	for _, f := range m.families {
+		fmt.Fprintf(wr, "# TYPE %s %s\n", f.name, f.typ)
+		fmt.Fprintf(wr, "# HELP %s %s\n", f.name, escapeMetricHelp(f.help))
+		for _, s := range f.samples {
+			wr.WriteString(f.name)
+			if len(s.labels) > 0 {
+				wr.WriteString("{")
+				for i := 0; i < len(s.labels); i += 2 {
+					if i > 0 {
+						wr.WriteString(",")
+					}
+					fmt.Fprintf(wr, "%s=\"%s\"", s.labels[i], escapeMetricLabel(s.labels[i+1]))
+				}
+				wr.WriteString("}")
+			}
+			fmt.Fprintf(wr, " %s\n", strconv.FormatFloat(s.value, 'g', -1, 64))
+		}
+	}
+	wr.WriteString("# EOF\n")
+
+	if wr.err != nil {
+		return wr.n, wr.err
+	}
+	return wr.n, wr.w.Flush()
+}
+
+type countingWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	w.err = err
+	return n, err
+}
+
+func (w *countingWriter) WriteString(s string) {
+	_, _ = w.Write([]byte(s))
+}
+
+var (
+	metricHelpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	metricLabelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeMetricHelp(s string) string {
+	return metricHelpEscaper.Replace(s)
+}
+
+func escapeMetricLabel(s string) string {
+	return metricLabelEscaper.Replace(s)
+}
+
+// writeMetricsFile atomically replaces filename with the metrics, so that
+// collectors never read a partially written file.
+func writeMetricsFile(filename string, m *metricSet) error {
+	f, err := os.CreateTemp(filepath.Dir(filename), "."+filepath.Base(filename)+"-*.tmp")
+	if err != nil {
+		return err
+	}
+
+	_, err = m.WriteTo(f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), filename)
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+	}
+	return err
+}
+
+// saveMetricsFile writes the metrics to filename if it is not empty. Errors
+// are only reported as a warning, as the metrics are not essential to the
+// command.
+func saveMetricsFile(filename string, m *metricSet) {
+	if filename == "" {
+		return
+	}
+	if err := writeMetricsFile(filename, m); err != nil {
+		Warnf("unable to write metrics file: %v\n", err)
+	}
+}
+
+func initMetricsFileFlag(f *pflag.FlagSet, filename *string) {
+	f.StringVar(filename, "metrics-file", "", "write OpenMetrics text to `file` after the command finished (e.g. for the node exporter textfile collector)")
+}
+
+func boolMetric(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
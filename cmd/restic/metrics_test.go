@@ -0,0 +1,36 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	rtest "github.com/chanhpng/vlbe/internal/test"
+)
+
+func TestMetricSetWriteTo(t *testing.T) {
+	m := newMetricSet()
+	m.Gauge("restic_test", "Test metric\nwith newline", 42, "host", "a\"b", "paths", `c:\d`)
+	m.Gauge("restic_test", "Test metric\nwith newline", 0.5, "host", "x\ny", "paths", "")
+	m.Timestamp("restic_test_timestamp_seconds", "Test timestamp", time.Unix(1700000000, 500000000))
+	m.Gauge("restic_test_unlabeled", "Unlabeled", 3)
+
+	buf := &bytes.Buffer{}
+	n, err := m.WriteTo(buf)
+	rtest.OK(t, err)
+	rtest.Equals(t, int64(buf.Len()), n)
+
+	want := `# TYPE restic_test gauge
+# HELP restic_test Test metric\nwith newline
+restic_test{host="a\"b",paths="c:\\d"} 42
+restic_test{host="x\ny",paths=""} 0.5
+# TYPE restic_test_timestamp_seconds gauge
+# HELP restic_test_timestamp_seconds Test timestamp
+restic_test_timestamp_seconds 1.7000000005e+09
+# TYPE restic_test_unlabeled gauge
+# HELP restic_test_unlabeled Unlabeled
+restic_test_unlabeled 3
+# EOF
+`
+	rtest.Equals(t, want, buf.String())
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+
+	"github.com/chanhpng/vlbe/internal/errors"
+	"github.com/chanhpng/vlbe/internal/options"
+	"github.com/chanhpng/vlbe/internal/textfile"
+)
+
+// profileFile is the content of a file defining profiles, for example:
+//
+//	profiles:
+//	  home:
+//	    repo: sftp:backup@example.com:/srv/restic
+//	    password-command: pass restic/home
+//	    option:
+//	      sftp.connections: 10
+//	    backup:
+//	      exclude-file: [/home/user/.restic-excludes]
+//	      tag: [home]
+//	    forget:
+//	      keep-daily: 7
+//	      prune: true
+type profileFile struct {
+	Profiles map[string]profile `yaml:"profiles"`
+}
+
+// profile maps the long names of global flags to their values. Values which
+// are mappings contain the flags for the command named by the key, e.g.
+// "backup" or "key add". The key "option" holds extended options, either as a
+// list of key=value pairs or as a mapping.
+type profile map[string]interface{}
+
+// profileEnv lists the environment variables which take precedence over the
+// value of a flag set by a profile.
+var profileEnv = map[string][]string{
+	"repo":             {"RESTIC_REPOSITORY", "RESTIC_REPOSITORY_FILE"},
+	"repository-file":  {"RESTIC_REPOSITORY", "RESTIC_REPOSITORY_FILE"},
+	"password-file":    {"RESTIC_PASSWORD", "RESTIC_PASSWORD_FILE", "RESTIC_PASSWORD_COMMAND"},
+	"password-command": {"RESTIC_PASSWORD", "RESTIC_PASSWORD_FILE", "RESTIC_PASSWORD_COMMAND"},
+	"key-hint":         {"RESTIC_KEY_HINT"},
+	"cacert":           {"RESTIC_CACERT"},
+	"tls-client-cert":  {"RESTIC_TLS_CLIENT_CERT"},
+	"compression":      {"RESTIC_COMPRESSION"},
+	"pack-size":        {"RESTIC_PACK_SIZE"},
+	"http-user-agent":  {"RESTIC_HTTP_USER_AGENT"},
+	"host":             {"RESTIC_HOST"},
+	"read-concurrency": {"RESTIC_READ_CONCURRENCY"},
+}
+
+// profileExclusive lists the flags which are mutually exclusive with a flag.
+// If one of them is set on the command line, the value from the profile is
+// ignored, so that setting for example --repository-file overrides the
+// repository of the profile instead of conflicting with it.
+var profileExclusive = map[string][]string{
+	"repo":             {"repository-file"},
+	"repository-file":  {"repo"},
+	"password-file":    {"password-command"},
+	"password-command": {"password-file"},
+}
+
+// defaultProfileFile returns the location of the profile file used if
+// neither --profile-file nor $RESTIC_PROFILE_FILE is set.
+func defaultProfileFile() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", errors.Fatalf("unable to locate the profile file, use --profile-file: %v", err)
+	}
+	return filepath.Join(dir, "restic", "profiles.yaml"), nil
+}
+
+// loadYAMLFile decodes the YAML configuration file filename into v, what
+// describes the file in error messages. Unknown fields are rejected, an empty
+// file leaves v unchanged.
+func loadYAMLFile(filename, what string, v interface{}) error {
+	buf, err := textfile.Read(filename)
+	if err != nil {
+		return errors.Fatalf("unable to read %v: %v", what, err)
+	}
+
+	dec := yaml.NewDecoder(bytes.NewReader(buf))
+	dec.KnownFields(true)
+	if err := dec.Decode(v); err != nil && err != io.EOF {
+		return errors.Fatalf("unable to parse %v %v: %v", what, filename, err)
+	}
+	return nil
+}
+
+// loadProfile reads the profile name from filename.
+func loadProfile(filename, name string) (profile, error) {
+	var f profileFile
+	if err := loadYAMLFile(filename, "profile file", &f); err != nil {
+		return nil, err
+	}
+
+	p, ok := f.Profiles[name]
+	if !ok {
+		return nil, errors.Fatalf("profile %q not found in %v", name, filename)
+	}
+	return p, nil
+}
+
+// applyProfile sets all flags of the root command and of cmd which are
+// neither set on the command line nor through an environment variable to the
+// values from the profile. The extended options of the profile are returned
+// separately, so that they can be merged with those from the command line.
+func applyProfile(p profile, root, cmd *cobra.Command, lookupEnv func(string) (string, bool)) (options.Options, error) {
+	var extended options.Options
+
+	for _, key := range sortedProfileKeys(p) {
+		value := p[key]
+
+		if key == "option" {
+			var err error
+			extended, err = parseProfileOptions(value)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		section, ok := profileMapping(value)
+		if !ok {
+			if err := setProfileFlag(root.PersistentFlags(), root.PersistentFlags(), key, value, lookupEnv); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		sub, _, err := root.Find(strings.Fields(key))
+		if err != nil || sub == root {
+			return nil, errors.Fatalf("profile contains options for unknown command %q", key)
+		}
+		if sub != cmd {
+			continue
+		}
+		for _, name := range sortedProfileKeys(section) {
+			if err := setProfileFlag(cmd.LocalFlags(), cmd.Flags(), name, section[name], lookupEnv); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return extended, nil
+}
+
+// setProfileFlag sets the flag name, which must be part of valid, using fs.
+func setProfileFlag(valid, fs *pflag.FlagSet, name string, value interface{}, lookupEnv func(string) (string, bool)) error {
+	flag := valid.Lookup(name)
+	if flag == nil {
+		return errors.Fatalf("profile contains unknown option %q", name)
+	}
+
+	if flag.Changed {
+		return nil
+	}
+	for _, other := range profileExclusive[name] {
+		if f := valid.Lookup(other); f != nil && f.Changed {
+			return nil
+		}
+	}
+	for _, env := range profileEnv[name] {
+		if v, ok := lookupEnv(env); ok && v != "" {
+			return nil
+		}
+	}
+
+	values, err := profileValues(value)
+	if err != nil {
+		return errors.Fatalf("invalid value for option %q in profile: %v", name, err)
+	}
+	for _, v := range values {
+		if err := fs.Set(name, v); err != nil {
+			return errors.Fatalf("invalid value %q for option %q in profile: %v", v, name, err)
+		}
+	}
+	return nil
+}
+
+// parseProfileOptions parses the extended options of a profile.
+func parseProfileOptions(value interface{}) (options.Options, error) {
+	var list []string
+	if m, ok := profileMapping(value); ok {
+		for _, k := range sortedProfileKeys(m) {
+			v, err := profileScalar(m[k])
+			if err != nil {
+				return nil, errors.Fatalf("invalid value for extended option %q in profile: %v", k, err)
+			}
+			list = append(list, k+"="+v)
+		}
+	} else {
+		var err error
+		list, err = profileValues(value)
+		if err != nil {
+			return nil, errors.Fatalf("invalid extended options in profile: %v", err)
+		}
+	}
+	return options.Parse(list)
+}
+
+// profileValues returns the values of a flag, which is either a scalar or a
+// list of scalars for flags which can be specified multiple times.
+func profileValues(value interface{}) ([]string, error) {
+	list, ok := value.([]interface{})
+	if !ok {
+		v, err := profileScalar(value)
+		if err != nil {
+			return nil, err
+		}
+		return []string{v}, nil
+	}
+
+	values := make([]string, 0, len(list))
+	for _, item := range list {
+		v, err := profileScalar(item)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+func profileScalar(value interface{}) (string, error) {
+	if _, ok := profileMapping(value); ok {
+		return "", errors.New("expected a single value")
+	}
+	switch value.(type) {
+	case nil:
+		return "", errors.New("value is empty")
+	case []interface{}:
+		return "", errors.New("expected a single value")
+	}
+	return fmt.Sprint(value), nil
+}
+
+// profileMapping returns value as a mapping if it is one. Nested mappings are
+// decoded with the type of the enclosing map.
+func profileMapping(value interface{}) (map[string]interface{}, bool) {
+	switch v := value.(type) {
+	case profile:
+		return v, true
+	case map[string]interface{}:
+		return v, true
+	}
+	return nil, false
+}
+
+func sortedProfileKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// useProfile loads the profile selected by gopts and applies it to the flags
+// of cmd.
+func useProfile(cmd *cobra.Command, gopts *GlobalOptions) error {
+	filename := gopts.ProfileFile
+	if filename == "" {
+		var err error
+		filename, err = defaultProfileFile()
+		if err != nil {
+			return err
+		}
+	}
+
+	p, err := loadProfile(filename, gopts.Profile)
+	if err != nil {
+		return err
+	}
+
+	gopts.profileOptions, err = applyProfile(p, cmd.Root(), cmd, os.LookupEnv)
+	return err
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/cobra"
+
+	"github.com/chanhpng/vlbe/internal/options"
+	rtest "github.com/chanhpng/vlbe/internal/test"
+)
+
+const testProfiles = `
+profiles:
+  home:
+    repo: /srv/restic
+    password-file: /etc/restic/password
+    verbose: 2
+    option:
+      sftp.connections: 10
+      s3.region: eu-west-1
+    backup:
+      exclude: ["*.tmp", "*.bak"]
+      host: example
+    forget:
+      keep-daily: 7
+  broken:
+    backup:
+      unknown-flag: true
+`
+
+type profileTestOptions struct {
+	repo, repositoryFile      string
+	passwordFile, passwordCmd string
+	verbose                   int
+	option                    []string
+	excludes                  []string
+	host                      string
+	keepDaily                 int
+}
+
+// newProfileTestCommands returns a minimal command tree with the flags used by
+// the test profiles.
+func newProfileTestCommands(opts *profileTestOptions) (root, backup *cobra.Command) {
+	run := func(*cobra.Command, []string) {}
+	root = &cobra.Command{Use: "restic"}
+	backup = &cobra.Command{Use: "backup", Run: run}
+	forget := &cobra.Command{Use: "forget", Run: run}
+	root.AddCommand(backup, forget)
+
+	f := root.PersistentFlags()
+	f.StringVarP(&opts.repo, "repo", "r", "", "")
+	f.StringVar(&opts.repositoryFile, "repository-file", "", "")
+	f.StringVar(&opts.passwordFile, "password-file", "", "")
+	f.StringVar(&opts.passwordCmd, "password-command", "", "")
+	f.CountVarP(&opts.verbose, "verbose", "v", "")
+	f.StringSliceVarP(&opts.option, "option", "o", nil, "")
+	backup.Flags().StringArrayVar(&opts.excludes, "exclude", nil, "")
+	backup.Flags().StringVar(&opts.host, "host", "", "")
+	forget.Flags().IntVar(&opts.keepDaily, "keep-daily", 0, "")
+	return root, backup
+}
+
+func writeTestProfiles(t testing.TB) string {
+	filename := filepath.Join(rtest.TempDir(t), "profiles.yaml")
+	rtest.OK(t, os.WriteFile(filename, []byte(testProfiles), 0600))
+	return filename
+}
+
+func applyTestProfile(t testing.TB, name string, env map[string]string, args ...string) (profileTestOptions, options.Options, error) {
+	p, err := loadProfile(writeTestProfiles(t), name)
+	rtest.OK(t, err)
+
+	var opts profileTestOptions
+	root, _ := newProfileTestCommands(&opts)
+	root.SetArgs(args)
+
+	var extended options.Options
+	root.PersistentPreRunE = func(c *cobra.Command, _ []string) error {
+		var err error
+		extended, err = applyProfile(p, root, c, func(key string) (string, bool) {
+			v, ok := env[key]
+			return v, ok
+		})
+		return err
+	}
+	err = root.Execute()
+	return opts, extended, err
+}
+
+func TestProfileApply(t *testing.T) {
+	opts, extended, err := applyTestProfile(t, "home", nil, "backup")
+	rtest.OK(t, err)
+	rtest.Equals(t, profileTestOptions{
+		repo:         "/srv/restic",
+		passwordFile: "/etc/restic/password",
+		verbose:      2,
+		excludes:     []string{"*.tmp", "*.bak"},
+		host:         "example",
+	}, opts)
+	rtest.Equals(t, options.Options{"sftp.connections": "10", "s3.region": "eu-west-1"}, extended)
+
+	opts, _, err = applyTestProfile(t, "home", nil, "forget")
+	rtest.OK(t, err)
+	rtest.Equals(t, 7, opts.keepDaily)
+	rtest.Equals(t, []string(nil), opts.excludes)
+}
+
+func TestProfilePrecedence(t *testing.T) {
+	env := map[string]string{
+		"RESTIC_REPOSITORY": "/from/env",
+		"RESTIC_HOST":       "envhost",
+	}
+	opts, _, err := applyTestProfile(t, "home", env, "backup", "--exclude", "*.iso", "--password-file", "/from/flag")
+	rtest.OK(t, err)
+
+	// flags take precedence over environment variables and the profile
+	rtest.Equals(t, []string{"*.iso"}, opts.excludes)
+	rtest.Equals(t, "/from/flag", opts.passwordFile)
+	// environment variables take precedence over the profile
+	rtest.Equals(t, "", opts.repo)
+	rtest.Equals(t, "", opts.host)
+	// everything else is taken from the profile
+	rtest.Equals(t, 2, opts.verbose)
+}
+
+func TestProfileExclusiveFlags(t *testing.T) {
+	// a flag on the command line overrides the mutually exclusive option of
+	// the profile
+	opts, _, err := applyTestProfile(t, "home", nil, "backup", "--repository-file", "/from/flag", "--password-command", "pass")
+	rtest.OK(t, err)
+	rtest.Equals(t, "", opts.repo)
+	rtest.Equals(t, "/from/flag", opts.repositoryFile)
+	rtest.Equals(t, "", opts.passwordFile)
+	rtest.Equals(t, "pass", opts.passwordCmd)
+
+	// the same holds for the environment variables
+	env := map[string]string{
+		"RESTIC_REPOSITORY_FILE":  "/from/env",
+		"RESTIC_PASSWORD_COMMAND": "pass",
+	}
+	opts, _, err = applyTestProfile(t, "home", env, "backup")
+	rtest.OK(t, err)
+	rtest.Equals(t, "", opts.repo)
+	rtest.Equals(t, "", opts.passwordFile)
+}
+
+func TestProfileErrors(t *testing.T) {
+	filename := writeTestProfiles(t)
+	_, err := loadProfile(filename, "missing")
+	rtest.Assert(t, err != nil, "expected error for missing profile")
+
+	_, _, err = applyTestProfile(t, "broken", nil, "backup")
+	rtest.Assert(t, err != nil, "expected error for unknown flag")
+
+	for _, p := range []profile{
+		{"unknown-global": "x"},
+		{"snapshot": map[string]interface{}{"host": "x"}},
+		{"repo": []interface{}{[]interface{}{"nested"}}},
+	} {
+		var opts profileTestOptions
+		root, backup := newProfileTestCommands(&opts)
+		_, err := applyProfile(p, root, backup, os.LookupEnv)
+		rtest.Assert(t, err != nil, "expected error for profile %v", p)
+	}
+}
@@ -3,6 +3,7 @@ package archiver
 import (
 	"context"
 	"fmt"
+	"io"
 	"os"
 	"path"
 	"runtime"
@@ -68,10 +69,12 @@ type archiverRepo interface {
 	restic.Loader
 	restic.BlobSaver
 	restic.SaverUnpacked
+	restic.RemoverUnpacked
 
 	Config() restic.Config
 	StartPackUploader(ctx context.Context, wg *errgroup.Group)
 	Flush(ctx context.Context) error
+	FlushPending(ctx context.Context) error
 }
 
 // Archiver saves a directory structure to the repo.
@@ -88,6 +91,9 @@ type Archiver struct {
 	mu        sync.Mutex
 	summary   *Summary
 
+	checkpoint     *checkpointTracker
+	lastCheckpoint *restic.ID
+
 	// Error is called for all errors that occur during backup.
 	Error ErrorFunc
 
@@ -109,6 +115,10 @@ type Archiver struct {
 	// CompleteBlob is called for all saved blobs for files.
 	CompleteBlob func(bytes uint64)
 
+	// Warn is called for problems which do not affect the snapshot, e.g. if
+	// a checkpoint which is no longer needed cannot be removed.
+	Warn func(msg string, args ...interface{})
+
 	// WithAtime configures if the access time for files and directories should
 	// be saved. Enabling it may result in much metadata, so it's off by
 	// default.
@@ -116,6 +126,11 @@ type Archiver struct {
 
 	// Flags controlling change detection. See doc/040_backup.rst for details.
 	ChangeIgnoreFlags uint
+
+	// Changes contains the paths which have changed since the parent
+	// snapshot. If set, directories without changes are taken from the
+	// parent snapshot without reading them.
+	Changes *ChangeSet
 }
 
 // Flags for the ChangeIgnoreFlags bitfield.
@@ -139,6 +154,10 @@ type Options struct {
 	// SaveTreeConcurrency sets how many trees are marshalled and saved to the
 	// repo concurrently.
 	SaveTreeConcurrency uint
+
+	// ReadSpecial enables reading the content of block devices, which are
+	// then stored as regular files.
+	ReadSpecial bool
 }
 
 // ApplyDefaults returns a copy of o with the default options set for all unset
@@ -181,6 +200,7 @@ func New(repo archiverRepo, fs fs.FS, opts Options) *Archiver {
 		CompleteItem: func(string, *restic.Node, *restic.Node, ItemStats, time.Duration) {},
 		StartFile:    func(string) {},
 		CompleteBlob: func(uint64) {},
+		Warn:         func(string, ...interface{}) {},
 	}
 
 	return arch
@@ -218,6 +238,7 @@ func (arch *Archiver) trackItem(item string, previous, current *restic.Node, s I
 
 	if current != nil {
 		arch.summary.ProcessedBytes += current.Size
+		arch.checkpoint.complete(item, current)
 	} else {
 		// last item or an error occurred
 		return
@@ -246,13 +267,23 @@ func (arch *Archiver) trackItem(item string, previous, current *restic.Node, s I
 	}
 }
 
-// nodeFromFileInfo returns the restic node from an os.FileInfo.
+// nodeFromFileInfo returns the restic node from an os.FileInfo. The metadata
+// which is not part of fi is read from the path the file system maps filename
+// to, e.g. from a filesystem snapshot.
 func (arch *Archiver) nodeFromFileInfo(snPath, filename string, fi os.FileInfo, ignoreXattrListError bool) (*restic.Node, error) {
+	if m, ok := arch.FS.(fs.PathMapper); ok {
+		mapped, err := m.MappedPath(filename)
+		if err != nil {
+			return nil, fmt.Errorf("nodeFromFileInfo %v: %w", filename, err)
+		}
+		filename = mapped
+	}
+
 	node, err := restic.NodeFromFileInfo(filename, fi, ignoreXattrListError)
 	if !arch.WithAtime {
 		node.AccessTime = node.ModTime
 	}
-	if feature.Flag.Enabled(feature.DeviceIDForHardlinks) {
+	if arch.Repo.Config().Version >= 3 || feature.Flag.Enabled(feature.DeviceIDForHardlinks) {
 		if node.Links == 1 || node.Type == "dir" {
 			// the DeviceID is only necessary for hardlinked files
 			// when using subvolumes or snapshots their deviceIDs tend to change which causes
@@ -303,6 +334,7 @@ func (arch *Archiver) saveDir(ctx context.Context, snPath string, dir string, fi
 	if err != nil {
 		return FutureNode{}, err
 	}
+	arch.checkpoint.enterDir(snPath, treeNode, previous)
 
 	names, err := fs.Readdirnames(arch.FS, dir, fs.O_NOFOLLOW)
 	if err != nil {
@@ -407,6 +439,19 @@ func (arch *Archiver) allBlobsPresent(previous *restic.Node) bool {
 	return true
 }
 
+// dirUnchanged reports whether the directory abstarget is known to be
+// unchanged since the parent snapshot, in which previous is its node.
+func (arch *Archiver) dirUnchanged(abstarget string, previous *restic.Node) bool {
+	if arch.Changes == nil || previous == nil || previous.Type != "dir" || previous.Subtree == nil {
+		return false
+	}
+	if arch.Changes.Changed(abstarget) {
+		return false
+	}
+	_, ok := arch.Repo.LookupBlobSize(restic.TreeBlob, *previous.Subtree)
+	return ok
+}
+
 // save saves a target (file or directory) to the repo. If the item is
 // excluded, this function returns a nil node and error, with excluded set to
 // true.
@@ -527,6 +572,18 @@ func (arch *Archiver) save(ctx context.Context, snPath, target string, previous
 		debug.Log("  %v dir", target)
 
 		snItem := snPath + "/"
+		if arch.dirUnchanged(abstarget, previous) {
+			debug.Log("%v hasn't changed, using old subtree", target)
+			node := *previous
+			arch.trackItem(snItem, previous, &node, ItemStats{}, time.Since(start))
+			fn = newFutureNodeWithResult(futureNodeResult{
+				snPath: snPath,
+				target: target,
+				node:   &node,
+			})
+			return fn, false, nil
+		}
+
 		oldSubtree, err := arch.loadSubtree(ctx, previous)
 		if err != nil {
 			err = arch.error(abstarget, err)
@@ -548,6 +605,11 @@ func (arch *Archiver) save(ctx context.Context, snPath, target string, previous
 		debug.Log("  %v is a socket, ignoring", target)
 		return FutureNode{}, true, nil
 
+	case arch.Options.ReadSpecial && fs.IsBlockDevice(fi):
+		debug.Log("  %v block device", target)
+
+		return arch.saveBlockDevice(ctx, snPath, target, abstarget, previous, start)
+
 	default:
 		debug.Log("  %v other", target)
 
@@ -602,6 +664,67 @@ func join(elem ...string) string {
 	return path.Join(elem...)
 }
 
+// blockDeviceInfo presents a block device as a regular file with the size of
+// the device.
+type blockDeviceInfo struct {
+	os.FileInfo
+	size int64
+}
+
+func (fi blockDeviceInfo) Mode() os.FileMode {
+	return fi.FileInfo.Mode() &^ os.ModeDevice
+}
+
+func (fi blockDeviceInfo) Size() int64 {
+	return fi.size
+}
+
+// saveBlockDevice stores the content of the block device target as a regular
+// file. The device is always read completely, as there is no way to find out
+// whether its content has changed.
+func (arch *Archiver) saveBlockDevice(ctx context.Context, snPath, target, abstarget string, previous *restic.Node, start time.Time) (FutureNode, bool, error) {
+	file, err := arch.FS.OpenFile(target, fs.O_RDONLY|fs.O_NOFOLLOW, 0)
+	if err != nil {
+		debug.Log("Openfile() for %v returned error: %v", target, err)
+		err = arch.error(abstarget, err)
+		if err != nil {
+			return FutureNode{}, false, errors.WithStack(err)
+		}
+		return FutureNode{}, true, nil
+	}
+
+	// make sure it's still a block device and determine its size
+	fi, err := file.Stat()
+	if err == nil && !fs.IsBlockDevice(fi) {
+		err = errors.Errorf("file %v changed type, refusing to archive", fi.Name())
+	}
+	var size int64
+	if err == nil {
+		size, err = file.Seek(0, io.SeekEnd)
+	}
+	if err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		_ = file.Close()
+		err = arch.error(abstarget, err)
+		if err != nil {
+			return FutureNode{}, false, errors.WithStack(err)
+		}
+		return FutureNode{}, true, nil
+	}
+
+	// Save will close the file, we don't need to do that
+	fn := arch.fileSaver.Save(ctx, snPath, target, file, blockDeviceInfo{FileInfo: fi, size: size}, func() {
+		arch.StartFile(snPath)
+	}, func() {
+		arch.trackItem(snPath, nil, nil, ItemStats{}, 0)
+	}, func(node *restic.Node, stats ItemStats) {
+		arch.trackItem(snPath, previous, node, stats, time.Since(start))
+	})
+	return fn, false, nil
+}
+
 // statDir returns the file info for the directory. Symbolic links are
 // resolved. If the target directory is not a directory, an error is returned.
 func (arch *Archiver) statDir(dir string) (os.FileInfo, error) {
@@ -644,6 +767,7 @@ func (arch *Archiver) saveTree(ctx context.Context, snPath string, atree *Tree,
 		// fake root node
 		node = &restic.Node{}
 	}
+	arch.checkpoint.enterDir(snPath, node, previous)
 
 	debug.Log("%v (%v nodes), parent %v", snPath, len(atree.Nodes), previous)
 	nodeNames := atree.NodeNames()
@@ -748,6 +872,10 @@ type SnapshotOptions struct {
 	ProgramVersion string
 	// SkipIfUnchanged omits the snapshot creation if it is identical to the parent snapshot.
 	SkipIfUnchanged bool
+	// CheckpointInterval is the interval in which checkpoints of the backup
+	// are saved. If the parent snapshot is a checkpoint, it is replaced by
+	// the new snapshot. Zero disables checkpoints.
+	CheckpointInterval time.Duration
 }
 
 // loadParentTree loads a tree referenced by snapshot id. If id is null, nil is returned.
@@ -777,7 +905,7 @@ func (arch *Archiver) runWorkers(ctx context.Context, wg *errgroup.Group) {
 
 	arch.fileSaver = NewFileSaver(ctx, wg,
 		arch.blobSaver.Save,
-		arch.Repo.Config().ChunkerPolynomial,
+		arch.Repo.Config().ChunkerParams(),
 		arch.Options.ReadConcurrency, arch.Options.SaveBlobConcurrency)
 	arch.fileSaver.CompleteBlob = arch.CompleteBlob
 	arch.fileSaver.NodeFromFileInfo = arch.nodeFromFileInfo
@@ -813,11 +941,25 @@ func (arch *Archiver) Snapshot(ctx context.Context, targets []string, opts Snaps
 	wgUp, wgUpCtx := errgroup.WithContext(ctx)
 	arch.Repo.StartPackUploader(wgUpCtx, wgUp)
 
+	arch.checkpoint = nil
+	arch.lastCheckpoint = nil
+	if opts.CheckpointInterval > 0 {
+		arch.checkpoint = &checkpointTracker{}
+	}
+
 	wgUp.Go(func() error {
 		wg, wgCtx := errgroup.WithContext(wgUpCtx)
 		start := time.Now()
 
+		done := make(chan struct{})
+		if arch.checkpoint != nil {
+			wg.Go(func() error {
+				return arch.runCheckpoints(wgCtx, targets, opts, done)
+			})
+		}
+
 		wg.Go(func() error {
+			defer close(done)
 			arch.runWorkers(wgCtx, wg)
 
 			debug.Log("starting snapshot")
@@ -861,23 +1003,18 @@ func (arch *Archiver) Snapshot(ctx context.Context, targets []string, opts Snaps
 		return nil, restic.ID{}, nil, err
 	}
 
-	if opts.ParentSnapshot != nil && opts.SkipIfUnchanged {
+	if opts.ParentSnapshot != nil && opts.SkipIfUnchanged && !opts.ParentSnapshot.Checkpoint {
 		ps := opts.ParentSnapshot
 		if ps.Tree != nil && rootTreeID.Equal(*ps.Tree) {
+			arch.removeCheckpoint(ctx)
 			return nil, restic.ID{}, arch.summary, nil
 		}
 	}
 
-	sn, err := restic.NewSnapshot(targets, opts.Tags, opts.Hostname, opts.Time)
+	sn, err := arch.newSnapshot(targets, opts)
 	if err != nil {
 		return nil, restic.ID{}, nil, err
 	}
-
-	sn.ProgramVersion = opts.ProgramVersion
-	sn.Excludes = opts.Excludes
-	if opts.ParentSnapshot != nil {
-		sn.Parent = opts.ParentSnapshot.ID()
-	}
 	sn.Tree = &rootTreeID
 	sn.Summary = &restic.SnapshotSummary{
 		BackupStart: opts.BackupStart,
@@ -902,5 +1039,92 @@ func (arch *Archiver) Snapshot(ctx context.Context, targets []string, opts Snaps
 		return nil, restic.ID{}, nil, err
 	}
 
+	// the checkpoints are superseded by the new snapshot
+	arch.removeCheckpoint(ctx)
+	if opts.ParentSnapshot != nil && opts.ParentSnapshot.Checkpoint {
+		arch.lastCheckpoint = opts.ParentSnapshot.ID()
+		arch.removeCheckpoint(ctx)
+	}
+
 	return sn, id, arch.summary, nil
 }
+
+// newSnapshot returns a snapshot of targets without a tree. If the parent
+// snapshot is a checkpoint, the parent of the checkpoint is used instead.
+func (arch *Archiver) newSnapshot(targets []string, opts SnapshotOptions) (*restic.Snapshot, error) {
+	sn, err := restic.NewSnapshot(targets, opts.Tags, opts.Hostname, opts.Time)
+	if err != nil {
+		return nil, err
+	}
+
+	sn.ProgramVersion = opts.ProgramVersion
+	sn.Excludes = opts.Excludes
+	if ps := opts.ParentSnapshot; ps != nil {
+		if ps.Checkpoint {
+			sn.Parent = ps.Parent
+		} else {
+			sn.Parent = ps.ID()
+		}
+	}
+	return sn, nil
+}
+
+// SaveFiles saves the content of the regular files in targets and returns the
+// nodes for them in the same order. In contrast to Snapshot, neither trees nor
+// a snapshot are saved.
+func (arch *Archiver) SaveFiles(ctx context.Context, targets []string) ([]*restic.Node, error) {
+	arch.summary = &Summary{}
+	nodes := make([]*restic.Node, len(targets))
+
+	wgUp, wgUpCtx := errgroup.WithContext(ctx)
+	arch.Repo.StartPackUploader(wgUpCtx, wgUp)
+
+	wgUp.Go(func() error {
+		wg, wgCtx := errgroup.WithContext(wgUpCtx)
+
+		wg.Go(func() error {
+			arch.runWorkers(wgCtx, wg)
+
+			fns := make([]FutureNode, len(targets))
+			for i, target := range targets {
+				fi, err := arch.FS.Lstat(target)
+				if err != nil {
+					return err
+				}
+				if !fs.IsRegularFile(fi) {
+					return errors.Errorf("%v is not a regular file", target)
+				}
+
+				fn, excluded, err := arch.save(wgCtx, "/"+arch.FS.Base(target), target, nil)
+				if err != nil {
+					return err
+				}
+				if excluded {
+					return errors.Errorf("%v could not be read", target)
+				}
+				fns[i] = fn
+			}
+
+			for i := range fns {
+				fnr := fns[i].take(wgCtx)
+				if fnr.err != nil {
+					return fnr.err
+				}
+				nodes[i] = fnr.node
+			}
+
+			arch.stopWorkers()
+			return nil
+		})
+
+		if err := wg.Wait(); err != nil {
+			return err
+		}
+		return arch.Repo.Flush(ctx)
+	})
+
+	if err := wgUp.Wait(); err != nil {
+		return nil, err
+	}
+	return nodes, nil
+}
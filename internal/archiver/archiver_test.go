@@ -3,6 +3,7 @@ package archiver
 import (
 	"bytes"
 	"context"
+	"fmt"
 	"io"
 	"os"
 	"path/filepath"
@@ -161,6 +162,35 @@ func TestArchiverSaveFile(t *testing.T) {
 	}
 }
 
+func TestArchiverSaveFiles(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	files := TestDir{
+		"empty": TestFile{Content: ""},
+		"foo":   TestFile{Content: "foo"},
+		"large": TestFile{Content: string(rtest.Random(23, 3*1024*1024+1287))},
+	}
+	tempdir, repo := prepareTempdirRepoSrc(t, files)
+
+	arch := New(repo, fs.Local{}, Options{})
+	names := []string{"large", "empty", "foo"}
+	var targets []string
+	for _, name := range names {
+		targets = append(targets, filepath.Join(tempdir, name))
+	}
+
+	nodes, err := arch.SaveFiles(ctx, targets)
+	rtest.OK(t, err)
+	rtest.Equals(t, len(names), len(nodes))
+	for i, name := range names {
+		TestEnsureFileContent(ctx, t, repo, name, nodes[i], files[name].(TestFile))
+	}
+
+	_, err = arch.SaveFiles(ctx, []string{tempdir})
+	rtest.Assert(t, err != nil, "expected error for directory")
+}
+
 func TestArchiverSaveFileReaderFS(t *testing.T) {
 	var tests = []struct {
 		Data string
@@ -2338,3 +2368,210 @@ func TestRacyFileSwap(t *testing.T) {
 		t.Errorf("Save() excluded the node, that's unexpected")
 	}
 }
+
+// blockingFS blocks opening the file with the given name until unblock is
+// closed.
+type blockingFS struct {
+	fs.FS
+	name    string
+	unblock chan struct{}
+}
+
+func (b *blockingFS) OpenFile(name string, flag int, perm os.FileMode) (fs.File, error) {
+	if filepath.Base(name) == b.name {
+		<-b.unblock
+		return nil, errors.New("blocked")
+	}
+	return b.FS.OpenFile(name, flag, perm)
+}
+
+func TestArchiverCheckpoint(t *testing.T) {
+	src := TestDir{
+		"a": TestDir{
+			"file1": TestFile{Content: "foo"},
+			"file2": TestFile{Content: "bar"},
+		},
+		"b": TestDir{
+			"blocked": TestFile{Content: "baz"},
+		},
+	}
+
+	tempdir := rtest.TempDir(t)
+	TestCreateFiles(t, tempdir, src)
+	back := rtest.Chdir(t, tempdir)
+	defer back()
+
+	repo, be := repository.TestRepositoryWithVersion(t, 0)
+	blocking := &blockingFS{FS: fs.Local{}, name: "blocked", unblock: make(chan struct{})}
+	arch := New(repo, blocking, Options{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	opts := SnapshotOptions{Time: time.Now(), Hostname: "host", CheckpointInterval: 10 * time.Millisecond}
+	errCh := make(chan error, 1)
+	go func() {
+		_, _, _, err := arch.Snapshot(ctx, []string{"."}, opts)
+		errCh <- err
+	}()
+
+	// wait for a checkpoint which contains the completed directory
+	var checkpoint *restic.Snapshot
+	for start := time.Now(); checkpoint == nil; time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > 10*time.Second {
+			t.Fatal("no checkpoint was saved")
+		}
+
+		sn, err := restic.FindCheckpoint(context.TODO(), repo, repo, "host", []string{"."})
+		rtest.OK(t, err)
+		if sn == nil {
+			continue
+		}
+		tree, err := restic.LoadTree(context.TODO(), repo, *sn.Tree)
+		rtest.OK(t, err)
+		if node := tree.Find("a"); node != nil {
+			subtree, err := restic.LoadTree(context.TODO(), repo, *node.Subtree)
+			rtest.OK(t, err)
+			if len(subtree.Nodes) == 2 {
+				checkpoint = sn
+			}
+		}
+	}
+
+	cancel()
+	close(blocking.unblock)
+	rtest.Assert(t, <-errCh != nil, "interrupted backup did not fail")
+	rtest.Assert(t, checkpoint.Checkpoint, "snapshot is not a checkpoint")
+
+	// resume the backup, the completed files are not read again
+	repo = repository.TestOpenBackend(t, be)
+	rtest.OK(t, repo.LoadIndex(context.TODO(), nil))
+	testFS := &MockFS{FS: fs.Local{}, bytesRead: make(map[string]int)}
+	arch = New(repo, testFS, Options{})
+	opts = SnapshotOptions{Time: time.Now(), Hostname: "host", ParentSnapshot: checkpoint, CheckpointInterval: time.Hour}
+	sn, _, _, err := arch.Snapshot(context.TODO(), []string{"."}, opts)
+	rtest.OK(t, err)
+
+	rtest.Equals(t, map[string]int{filepath.FromSlash("b/blocked"): 3}, testFS.bytesRead)
+	rtest.Assert(t, sn.Parent == nil, "snapshot has parent %v", sn.Parent)
+	rtest.Assert(t, !sn.Checkpoint, "snapshot is a checkpoint")
+
+	// the checkpoint has been replaced by the snapshot
+	sn, err = restic.FindCheckpoint(context.TODO(), repo, repo, "host", []string{"."})
+	rtest.OK(t, err)
+	rtest.Assert(t, sn == nil, "checkpoint %v was not removed", sn)
+	// the trees of removed checkpoints are unused until the next prune
+	checker.TestCheckRepo(t, repo, true)
+}
+
+// noRemoveRepo fails to remove files, like credentials for append-only
+// repositories.
+type noRemoveRepo struct {
+	archiverRepo
+}
+
+func (r noRemoveRepo) RemoveUnpacked(_ context.Context, _ restic.FileType, _ restic.ID) error {
+	return errors.New("permission denied")
+}
+
+func TestArchiverCheckpointRemoveError(t *testing.T) {
+	tempdir, repo := prepareTempdirRepoSrc(t, TestDir{"file": TestFile{Content: "content"}})
+	back := rtest.Chdir(t, tempdir)
+	defer back()
+
+	checkpoint, err := restic.NewSnapshot([]string{"."}, nil, "host", time.Now())
+	rtest.OK(t, err)
+	checkpoint.Checkpoint = true
+	checkpoint.Tree = &restic.ID{}
+	id, err := restic.SaveSnapshot(context.TODO(), repo, checkpoint)
+	rtest.OK(t, err)
+	checkpoint, err = restic.LoadSnapshot(context.TODO(), repo, id)
+	rtest.OK(t, err)
+
+	var warnings []string
+	arch := New(noRemoveRepo{repo}, fs.Local{}, Options{})
+	arch.Warn = func(msg string, args ...interface{}) {
+		warnings = append(warnings, fmt.Sprintf(msg, args...))
+	}
+	opts := SnapshotOptions{Time: time.Now(), Hostname: "host", ParentSnapshot: checkpoint, CheckpointInterval: time.Hour}
+	_, _, _, err = arch.Snapshot(context.TODO(), []string{"."}, opts)
+	rtest.OK(t, err)
+
+	rtest.Equals(t, 1, len(warnings))
+	rtest.Assert(t, strings.Contains(warnings[0], id.Str()), "warning %q does not mention the checkpoint", warnings[0])
+	// the checkpoint is kept until the next prune
+	_, err = restic.LoadSnapshot(context.TODO(), repo, id)
+	rtest.OK(t, err)
+}
+
+// deviceFS reports the file name as a block device.
+type deviceFS struct {
+	fs.FS
+	name string
+}
+
+type deviceFileInfo struct {
+	os.FileInfo
+}
+
+func (fi deviceFileInfo) Mode() os.FileMode {
+	return fi.FileInfo.Mode() | os.ModeDevice
+}
+
+type deviceFile struct {
+	fs.File
+}
+
+func (f deviceFile) Stat() (os.FileInfo, error) {
+	fi, err := f.File.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return deviceFileInfo{fi}, nil
+}
+
+func (d *deviceFS) Lstat(name string) (os.FileInfo, error) {
+	fi, err := d.FS.Lstat(name)
+	if err != nil || filepath.Base(name) != d.name {
+		return fi, err
+	}
+	return deviceFileInfo{fi}, nil
+}
+
+func (d *deviceFS) OpenFile(name string, flag int, perm os.FileMode) (fs.File, error) {
+	f, err := d.FS.OpenFile(name, flag, perm)
+	if err != nil || filepath.Base(name) != d.name {
+		return f, err
+	}
+	return deviceFile{f}, nil
+}
+
+func TestArchiverReadSpecial(t *testing.T) {
+	src := TestDir{
+		"disk": TestFile{Content: strings.Repeat("data", 1000)},
+	}
+
+	tempdir, repo := prepareTempdirRepoSrc(t, src)
+	back := rtest.Chdir(t, tempdir)
+	defer back()
+
+	for _, readSpecial := range []bool{false, true} {
+		arch := New(repo, &deviceFS{FS: fs.Local{}, name: "disk"}, Options{ReadSpecial: readSpecial})
+		sn, _, _, err := arch.Snapshot(context.TODO(), []string{"."}, SnapshotOptions{Time: time.Now()})
+		rtest.OK(t, err)
+
+		tree, err := restic.LoadTree(context.TODO(), repo, *sn.Tree)
+		rtest.OK(t, err)
+		node := tree.Find("disk")
+		if !readSpecial {
+			rtest.Equals(t, "dev", node.Type)
+			rtest.Equals(t, 0, len(node.Content))
+			continue
+		}
+
+		rtest.Equals(t, "file", node.Type)
+		rtest.Equals(t, uint64(4000), node.Size)
+		rtest.Assert(t, node.Mode&os.ModeDevice == 0, "node has device mode %v", node.Mode)
+		TestEnsureFileContent(context.TODO(), t, repo, "disk", node, src["disk"].(TestFile))
+	}
+	checker.TestCheckRepo(t, repo, false)
+}
@@ -4,12 +4,16 @@
 package archiver
 
 import (
+	"context"
 	"os"
+	"strings"
 	"syscall"
 	"testing"
+	"time"
 
 	"github.com/chanhpng/vlbe/internal/feature"
 	"github.com/chanhpng/vlbe/internal/fs"
+	"github.com/chanhpng/vlbe/internal/repository"
 	"github.com/chanhpng/vlbe/internal/restic"
 	rtest "github.com/chanhpng/vlbe/internal/test"
 )
@@ -87,3 +91,82 @@ func TestHardlinkMetadata(t *testing.T) {
 	_, node = statAndSnapshot(t, repo, "testdir")
 	rtest.Assert(t, node.DeviceID == 0, "device id mismatch for testdir expected %v got %v", 0, node.DeviceID)
 }
+
+func TestHardlinkMetadataRepoV3(t *testing.T) {
+	files := TestDir{
+		"linktarget": TestFile{
+			Content: "test file",
+		},
+		"testlink": TestHardlink{
+			Target: "./linktarget",
+		},
+		"testfile": TestFile{
+			Content: "foo bar test file",
+		},
+	}
+
+	tempdir := rtest.TempDir(t)
+	TestCreateFiles(t, tempdir, files)
+	repo, _ := repository.TestRepositoryWithVersion(t, 3)
+
+	back := rtest.Chdir(t, tempdir)
+	defer back()
+
+	// repository format 3 only stores the device ID for hardlinks
+	want, node := statAndSnapshot(t, repo, "testlink")
+	rtest.Assert(t, node.DeviceID == want.DeviceID, "device id mismatch expected %v got %v", want.DeviceID, node.DeviceID)
+
+	_, node = statAndSnapshot(t, repo, "testfile")
+	rtest.Assert(t, node.DeviceID == 0, "device id mismatch for testfile expected %v got %v", 0, node.DeviceID)
+}
+
+// mappingFS reads the files of the directory live from the directory snap.
+type mappingFS struct {
+	fs.FS
+}
+
+func (m mappingFS) MappedPath(name string) (string, error) {
+	return strings.Replace(name, "live", "snap", 1), nil
+}
+
+func (m mappingFS) OpenFile(name string, flag int, perm os.FileMode) (fs.File, error) {
+	p, _ := m.MappedPath(name)
+	return m.FS.OpenFile(p, flag, perm)
+}
+
+func (m mappingFS) Lstat(name string) (os.FileInfo, error) {
+	p, _ := m.MappedPath(name)
+	return m.FS.Lstat(p)
+}
+
+func (m mappingFS) Stat(name string) (os.FileInfo, error) {
+	p, _ := m.MappedPath(name)
+	return m.FS.Stat(p)
+}
+
+func TestArchiverMappedPathMetadata(t *testing.T) {
+	tempdir, repo := prepareTempdirRepoSrc(t, TestDir{
+		"live": TestDir{
+			"link": TestSymlink{Target: "live-target"},
+		},
+		"snap": TestDir{
+			"link": TestSymlink{Target: "snap-target"},
+		},
+	})
+	back := rtest.Chdir(t, tempdir)
+	defer back()
+
+	arch := New(repo, mappingFS{fs.Local{}}, Options{})
+	sn, _, _, err := arch.Snapshot(context.TODO(), []string{"live"}, SnapshotOptions{Time: time.Now()})
+	rtest.OK(t, err)
+
+	tree, err := restic.LoadTree(context.TODO(), repo, *sn.Tree)
+	rtest.OK(t, err)
+	dir := tree.Find("live")
+	rtest.Assert(t, dir != nil, "directory live not found")
+	tree, err = restic.LoadTree(context.TODO(), repo, *dir.Subtree)
+	rtest.OK(t, err)
+	node := tree.Find("link")
+	rtest.Assert(t, node != nil, "symlink not found")
+	rtest.Equals(t, "snap-target", node.LinkTarget)
+}
@@ -0,0 +1,62 @@
+package archiver
+
+import (
+	"github.com/chanhpng/vlbe/internal/fs"
+)
+
+// ChangeSet contains the paths which have changed since the parent snapshot,
+// for example as reported by a filesystem change journal. Directories which
+// contain no changed paths are taken from the parent snapshot without reading
+// them.
+type ChangeSet struct {
+	fs      fs.FS
+	changed map[string]struct{}
+}
+
+// NewChangeSet returns a change set for paths on filesys. A path marks itself
+// and all of its parent directories as changed. For directories, only the
+// list of entries and the metadata are considered as changed, unchanged
+// subdirectories are still taken from the parent snapshot.
+func NewChangeSet(filesys fs.FS, paths []string) (*ChangeSet, error) {
+	c := &ChangeSet{
+		fs:      filesys,
+		changed: make(map[string]struct{}),
+	}
+
+	for _, p := range paths {
+		if p == "" {
+			continue
+		}
+
+		p, err := filesys.Abs(p)
+		if err != nil {
+			return nil, err
+		}
+
+		for {
+			if _, ok := c.changed[p]; ok {
+				// the parent directories have been added before
+				break
+			}
+			c.changed[p] = struct{}{}
+
+			parent := filesys.Dir(p)
+			if parent == p {
+				break
+			}
+			p = parent
+		}
+	}
+
+	return c, nil
+}
+
+// Changed reports whether the path or any path within it has changed.
+func (c *ChangeSet) Changed(path string) bool {
+	p, err := c.fs.Abs(path)
+	if err != nil {
+		return true
+	}
+	_, ok := c.changed[p]
+	return ok
+}
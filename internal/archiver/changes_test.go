@@ -0,0 +1,89 @@
+package archiver
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/chanhpng/vlbe/internal/checker"
+	"github.com/chanhpng/vlbe/internal/fs"
+	"github.com/chanhpng/vlbe/internal/restic"
+	rtest "github.com/chanhpng/vlbe/internal/test"
+)
+
+func TestChangeSet(t *testing.T) {
+	tempdir := rtest.TempDir(t)
+	changes, err := NewChangeSet(fs.Local{}, []string{
+		filepath.Join(tempdir, "a", "b", "file"),
+		filepath.Join(tempdir, "c"),
+		"",
+	})
+	rtest.OK(t, err)
+
+	for _, test := range []struct {
+		path    string
+		changed bool
+	}{
+		{tempdir, true},
+		{filepath.Join(tempdir, "a"), true},
+		{filepath.Join(tempdir, "a", "b"), true},
+		{filepath.Join(tempdir, "a", "b", "file"), true},
+		{filepath.Join(tempdir, "a", "b", "other"), false},
+		{filepath.Join(tempdir, "a", "d"), false},
+		{filepath.Join(tempdir, "c"), true},
+		{filepath.Join(tempdir, "c", "d"), false},
+		{filepath.Join(tempdir, "e"), false},
+	} {
+		rtest.Equals(t, test.changed, changes.Changed(test.path), "path %v", test.path)
+	}
+}
+
+func TestArchiverChanges(t *testing.T) {
+	src := TestDir{
+		"a": TestDir{
+			"file": TestFile{Content: "foo"},
+		},
+		"b": TestDir{
+			"c": TestDir{
+				"file": TestFile{Content: "bar"},
+			},
+			"d": TestDir{
+				"file": TestFile{Content: "baz"},
+			},
+		},
+	}
+
+	tempdir, repo := prepareTempdirRepoSrc(t, src)
+	back := rtest.Chdir(t, tempdir)
+	defer back()
+
+	testFS := &MockFS{FS: fs.Local{}, bytesRead: make(map[string]int)}
+	arch := New(repo, testFS, Options{})
+	parent, _, _, err := arch.Snapshot(context.TODO(), []string{"."}, SnapshotOptions{Time: time.Now()})
+	rtest.OK(t, err)
+
+	// only the change of b/c/file is listed
+	save(t, filepath.Join(tempdir, "a", "file"), []byte("changed"))
+	save(t, filepath.Join(tempdir, "b", "c", "file"), []byte("changed"))
+	changes, err := NewChangeSet(fs.Local{}, []string{filepath.Join("b", "c", "file")})
+	rtest.OK(t, err)
+
+	testFS.bytesRead = make(map[string]int)
+	arch = New(repo, testFS, Options{})
+	arch.Changes = changes
+	sn, _, summary, err := arch.Snapshot(context.TODO(), []string{"."}, SnapshotOptions{Time: time.Now(), ParentSnapshot: parent})
+	rtest.OK(t, err)
+
+	rtest.Equals(t, map[string]int{filepath.Join("b", "c", "file"): 7}, testFS.bytesRead)
+	rtest.Equals(t, ChangeStats{0, 1, 0}, summary.Files)
+	// a and b/d are taken from the parent snapshot
+	rtest.Equals(t, ChangeStats{0, 2, 2}, summary.Dirs)
+
+	tree, err := restic.LoadTree(context.TODO(), repo, *sn.Tree)
+	rtest.OK(t, err)
+	parentTree, err := restic.LoadTree(context.TODO(), repo, *parent.Tree)
+	rtest.OK(t, err)
+	rtest.Equals(t, *parentTree.Find("a").Subtree, *tree.Find("a").Subtree)
+	checker.TestCheckRepo(t, repo, false)
+}
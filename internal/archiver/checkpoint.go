@@ -0,0 +1,242 @@
+package archiver
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chanhpng/vlbe/internal/debug"
+	"github.com/chanhpng/vlbe/internal/errors"
+	"github.com/chanhpng/vlbe/internal/restic"
+)
+
+// checkpointTracker records the progress of a backup, so that a partial
+// snapshot can be saved while the backup is still running. The tree of the
+// partial snapshot contains all completed files and directories. Directories
+// which are not completed yet are filled up with the nodes from the parent
+// snapshot.
+type checkpointTracker struct {
+	m    sync.Mutex
+	root *checkpointDir
+}
+
+// checkpointDir is a directory which is currently being saved.
+type checkpointDir struct {
+	node     *restic.Node
+	previous *restic.Tree
+	// done contains the completed nodes of the directory
+	done map[string]*restic.Node
+	// dirs contains the subdirectories which are being saved
+	dirs map[string]*checkpointDir
+}
+
+// splitPath returns the components of a path within the snapshot.
+func splitPath(snPath string) []string {
+	snPath = strings.Trim(snPath, "/")
+	if snPath == "" {
+		return nil
+	}
+	return strings.Split(snPath, "/")
+}
+
+// find returns the directory at the path given by components, or nil.
+func (d *checkpointDir) find(components []string) *checkpointDir {
+	for _, name := range components {
+		if d == nil {
+			return nil
+		}
+		d = d.dirs[name]
+	}
+	return d
+}
+
+// enterDir records that saving the directory snPath has started. previous is
+// the tree of the directory in the parent snapshot.
+func (c *checkpointTracker) enterDir(snPath string, node *restic.Node, previous *restic.Tree) {
+	if c == nil {
+		return
+	}
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	// the tree saver modifies node once the directory is completed
+	n := *node
+	dir := &checkpointDir{
+		node:     &n,
+		previous: previous,
+		done:     make(map[string]*restic.Node),
+		dirs:     make(map[string]*checkpointDir),
+	}
+
+	components := splitPath(snPath)
+	if len(components) == 0 {
+		c.root = dir
+		return
+	}
+	parent := c.root.find(components[:len(components)-1])
+	if parent == nil {
+		debug.Log("parent of %v is unknown", snPath)
+		return
+	}
+	parent.dirs[components[len(components)-1]] = dir
+}
+
+// complete records that the item snPath has been saved as node.
+func (c *checkpointTracker) complete(snPath string, node *restic.Node) {
+	if c == nil {
+		return
+	}
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	components := splitPath(snPath)
+	if len(components) == 0 {
+		// the root tree is saved in the final snapshot
+		return
+	}
+	parent := c.root.find(components[:len(components)-1])
+	if parent == nil {
+		debug.Log("parent of %v is unknown", snPath)
+		return
+	}
+	name := components[len(components)-1]
+	parent.done[name] = node
+	delete(parent.dirs, name)
+}
+
+// build returns the serialized trees of the partial snapshot and the ID of
+// the root tree.
+func (c *checkpointTracker) build() ([][]byte, restic.ID, error) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if c.root == nil {
+		return nil, restic.ID{}, errors.New("backup has not started yet")
+	}
+
+	var trees [][]byte
+	id, err := c.root.build(&trees)
+	return trees, id, err
+}
+
+func (d *checkpointDir) build(trees *[][]byte) (restic.ID, error) {
+	var names []string
+	if d.previous != nil {
+		for _, node := range d.previous.Nodes {
+			names = append(names, node.Name)
+		}
+	}
+	for name := range d.done {
+		if d.previous.Find(name) == nil {
+			names = append(names, name)
+		}
+	}
+	for name := range d.dirs {
+		if d.previous.Find(name) == nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	tb := restic.NewTreeJSONBuilder()
+	for _, name := range names {
+		var node *restic.Node
+		if sub, ok := d.dirs[name]; ok {
+			id, err := sub.build(trees)
+			if err != nil {
+				return restic.ID{}, err
+			}
+			n := *sub.node
+			n.Subtree = &id
+			node = &n
+		} else if n, ok := d.done[name]; ok {
+			node = n
+		} else {
+			node = d.previous.Find(name)
+		}
+
+		if err := tb.AddNode(node); err != nil {
+			return restic.ID{}, err
+		}
+	}
+
+	buf, err := tb.Finalize()
+	if err != nil {
+		return restic.ID{}, err
+	}
+	*trees = append(*trees, buf)
+	return restic.Hash(buf), nil
+}
+
+// runCheckpoints saves a checkpoint every opts.CheckpointInterval until done
+// is closed.
+func (arch *Archiver) runCheckpoints(ctx context.Context, targets []string, opts SnapshotOptions, done <-chan struct{}) error {
+	ticker := time.NewTicker(opts.CheckpointInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-done:
+			return nil
+		case <-ticker.C:
+			if err := arch.saveCheckpoint(ctx, targets, opts); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// saveCheckpoint saves a partial snapshot of the completed files and
+// directories. All data referenced by the snapshot is uploaded and indexed
+// first. The previous checkpoint of the backup is removed afterwards.
+func (arch *Archiver) saveCheckpoint(ctx context.Context, targets []string, opts SnapshotOptions) error {
+	trees, rootTreeID, err := arch.checkpoint.build()
+	if err != nil {
+		debug.Log("skipping checkpoint: %v", err)
+		return nil
+	}
+
+	for _, buf := range trees {
+		if _, _, _, err := arch.Repo.SaveBlob(ctx, restic.TreeBlob, buf, restic.ID{}, false); err != nil {
+			return err
+		}
+	}
+	if err := arch.Repo.FlushPending(ctx); err != nil {
+		return err
+	}
+
+	sn, err := arch.newSnapshot(targets, opts)
+	if err != nil {
+		return err
+	}
+	sn.Tree = &rootTreeID
+	sn.Checkpoint = true
+
+	id, err := restic.SaveSnapshot(ctx, arch.Repo, sn)
+	if err != nil {
+		return err
+	}
+	debug.Log("saved checkpoint %v", id)
+
+	arch.removeCheckpoint(ctx)
+	arch.lastCheckpoint = &id
+	return nil
+}
+
+// removeCheckpoint removes the last checkpoint saved by the archiver. Errors
+// only cause a warning, e.g. credentials for append-only repositories do not
+// allow to remove files. prune removes checkpoints of completed backups.
+func (arch *Archiver) removeCheckpoint(ctx context.Context) {
+	if arch.lastCheckpoint == nil {
+		return
+	}
+	if err := arch.Repo.RemoveUnpacked(ctx, restic.SnapshotFile, *arch.lastCheckpoint); err != nil {
+		debug.Log("unable to remove checkpoint %v: %v", arch.lastCheckpoint, err)
+		arch.Warn("unable to remove checkpoint %v, it will be removed by prune: %v\n", arch.lastCheckpoint.Str(), err)
+	}
+	arch.lastCheckpoint = nil
+}
@@ -23,7 +23,7 @@ type FileSaver struct {
 	saveFilePool *BufferPool
 	saveBlob     SaveBlobFn
 
-	pol chunker.Pol
+	chunkerParams restic.ChunkerParams
 
 	ch chan<- saveFileJob
 
@@ -32,9 +32,10 @@ type FileSaver struct {
 	NodeFromFileInfo func(snPath, filename string, fi os.FileInfo, ignoreXattrListError bool) (*restic.Node, error)
 }
 
-// NewFileSaver returns a new file saver. A worker pool with fileWorkers is
-// started, it is stopped when ctx is cancelled.
-func NewFileSaver(ctx context.Context, wg *errgroup.Group, save SaveBlobFn, pol chunker.Pol, fileWorkers, blobWorkers uint) *FileSaver {
+// NewFileSaver returns a new file saver. Files are split into blobs using
+// chunkerParams. A worker pool with fileWorkers is started, it is stopped when
+// ctx is cancelled.
+func NewFileSaver(ctx context.Context, wg *errgroup.Group, save SaveBlobFn, chunkerParams restic.ChunkerParams, fileWorkers, blobWorkers uint) *FileSaver {
 	ch := make(chan saveFileJob)
 
 	debug.Log("new file saver with %v file workers and %v blob workers", fileWorkers, blobWorkers)
@@ -42,10 +43,10 @@ func NewFileSaver(ctx context.Context, wg *errgroup.Group, save SaveBlobFn, pol
 	poolSize := fileWorkers + blobWorkers
 
 	s := &FileSaver{
-		saveBlob:     save,
-		saveFilePool: NewBufferPool(int(poolSize), chunker.MaxSize),
-		pol:          pol,
-		ch:           ch,
+		saveBlob:      save,
+		saveFilePool:  NewBufferPool(int(poolSize), int(chunkerParams.MaxSize)),
+		chunkerParams: chunkerParams,
+		ch:            ch,
 
 		CompleteBlob: func(uint64) {},
 	}
@@ -156,7 +157,7 @@ func (s *FileSaver) saveFile(ctx context.Context, chnker *chunker.Chunker, snPat
 
 	debug.Log("%v", snPath)
 
-	node, err := s.NodeFromFileInfo(snPath, f.Name(), fi, false)
+	node, err := s.NodeFromFileInfo(snPath, target, fi, false)
 	if err != nil {
 		_ = f.Close()
 		completeError(err)
@@ -169,8 +170,14 @@ func (s *FileSaver) saveFile(ctx context.Context, chnker *chunker.Chunker, snPat
 		return
 	}
 
+	// holes smaller than a chunk are not worth the additional system calls
+	var rd io.Reader = f
+	if fi.Size() > int64(s.chunkerParams.MinSize) {
+		rd = newHoleReader(f, fi.Size())
+	}
+
 	// reuse the chunker
-	chnker.Reset(f, s.pol)
+	s.chunkerParams.ResetChunker(chnker, rd)
 
 	node.Content = []restic.ID{}
 	node.Size = 0
@@ -248,7 +255,7 @@ func (s *FileSaver) saveFile(ctx context.Context, chnker *chunker.Chunker, snPat
 
 func (s *FileSaver) worker(ctx context.Context, jobs <-chan saveFileJob) {
 	// a worker has one chunker which is reused for each file (because it contains a rather large buffer)
-	chnker := chunker.New(nil, s.pol)
+	chnker := s.chunkerParams.NewChunker(nil)
 
 	for {
 		var job saveFileJob
@@ -48,7 +48,7 @@ func startFileSaver(ctx context.Context, t testing.TB) (*FileSaver, context.Cont
 		t.Fatal(err)
 	}
 
-	s := NewFileSaver(ctx, wg, saveBlob, pol, workers, workers)
+	s := NewFileSaver(ctx, wg, saveBlob, restic.Config{ChunkerPolynomial: pol}.ChunkerParams(), workers, workers)
 	s.NodeFromFileInfo = func(snPath, filename string, fi os.FileInfo, ignoreXattrListError bool) (*restic.Node, error) {
 		return restic.NodeFromFileInfo(filename, fi, ignoreXattrListError)
 	}
@@ -0,0 +1,75 @@
+package archiver
+
+import (
+	"io"
+
+	"github.com/chanhpng/vlbe/internal/debug"
+	"github.com/chanhpng/vlbe/internal/fs"
+)
+
+// holeReader reads a sparse file. The holes of the file are returned as
+// zeros without reading them from the file system.
+type holeReader struct {
+	f    fs.File
+	size int64
+
+	pos  int64 // current offset in the file
+	data int64 // start of the data region after the hole at pos
+	hole int64 // start of the hole after the data region
+
+	// unsupported is set if the file system cannot report holes, the file
+	// is read sequentially instead
+	unsupported bool
+}
+
+// newHoleReader returns a reader for f, which is a file of the given size
+// opened at offset zero.
+func newHoleReader(f fs.File, size int64) *holeReader {
+	return &holeReader{f: f, size: size}
+}
+
+func (r *holeReader) Read(p []byte) (int, error) {
+	if r.unsupported || r.pos >= r.size {
+		// the file may have grown in the meantime
+		n, err := r.f.Read(p)
+		r.pos += int64(n)
+		return n, err
+	}
+
+	if r.pos >= r.hole {
+		data, hole, err := fs.SeekData(r.f, r.pos)
+		if err != nil || hole <= r.pos {
+			if err != nil {
+				debug.Log("unable to find holes in %v: %v", r.f.Name(), err)
+			} else {
+				// the file was truncated, the sequential read reports its end
+				debug.Log("%v was truncated at %d", r.f.Name(), hole)
+			}
+			if _, err := r.f.Seek(r.pos, io.SeekStart); err != nil {
+				return 0, err
+			}
+			r.unsupported = true
+			return r.Read(p)
+		}
+		r.data, r.hole = data, hole
+	}
+
+	if r.pos < r.data {
+		n := len(p)
+		if int64(n) > r.data-r.pos {
+			n = int(r.data - r.pos)
+		}
+		for i := range p[:n] {
+			p[i] = 0
+		}
+		r.pos += int64(n)
+		return n, nil
+	}
+
+	if int64(len(p)) > r.hole-r.pos {
+		p = p[:r.hole-r.pos]
+	}
+	n, err := r.f.Read(p)
+	r.pos += int64(n)
+	return n, err
+}
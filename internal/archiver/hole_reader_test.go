@@ -0,0 +1,82 @@
+package archiver
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/chanhpng/vlbe/internal/fs"
+	rtest "github.com/chanhpng/vlbe/internal/test"
+)
+
+func TestHoleReader(t *testing.T) {
+	const size = 8 << 20
+	filename := filepath.Join(rtest.TempDir(t), "sparse")
+
+	// a file with data in the middle and at the end, the remaining parts are
+	// holes
+	f, err := os.Create(filename)
+	rtest.OK(t, err)
+	rtest.OK(t, f.Truncate(size))
+	data := bytes.Repeat([]byte("data"), 1024)
+	_, err = f.WriteAt(data, 3<<20)
+	rtest.OK(t, err)
+	_, err = f.WriteAt(data, size-int64(len(data)))
+	rtest.OK(t, err)
+	rtest.OK(t, f.Close())
+
+	expected, err := os.ReadFile(filename)
+	rtest.OK(t, err)
+
+	testFS := &MockFS{FS: fs.Local{}, bytesRead: make(map[string]int)}
+	file, err := testFS.OpenFile(filename, fs.O_RDONLY, 0)
+	rtest.OK(t, err)
+	defer func() {
+		rtest.OK(t, file.Close())
+	}()
+
+	rd := newHoleReader(file, size)
+	buf, err := io.ReadAll(rd)
+	rtest.OK(t, err)
+	rtest.Assert(t, bytes.Equal(expected, buf), "content differs")
+
+	if rd.unsupported {
+		t.Skip("filesystem does not report holes")
+	}
+	// only the data regions, rounded up to full filesystem blocks, are read
+	rtest.Assert(t, testFS.bytesRead[filename] < size/2, "read %d bytes of the holes", testFS.bytesRead[filename])
+}
+
+func TestHoleReaderTruncated(t *testing.T) {
+	const size = 8 << 20
+	filename := filepath.Join(rtest.TempDir(t), "sparse")
+
+	// a file with data at the start, followed by a hole
+	f, err := os.Create(filename)
+	rtest.OK(t, err)
+	rtest.OK(t, f.Truncate(size))
+	data := rtest.Random(23, 1<<20)
+	_, err = f.WriteAt(data, 0)
+	rtest.OK(t, err)
+	rtest.OK(t, f.Close())
+
+	file, err := fs.Local{}.OpenFile(filename, fs.O_RDONLY, 0)
+	rtest.OK(t, err)
+	defer func() {
+		rtest.OK(t, file.Close())
+	}()
+
+	rd := newHoleReader(file, size)
+	buf := make([]byte, len(data))
+	_, err = io.ReadFull(rd, buf)
+	rtest.OK(t, err)
+	rtest.Assert(t, bytes.Equal(data, buf), "content differs")
+
+	// truncate the file before the current offset, reading must stop
+	rtest.OK(t, os.Truncate(filename, int64(len(data)/2)))
+	rest, err := io.ReadAll(rd)
+	rtest.OK(t, err)
+	rtest.Equals(t, 0, len(rest))
+}
@@ -19,6 +19,11 @@ type Scanner struct {
 	Select       SelectFunc
 	Error        ErrorFunc
 	Result       func(item string, s ScanStats)
+
+	// Changes contains the paths which have changed since the parent
+	// snapshot. If set, directories without changes are not traversed, as
+	// the archiver does not read them either.
+	Changes *ChangeSet
 }
 
 // NewScanner initializes a new Scanner.
@@ -124,6 +129,11 @@ func (s *Scanner) scan(ctx context.Context, stats ScanStats, target string) (Sca
 		stats.Files++
 		stats.Bytes += uint64(fi.Size())
 	case fi.Mode().IsDir():
+		if s.Changes != nil && !s.Changes.Changed(target) {
+			stats.Dirs++
+			break
+		}
+
 		names, err := fs.Readdirnames(s.FS, target, fs.O_NOFOLLOW)
 		if err != nil {
 			return stats, s.Error(target, err)
@@ -0,0 +1,129 @@
+package location
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/chanhpng/vlbe/internal/backend"
+	"github.com/chanhpng/vlbe/internal/backend/limiter"
+	"github.com/chanhpng/vlbe/internal/backend/logger"
+	"github.com/chanhpng/vlbe/internal/backend/mirror"
+	"github.com/chanhpng/vlbe/internal/backend/sema"
+	"github.com/chanhpng/vlbe/internal/errors"
+)
+
+// MirrorSeparator separates the locations of a mirror location, e.g.
+// "mirror:/srv/restic|sftp:host:/srv/restic".
+const MirrorSeparator = "|"
+
+// MirrorConfig is the configuration of a mirror backend.
+type MirrorConfig struct {
+	// Locations contains the parsed locations of all mirrored repositories.
+	Locations []Location
+	// Names contains the locations without passwords for use in messages.
+	Names []string
+}
+
+type mirrorFactory struct {
+	registry *Registry
+	report   func(msg string, args ...interface{})
+}
+
+// NewMirrorFactory returns the factory for the "mirror" scheme. The mirrored
+// locations are parsed and opened using registry. Divergence between the
+// mirrored repositories is passed to report.
+func NewMirrorFactory(registry *Registry, report func(msg string, args ...interface{})) Factory {
+	return &mirrorFactory{registry: registry, report: report}
+}
+
+func (f *mirrorFactory) Scheme() string {
+	return "mirror"
+}
+
+func (f *mirrorFactory) ParseConfig(s string) (interface{}, error) {
+	if !strings.HasPrefix(s, "mirror:") {
+		return nil, errors.New("invalid format, prefix mirror not found")
+	}
+	s = strings.TrimPrefix(s, "mirror:")
+
+	cfg := &MirrorConfig{}
+	for _, part := range strings.Split(s, MirrorSeparator) {
+		if part == "" {
+			return nil, errors.New("invalid format, empty location in mirror")
+		}
+		if extractScheme(part) == f.Scheme() {
+			return nil, errors.New("invalid format, mirrors cannot be nested")
+		}
+
+		loc, err := Parse(f.registry, part)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Locations = append(cfg.Locations, loc)
+		cfg.Names = append(cfg.Names, StripPassword(f.registry, part))
+	}
+
+	if len(cfg.Locations) < 2 {
+		return nil, errors.Errorf("invalid format, mirror needs at least two locations separated by %q", MirrorSeparator)
+	}
+	return cfg, nil
+}
+
+func (f *mirrorFactory) StripPassword(s string) string {
+	if !strings.HasPrefix(s, "mirror:") {
+		return s
+	}
+	s = strings.TrimPrefix(s, "mirror:")
+
+	parts := strings.Split(s, MirrorSeparator)
+	for i, part := range parts {
+		parts[i] = StripPassword(f.registry, part)
+	}
+	return "mirror:" + strings.Join(parts, MirrorSeparator)
+}
+
+func (f *mirrorFactory) Create(ctx context.Context, cfg interface{}, rt http.RoundTripper, lim limiter.Limiter) (backend.Backend, error) {
+	return f.open(ctx, cfg.(*MirrorConfig), func(factory Factory, cfg interface{}) (backend.Backend, error) {
+		return factory.Create(ctx, cfg, rt, lim)
+	})
+}
+
+func (f *mirrorFactory) Open(ctx context.Context, cfg interface{}, rt http.RoundTripper, lim limiter.Limiter) (backend.Backend, error) {
+	return f.open(ctx, cfg.(*MirrorConfig), func(factory Factory, cfg interface{}) (backend.Backend, error) {
+		return factory.Open(ctx, cfg, rt, lim)
+	})
+}
+
+func (f *mirrorFactory) open(_ context.Context, cfg *MirrorConfig, openFn func(factory Factory, cfg interface{}) (backend.Backend, error)) (backend.Backend, error) {
+	var backends []backend.Backend
+	closeAll := func() {
+		for _, be := range backends {
+			_ = be.Close()
+		}
+	}
+
+	for i, loc := range cfg.Locations {
+		factory := f.registry.Lookup(loc.Scheme)
+		if factory == nil {
+			closeAll()
+			return nil, errors.Errorf("invalid backend: %q", loc.Scheme)
+		}
+
+		be, err := openFn(factory, loc.Config)
+		if err != nil {
+			closeAll()
+			return nil, errors.Wrapf(err, "mirror %v", cfg.Names[i])
+		}
+		// wrap with debug logging and connection limiting, like a backend
+		// which is opened directly
+		backends = append(backends, logger.New(sema.NewBackend(be)))
+	}
+
+	be, err := mirror.New(backends, cfg.Names, f.report)
+	if err != nil {
+		closeAll()
+		return nil, err
+	}
+	return be, nil
+}
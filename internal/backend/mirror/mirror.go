@@ -0,0 +1,376 @@
+// Package mirror implements a backend which stores all files in several
+// backends at once.
+package mirror
+
+import (
+	"context"
+	"hash"
+	"io"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/chanhpng/vlbe/internal/backend"
+	"github.com/chanhpng/vlbe/internal/debug"
+	"github.com/chanhpng/vlbe/internal/errors"
+)
+
+// Backend stores files in all of its backends. Files are read from the first
+// healthy backend, falling back to the other backends if a file is missing or
+// cannot be read. Files which are not present in all backends are reported as
+// divergence.
+type Backend struct {
+	backends []backend.Backend
+	names    []string
+	// unhealthy is set for backends whose last operation failed
+	unhealthy []atomic.Bool
+
+	report func(msg string, args ...interface{})
+}
+
+// statically ensure that Backend implements backend.Backend.
+var _ backend.Backend = &Backend{}
+
+// New returns a backend which mirrors all files to backends. The names are
+// used in messages, report is called for each divergence between the
+// backends which is detected.
+func New(backends []backend.Backend, names []string, report func(msg string, args ...interface{})) (*Backend, error) {
+	if len(backends) == 0 {
+		return nil, errors.New("no backends to mirror")
+	}
+	if len(backends) != len(names) {
+		return nil, errors.New("number of backends and names does not match")
+	}
+	if report == nil {
+		report = func(string, ...interface{}) {}
+	}
+
+	return &Backend{
+		backends:  backends,
+		names:     names,
+		unhealthy: make([]atomic.Bool, len(backends)),
+		report:    report,
+	}, nil
+}
+
+// Connections returns the lowest number of connections of all backends, as
+// each operation may use one connection of every backend.
+func (be *Backend) Connections() uint {
+	connections := be.backends[0].Connections()
+	for _, b := range be.backends[1:] {
+		if c := b.Connections(); c < connections {
+			connections = c
+		}
+	}
+	return connections
+}
+
+// Hasher returns nil, the content hash is calculated for each backend when
+// saving a file.
+func (be *Backend) Hasher() hash.Hash {
+	return nil
+}
+
+// HasAtomicReplace returns true, as Save takes care of the backends which
+// cannot atomically replace files itself. Otherwise a caller would remove the
+// file after a failed save, which also deletes the copies which were saved
+// successfully to the other backends.
+func (be *Backend) HasAtomicReplace() bool {
+	return true
+}
+
+// IsNotExist returns true if the error was caused by a non-existing file in
+// any of the backends.
+func (be *Backend) IsNotExist(err error) bool {
+	for _, b := range be.backends {
+		if b.IsNotExist(err) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsPermanentError returns true if the error is permanent for any of the
+// backends.
+func (be *Backend) IsPermanentError(err error) bool {
+	for _, b := range be.backends {
+		if b.IsPermanentError(err) {
+			return true
+		}
+	}
+	return false
+}
+
+// Save stores the file in all backends concurrently. Backends which already
+// contain the file are skipped, so that saving the file again after a partial
+// failure only uploads it to the remaining backends. If saving to a backend
+// fails, the file is not removed from the other backends, as it may have
+// existed there before. The divergence is reported instead, the file is
+// found by check or removed by prune later on.
+func (be *Backend) Save(ctx context.Context, h backend.Handle, rd backend.RewindReader) error {
+	// the backends read the file concurrently and may use different content
+	// hashes, thus each one gets its own reader
+	if err := rd.Rewind(); err != nil {
+		return err
+	}
+	buf, err := io.ReadAll(rd)
+	if err != nil {
+		return err
+	}
+	if int64(len(buf)) != rd.Length() {
+		return errors.Errorf("read %d bytes instead of the expected %d bytes", len(buf), rd.Length())
+	}
+
+	errs := be.forAll(func(i int, b backend.Backend) error {
+		return be.saveTo(ctx, i, b, h, buf)
+	})
+
+	var firstErr error
+	var saved []string
+	for i, err := range errs {
+		if err == nil {
+			saved = append(saved, be.names[i])
+			continue
+		}
+		be.setHealthy(i, false)
+		if firstErr == nil {
+			firstErr = errors.Wrapf(err, "save to %v", be.names[i])
+		}
+	}
+	if firstErr != nil && len(saved) > 0 {
+		be.report("%v was only saved to %v: %v\n", h, saved, firstErr)
+	}
+	return firstErr
+}
+
+// saveTo stores the file in a single backend. As HasAtomicReplace always
+// returns true, it also does the cleanup for backends which cannot replace
+// files, which callers of Save do otherwise.
+func (be *Backend) saveTo(ctx context.Context, i int, b backend.Backend, h backend.Handle, buf []byte) error {
+	if h.Type != backend.ConfigFile {
+		// all other files are named after their content, a file with the
+		// expected size was already saved completely
+		fi, err := b.Stat(ctx, h)
+		if err == nil && fi.Size == int64(len(buf)) {
+			debug.Log("%v already exists in %v, skipping", h, be.names[i])
+			return nil
+		}
+	} else if !b.HasAtomicReplace() {
+		// the config file is replaced when upgrading the repository
+		err := b.Remove(ctx, h)
+		if err != nil && !b.IsNotExist(err) {
+			return err
+		}
+	}
+
+	err := b.Save(ctx, h, backend.NewByteReader(buf, b.Hasher()))
+	if err != nil && !b.HasAtomicReplace() {
+		// remove incomplete files, so that saving the file again can succeed
+		debug.Log("Save(%v) to %v failed, removing file: %v", h, be.names[i], err)
+		if rerr := b.Remove(ctx, h); rerr != nil {
+			debug.Log("Remove(%v) from %v returned error: %v", h, be.names[i], rerr)
+		}
+	}
+	return err
+}
+
+// Remove removes the file from all backends. Backends which do not contain
+// the file are ignored, unless the file is missing in all of them.
+func (be *Backend) Remove(ctx context.Context, h backend.Handle) error {
+	errs := be.forAll(func(i int, b backend.Backend) error {
+		return b.Remove(ctx, h)
+	})
+
+	var firstErr error
+	missing := 0
+	for i, err := range errs {
+		switch {
+		case err == nil:
+		case be.backends[i].IsNotExist(err):
+			missing++
+			if firstErr == nil {
+				firstErr = err
+			}
+		default:
+			return errors.Wrapf(err, "remove from %v", be.names[i])
+		}
+	}
+
+	if missing == len(be.backends) {
+		return firstErr
+	}
+	if missing > 0 {
+		be.report("%v was not present in all mirrored repositories\n", h)
+	}
+	return nil
+}
+
+// Load reads the file from the first healthy backend which contains it.
+func (be *Backend) Load(ctx context.Context, h backend.Handle, length int, offset int64, fn func(rd io.Reader) error) error {
+	return be.tryInOrder(ctx, h, func(b backend.Backend) error {
+		return b.Load(ctx, h, length, offset, fn)
+	})
+}
+
+// Stat returns information about the file from the first healthy backend
+// which contains it.
+func (be *Backend) Stat(ctx context.Context, h backend.Handle) (backend.FileInfo, error) {
+	var fi backend.FileInfo
+	err := be.tryInOrder(ctx, h, func(b backend.Backend) error {
+		var err error
+		fi, err = b.Stat(ctx, h)
+		return err
+	})
+	return fi, err
+}
+
+// tryInOrder runs fn for the healthy backends and then for the others, until
+// it succeeds for one of them.
+func (be *Backend) tryInOrder(ctx context.Context, h backend.Handle, fn func(b backend.Backend) error) error {
+	var firstErr error
+	var missing []string
+	for _, i := range be.order() {
+		err := fn(be.backends[i])
+		if err == nil {
+			be.setHealthy(i, true)
+			if len(missing) > 0 {
+				be.report("%v is missing in %v\n", h, missing)
+			}
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if be.backends[i].IsNotExist(err) {
+			missing = append(missing, be.names[i])
+		} else {
+			debug.Log("reading %v from %v failed: %v", h, be.names[i], err)
+			be.setHealthy(i, false)
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// order returns the indexes of the healthy backends followed by the others.
+func (be *Backend) order() []int {
+	order := make([]int, 0, len(be.backends))
+	for i := range be.backends {
+		if !be.unhealthy[i].Load() {
+			order = append(order, i)
+		}
+	}
+	for i := range be.backends {
+		if be.unhealthy[i].Load() {
+			order = append(order, i)
+		}
+	}
+	return order
+}
+
+func (be *Backend) setHealthy(i int, healthy bool) {
+	if be.unhealthy[i].Swap(!healthy) != !healthy {
+		debug.Log("backend %v is now healthy: %v", be.names[i], healthy)
+	}
+}
+
+// List runs fn for all files of type t which are present in any of the
+// backends. Files which are missing in some backends are reported. Backends
+// which cannot be listed are skipped, unless all of them fail.
+func (be *Backend) List(ctx context.Context, t backend.FileType, fn func(backend.FileInfo) error) error {
+	lists := make([]map[string]int64, len(be.backends))
+	errs := be.forAll(func(i int, b backend.Backend) error {
+		files := make(map[string]int64)
+		err := b.List(ctx, t, func(fi backend.FileInfo) error {
+			files[fi.Name] = fi.Size
+			return nil
+		})
+		lists[i] = files
+		return err
+	})
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	files := make(map[string]int64)
+	listed := 0
+	for _, i := range be.order() {
+		if errs[i] != nil {
+			be.setHealthy(i, false)
+			be.report("unable to list files in %v: %v\n", be.names[i], errs[i])
+			continue
+		}
+		listed++
+		for name, size := range lists[i] {
+			if _, ok := files[name]; !ok {
+				files[name] = size
+			}
+		}
+	}
+	if listed == 0 {
+		return errs[0]
+	}
+
+	for i, list := range lists {
+		if errs[i] == nil && len(list) != len(files) {
+			be.report("%d files of type %v are missing in %v\n", len(files)-len(list), t, be.names[i])
+		}
+	}
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err := fn(backend.FileInfo{Name: name, Size: files[name]}); err != nil {
+			return err
+		}
+	}
+	return ctx.Err()
+}
+
+// Delete removes all data in all backends.
+func (be *Backend) Delete(ctx context.Context) error {
+	return be.firstError(be.forAll(func(_ int, b backend.Backend) error {
+		return b.Delete(ctx)
+	}), "delete")
+}
+
+// Close closes all backends.
+func (be *Backend) Close() error {
+	return be.firstError(be.forAll(func(_ int, b backend.Backend) error {
+		return b.Close()
+	}), "close")
+}
+
+// forAll runs fn concurrently for all backends and returns the errors.
+func (be *Backend) forAll(fn func(i int, b backend.Backend) error) []error {
+	errs := make([]error, len(be.backends))
+	var wg sync.WaitGroup
+	for i, b := range be.backends {
+		wg.Add(1)
+		go func(i int, b backend.Backend) {
+			defer wg.Done()
+			errs[i] = fn(i, b)
+		}(i, b)
+	}
+	wg.Wait()
+	return errs
+}
+
+func (be *Backend) firstError(errs []error, op string) error {
+	for i, err := range errs {
+		if err != nil {
+			return errors.Wrapf(err, "%v %v", op, be.names[i])
+		}
+	}
+	return nil
+}
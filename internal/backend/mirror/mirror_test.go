@@ -0,0 +1,214 @@
+package mirror_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/chanhpng/vlbe/internal/backend"
+	"github.com/chanhpng/vlbe/internal/backend/local"
+	"github.com/chanhpng/vlbe/internal/backend/location"
+	"github.com/chanhpng/vlbe/internal/backend/mem"
+	"github.com/chanhpng/vlbe/internal/backend/mirror"
+	"github.com/chanhpng/vlbe/internal/backend/mock"
+	"github.com/chanhpng/vlbe/internal/backend/retry"
+	"github.com/chanhpng/vlbe/internal/backend/test"
+	"github.com/chanhpng/vlbe/internal/errors"
+	"github.com/chanhpng/vlbe/internal/restic"
+	rtest "github.com/chanhpng/vlbe/internal/test"
+)
+
+func newTestSuite(t testing.TB) *test.Suite[location.MirrorConfig] {
+	registry := location.NewRegistry()
+	registry.Register(local.NewFactory())
+	factory := location.NewMirrorFactory(registry, nil)
+
+	return &test.Suite[location.MirrorConfig]{
+		// NewConfig returns a config for a new temporary backend that will be used in tests.
+		NewConfig: func() (*location.MirrorConfig, error) {
+			dir := rtest.TempDir(t)
+			cfg, err := factory.ParseConfig("mirror:" + filepath.Join(dir, "a") + location.MirrorSeparator + filepath.Join(dir, "b"))
+			if err != nil {
+				return nil, err
+			}
+			return cfg.(*location.MirrorConfig), nil
+		},
+
+		Factory: factory,
+	}
+}
+
+func TestSuiteBackendMirror(t *testing.T) {
+	newTestSuite(t).RunTests(t)
+}
+
+func BenchmarkSuiteBackendMirror(t *testing.B) {
+	newTestSuite(t).RunBenchmarks(t)
+}
+
+type reports []string
+
+func (r *reports) report(msg string, args ...interface{}) {
+	*r = append(*r, fmt.Sprintf(msg, args...))
+}
+
+func newMemMirror(t testing.TB) (*mirror.Backend, []*mem.MemoryBackend, *reports) {
+	backends := []*mem.MemoryBackend{mem.New(), mem.New()}
+	r := &reports{}
+	be, err := mirror.New([]backend.Backend{backends[0], backends[1]}, []string{"first", "second"}, r.report)
+	rtest.OK(t, err)
+	return be, backends, r
+}
+
+func save(t testing.TB, be backend.Backend, h backend.Handle, data []byte) {
+	rtest.OK(t, be.Save(context.TODO(), h, backend.NewByteReader(data, be.Hasher())))
+}
+
+func load(t testing.TB, be backend.Backend, h backend.Handle) []byte {
+	var buf []byte
+	rtest.OK(t, be.Load(context.TODO(), h, 0, 0, func(rd io.Reader) error {
+		var err error
+		buf, err = io.ReadAll(rd)
+		return err
+	}))
+	return buf
+}
+
+func TestMirrorSaveLoad(t *testing.T) {
+	be, backends, r := newMemMirror(t)
+	data := rtest.Random(23, 1000)
+	h := backend.Handle{Type: restic.PackFile, Name: restic.Hash(data).String()}
+
+	save(t, be, h, data)
+	for _, b := range backends {
+		rtest.Equals(t, data, load(t, b, h))
+	}
+
+	// reading falls back to the second backend and reports the missing file
+	rtest.OK(t, backends[0].Remove(context.TODO(), h))
+	rtest.Equals(t, data, load(t, be, h))
+	fi, err := be.Stat(context.TODO(), h)
+	rtest.OK(t, err)
+	rtest.Equals(t, int64(len(data)), fi.Size)
+	rtest.Assert(t, len(*r) == 2, "expected divergence to be reported twice, got %v", *r)
+
+	// removing succeeds as long as one backend contains the file
+	rtest.OK(t, be.Remove(context.TODO(), h))
+	_, err = be.Stat(context.TODO(), h)
+	rtest.Assert(t, be.IsNotExist(err), "expected not exist error, got %v", err)
+	err = be.Remove(context.TODO(), h)
+	rtest.Assert(t, be.IsNotExist(err), "expected not exist error, got %v", err)
+}
+
+func TestMirrorList(t *testing.T) {
+	be, backends, r := newMemMirror(t)
+
+	var handles []backend.Handle
+	for i := 0; i < 5; i++ {
+		data := rtest.Random(i, 100)
+		h := backend.Handle{Type: restic.PackFile, Name: restic.Hash(data).String()}
+		save(t, be, h, data)
+		handles = append(handles, h)
+	}
+	rtest.OK(t, backends[1].Remove(context.TODO(), handles[0]))
+	rtest.OK(t, backends[0].Remove(context.TODO(), handles[1]))
+
+	names := make(map[string]struct{})
+	rtest.OK(t, be.List(context.TODO(), restic.PackFile, func(fi backend.FileInfo) error {
+		names[fi.Name] = struct{}{}
+		return nil
+	}))
+	rtest.Equals(t, len(handles), len(names))
+	rtest.Equals(t, reports{
+		"1 files of type data are missing in first\n",
+		"1 files of type data are missing in second\n",
+	}, *r)
+}
+
+func TestMirrorSaveFailure(t *testing.T) {
+	first := mem.New()
+	second := mock.NewBackend()
+	second.SaveFn = func(context.Context, backend.Handle, backend.RewindReader) error {
+		return errors.New("save failed")
+	}
+	var reports []string
+	be, err := mirror.New([]backend.Backend{first, second}, []string{"first", "second"}, func(msg string, args ...interface{}) {
+		reports = append(reports, fmt.Sprintf(msg, args...))
+	})
+	rtest.OK(t, err)
+
+	data := rtest.Random(42, 100)
+	h := backend.Handle{Type: restic.PackFile, Name: restic.Hash(data).String()}
+	err = be.Save(context.TODO(), h, backend.NewByteReader(data, be.Hasher()))
+	rtest.Assert(t, err != nil, "expected save to fail")
+
+	// the file is kept in the first backend, as it may have existed before
+	_, err = first.Stat(context.TODO(), h)
+	rtest.OK(t, err)
+	rtest.Equals(t, 1, len(reports))
+}
+
+func TestMirrorSaveRetry(t *testing.T) {
+	first := mem.New()
+	inner := mem.New()
+	second := mock.NewBackend()
+	failed := false
+	second.SaveFn = func(ctx context.Context, h backend.Handle, rd backend.RewindReader) error {
+		if !failed {
+			failed = true
+			return errors.New("save failed")
+		}
+		return inner.Save(ctx, h, rd)
+	}
+	second.HasherFn = inner.Hasher
+	second.StatFn = inner.Stat
+	second.RemoveFn = inner.Remove
+	second.IsNotExistFn = inner.IsNotExist
+
+	m, err := mirror.New([]backend.Backend{first, second}, []string{"first", "second"}, nil)
+	rtest.OK(t, err)
+	be := retry.New(m, time.Minute, nil, nil)
+
+	// the retried save must neither remove the file from the first backend
+	// nor try to overwrite it there, which the mem backend refuses
+	data := rtest.Random(42, 100)
+	h := backend.Handle{Type: restic.PackFile, Name: restic.Hash(data).String()}
+	save(t, be, h, data)
+	rtest.Assert(t, failed, "expected the first save to fail")
+	rtest.Equals(t, data, load(t, first, h))
+	rtest.Equals(t, data, load(t, inner, h))
+}
+
+func TestMirrorSaveConfig(t *testing.T) {
+	be, backends, _ := newMemMirror(t)
+	h := backend.Handle{Type: restic.ConfigFile}
+
+	// the config file is replaced even though the size does not change
+	save(t, be, h, []byte("old config"))
+	save(t, be, h, []byte("new config"))
+	for _, b := range backends {
+		rtest.Equals(t, []byte("new config"), load(t, b, h))
+	}
+}
+
+func TestMirrorParseConfig(t *testing.T) {
+	registry := location.NewRegistry()
+	registry.Register(local.NewFactory())
+	factory := location.NewMirrorFactory(registry, nil)
+	registry.Register(factory)
+
+	loc, err := location.Parse(registry, "mirror:/srv/a|local:/srv/b")
+	rtest.OK(t, err)
+	cfg := loc.Config.(*location.MirrorConfig)
+	rtest.Equals(t, []string{"/srv/a", "local:/srv/b"}, cfg.Names)
+	rtest.Equals(t, "local", cfg.Locations[0].Scheme)
+	rtest.Equals(t, "local", cfg.Locations[1].Scheme)
+
+	for _, s := range []string{"mirror:/srv/a", "mirror:/srv/a|", "mirror:/srv/a|mirror:/srv/b|/srv/c"} {
+		_, err := location.Parse(registry, s)
+		rtest.Assert(t, err != nil, "expected error for %v", s)
+	}
+}
@@ -75,6 +75,17 @@ func (e *ErrMixedPack) Error() string {
 	return fmt.Sprintf("pack %v contains a mix of tree and data blobs", e.PackID.Str())
 }
 
+// ErrCompressedPack is returned when a pack containing compressed blobs is
+// found in a repository using format version 1, which does not support
+// compression.
+type ErrCompressedPack struct {
+	PackID restic.ID
+}
+
+func (e *ErrCompressedPack) Error() string {
+	return fmt.Sprintf("pack %v contains compressed blobs, which repository version 1 does not support", e.PackID.Str())
+}
+
 // ErrOldIndexFormat is returned when an index with the old format is
 // found.
 type ErrOldIndexFormat struct {
@@ -112,6 +123,9 @@ func (c *Checker) LoadIndex(ctx context.Context, p *progress.Counter) (hints []e
 	debug.Log("Start")
 
 	packToIndex := make(map[restic.ID]restic.IDSet)
+	// compression is supported since repository version 2
+	compressedPacks := restic.NewIDSet()
+	supportsCompression := c.repo.Config().Version >= 2
 	err := c.masterIndex.Load(ctx, c.repo, p, func(id restic.ID, idx *index.Index, oldFormat bool, err error) error {
 		debug.Log("process index %v, err %v", id, err)
 
@@ -136,6 +150,10 @@ func (c *Checker) LoadIndex(ctx context.Context, p *progress.Counter) (hints []e
 				packToIndex[blob.PackID] = restic.NewIDSet()
 			}
 			packToIndex[blob.PackID].Insert(id)
+
+			if !supportsCompression && blob.IsCompressed() {
+				compressedPacks.Insert(blob.PackID)
+			}
 		})
 
 		debug.Log("%d blobs processed", cnt)
@@ -176,6 +194,11 @@ func (c *Checker) LoadIndex(ctx context.Context, p *progress.Counter) (hints []e
 				PackID: packID,
 			})
 		}
+		if compressedPacks.Has(packID) {
+			hints = append(hints, &ErrCompressedPack{
+				PackID: packID,
+			})
+		}
 	}
 
 	return hints, errs
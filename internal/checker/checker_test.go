@@ -648,3 +648,44 @@ func BenchmarkCheckerSnapshotScaling(b *testing.B) {
 		})
 	}
 }
+
+// versionRepository reports a different repository version.
+type versionRepository struct {
+	restic.Repository
+	version uint
+}
+
+func (r versionRepository) Config() restic.Config {
+	cfg := r.Repository.Config()
+	cfg.Version = r.version
+	return cfg
+}
+
+func TestCheckerCompressedPackVersion(t *testing.T) {
+	ctx := context.TODO()
+	repo, _ := repository.TestRepositoryWithVersion(t, 2)
+
+	wg, wgCtx := errgroup.WithContext(ctx)
+	repo.StartPackUploader(wgCtx, wg)
+	_, _, _, err := repo.SaveBlob(ctx, restic.DataBlob, make([]byte, 64*1024), restic.ID{}, false)
+	test.OK(t, err)
+	test.OK(t, repo.Flush(ctx))
+
+	for _, version := range []uint{1, 2, 3} {
+		chkr := checker.New(versionRepository{repo, version}, false)
+		hints, errs := chkr.LoadIndex(ctx, nil)
+		test.Equals(t, 0, len(errs))
+
+		compressed := 0
+		for _, hint := range hints {
+			if _, ok := hint.(*checker.ErrCompressedPack); ok {
+				compressed++
+			}
+		}
+		if version == 1 {
+			test.Equals(t, 1, compressed)
+		} else {
+			test.Equals(t, 0, len(hints))
+		}
+	}
+}
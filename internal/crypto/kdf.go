@@ -7,6 +7,7 @@ import (
 	"github.com/chanhpng/vlbe/internal/errors"
 
 	sscrypt "github.com/elithrar/simple-scrypt"
+	"golang.org/x/crypto/argon2"
 	"golang.org/x/crypto/scrypt"
 )
 
@@ -89,6 +90,103 @@ func KDF(p Params, salt []byte, password string) (*Key, error) {
 	return derKeys, nil
 }
 
+// Argon2Params are the parameters used for the key derivation function
+// Argon2idKDF(). Memory is specified in KiB.
+type Argon2Params struct {
+	Time        uint32
+	Memory      uint32
+	Parallelism uint8
+}
+
+// DefaultArgon2Params are the default parameters used for CalibrateArgon2 and
+// Argon2idKDF(), as recommended by RFC 9106 for memory-constrained
+// environments.
+var DefaultArgon2Params = Argon2Params{
+	Time:        3,
+	Memory:      64 * 1024,
+	Parallelism: 4,
+}
+
+// maxArgon2Memory limits the memory (in KiB) of Argon2 parameters read from
+// untrusted key files.
+const maxArgon2Memory = 4 * 1024 * 1024
+
+// Check returns an error if the parameters are invalid.
+func (p Argon2Params) Check() error {
+	if p.Time < 1 {
+		return errors.New("argon2 time parameter must be at least 1")
+	}
+	if p.Parallelism < 1 {
+		return errors.New("argon2 parallelism parameter must be at least 1")
+	}
+	if p.Memory < 8*uint32(p.Parallelism) {
+		return errors.Errorf("argon2 memory parameter must be at least %d KiB", 8*uint32(p.Parallelism))
+	}
+	if p.Memory > maxArgon2Memory {
+		return errors.Errorf("argon2 memory parameter %d KiB is too large", p.Memory)
+	}
+	return nil
+}
+
+// CalibrateArgon2 determines new Argon2id parameters for the current
+// hardware. The memory limit is specified in MiB. The number of passes is
+// increased until deriving a key takes about as long as timeout.
+func CalibrateArgon2(timeout time.Duration, memory int) (Argon2Params, error) {
+	params := DefaultArgon2Params
+	if memory > 0 && uint32(memory)*1024 < params.Memory {
+		params.Memory = uint32(memory) * 1024
+	}
+	params.Time = 1
+
+	if err := params.Check(); err != nil {
+		return DefaultArgon2Params, errors.Wrap(err, "argon2.Calibrate")
+	}
+
+	salt, err := NewSalt()
+	if err != nil {
+		return DefaultArgon2Params, err
+	}
+
+	// measure a single pass and scale the number of passes accordingly
+	start := time.Now()
+	argon2.IDKey([]byte("password"), salt, params.Time, params.Memory, params.Parallelism, macKeySize+aesKeySize)
+	elapsed := time.Since(start)
+
+	if elapsed > 0 {
+		params.Time = uint32(timeout / elapsed)
+	}
+	if params.Time < 1 {
+		params.Time = 1
+	}
+
+	return params, nil
+}
+
+// Argon2idKDF derives encryption and message authentication keys from the
+// password using Argon2id with the supplied parameters and the salt.
+func Argon2idKDF(p Argon2Params, salt []byte, password string) (*Key, error) {
+	if len(salt) != saltLength {
+		return nil, errors.Errorf("argon2id() called with invalid salt bytes (len %d)", len(salt))
+	}
+
+	if err := p.Check(); err != nil {
+		return nil, errors.Wrap(err, "Check")
+	}
+
+	keybytes := macKeySize + aesKeySize
+	argonKeys := argon2.IDKey([]byte(password), salt, p.Time, p.Memory, p.Parallelism, uint32(keybytes))
+
+	derKeys := &Key{}
+
+	// first 32 byte of argon2id output is the encryption key
+	copy(derKeys.EncryptionKey[:], argonKeys[:aesKeySize])
+
+	// next 32 byte of argon2id output is the mac key, in the form k||r
+	macKeyFromSlice(&derKeys.MACKey, argonKeys[aesKeySize:])
+
+	return derKeys, nil
+}
+
 // NewSalt returns new random salt bytes to use with KDF(). If NewSalt returns
 // an error, this is a grave situation and the program must abort and terminate.
 func NewSalt() ([]byte, error) {
@@ -12,3 +12,48 @@ func TestCalibrate(t *testing.T) {
 	}
 	t.Logf("testing calibrate, params after: %v", params)
 }
+
+func TestCalibrateArgon2(t *testing.T) {
+	params, err := CalibrateArgon2(100*time.Millisecond, 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("testing calibrate, params after: %v", params)
+
+	if params.Memory != 50*1024 {
+		t.Fatalf("memory limit was not applied, got %v KiB", params.Memory)
+	}
+}
+
+func TestArgon2idKDF(t *testing.T) {
+	salt, err := NewSalt()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	params := Argon2Params{Time: 1, Memory: 64, Parallelism: 1}
+	k1, err := Argon2idKDF(params, salt, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	k2, err := Argon2idKDF(params, salt, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !k1.Valid() || k1.EncryptionKey != k2.EncryptionKey || k1.MACKey != k2.MACKey {
+		t.Fatal("derived keys are invalid or differ")
+	}
+
+	k3, err := Argon2idKDF(params, salt, "other password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k1.EncryptionKey == k3.EncryptionKey {
+		t.Fatal("different passwords yield the same key")
+	}
+
+	_, err = Argon2idKDF(Argon2Params{Time: 0, Memory: 64, Parallelism: 1}, salt, "password")
+	if err == nil {
+		t.Fatal("invalid parameters were accepted")
+	}
+}
@@ -0,0 +1,356 @@
+//go:build darwin || freebsd || linux
+// +build darwin freebsd linux
+
+package davserver
+
+import (
+	"context"
+	"crypto/sha256"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/fuse"
+	"github.com/anacrolix/fuse/fs"
+	lru "github.com/hashicorp/golang-lru/v2"
+	"golang.org/x/net/webdav"
+
+	"github.com/chanhpng/vlbe/internal/debug"
+	"github.com/chanhpng/vlbe/internal/errors"
+)
+
+// maxLinkDepth is the maximum number of symlinks followed to resolve a path.
+const maxLinkDepth = 8
+
+// nodeCacheSize is the number of resolved nodes which are cached.
+const nodeCacheSize = 4096
+
+// fileSystem provides read-only access to the nodes of a fuse mount. Relative
+// symlinks within the file system are followed, other symlinks are hidden as
+// WebDAV cannot represent them.
+type fileSystem struct {
+	root fs.Node
+	// snapshotsHash identifies the current snapshots of the mount
+	snapshotsHash func(ctx context.Context) ([sha256.Size]byte, error)
+
+	// nodes caches the nodes by path, so that the trees along a path are
+	// not loaded again for each request. Symlinks are not cached, as the
+	// "latest" links change. The cache is cleared whenever the snapshots
+	// change.
+	nodes *lru.Cache[string, fs.Node]
+	m     sync.Mutex
+	hash  [sha256.Size]byte
+}
+
+func newFileSystem(root fs.Node, snapshotsHash func(ctx context.Context) ([sha256.Size]byte, error)) (*fileSystem, error) {
+	nodes, err := lru.New[string, fs.Node](nodeCacheSize)
+	if err != nil {
+		return nil, err
+	}
+	return &fileSystem{root: root, snapshotsHash: snapshotsHash, nodes: nodes}, nil
+}
+
+// checkSnapshots clears the cached nodes if the snapshots have changed.
+func (fsys *fileSystem) checkSnapshots(ctx context.Context) error {
+	hash, err := fsys.snapshotsHash(ctx)
+	if err != nil {
+		return err
+	}
+
+	fsys.m.Lock()
+	defer fsys.m.Unlock()
+	if hash != fsys.hash {
+		debug.Log("snapshots changed, clearing cached nodes")
+		fsys.nodes.Purge()
+		fsys.hash = hash
+	}
+	return nil
+}
+
+var _ webdav.FileSystem = &fileSystem{}
+
+func (fsys *fileSystem) Mkdir(_ context.Context, _ string, _ os.FileMode) error {
+	return os.ErrPermission
+}
+
+func (fsys *fileSystem) RemoveAll(_ context.Context, _ string) error {
+	return os.ErrPermission
+}
+
+func (fsys *fileSystem) Rename(_ context.Context, _, _ string) error {
+	return os.ErrPermission
+}
+
+func (fsys *fileSystem) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	node, err := fsys.resolve(ctx, name, 0)
+	if err != nil {
+		return nil, err
+	}
+	return stat(ctx, path.Base(path.Clean("/"+name)), node)
+}
+
+func (fsys *fileSystem) OpenFile(ctx context.Context, name string, flag int, _ os.FileMode) (webdav.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0 {
+		return nil, os.ErrPermission
+	}
+
+	p := path.Clean("/" + name)
+	node, err := fsys.resolve(ctx, p, 0)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := stat(ctx, path.Base(p), node)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &file{ctx: ctx, fsys: fsys, path: p, node: node, fi: fi}
+	if fi.IsDir() {
+		return f, nil
+	}
+
+	opener, ok := node.(fs.NodeOpener)
+	if !ok {
+		return nil, os.ErrPermission
+	}
+	f.handle, err = opener.Open(ctx, &fuse.OpenRequest{Flags: fuse.OpenReadOnly}, &fuse.OpenResponse{})
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// resolve returns the node for the slash-separated path name.
+func (fsys *fileSystem) resolve(ctx context.Context, name string, depth int) (fs.Node, error) {
+	if depth == 0 {
+		if err := fsys.checkSnapshots(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	node := fsys.root
+	dir := "/"
+	for _, elem := range strings.Split(path.Clean("/"+name), "/") {
+		if elem == "" {
+			continue
+		}
+
+		var err error
+		node, err = fsys.lookupLink(ctx, dir, node, elem, depth)
+		if err != nil {
+			return nil, err
+		}
+		dir = path.Join(dir, elem)
+	}
+	return node, nil
+}
+
+// lookupLink returns the item name within the directory node at dir. If the
+// item is a symlink, its target is returned.
+func (fsys *fileSystem) lookupLink(ctx context.Context, dir string, node fs.Node, name string, depth int) (fs.Node, error) {
+	p := path.Join(dir, name)
+	if child, ok := fsys.nodes.Get(p); ok {
+		return child, nil
+	}
+
+	lookuper, ok := node.(fs.NodeStringLookuper)
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	child, err := lookuper.Lookup(ctx, name)
+	if err != nil {
+		return nil, convertError(err)
+	}
+
+	if link, ok := child.(fs.NodeReadlinker); ok {
+		return fsys.followLink(ctx, dir, link, depth)
+	}
+	fsys.nodes.Add(p, child)
+	return child, nil
+}
+
+// followLink returns the target of a relative symlink within dir.
+func (fsys *fileSystem) followLink(ctx context.Context, dir string, link fs.NodeReadlinker, depth int) (fs.Node, error) {
+	if depth >= maxLinkDepth {
+		return nil, os.ErrNotExist
+	}
+
+	target, err := link.Readlink(ctx, &fuse.ReadlinkRequest{})
+	if err != nil {
+		return nil, convertError(err)
+	}
+	if path.IsAbs(target) {
+		debug.Log("not following absolute symlink to %v", target)
+		return nil, os.ErrNotExist
+	}
+	return fsys.resolve(ctx, path.Join(dir, target), depth+1)
+}
+
+func convertError(err error) error {
+	if errors.Is(err, os.ErrNotExist) {
+		return os.ErrNotExist
+	}
+	return err
+}
+
+// fileInfo implements os.FileInfo for the attributes of a node.
+type fileInfo struct {
+	name string
+	attr fuse.Attr
+}
+
+func stat(ctx context.Context, name string, node fs.Node) (*fileInfo, error) {
+	fi := &fileInfo{name: name}
+	if err := node.Attr(ctx, &fi.attr); err != nil {
+		return nil, err
+	}
+	if name == "/" {
+		fi.name = ""
+	}
+	return fi, nil
+}
+
+func (fi *fileInfo) Name() string       { return fi.name }
+func (fi *fileInfo) Size() int64        { return int64(fi.attr.Size) }
+func (fi *fileInfo) Mode() os.FileMode  { return fi.attr.Mode }
+func (fi *fileInfo) ModTime() time.Time { return fi.attr.Mtime }
+func (fi *fileInfo) IsDir() bool        { return fi.attr.Mode.IsDir() }
+func (fi *fileInfo) Sys() interface{}   { return nil }
+
+// file is an open file or directory.
+type file struct {
+	ctx  context.Context
+	fsys *fileSystem
+	path string
+	node fs.Node
+	fi   *fileInfo
+
+	// handle is set for regular files
+	handle fs.Handle
+	offset int64
+
+	// entries contains the directory entries not yet returned by Readdir
+	entries []os.FileInfo
+	listed  bool
+}
+
+var _ webdav.File = &file{}
+
+func (f *file) Close() error {
+	if releaser, ok := f.handle.(fs.HandleReleaser); ok {
+		return releaser.Release(f.ctx, &fuse.ReleaseRequest{})
+	}
+	return nil
+}
+
+func (f *file) Read(p []byte) (int, error) {
+	reader, ok := f.handle.(fs.HandleReader)
+	if !ok {
+		return 0, os.ErrInvalid
+	}
+	if f.offset >= f.fi.Size() {
+		return 0, io.EOF
+	}
+	if remaining := f.fi.Size() - f.offset; int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+
+	resp := &fuse.ReadResponse{Data: p}
+	err := reader.Read(f.ctx, &fuse.ReadRequest{Offset: f.offset, Size: len(p)}, resp)
+	if err != nil {
+		return 0, err
+	}
+	f.offset += int64(len(resp.Data))
+	if len(resp.Data) == 0 {
+		return 0, io.EOF
+	}
+	return len(resp.Data), nil
+}
+
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.fi.Size()
+	default:
+		return 0, os.ErrInvalid
+	}
+	if offset < 0 {
+		return 0, os.ErrInvalid
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *file) Write(_ []byte) (int, error) {
+	return 0, os.ErrPermission
+}
+
+func (f *file) Stat() (os.FileInfo, error) {
+	return f.fi, nil
+}
+
+// Readdir returns the next count entries of the directory, or all remaining
+// entries if count is not positive.
+func (f *file) Readdir(count int) ([]os.FileInfo, error) {
+	if !f.listed {
+		entries, err := f.fsys.readDir(f.ctx, f.path, f.node)
+		if err != nil {
+			return nil, err
+		}
+		f.entries = entries
+		f.listed = true
+	}
+
+	if count <= 0 {
+		entries := f.entries
+		f.entries = nil
+		return entries, nil
+	}
+	if len(f.entries) == 0 {
+		return nil, io.EOF
+	}
+	if count > len(f.entries) {
+		count = len(f.entries)
+	}
+	entries := f.entries[:count]
+	f.entries = f.entries[count:]
+	return entries, nil
+}
+
+// readDir returns the entries of the directory node at dir. Entries which
+// cannot be resolved are skipped.
+func (fsys *fileSystem) readDir(ctx context.Context, dir string, node fs.Node) ([]os.FileInfo, error) {
+	reader, ok := node.(fs.HandleReadDirAller)
+	if !ok {
+		return nil, os.ErrInvalid
+	}
+	dirents, err := reader.ReadDirAll(ctx)
+	if err != nil {
+		return nil, convertError(err)
+	}
+
+	entries := make([]os.FileInfo, 0, len(dirents))
+	for _, dirent := range dirents {
+		if dirent.Name == "." || dirent.Name == ".." {
+			continue
+		}
+
+		child, err := fsys.lookupLink(ctx, dir, node, dirent.Name, 0)
+		if err != nil {
+			debug.Log("skipping %v: %v", path.Join(dir, dirent.Name), err)
+			continue
+		}
+		fi, err := stat(ctx, dirent.Name, child)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, fi)
+	}
+	return entries, nil
+}
@@ -0,0 +1,219 @@
+//go:build darwin || freebsd || linux
+// +build darwin freebsd linux
+
+// Package davserver serves the snapshots of a repository read-only via WebDAV
+// and plain HTTP. It uses the same directory structure as the fuse mount and
+// is built on its nodes, thus it is only available on the same platforms.
+package davserver
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/net/webdav"
+
+	"github.com/chanhpng/vlbe/internal/debug"
+	"github.com/chanhpng/vlbe/internal/errors"
+	"github.com/chanhpng/vlbe/internal/fuse"
+	"github.com/chanhpng/vlbe/internal/restic"
+	"github.com/chanhpng/vlbe/internal/restserver"
+)
+
+// Config configures a Server.
+type Config struct {
+	// Mount configures the directory structure of the snapshots. Writable
+	// mounts are not supported.
+	Mount fuse.Config
+
+	// Users contains the credentials of all users which are allowed to
+	// access the server. Authentication is disabled if Users is nil.
+	Users *restserver.Htpasswd
+}
+
+// Server serves the snapshots of a repository. Files can be downloaded with
+// GET requests, including range requests, or accessed by WebDAV clients.
+// Directories are listed as HTML, or as JSON if requested by the client.
+type Server struct {
+	cfg  Config
+	fsys *fileSystem
+	dav  *webdav.Handler
+}
+
+// New returns a new Server for repo. The index of repo must already be loaded.
+func New(repo restic.Repository, cfg Config) (*Server, error) {
+	if cfg.Mount.Overlay != nil {
+		return nil, errors.New("writable snapshots are not supported")
+	}
+
+	root := fuse.NewRoot(repo, cfg.Mount)
+	fsys, err := newFileSystem(root, root.SnapshotsHash)
+	if err != nil {
+		return nil, err
+	}
+	return &Server{
+		cfg:  cfg,
+		fsys: fsys,
+		dav: &webdav.Handler{
+			FileSystem: fsys,
+			// required by the handler, locking is rejected as the server is read-only
+			LockSystem: webdav.NewMemLS(),
+			Logger: func(r *http.Request, err error) {
+				if err != nil {
+					debug.Log("%v %v failed: %v", r.Method, r.URL.Path, err)
+				}
+			},
+		},
+	}, nil
+}
+
+const allowedMethods = "GET, HEAD, OPTIONS, PROPFIND"
+
+// ServeHTTP implements http.Handler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	debug.Log("%v %v", r.Method, r.URL.Path)
+
+	if s.cfg.Users != nil {
+		user, password, ok := r.BasicAuth()
+		if !ok || !s.cfg.Users.Validate(user, password) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="restic"`)
+			httpError(w, http.StatusUnauthorized)
+			return
+		}
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		fi, err := s.fsys.Stat(r.Context(), r.URL.Path)
+		if err != nil {
+			s.error(w, err)
+			return
+		}
+		if !fi.IsDir() {
+			s.dav.ServeHTTP(w, r)
+			return
+		}
+		if !strings.HasSuffix(r.URL.Path, "/") {
+			// the links within the listing are relative to the directory
+			u := *r.URL
+			u.Path += "/"
+			http.Redirect(w, r, u.String(), http.StatusMovedPermanently)
+			return
+		}
+		s.listDir(w, r)
+	case http.MethodOptions, "PROPFIND":
+		w.Header().Set("Allow", allowedMethods)
+		s.dav.ServeHTTP(w, r)
+	default:
+		w.Header().Set("Allow", allowedMethods)
+		httpError(w, http.StatusMethodNotAllowed)
+	}
+}
+
+// dirEntry is an item of a directory listing.
+type dirEntry struct {
+	Name    string    `json:"name"`
+	Type    string    `json:"type"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+}
+
+var listingTemplate = template.Must(template.New("listing").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>{{.Path}}</title></head>
+<body>
+<h1>{{.Path}}</h1>
+<table>
+<tr><th>Name</th><th>Size</th><th>Modified</th></tr>
+{{- if ne .Path "/"}}
+<tr><td><a href="../">../</a></td><td></td><td></td></tr>
+{{- end}}
+{{- range .Entries}}
+<tr><td><a href="{{.Href}}">{{.Name}}{{if eq .Type "dir"}}/{{end}}</a></td><td>{{if ne .Type "dir"}}{{.Size}}{{end}}</td><td>{{.ModTime.Format "2006-01-02 15:04:05"}}</td></tr>
+{{- end}}
+</table>
+</body>
+</html>
+`))
+
+func (s *Server) listDir(w http.ResponseWriter, r *http.Request) {
+	f, err := s.fsys.OpenFile(r.Context(), r.URL.Path, os.O_RDONLY, 0)
+	if err != nil {
+		s.error(w, err)
+		return
+	}
+	infos, err := f.Readdir(0)
+	_ = f.Close()
+	if err != nil {
+		s.error(w, err)
+		return
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name() < infos[j].Name()
+	})
+
+	entries := make([]dirEntry, 0, len(infos))
+	for _, fi := range infos {
+		typ := "file"
+		if fi.IsDir() {
+			typ = "dir"
+		}
+		entries = append(entries, dirEntry{Name: fi.Name(), Type: typ, Size: fi.Size(), ModTime: fi.ModTime()})
+	}
+
+	if r.URL.Query().Get("format") == "json" || strings.Contains(r.Header.Get("Accept"), "application/json") {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == http.MethodHead {
+			return
+		}
+		if err := json.NewEncoder(w).Encode(entries); err != nil {
+			debug.Log("sending listing failed: %v", err)
+		}
+		return
+	}
+
+	type htmlEntry struct {
+		dirEntry
+		Href string
+	}
+	data := struct {
+		Path    string
+		Entries []htmlEntry
+	}{Path: r.URL.Path}
+	for _, entry := range entries {
+		href := url.PathEscape(entry.Name)
+		if entry.Type == "dir" {
+			href += "/"
+		}
+		data.Entries = append(data.Entries, htmlEntry{entry, href})
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if r.Method == http.MethodHead {
+		return
+	}
+	if err := listingTemplate.Execute(w, data); err != nil {
+		debug.Log("sending listing failed: %v", err)
+	}
+}
+
+func (s *Server) error(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		httpError(w, http.StatusNotFound)
+	case errors.Is(err, os.ErrPermission):
+		httpError(w, http.StatusForbidden)
+	default:
+		debug.Log("request failed: %v", err)
+		httpError(w, http.StatusInternalServerError)
+	}
+}
+
+func httpError(w http.ResponseWriter, code int) {
+	http.Error(w, http.StatusText(code), code)
+}
@@ -0,0 +1,127 @@
+//go:build darwin || freebsd || linux
+// +build darwin freebsd linux
+
+package davserver_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chanhpng/vlbe/internal/archiver"
+	"github.com/chanhpng/vlbe/internal/davserver"
+	"github.com/chanhpng/vlbe/internal/fuse"
+	"github.com/chanhpng/vlbe/internal/repository"
+	"github.com/chanhpng/vlbe/internal/restic"
+	rtest "github.com/chanhpng/vlbe/internal/test"
+)
+
+func runServer(t testing.TB) (string, *restic.Snapshot) {
+	repo := repository.TestRepository(t)
+	tempdir := rtest.TempDir(t)
+	archiver.TestCreateFiles(t, tempdir, archiver.TestDir{
+		"file": archiver.TestFile{Content: "0123456789"},
+		"sub": archiver.TestDir{
+			"other": archiver.TestFile{Content: "other"},
+		},
+	})
+	back := rtest.Chdir(t, tempdir)
+	archiver.TestSnapshot(t, repo, ".", nil)
+	back()
+
+	var id restic.ID
+	rtest.OK(t, repo.List(context.TODO(), restic.SnapshotFile, func(snID restic.ID, _ int64) error {
+		id = snID
+		return nil
+	}))
+	sn, err := restic.LoadSnapshot(context.TODO(), repo, id)
+	rtest.OK(t, err)
+
+	srv, err := davserver.New(repo, davserver.Config{Mount: fuse.Config{TimeTemplate: time.RFC3339}})
+	rtest.OK(t, err)
+	ts := httptest.NewServer(srv)
+	t.Cleanup(ts.Close)
+	return ts.URL, sn
+}
+
+func request(t testing.TB, method, url string, header http.Header) (*http.Response, string) {
+	req, err := http.NewRequest(method, url, nil)
+	rtest.OK(t, err)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := http.DefaultClient.Do(req)
+	rtest.OK(t, err)
+	body, err := io.ReadAll(resp.Body)
+	rtest.OK(t, err)
+	rtest.OK(t, resp.Body.Close())
+	return resp, string(body)
+}
+
+func TestServerListing(t *testing.T) {
+	url, sn := runServer(t)
+
+	resp, body := request(t, http.MethodGet, url+"/?format=json", nil)
+	rtest.Equals(t, http.StatusOK, resp.StatusCode)
+	var entries []struct {
+		Name string `json:"name"`
+		Type string `json:"type"`
+	}
+	rtest.OK(t, json.Unmarshal([]byte(body), &entries))
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name)
+	}
+	rtest.Equals(t, []string{"hosts", "ids", "snapshots", "tags", "versions"}, names)
+
+	// symlinks to snapshots are followed
+	resp, body = request(t, http.MethodGet, url+"/snapshots/latest/", http.Header{"Accept": {"application/json"}})
+	rtest.Equals(t, http.StatusOK, resp.StatusCode)
+	rtest.Assert(t, strings.Contains(body, `"name":"file"`), "file missing in listing %v", body)
+
+	resp, body = request(t, http.MethodGet, url+"/ids/"+sn.ID().Str()+"/", nil)
+	rtest.Equals(t, http.StatusOK, resp.StatusCode)
+	rtest.Assert(t, strings.Contains(body, `<a href="sub/">sub/</a>`), "directory missing in listing %v", body)
+
+	resp, _ = request(t, http.MethodGet, url+"/ids", nil)
+	rtest.Equals(t, http.StatusOK, resp.StatusCode)
+	rtest.Equals(t, url+"/ids/", resp.Request.URL.String())
+
+	resp, _ = request(t, http.MethodGet, url+"/missing/", nil)
+	rtest.Equals(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestServerDownload(t *testing.T) {
+	url, sn := runServer(t)
+	fileURL := url + "/ids/" + sn.ID().Str() + "/file"
+
+	resp, body := request(t, http.MethodGet, fileURL, nil)
+	rtest.Equals(t, http.StatusOK, resp.StatusCode)
+	rtest.Equals(t, "0123456789", body)
+
+	resp, body = request(t, http.MethodGet, fileURL, http.Header{"Range": {"bytes=3-5"}})
+	rtest.Equals(t, http.StatusPartialContent, resp.StatusCode)
+	rtest.Equals(t, "345", body)
+
+	resp, body = request(t, http.MethodGet, url+"/versions/sub/other/"+sn.Time.Format(time.RFC3339), nil)
+	rtest.Equals(t, http.StatusOK, resp.StatusCode)
+	rtest.Equals(t, "other", body)
+}
+
+func TestServerReadOnly(t *testing.T) {
+	url, sn := runServer(t)
+
+	for _, method := range []string{http.MethodPut, http.MethodDelete, "MKCOL", "MOVE", "LOCK"} {
+		resp, _ := request(t, method, url+"/ids/"+sn.ID().Str()+"/file", nil)
+		rtest.Equals(t, http.StatusMethodNotAllowed, resp.StatusCode)
+	}
+
+	resp, body := request(t, "PROPFIND", url+"/ids/", http.Header{"Depth": {"1"}})
+	rtest.Equals(t, http.StatusMultiStatus, resp.StatusCode)
+	rtest.Assert(t, strings.Contains(body, sn.ID().Str()), "snapshot missing in %v", body)
+}
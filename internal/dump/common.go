@@ -8,20 +8,42 @@ import (
 	"github.com/chanhpng/vlbe/internal/bloblru"
 	"github.com/chanhpng/vlbe/internal/errors"
 	"github.com/chanhpng/vlbe/internal/restic"
+	"github.com/chanhpng/vlbe/internal/restorer"
 	"github.com/chanhpng/vlbe/internal/walker"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
 	"golang.org/x/sync/errgroup"
 )
 
 // A Dumper writes trees and files from a repository to a Writer
 // in an archive format.
 type Dumper struct {
+	// SelectFilter selects the items written to an archive, with the same
+	// semantics as for the restorer. All items are selected if it is nil.
+	SelectFilter func(item string, isDir bool) (selectedForRestore bool, childMayBeSelected bool)
+	// Sparse enables writing files with all-zero chunks as sparse files if
+	// the archive format supports it.
+	Sparse bool
+
 	cache  *bloblru.Cache
 	format string
-	repo   restic.Loader
+	repo   Loader
 	w      io.Writer
+
+	// hardlinks contains the path of the first link for each hard linked
+	// file written to the archive
+	hardlinks *restorer.HardlinkIndex[string]
 }
 
-func New(format string, repo restic.Loader, w io.Writer) *Dumper {
+// Loader loads the content of files from the repository.
+type Loader interface {
+	restic.Loader
+	// ZeroChunk returns the ID of an all-zero chunk, which is used to find
+	// holes in sparse files.
+	ZeroChunk() restic.ID
+}
+
+func New(format string, repo Loader, w io.Writer) *Dumper {
 	return &Dumper{
 		cache:  bloblru.New(64 << 20),
 		format: format,
@@ -30,48 +52,98 @@ func New(format string, repo restic.Loader, w io.Writer) *Dumper {
 	}
 }
 
+// DumpTree writes the nodes of tree and their content to an archive. The
+// paths within the archive start with rootPath.
 func (d *Dumper) DumpTree(ctx context.Context, tree *restic.Tree, rootPath string) error {
+	for _, root := range tree.Nodes {
+		root.Path = path.Join(rootPath, root.Name)
+	}
+	return d.DumpNodes(ctx, tree.Nodes)
+}
+
+// DumpNodes writes nodes to an archive, including the content of
+// directories. The Path of each node must be set to its path within the
+// archive.
+func (d *Dumper) DumpNodes(ctx context.Context, nodes []*restic.Node) error {
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
 	// ch is buffered to deal with variable download/write speeds.
 	ch := make(chan *restic.Node, 10)
-	go sendTrees(ctx, d.repo, tree, rootPath, ch)
+	go d.sendTrees(ctx, nodes, ch)
 
 	switch d.format {
 	case "tar":
 		return d.dumpTar(ctx, ch)
+	case "tar.gz":
+		return d.dumpCompressedTar(ctx, ch, gzip.NewWriter(d.w))
+	case "tar.zst":
+		zw, err := zstd.NewWriter(d.w)
+		if err != nil {
+			return err
+		}
+		return d.dumpCompressedTar(ctx, ch, zw)
 	case "zip":
 		return d.dumpZip(ctx, ch)
+	case "cpio":
+		return d.dumpCpio(ctx, ch)
 	default:
 		panic("unknown dump format")
 	}
 }
 
-func sendTrees(ctx context.Context, repo restic.BlobLoader, tree *restic.Tree, rootPath string, ch chan *restic.Node) {
+// dumpCompressedTar writes a tar archive to cw, which compresses the data
+// written to d.w.
+func (d *Dumper) dumpCompressedTar(ctx context.Context, ch <-chan *restic.Node, cw io.WriteCloser) error {
+	w := d.w
+	d.w = cw
+	defer func() {
+		d.w = w
+	}()
+
+	if err := d.dumpTar(ctx, ch); err != nil {
+		// release the resources of the compressor, the archive is
+		// incomplete anyway
+		_ = cw.Close()
+		return err
+	}
+	return errors.Wrap(cw.Close(), "Close")
+}
+
+func (d *Dumper) sendTrees(ctx context.Context, roots []*restic.Node, ch chan *restic.Node) {
 	defer close(ch)
 
-	for _, root := range tree.Nodes {
-		root.Path = path.Join(rootPath, root.Name)
-		if sendNodes(ctx, repo, root, ch) != nil {
+	for _, root := range roots {
+		if d.sendNodes(ctx, root, ch) != nil {
 			break
 		}
 	}
 }
 
-func sendNodes(ctx context.Context, repo restic.BlobLoader, root *restic.Node, ch chan *restic.Node) error {
-	select {
-	case ch <- root:
-	case <-ctx.Done():
-		return ctx.Err()
+// selectNode applies d.SelectFilter to node.
+func (d *Dumper) selectNode(node *restic.Node) (selected bool, childMayBeSelected bool) {
+	if d.SelectFilter == nil {
+		return true, IsDir(node)
+	}
+	return d.SelectFilter(node.Path, IsDir(node))
+}
+
+func (d *Dumper) sendNodes(ctx context.Context, root *restic.Node, ch chan *restic.Node) error {
+	selected, childMayBeSelected := d.selectNode(root)
+	if selected {
+		select {
+		case ch <- root:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
 	}
 
-	// If this is no directory we are finished
-	if !IsDir(root) {
+	// If this is no directory or nothing within it is selected we are finished
+	if !IsDir(root) || !childMayBeSelected {
 		return nil
 	}
 
-	err := walker.Walk(ctx, repo, *root.Subtree, walker.WalkVisitor{ProcessNode: func(_ restic.ID, nodepath string, node *restic.Node, err error) error {
+	err := walker.Walk(ctx, d.repo, *root.Subtree, walker.WalkVisitor{ProcessNode: func(_ restic.ID, nodepath string, node *restic.Node, err error) error {
 		if err != nil {
 			return err
 		}
@@ -85,12 +157,18 @@ func sendNodes(ctx context.Context, repo restic.BlobLoader, root *restic.Node, c
 			return nil
 		}
 
-		select {
-		case ch <- node:
-		case <-ctx.Done():
-			return ctx.Err()
+		selected, childMayBeSelected := d.selectNode(node)
+		if selected {
+			select {
+			case ch <- node:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
 		}
 
+		if IsDir(node) && !childMayBeSelected {
+			return walker.ErrSkipNode
+		}
 		return nil
 	}})
 
@@ -1,8 +1,12 @@
 package dump
 
 import (
+	"archive/tar"
 	"bytes"
 	"context"
+	"io"
+	"path"
+	"sort"
 	"testing"
 
 	"github.com/chanhpng/vlbe/internal/archiver"
@@ -95,3 +99,83 @@ func WriteTest(t *testing.T, format string, cd CheckDump) {
 		})
 	}
 }
+
+// dumpTestDir creates a snapshot of src and writes it as an archive. setup is
+// called to configure the Dumper.
+func dumpTestDir(t *testing.T, format string, src archiver.TestDir, setup func(d *Dumper)) *bytes.Buffer {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	tmpdir, repo := prepareTempdirRepoSrc(t, src)
+	arch := archiver.New(repo, fs.Track{FS: fs.Local{}}, archiver.Options{})
+
+	back := rtest.Chdir(t, tmpdir)
+	defer back()
+
+	sn, _, _, err := arch.Snapshot(ctx, []string{"."}, archiver.SnapshotOptions{})
+	rtest.OK(t, err)
+
+	tree, err := restic.LoadTree(ctx, repo, *sn.Tree)
+	rtest.OK(t, err)
+
+	dst := &bytes.Buffer{}
+	d := New(format, repo, dst)
+	setup(d)
+	rtest.OK(t, d.DumpTree(ctx, tree, "/"))
+	return dst
+}
+
+func TestDumpSelectFilter(t *testing.T) {
+	src := archiver.TestDir{
+		"file.txt": archiver.TestFile{Content: "string"},
+		"file.log": archiver.TestFile{Content: "string"},
+		"dir": archiver.TestDir{
+			"nested.txt": archiver.TestFile{Content: "string"},
+			"nested.log": archiver.TestFile{Content: "string"},
+		},
+		"skipped": archiver.TestDir{
+			"other.txt": archiver.TestFile{Content: "string"},
+		},
+	}
+
+	for _, test := range []struct {
+		name   string
+		filter func(item string, isDir bool) (bool, bool)
+		want   []string
+	}{
+		{
+			name: "exclude",
+			filter: func(item string, isDir bool) (bool, bool) {
+				selected := path.Ext(item) != ".log" && item != "/skipped"
+				return selected, selected && isDir
+			},
+			want: []string{"dir/", "dir/nested.txt", "file.txt"},
+		},
+		{
+			name: "include",
+			filter: func(item string, isDir bool) (bool, bool) {
+				return item == "/dir/nested.log", isDir && item == "/dir"
+			},
+			want: []string{"dir/nested.log"},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			buf := dumpTestDir(t, "tar", src, func(d *Dumper) {
+				d.SelectFilter = test.filter
+			})
+
+			var names []string
+			tr := tar.NewReader(buf)
+			for {
+				hdr, err := tr.Next()
+				if err == io.EOF {
+					break
+				}
+				rtest.OK(t, err)
+				names = append(names, hdr.Name)
+			}
+			sort.Strings(names)
+			rtest.Equals(t, test.want, names)
+		})
+	}
+}
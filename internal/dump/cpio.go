@@ -0,0 +1,229 @@
+package dump
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+
+	"github.com/chanhpng/vlbe/internal/debug"
+	"github.com/chanhpng/vlbe/internal/errors"
+	"github.com/chanhpng/vlbe/internal/restic"
+	"github.com/chanhpng/vlbe/internal/restorer"
+)
+
+// file type bits of the mode in cpio headers
+const (
+	cpioTypeFifo    = 0o010000
+	cpioTypeChar    = 0o020000
+	cpioTypeDir     = 0o040000
+	cpioTypeBlock   = 0o060000
+	cpioTypeReg     = 0o100000
+	cpioTypeSymlink = 0o120000
+	cpioTypeSocket  = 0o140000
+)
+
+// cpioHeader is the header of an entry in a cpio archive in the "newc"
+// format, as used by the Linux initramfs.
+type cpioHeader struct {
+	Name    string
+	Inode   uint32
+	Mode    uint32
+	UID     uint32
+	GID     uint32
+	Links   uint32
+	ModTime uint32
+	Size    int64
+	// RdevMajor and RdevMinor identify the device of a device file
+	RdevMajor uint32
+	RdevMinor uint32
+}
+
+// cpioWriter writes a cpio archive in the "newc" format.
+type cpioWriter struct {
+	w io.Writer
+	// remaining is the number of bytes of the current entry not written yet
+	remaining int64
+	// pad is the number of padding bytes after the current entry
+	pad int64
+}
+
+// WriteHeader finishes the current entry and starts a new one.
+func (cw *cpioWriter) WriteHeader(hdr *cpioHeader) error {
+	if err := cw.flush(); err != nil {
+		return err
+	}
+	if hdr.Size > math.MaxUint32 {
+		return errors.New("file too large for cpio archive")
+	}
+
+	// the header is followed by the name, both are padded to four bytes
+	buf := fmt.Sprintf("070701%08X%08X%08X%08X%08X%08X%08X%08X%08X%08X%08X%08X%08X%s\x00",
+		hdr.Inode, hdr.Mode, hdr.UID, hdr.GID, hdr.Links, hdr.ModTime, hdr.Size,
+		0, 0, hdr.RdevMajor, hdr.RdevMinor, len(hdr.Name)+1, 0, hdr.Name)
+	buf += string(make([]byte, cpioPadding(int64(len(buf)))))
+	if _, err := io.WriteString(cw.w, buf); err != nil {
+		return err
+	}
+
+	cw.remaining = hdr.Size
+	cw.pad = cpioPadding(hdr.Size)
+	return nil
+}
+
+func (cw *cpioWriter) Write(p []byte) (int, error) {
+	if int64(len(p)) > cw.remaining {
+		return 0, errors.New("write too long")
+	}
+	n, err := cw.w.Write(p)
+	cw.remaining -= int64(n)
+	return n, err
+}
+
+// flush checks that the current entry is complete and writes its padding.
+func (cw *cpioWriter) flush() error {
+	if cw.remaining > 0 {
+		return fmt.Errorf("missing %d bytes of content", cw.remaining)
+	}
+	_, err := cw.w.Write(make([]byte, cw.pad))
+	cw.pad = 0
+	return err
+}
+
+// Close writes the trailer of the archive.
+func (cw *cpioWriter) Close() error {
+	if err := cw.WriteHeader(&cpioHeader{Name: "TRAILER!!!", Links: 1}); err != nil {
+		return err
+	}
+	return cw.flush()
+}
+
+func cpioPadding(n int64) int64 {
+	return -n & 3
+}
+
+func (d *Dumper) dumpCpio(ctx context.Context, ch <-chan *restic.Node) (err error) {
+	w := &cpioWriter{w: d.w}
+	d.hardlinks = restorer.NewHardlinkIndex[string]()
+	inodes := make(map[string]uint32)
+
+	defer func() {
+		if err == nil {
+			err = w.Close()
+			err = errors.Wrap(err, "Close")
+		}
+	}()
+
+	for node := range ch {
+		if err := d.dumpNodeCpio(ctx, node, w, inodes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dumpNodeCpio writes node to w. inodes contains the inode numbers of the
+// entries written so far, the first link of a hard linked file contains its
+// content.
+func (d *Dumper) dumpNodeCpio(ctx context.Context, node *restic.Node, w *cpioWriter, inodes map[string]uint32) error {
+	relPath, err := filepath.Rel("/", node.Path)
+	if err != nil {
+		return err
+	}
+
+	header := &cpioHeader{
+		Name:    filepath.ToSlash(relPath),
+		Inode:   uint32(len(inodes) + 1),
+		Mode:    uint32(node.Mode.Perm()),
+		UID:     node.UID,
+		GID:     node.GID,
+		Links:   1,
+		ModTime: cpioTime(node.ModTime.Unix()),
+		Size:    int64(node.Size),
+	}
+	if node.Mode&os.ModeSetuid != 0 {
+		header.Mode |= cISUID
+	}
+	if node.Mode&os.ModeSetgid != 0 {
+		header.Mode |= cISGID
+	}
+	if node.Mode&os.ModeSticky != 0 {
+		header.Mode |= cISVTX
+	}
+
+	var content []byte
+	switch {
+	case IsFile(node):
+		header.Mode |= cpioTypeReg
+		if node.Links > 1 && node.Links <= math.MaxUint32 {
+			header.Links = uint32(node.Links)
+			if d.hardlinks.Has(node.Inode, node.DeviceID) {
+				// the content is stored with the first link
+				header.Inode = inodes[d.hardlinks.Value(node.Inode, node.DeviceID)]
+				header.Size = 0
+			} else {
+				d.hardlinks.Add(node.Inode, node.DeviceID, header.Name)
+			}
+		}
+	case IsLink(node):
+		header.Mode |= cpioTypeSymlink
+		content = []byte(node.LinkTarget)
+		header.Size = int64(len(content))
+	case IsDir(node):
+		header.Mode |= cpioTypeDir
+		header.Links = 2
+		header.Size = 0
+	case node.Type == "dev":
+		header.Mode |= cpioTypeBlock
+		header.RdevMajor, header.RdevMinor = linuxDevice(node.Device)
+		header.Size = 0
+	case node.Type == "chardev":
+		header.Mode |= cpioTypeChar
+		header.RdevMajor, header.RdevMinor = linuxDevice(node.Device)
+		header.Size = 0
+	case node.Type == "fifo":
+		header.Mode |= cpioTypeFifo
+		header.Size = 0
+	case node.Type == "socket":
+		header.Mode |= cpioTypeSocket
+		header.Size = 0
+	default:
+		debug.Log("skipping %v with unsupported type %q", node.Path, node.Type)
+		return nil
+	}
+	inodes[header.Name] = header.Inode
+
+	if err := w.WriteHeader(header); err != nil {
+		return fmt.Errorf("writing header for %q: %w", node.Path, err)
+	}
+
+	switch {
+	case content != nil:
+		_, err = w.Write(content)
+		return errors.Wrap(err, "Write")
+	case IsFile(node) && header.Size > 0:
+		return d.writeNode(ctx, w, node)
+	}
+	return nil
+}
+
+// linuxDevice splits a device number as encoded by Linux into its major and
+// minor number.
+func linuxDevice(dev uint64) (major, minor uint32) {
+	major = uint32((dev>>8)&0xfff | (dev>>32)&0xfffff000)
+	minor = uint32(dev&0xff | (dev>>12)&0xffffff00)
+	return major, minor
+}
+
+// cpioTime clamps a timestamp to the range supported by cpio archives.
+func cpioTime(t int64) uint32 {
+	switch {
+	case t < 0:
+		return 0
+	case t > math.MaxUint32:
+		return math.MaxUint32
+	}
+	return uint32(t)
+}
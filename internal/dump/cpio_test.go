@@ -0,0 +1,162 @@
+package dump
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/chanhpng/vlbe/internal/archiver"
+	"github.com/chanhpng/vlbe/internal/restic"
+	"github.com/chanhpng/vlbe/internal/restorer"
+	rtest "github.com/chanhpng/vlbe/internal/test"
+)
+
+type cpioEntry struct {
+	cpioHeader
+	data []byte
+}
+
+// readCpio returns the entries of a cpio archive in the "newc" format.
+func readCpio(t *testing.T, buf *bytes.Buffer) map[string]cpioEntry {
+	data := buf.Bytes()
+	offset := 0
+	next := func(n int) []byte {
+		if offset+n > len(data) {
+			t.Fatalf("archive truncated at offset %d", offset)
+		}
+		b := data[offset : offset+n]
+		offset += n
+		return b
+	}
+	align := func() {
+		offset += int(cpioPadding(int64(offset)))
+	}
+
+	entries := make(map[string]cpioEntry)
+	for {
+		hdr := next(110)
+		rtest.Equals(t, "070701", string(hdr[:6]))
+		fields := make([]uint32, 13)
+		for i := range fields {
+			v, err := strconv.ParseUint(string(hdr[6+8*i:14+8*i]), 16, 32)
+			rtest.OK(t, err)
+			fields[i] = uint32(v)
+		}
+		name := string(next(int(fields[11]) - 1))
+		rtest.Equals(t, byte(0), next(1)[0])
+		align()
+
+		if name == "TRAILER!!!" {
+			rtest.Equals(t, len(data), offset)
+			return entries
+		}
+
+		entry := cpioEntry{
+			cpioHeader: cpioHeader{
+				Name:    name,
+				Inode:   fields[0],
+				Mode:    fields[1],
+				UID:     fields[2],
+				GID:     fields[3],
+				Links:   fields[4],
+				ModTime: fields[5],
+				Size:    int64(fields[6]),
+
+				RdevMajor: fields[9],
+				RdevMinor: fields[10],
+			},
+		}
+		entry.data = next(int(entry.Size))
+		align()
+		entries[name] = entry
+	}
+}
+
+func TestWriteCpio(t *testing.T) {
+	src := archiver.TestDir{
+		"dir": archiver.TestDir{
+			"file":   archiver.TestFile{Content: "content"},
+			"nested": archiver.TestFile{Content: "nested content"},
+		},
+		"link":    archiver.TestHardlink{Target: "dir/file"},
+		"symlink": archiver.TestSymlink{Target: "dir/file"},
+	}
+
+	buf := dumpTestDir(t, "cpio", src, func(_ *Dumper) {})
+	entries := readCpio(t, buf)
+	rtest.Equals(t, 5, len(entries))
+
+	file := entries["dir/file"]
+	rtest.Equals(t, uint32(cpioTypeReg), file.Mode&^0o7777)
+	rtest.Equals(t, uint32(2), file.Links)
+	rtest.Equals(t, "content", string(file.data))
+
+	// the hard link refers to the first entry without storing the content again
+	link := entries["link"]
+	rtest.Equals(t, file.Inode, link.Inode)
+	rtest.Equals(t, uint32(2), link.Links)
+	rtest.Equals(t, int64(0), link.Size)
+
+	dir := entries["dir"]
+	rtest.Equals(t, uint32(cpioTypeDir), dir.Mode&^0o7777)
+	rtest.Equals(t, "nested content", string(entries["dir/nested"].data))
+
+	symlink := entries["symlink"]
+	rtest.Equals(t, uint32(cpioTypeSymlink), symlink.Mode&^0o7777)
+	rtest.Equals(t, "dir/file", string(symlink.data))
+
+	inodes := make(map[uint32]struct{})
+	for _, entry := range entries {
+		inodes[entry.Inode] = struct{}{}
+	}
+	rtest.Equals(t, 4, len(inodes))
+}
+
+func TestWriteCpioSpecialFiles(t *testing.T) {
+	buf := &bytes.Buffer{}
+	d := New("cpio", nil, buf)
+	w := &cpioWriter{w: buf}
+	d.hardlinks = restorer.NewHardlinkIndex[string]()
+	inodes := make(map[string]uint32)
+	for _, node := range []*restic.Node{
+		{Path: "/null", Type: "chardev", Mode: os.ModeDevice | os.ModeCharDevice | 0o666, Device: 0x103},
+		{Path: "/sda", Type: "dev", Mode: os.ModeDevice | 0o660, Device: 0x800},
+		{Path: "/large", Type: "dev", Mode: os.ModeDevice | 0o660, Device: 0x12310345},
+		{Path: "/fifo", Type: "fifo", Mode: os.ModeNamedPipe | 0o644},
+		{Path: "/socket", Type: "socket", Mode: os.ModeSocket | 0o755},
+	} {
+		rtest.OK(t, d.dumpNodeCpio(context.TODO(), node, w, inodes))
+	}
+	rtest.OK(t, w.Close())
+
+	entries := readCpio(t, buf)
+	for name, test := range map[string]struct {
+		mode         uint32
+		major, minor uint32
+	}{
+		"null":   {cpioTypeChar | 0o666, 1, 3},
+		"sda":    {cpioTypeBlock | 0o660, 8, 0},
+		"large":  {cpioTypeBlock | 0o660, 259, 0x12345},
+		"fifo":   {cpioTypeFifo | 0o644, 0, 0},
+		"socket": {cpioTypeSocket | 0o755, 0, 0},
+	} {
+		entry := entries[name]
+		rtest.Equals(t, test.mode, entry.Mode, name)
+		rtest.Equals(t, test.major, entry.RdevMajor, name)
+		rtest.Equals(t, test.minor, entry.RdevMinor, name)
+		rtest.Equals(t, int64(0), entry.Size, name)
+	}
+}
+
+func TestCpioWriterSize(t *testing.T) {
+	w := &cpioWriter{w: io.Discard}
+	rtest.OK(t, w.WriteHeader(&cpioHeader{Name: "file", Links: 1, Size: 2}))
+	_, err := w.Write([]byte("abc"))
+	rtest.Assert(t, err != nil, "writing too much data did not fail")
+	_, err = w.Write([]byte("a"))
+	rtest.OK(t, err)
+	rtest.Assert(t, w.Close() != nil, "missing data was not detected")
+}
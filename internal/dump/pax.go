@@ -0,0 +1,76 @@
+package dump
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/chanhpng/vlbe/internal/debug"
+	"github.com/chanhpng/vlbe/internal/restic"
+)
+
+// parseXattrs returns the PAX records for the extended attributes and ACLs
+// of a node, which are written to tar archives.
+func parseXattrs(xattrs []restic.ExtendedAttribute) map[string]string {
+	tmpMap := make(map[string]string)
+
+	for _, attr := range xattrs {
+		// Check for Linux POSIX.1e ACLs.
+		//
+		// TODO support ACLs from other operating systems.
+		// FreeBSD ACLs have names "posix1e.acl_(access|default)",
+		// but their binary format may not match the Linux format.
+		aclKey := ""
+		switch attr.Name {
+		case "system.posix_acl_access":
+			aclKey = "SCHILY.acl.access"
+		case "system.posix_acl_default":
+			aclKey = "SCHILY.acl.default"
+		}
+
+		if aclKey != "" {
+			text, err := formatLinuxACL(attr.Value)
+			if err != nil {
+				debug.Log("parsing Linux ACL: %v, skipping", err)
+				continue
+			}
+			tmpMap[aclKey] = text
+		} else {
+			tmpMap["SCHILY.xattr."+attr.Name] = string(attr.Value)
+		}
+	}
+
+	return tmpMap
+}
+
+// formatPAXRecords encodes records as in a PAX extended header, sorted by
+// key.
+func formatPAXRecords(records map[string]string) []byte {
+	keys := make([]string, 0, len(records))
+	for k := range records {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var buf strings.Builder
+	for _, k := range keys {
+		buf.WriteString(formatPAXRecord(k, records[k]))
+	}
+	return []byte(buf.String())
+}
+
+// formatPAXRecord formats a single record as "%d %s=%s\n", where the length
+// includes the length field itself.
+func formatPAXRecord(k, v string) string {
+	const padding = 3 // ' ', '=' and '\n'
+	size := len(k) + len(v) + padding
+	size += len(strconv.Itoa(size))
+	record := strconv.Itoa(size) + " " + k + "=" + v + "\n"
+
+	// the length field may have gained a digit
+	if len(record) != size {
+		size = len(record)
+		record = strconv.Itoa(size) + " " + k + "=" + v + "\n"
+	}
+	return record
+}
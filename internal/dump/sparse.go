@@ -0,0 +1,251 @@
+package dump
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/chanhpng/vlbe/internal/errors"
+	"github.com/chanhpng/vlbe/internal/restic"
+)
+
+const blockSize = 512
+
+// sparseEntry is a fragment of a file.
+type sparseEntry struct {
+	Offset, Length int64
+}
+
+func (s sparseEntry) end() int64 {
+	return s.Offset + s.Length
+}
+
+// sparseHoles returns the all-zero fragments of a file. These are stored as
+// zero chunks in the repository. sparseHoles returns nil if the file has no
+// holes or the sizes of its blobs are unknown.
+func (d *Dumper) sparseHoles(node *restic.Node) []sparseEntry {
+	zeroChunk := d.repo.ZeroChunk()
+
+	var holes []sparseEntry
+	var offset int64
+	for _, id := range node.Content {
+		size, ok := d.repo.LookupBlobSize(restic.DataBlob, id)
+		if !ok {
+			return nil
+		}
+
+		if id == zeroChunk {
+			if n := len(holes); n > 0 && holes[n-1].end() == offset {
+				holes[n-1].Length += int64(size)
+			} else {
+				holes = append(holes, sparseEntry{Offset: offset, Length: int64(size)})
+			}
+		}
+		offset += int64(size)
+	}
+
+	if offset != int64(node.Size) {
+		return nil
+	}
+	return holes
+}
+
+// dataFragments returns the fragments of a file of the given size which are
+// not holes. The last fragment always ends at size, even if it is empty.
+func dataFragments(holes []sparseEntry, size int64) []sparseEntry {
+	var data []sparseEntry
+	var offset int64
+	for _, hole := range holes {
+		if hole.Offset > offset {
+			data = append(data, sparseEntry{Offset: offset, Length: hole.Offset - offset})
+		}
+		offset = hole.end()
+	}
+	return append(data, sparseEntry{Offset: offset, Length: size - offset})
+}
+
+// dumpSparseTar writes a file as a sparse file in the PAX format 1.0 defined
+// by GNU tar. archive/tar can read but not write sparse files, it drops the
+// GNU.sparse records of a header. Therefore the headers and the data are
+// written to d.w directly, which is possible because tw does not buffer any
+// data once it has been flushed. A tarEntryWriter takes over the size
+// accounting of tw for the entry.
+func (d *Dumper) dumpSparseTar(ctx context.Context, node *restic.Node, header *tar.Header, holes []sparseEntry, tw *tar.Writer) error {
+	if err := tw.Flush(); err != nil {
+		return errors.Wrap(err, "Flush")
+	}
+
+	data := dataFragments(holes, header.Size)
+	sparseMap := append(strconv.AppendInt(nil, int64(len(data)), 10), '\n')
+	var dataSize int64
+	for _, s := range data {
+		sparseMap = append(strconv.AppendInt(sparseMap, s.Offset, 10), '\n')
+		sparseMap = append(strconv.AppendInt(sparseMap, s.Length, 10), '\n')
+		dataSize += s.Length
+	}
+	sparseMap = append(sparseMap, make([]byte, blockPadding(int64(len(sparseMap))))...)
+	size := int64(len(sparseMap)) + dataSize
+
+	dir, file := path.Split(header.Name)
+	name := path.Join(dir, "GNUSparseFile.0", file)
+	mtime := header.ModTime.Round(time.Second).Unix()
+
+	records := make(map[string]string, len(header.PAXRecords)+4)
+	for k, v := range header.PAXRecords {
+		records[k] = v
+	}
+	records["GNU.sparse.major"] = "1"
+	records["GNU.sparse.minor"] = "0"
+	records["GNU.sparse.name"] = header.Name
+	records["GNU.sparse.realsize"] = strconv.FormatInt(header.Size, 10)
+
+	// fields which do not fit into the USTAR header are stored as records,
+	// the name is replaced by GNU.sparse.name
+	if !fitsOctal(size, 12) {
+		records["size"] = strconv.FormatInt(size, 10)
+	}
+	if !fitsOctal(mtime, 12) {
+		records["mtime"] = strconv.FormatInt(mtime, 10)
+	}
+	if !fitsOctal(int64(header.Uid), 8) {
+		records["uid"] = strconv.Itoa(header.Uid)
+	}
+	if !fitsOctal(int64(header.Gid), 8) {
+		records["gid"] = strconv.Itoa(header.Gid)
+	}
+	uname, gname := header.Uname, header.Gname
+	if !fitsString(uname, 32) {
+		records["uname"] = uname
+		uname = ""
+	}
+	if !fitsString(gname, 32) {
+		records["gname"] = gname
+		gname = ""
+	}
+
+	paxData := formatPAXRecords(records)
+	paxName := path.Join(dir, "PaxHeaders.0", file)
+
+	var buf bytes.Buffer
+	buf.Write(ustarHeader(paxName, tar.TypeXHeader, 0o644, 0, 0, int64(len(paxData)), mtime, "", ""))
+	buf.Write(paxData)
+	buf.Write(make([]byte, blockPadding(int64(len(paxData)))))
+	buf.Write(ustarHeader(name, tar.TypeReg, header.Mode, int64(header.Uid), int64(header.Gid), size, mtime, uname, gname))
+	if _, err := d.w.Write(buf.Bytes()); err != nil {
+		return fmt.Errorf("writing header for %q: %w", node.Path, err)
+	}
+
+	ew := &tarEntryWriter{w: d.w, size: size, remaining: size}
+	if _, err := ew.Write(sparseMap); err != nil {
+		return errors.Wrap(err, "Write")
+	}
+
+	// the holes consist of zero chunks, all other blobs contain the data
+	zeroChunk := d.repo.ZeroChunk()
+	dataNode := *node
+	dataNode.Content = nil
+	for _, id := range node.Content {
+		if id != zeroChunk {
+			dataNode.Content = append(dataNode.Content, id)
+		}
+	}
+	if err := d.writeNode(ctx, ew, &dataNode); err != nil {
+		return err
+	}
+	if err := ew.Close(); err != nil {
+		return fmt.Errorf("writing %q: %w", node.Path, err)
+	}
+	return nil
+}
+
+// tarEntryWriter writes the content of a tar entry whose header was written
+// without tar.Writer. It ensures that the content has the size stated in the
+// header and pads it to a full block.
+type tarEntryWriter struct {
+	w io.Writer
+	// size is the size of the entry and remaining the number of bytes not
+	// written yet
+	size, remaining int64
+}
+
+func (ew *tarEntryWriter) Write(p []byte) (int, error) {
+	if int64(len(p)) > ew.remaining {
+		return 0, errors.New("write too long")
+	}
+	n, err := ew.w.Write(p)
+	ew.remaining -= int64(n)
+	return n, err
+}
+
+// Close checks that the entry is complete and writes the padding.
+func (ew *tarEntryWriter) Close() error {
+	if ew.remaining > 0 {
+		return fmt.Errorf("missing %d bytes of content", ew.remaining)
+	}
+	_, err := ew.w.Write(make([]byte, blockPadding(ew.size)))
+	return err
+}
+
+// ustarHeader returns a USTAR header block. Values which do not fit into
+// their fields are replaced by zero and must be stored in PAX records.
+func ustarHeader(name string, typeflag byte, mode, uid, gid, size, mtime int64, uname, gname string) []byte {
+	blk := make([]byte, blockSize)
+	// names which are too long are truncated, the full name is stored in a
+	// PAX record
+	copy(blk[0:100], name)
+	putOctal(blk[100:108], mode)
+	putOctal(blk[108:116], uid)
+	putOctal(blk[116:124], gid)
+	putOctal(blk[124:136], size)
+	putOctal(blk[136:148], mtime)
+	blk[156] = typeflag
+	copy(blk[257:265], "ustar\x0000")
+	copy(blk[265:297], uname)
+	copy(blk[297:329], gname)
+	putOctal(blk[329:337], 0)
+	putOctal(blk[337:345], 0)
+
+	// the checksum is computed with the checksum field set to spaces
+	copy(blk[148:156], "        ")
+	var chksum int64
+	for _, c := range blk {
+		chksum += int64(c)
+	}
+	copy(blk[148:156], fmt.Sprintf("%06o\x00 ", chksum))
+	return blk
+}
+
+// fitsOctal reports whether v can be stored as octal number in a field of n
+// bytes, which is terminated by a NUL byte.
+func fitsOctal(v int64, n int) bool {
+	return v >= 0 && v < 1<<(3*(n-1))
+}
+
+func putOctal(b []byte, v int64) {
+	if !fitsOctal(v, len(b)) {
+		v = 0
+	}
+	copy(b, fmt.Sprintf("%0*o", len(b)-1, v))
+}
+
+// fitsString reports whether s can be stored in a field of n bytes.
+func fitsString(s string, n int) bool {
+	if len(s) > n {
+		return false
+	}
+	for _, c := range []byte(s) {
+		if c >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
+
+func blockPadding(n int64) int64 {
+	return -n & (blockSize - 1)
+}
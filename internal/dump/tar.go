@@ -7,13 +7,14 @@ import (
 	"os"
 	"path/filepath"
 
-	"github.com/chanhpng/vlbe/internal/debug"
 	"github.com/chanhpng/vlbe/internal/errors"
 	"github.com/chanhpng/vlbe/internal/restic"
+	"github.com/chanhpng/vlbe/internal/restorer"
 )
 
 func (d *Dumper) dumpTar(ctx context.Context, ch <-chan *restic.Node) (err error) {
 	w := tar.NewWriter(d.w)
+	d.hardlinks = restorer.NewHardlinkIndex[string]()
 
 	defer func() {
 		if err == nil {
@@ -81,6 +82,22 @@ func (d *Dumper) dumpNodeTar(ctx context.Context, node *restic.Node, w *tar.Writ
 
 	if IsFile(node) {
 		header.Typeflag = tar.TypeReg
+
+		if d.hardlinks != nil && node.Links > 1 {
+			if d.hardlinks.Has(node.Inode, node.DeviceID) {
+				header.Typeflag = tar.TypeLink
+				header.Linkname = d.hardlinks.Value(node.Inode, node.DeviceID)
+				header.Size = 0
+			} else {
+				d.hardlinks.Add(node.Inode, node.DeviceID, header.Name)
+			}
+		}
+
+		if d.Sparse && header.Typeflag == tar.TypeReg {
+			if holes := d.sparseHoles(node); len(holes) > 0 {
+				return d.dumpSparseTar(ctx, node, header, holes, w)
+			}
+		}
 	}
 
 	if IsLink(node) {
@@ -97,37 +114,9 @@ func (d *Dumper) dumpNodeTar(ctx context.Context, node *restic.Node, w *tar.Writ
 	if err != nil {
 		return fmt.Errorf("writing header for %q: %w", node.Path, err)
 	}
-	return d.writeNode(ctx, w, node)
-}
-
-func parseXattrs(xattrs []restic.ExtendedAttribute) map[string]string {
-	tmpMap := make(map[string]string)
-
-	for _, attr := range xattrs {
-		// Check for Linux POSIX.1e ACLs.
-		//
-		// TODO support ACLs from other operating systems.
-		// FreeBSD ACLs have names "posix1e.acl_(access|default)",
-		// but their binary format may not match the Linux format.
-		aclKey := ""
-		switch attr.Name {
-		case "system.posix_acl_access":
-			aclKey = "SCHILY.acl.access"
-		case "system.posix_acl_default":
-			aclKey = "SCHILY.acl.default"
-		}
-
-		if aclKey != "" {
-			text, err := formatLinuxACL(attr.Value)
-			if err != nil {
-				debug.Log("parsing Linux ACL: %v, skipping", err)
-				continue
-			}
-			tmpMap[aclKey] = text
-		} else {
-			tmpMap["SCHILY.xattr."+attr.Name] = string(attr.Value)
-		}
+	if header.Typeflag == tar.TypeLink {
+		// the content is stored with the first link
+		return nil
 	}
-
-	return tmpMap
+	return d.writeNode(ctx, w, node)
 }
@@ -8,14 +8,18 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/chanhpng/vlbe/internal/archiver"
 	"github.com/chanhpng/vlbe/internal/fs"
 	"github.com/chanhpng/vlbe/internal/restic"
 	rtest "github.com/chanhpng/vlbe/internal/test"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
 )
 
 func TestWriteTar(t *testing.T) {
@@ -142,3 +146,194 @@ func TestFieldTooLong(t *testing.T) {
 	rtest.Assert(t, strings.Contains(err.Error(), node.Path),
 		"no filename in %q", err)
 }
+
+func TestWriteTarSparse(t *testing.T) {
+	zeros := strings.Repeat("\x00", 4<<20)
+	content := "start" + zeros + "middle" + zeros
+	src := archiver.TestDir{
+		"sparse": archiver.TestFile{Content: content},
+		"small":  archiver.TestFile{Content: "string"},
+	}
+
+	buf := dumpTestDir(t, "tar", src, func(d *Dumper) {
+		d.Sparse = true
+	})
+	rtest.Assert(t, buf.Len() < len(zeros), "archive with %d bytes is not sparse", buf.Len())
+
+	files := make(map[string]string)
+	tr := tar.NewReader(buf)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		rtest.OK(t, err)
+
+		data, err := io.ReadAll(tr)
+		rtest.OK(t, err)
+		rtest.Equals(t, hdr.Size, int64(len(data)))
+		files[hdr.Name] = string(data)
+	}
+
+	rtest.Equals(t, 2, len(files))
+	rtest.Assert(t, files["sparse"] == content, "wrong content for sparse file")
+	rtest.Equals(t, "string", files["small"])
+}
+
+func TestWriteTarSparseMaps(t *testing.T) {
+	zeros := strings.Repeat("\x00", 4<<20)
+	for _, test := range []struct {
+		name    string
+		content string
+	}{
+		{"hole-at-start", zeros + "end"},
+		{"hole-at-end", "start" + zeros},
+		{"several-holes", "a" + zeros + "b" + zeros + "c" + zeros + "d"},
+		{"adjacent-holes", zeros + zeros + "data"},
+		{"only-hole", zeros + zeros},
+		{"unaligned-data", strings.Repeat("x", 1000) + zeros + strings.Repeat("y", 513)},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			src := archiver.TestDir{
+				"sparse": archiver.TestFile{Content: test.content},
+				"next":   archiver.TestFile{Content: "next file"},
+			}
+			buf := dumpTestDir(t, "tar", src, func(d *Dumper) {
+				d.Sparse = true
+			})
+			archive := buf.Bytes()
+
+			files := make(map[string]string)
+			tr := tar.NewReader(bytes.NewReader(archive))
+			for {
+				hdr, err := tr.Next()
+				if err == io.EOF {
+					break
+				}
+				rtest.OK(t, err)
+
+				data, err := io.ReadAll(tr)
+				rtest.OK(t, err)
+				files[hdr.Name] = string(data)
+			}
+			rtest.Equals(t, 2, len(files))
+			rtest.Assert(t, files["sparse"] == test.content, "wrong content for sparse file")
+			rtest.Equals(t, "next file", files["next"])
+
+			checkGNUTar(t, archive, files)
+		})
+	}
+}
+
+// checkGNUTar extracts archive with GNU tar, if it is installed, and compares
+// the extracted files with files.
+func checkGNUTar(t *testing.T, archive []byte, files map[string]string) {
+	out, err := exec.Command("tar", "--version").Output()
+	if err != nil || !bytes.Contains(out, []byte("GNU tar")) {
+		t.Log("GNU tar not available")
+		return
+	}
+
+	dir := rtest.TempDir(t)
+	cmd := exec.Command("tar", "-xf", "-", "-C", dir)
+	cmd.Stdin = bytes.NewReader(archive)
+	out, err = cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("GNU tar failed: %v\n%s", err, out)
+	}
+
+	for name, content := range files {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		rtest.OK(t, err)
+		rtest.Assert(t, string(data) == content, "GNU tar extracted wrong content for %v", name)
+	}
+}
+
+func TestTarEntryWriter(t *testing.T) {
+	buf := &bytes.Buffer{}
+	ew := &tarEntryWriter{w: buf, size: 3, remaining: 3}
+	_, err := ew.Write([]byte("abcd"))
+	rtest.Assert(t, err != nil, "writing too much data did not fail")
+	_, err = ew.Write([]byte("ab"))
+	rtest.OK(t, err)
+	rtest.Assert(t, ew.Close() != nil, "closing an incomplete entry did not fail")
+	_, err = ew.Write([]byte("c"))
+	rtest.OK(t, err)
+	rtest.OK(t, ew.Close())
+	rtest.Equals(t, blockSize, buf.Len())
+}
+
+func TestWriteTarCompressed(t *testing.T) {
+	for _, test := range []struct {
+		format     string
+		decompress func(r io.Reader) (io.Reader, error)
+	}{
+		{"tar.gz", func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) }},
+		{"tar.zst", func(r io.Reader) (io.Reader, error) { return zstd.NewReader(r) }},
+	} {
+		t.Run(test.format, func(t *testing.T) {
+			WriteTest(t, test.format, func(t *testing.T, testDir string, srcTar *bytes.Buffer) error {
+				r, err := test.decompress(srcTar)
+				if err != nil {
+					return err
+				}
+				buf := &bytes.Buffer{}
+				if _, err := io.Copy(buf, r); err != nil {
+					return err
+				}
+				return checkTar(t, testDir, buf)
+			})
+		})
+	}
+}
+
+func TestWriteTarHardlinks(t *testing.T) {
+	src := archiver.TestDir{
+		"dir": archiver.TestDir{
+			"file": archiver.TestFile{Content: "content"},
+		},
+		"link": archiver.TestHardlink{Target: "dir/file"},
+	}
+
+	buf := dumpTestDir(t, "tar", src, func(_ *Dumper) {})
+
+	headers := make(map[string]*tar.Header)
+	tr := tar.NewReader(buf)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		rtest.OK(t, err)
+		headers[hdr.Name] = hdr
+	}
+
+	rtest.Equals(t, byte(tar.TypeReg), headers["dir/file"].Typeflag)
+	rtest.Equals(t, int64(len("content")), headers["dir/file"].Size)
+	rtest.Equals(t, byte(tar.TypeLink), headers["link"].Typeflag)
+	rtest.Equals(t, "dir/file", headers["link"].Linkname)
+}
+
+// closeRecorder records whether Close was called.
+type closeRecorder struct {
+	io.Writer
+	closed bool
+}
+
+func (w *closeRecorder) Close() error {
+	w.closed = true
+	return nil
+}
+
+func TestDumpCompressedTarError(t *testing.T) {
+	ch := make(chan *restic.Node, 1)
+	// a relative path cannot be written to the archive
+	ch <- &restic.Node{Name: "file", Path: "file", Type: "file"}
+	close(ch)
+
+	cw := &closeRecorder{Writer: io.Discard}
+	d := Dumper{format: "tar.gz", w: io.Discard}
+	err := d.dumpCompressedTar(context.Background(), ch, cw)
+	rtest.Assert(t, err != nil, "writing an invalid node did not fail")
+	rtest.Assert(t, cw.closed, "compressor was not closed")
+}
@@ -0,0 +1,549 @@
+package fs
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/chanhpng/vlbe/internal/debug"
+	"github.com/chanhpng/vlbe/internal/errors"
+	"github.com/chanhpng/vlbe/internal/options"
+)
+
+// SnapshotConfig holds extended options of filesystem snapshots on Linux.
+type SnapshotConfig struct {
+	Provider string `option:"provider" help:"only use the snapshot provider btrfs, lvm or zfs instead of all of them (default: auto)"`
+	LVMSize  string `option:"lvm-size" help:"size of the copy-on-write space of LVM snapshots, either a size or a percentage of the extents, not used for thin volumes (default: 10%ORIGIN)"`
+	MountDir string `option:"mount-dir" help:"directory in which LVM snapshots are mounted (default: temporary directory)"`
+}
+
+func init() {
+	if runtime.GOOS == "linux" {
+		options.Register("fs-snapshot", SnapshotConfig{})
+	}
+}
+
+// NewSnapshotConfig returns a new SnapshotConfig with the default values
+// filled in.
+func NewSnapshotConfig() SnapshotConfig {
+	return SnapshotConfig{
+		Provider: "auto",
+		LVMSize:  "10%ORIGIN",
+	}
+}
+
+// ParseSnapshotConfig parses the extended options of filesystem snapshots to
+// a SnapshotConfig struct.
+func ParseSnapshotConfig(o options.Options) (SnapshotConfig, error) {
+	cfg := NewSnapshotConfig()
+	o = o.Extract("fs-snapshot")
+	if err := o.Apply("fs-snapshot", &cfg); err != nil {
+		return SnapshotConfig{}, err
+	}
+
+	switch cfg.Provider {
+	case "auto", "btrfs", "lvm", "zfs":
+	default:
+		return SnapshotConfig{}, errors.Errorf("invalid snapshot provider %q", cfg.Provider)
+	}
+
+	return cfg, nil
+}
+
+// mountInfo describes a mounted filesystem.
+type mountInfo struct {
+	// Root is the directory within the filesystem which is mounted
+	Root       string
+	MountPoint string
+	FSType     string
+	Source     string
+}
+
+// parseMountInfo parses the mounted filesystems in the format of
+// /proc/self/mountinfo.
+func parseMountInfo(rd io.Reader) ([]mountInfo, error) {
+	var mounts []mountInfo
+
+	sc := bufio.NewScanner(rd)
+	for sc.Scan() {
+		// 36 35 98:0 /mnt1 /mnt2 rw,noatime master:1 - ext3 /dev/root rw,errors=continue
+		fields := strings.Fields(sc.Text())
+		sep := -1
+		for i, field := range fields {
+			if field == "-" {
+				sep = i
+				break
+			}
+		}
+		if sep < 6 || len(fields) < sep+3 {
+			return nil, errors.Errorf("invalid mountinfo line %q", sc.Text())
+		}
+
+		mounts = append(mounts, mountInfo{
+			Root:       unescapeMountPath(fields[3]),
+			MountPoint: unescapeMountPath(fields[4]),
+			FSType:     fields[sep+1],
+			Source:     unescapeMountPath(fields[sep+2]),
+		})
+	}
+
+	return mounts, sc.Err()
+}
+
+// unescapeMountPath replaces the octal escape sequences which are used for
+// whitespace and backslashes in mountinfo.
+func unescapeMountPath(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+
+	var buf strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+4 <= len(s) {
+			if v, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				buf.WriteByte(byte(v))
+				i += 3
+				continue
+			}
+		}
+		buf.WriteByte(s[i])
+	}
+	return buf.String()
+}
+
+// fsSnapshot is a snapshot of a mounted filesystem.
+type fsSnapshot struct {
+	// path is the directory in which the snapshot of the mount point is
+	// accessible
+	path string
+	// cleanup contains the functions which undo the steps of creating the
+	// snapshot
+	cleanup []func() error
+	// live contains the paths below the mount point which are not contained
+	// in the snapshot and are read from the filesystem directly
+	live []string
+}
+
+// remove runs the cleanup functions in reverse order and returns the first
+// error.
+func (s *fsSnapshot) remove() error {
+	var firstErr error
+	for i := len(s.cleanup) - 1; i >= 0; i-- {
+		if err := s.cleanup[i](); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// LocalSnapshot is a wrapper around the local file system which reads all
+// files from snapshots of the filesystems in a transparent way. The snapshot
+// of a filesystem is created when a path on it is accessed for the first time.
+// btrfs, LVM and ZFS snapshots are supported.
+type LocalSnapshot struct {
+	FS
+	cfg        SnapshotConfig
+	msgError   ErrorHandler
+	msgMessage MessageHandler
+
+	mutex  sync.Mutex
+	mounts []mountInfo
+	// snapshots contains the snapshots by mount point, the value is nil if
+	// no snapshot could be created
+	snapshots map[string]*fsSnapshot
+
+	// readMounts returns the mounted filesystems
+	readMounts func() ([]mountInfo, error)
+	// run executes an external command and returns its output
+	run func(name string, args ...string) ([]byte, error)
+}
+
+// statically ensure that LocalSnapshot implements FS and PathMapper.
+var _ FS = &LocalSnapshot{}
+var _ PathMapper = &LocalSnapshot{}
+
+// NewLocalSnapshot creates a new wrapper around the local filesystem which
+// uses filesystem snapshots on Linux.
+func NewLocalSnapshot(msgError ErrorHandler, msgMessage MessageHandler, cfg SnapshotConfig) *LocalSnapshot {
+	return &LocalSnapshot{
+		FS:         Local{},
+		cfg:        cfg,
+		msgError:   msgError,
+		msgMessage: msgMessage,
+		snapshots:  make(map[string]*fsSnapshot),
+		readMounts: func() ([]mountInfo, error) {
+			f, err := os.Open("/proc/self/mountinfo")
+			if err != nil {
+				return nil, err
+			}
+			defer func() {
+				_ = f.Close()
+			}()
+			return parseMountInfo(f)
+		},
+		run: runCommand,
+	}
+}
+
+// runCommand runs the command and returns its output. The error contains
+// the output on stderr.
+func runCommand(name string, args ...string) ([]byte, error) {
+	debug.Log("running %v %v", name, args)
+	var stderr bytes.Buffer
+	cmd := exec.Command(name, args...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, errors.Errorf("%v %v: %v: %s", name, strings.Join(args, " "), err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return out, nil
+}
+
+// DeleteSnapshots deletes all snapshots that were created.
+func (fs *LocalSnapshot) DeleteSnapshots() {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	for mountPoint, snapshot := range fs.snapshots {
+		if snapshot == nil {
+			continue
+		}
+		if err := snapshot.remove(); err != nil {
+			fs.msgError(mountPoint, errors.Errorf("failed to delete snapshot: %s", err))
+		}
+		delete(fs.snapshots, mountPoint)
+	}
+}
+
+// Open wraps the Open method of the underlying file system.
+func (fs *LocalSnapshot) Open(name string) (File, error) {
+	p, err := fs.snapshotPath(name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(p)
+}
+
+// OpenFile wraps the OpenFile method of the underlying file system.
+func (fs *LocalSnapshot) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	p, err := fs.snapshotPath(name)
+	if err != nil {
+		return nil, err
+	}
+	return os.OpenFile(p, flag, perm)
+}
+
+// Stat wraps the Stat method of the underlying file system.
+func (fs *LocalSnapshot) Stat(name string) (os.FileInfo, error) {
+	p, err := fs.snapshotPath(name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Stat(p)
+}
+
+// Lstat wraps the Lstat method of the underlying file system.
+func (fs *LocalSnapshot) Lstat(name string) (os.FileInfo, error) {
+	p, err := fs.snapshotPath(name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Lstat(p)
+}
+
+// MappedPath returns the path of name within the snapshot of the filesystem.
+func (fs *LocalSnapshot) MappedPath(name string) (string, error) {
+	return fs.snapshotPath(name)
+}
+
+// snapshotPath returns the path of name within the snapshot of the
+// filesystem it is stored on. The snapshot is created if it does not exist
+// yet. If no snapshot is available, name is returned.
+func (fs *LocalSnapshot) snapshotPath(name string) (string, error) {
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		return name, nil
+	}
+
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	if fs.mounts == nil {
+		fs.mounts, err = fs.readMounts()
+		if err != nil {
+			fs.msgError(name, errors.Errorf("failed to list mounted filesystems: %s", err))
+			fs.mounts = []mountInfo{}
+		}
+	}
+
+	// use the innermost mount point, later mounts hide earlier ones
+	var mount *mountInfo
+	for i := range fs.mounts {
+		m := &fs.mounts[i]
+		if HasPathPrefix(m.MountPoint, abs) && (mount == nil || len(m.MountPoint) >= len(mount.MountPoint)) {
+			mount = m
+		}
+	}
+	if mount == nil {
+		return name, nil
+	}
+
+	snapshot, ok := fs.snapshots[mount.MountPoint]
+	if !ok {
+		snapshot = fs.createSnapshot(*mount)
+		fs.snapshots[mount.MountPoint] = snapshot
+	}
+	if snapshot == nil {
+		return name, nil
+	}
+
+	for _, p := range snapshot.live {
+		if HasPathPrefix(p, abs) {
+			return name, nil
+		}
+	}
+
+	rel, err := filepath.Rel(mount.MountPoint, abs)
+	if err != nil {
+		return "", errors.Errorf("path %v is not within the mount point %v: %v", abs, mount.MountPoint, err)
+	}
+	return filepath.Join(snapshot.path, rel), nil
+}
+
+// createSnapshot creates a snapshot of the mounted filesystem. It returns nil
+// if the filesystem does not support snapshots or the creation failed.
+func (fs *LocalSnapshot) createSnapshot(mount mountInfo) *fsSnapshot {
+	var provider string
+	switch {
+	case mount.FSType == "btrfs":
+		provider = "btrfs"
+	case mount.FSType == "zfs":
+		provider = "zfs"
+	case strings.HasPrefix(mount.Source, "/dev/mapper/"), strings.HasPrefix(mount.Source, "/dev/dm-"):
+		provider = "lvm"
+	}
+	if provider == "" {
+		fs.msgMessage("no snapshot support for [%s] (%s), reading files directly\n", mount.MountPoint, mount.FSType)
+		return nil
+	}
+	if fs.cfg.Provider != "auto" && fs.cfg.Provider != provider {
+		fs.msgMessage("%s snapshots for [%s] excluded by user\n", provider, mount.MountPoint)
+		return nil
+	}
+
+	var lv logicalVolume
+	if provider == "lvm" {
+		// device mapper is also used for LUKS and multipath devices
+		var err error
+		lv, err = fs.lookupLogicalVolume(mount.Source)
+		if err != nil {
+			debug.Log("%v is not a logical volume: %v", mount.Source, err)
+			fs.msgMessage("no snapshot support for [%s] (%s is not a logical volume), reading files directly\n", mount.MountPoint, mount.Source)
+			return nil
+		}
+	}
+
+	fs.msgMessage("creating %s snapshot for [%s]\n", provider, mount.MountPoint)
+
+	name := "restic-snapshot-" + randomSuffix()
+	var snapshot *fsSnapshot
+	var err error
+	switch provider {
+	case "btrfs":
+		snapshot, err = fs.createBtrfsSnapshot(mount, name)
+	case "zfs":
+		snapshot, err = fs.createZFSSnapshot(mount, name)
+	case "lvm":
+		snapshot, err = fs.createLVMSnapshot(mount, lv, name)
+	}
+	if err != nil {
+		fs.msgError(mount.MountPoint, errors.Errorf("failed to create snapshot for [%s]: %s", mount.MountPoint, err))
+		return nil
+	}
+
+	fs.msgMessage("successfully created snapshot for [%s]\n", mount.MountPoint)
+	return snapshot
+}
+
+func randomSuffix() string {
+	buf := make([]byte, 4)
+	_, err := io.ReadFull(rand.Reader, buf)
+	if err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(buf)
+}
+
+// createBtrfsSnapshot creates a read-only snapshot of the subvolume mounted
+// at the mount point. The snapshot is stored within the subvolume. Nested
+// subvolumes are only empty directories in the snapshot, they are read from
+// the filesystem directly.
+func (fs *LocalSnapshot) createBtrfsSnapshot(mount mountInfo, name string) (*fsSnapshot, error) {
+	nested, err := fs.nestedBtrfsSubvolumes(mount)
+	if err != nil {
+		return nil, err
+	}
+
+	path := filepath.Join(mount.MountPoint, "."+name)
+	if _, err := fs.run("btrfs", "subvolume", "snapshot", "-r", mount.MountPoint, path); err != nil {
+		return nil, err
+	}
+
+	for _, p := range nested {
+		fs.msgMessage("nested subvolume [%s] is not contained in the snapshot, reading files directly\n", p)
+	}
+
+	return &fsSnapshot{
+		path: path,
+		cleanup: []func() error{
+			func() error {
+				_, err := fs.run("btrfs", "subvolume", "delete", path)
+				return err
+			},
+		},
+		live: nested,
+	}, nil
+}
+
+// nestedBtrfsSubvolumes returns the paths of the subvolumes directly below the
+// subvolume mounted at the mount point.
+func (fs *LocalSnapshot) nestedBtrfsSubvolumes(mount mountInfo) ([]string, error) {
+	out, err := fs.run("btrfs", "subvolume", "list", "-o", mount.MountPoint)
+	if err != nil {
+		return nil, err
+	}
+
+	// the paths are relative to the top-level subvolume, the mount point
+	// contains the subvolume mount.Root
+	root := strings.Trim(mount.Root, "/")
+	var paths []string
+	for _, line := range strings.Split(string(out), "\n") {
+		// ID 258 gen 12 top level 256 path @/var/lib/docker
+		_, p, found := strings.Cut(line, " path ")
+		if !found {
+			continue
+		}
+		if root != "" {
+			if !strings.HasPrefix(p, root+"/") {
+				return nil, errors.Errorf("subvolume %v is not within the mounted subvolume %v", p, root)
+			}
+			p = strings.TrimPrefix(p, root+"/")
+		}
+		paths = append(paths, filepath.Join(mount.MountPoint, p))
+	}
+	return paths, nil
+}
+
+// createZFSSnapshot creates a snapshot of the dataset mounted at the mount
+// point. The snapshot is accessible in the .zfs directory of the dataset.
+func (fs *LocalSnapshot) createZFSSnapshot(mount mountInfo, name string) (*fsSnapshot, error) {
+	snapshotName := mount.Source + "@" + name
+	if _, err := fs.run("zfs", "snapshot", snapshotName); err != nil {
+		return nil, err
+	}
+
+	return &fsSnapshot{
+		path: filepath.Join(mount.MountPoint, ".zfs", "snapshot", name),
+		cleanup: []func() error{
+			func() error {
+				_, err := fs.run("zfs", "destroy", snapshotName)
+				return err
+			},
+		},
+	}, nil
+}
+
+// logicalVolume describes an LVM logical volume.
+type logicalVolume struct {
+	VG, LV string
+	// Thin is set for thinly provisioned volumes, whose snapshots do not
+	// need copy-on-write space
+	Thin bool
+}
+
+// lookupLogicalVolume returns the logical volume of the device. An error is
+// returned if the device is not a logical volume.
+func (fs *LocalSnapshot) lookupLogicalVolume(device string) (logicalVolume, error) {
+	out, err := fs.run("lvs", "--noheadings", "-o", "vg_name,lv_name,lv_attr", device)
+	if err != nil {
+		return logicalVolume{}, err
+	}
+	fields := strings.Fields(string(out))
+	if len(fields) != 3 {
+		return logicalVolume{}, errors.Errorf("%v is not a logical volume", device)
+	}
+	// the first character of the attributes is the volume type, "V" is a
+	// thin volume
+	return logicalVolume{VG: fields[0], LV: fields[1], Thin: strings.HasPrefix(fields[2], "V")}, nil
+}
+
+// createLVMSnapshot creates a snapshot of the logical volume mounted at the
+// mount point and mounts the snapshot read-only.
+func (fs *LocalSnapshot) createLVMSnapshot(mount mountInfo, volume logicalVolume, name string) (*fsSnapshot, error) {
+	vg, lv := volume.VG, volume.LV
+	snapshotLV := vg + "/" + lv + "-" + name
+
+	args := []string{"--snapshot", "--name", lv + "-" + name}
+	if volume.Thin {
+		// snapshots of thin volumes are allocated from the thin pool,
+		// lvcreate rejects a size for them. They are skipped on activation
+		// by default.
+		args = append(args, "--setactivationskip", "n")
+	} else if strings.Contains(fs.cfg.LVMSize, "%") {
+		args = append(args, "--extents", fs.cfg.LVMSize)
+	} else {
+		args = append(args, "--size", fs.cfg.LVMSize)
+	}
+	if _, err := fs.run("lvcreate", append(args, vg+"/"+lv)...); err != nil {
+		return nil, err
+	}
+
+	snapshot := &fsSnapshot{
+		cleanup: []func() error{
+			func() error {
+				_, err := fs.run("lvremove", "--force", snapshotLV)
+				return err
+			},
+		},
+	}
+	removeOnError := func(err error) (*fsSnapshot, error) {
+		if cerr := snapshot.remove(); cerr != nil {
+			debug.Log("cleanup failed: %v", cerr)
+		}
+		return nil, err
+	}
+
+	dir, err := os.MkdirTemp(fs.cfg.MountDir, "restic-snapshot-")
+	if err != nil {
+		return removeOnError(err)
+	}
+	snapshot.cleanup = append(snapshot.cleanup, func() error {
+		return os.Remove(dir)
+	})
+
+	mountOptions := "ro"
+	if mount.FSType == "xfs" {
+		// the snapshot has the same UUID as the original filesystem
+		mountOptions += ",nouuid"
+	}
+	if _, err := fs.run("mount", "-t", mount.FSType, "-o", mountOptions, "/dev/"+snapshotLV, dir); err != nil {
+		return removeOnError(err)
+	}
+	snapshot.cleanup = append(snapshot.cleanup, func() error {
+		_, err := fs.run("umount", dir)
+		return err
+	})
+
+	// bind mounts only show a directory of the filesystem
+	snapshot.path = filepath.Join(dir, mount.Root)
+	return snapshot, nil
+}
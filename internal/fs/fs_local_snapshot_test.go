@@ -0,0 +1,255 @@
+package fs
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/chanhpng/vlbe/internal/errors"
+	"github.com/chanhpng/vlbe/internal/options"
+	rtest "github.com/chanhpng/vlbe/internal/test"
+)
+
+func TestParseMountInfo(t *testing.T) {
+	data := `22 1 0:21 / / rw,relatime shared:1 - btrfs /dev/sda2 rw,subvol=/@
+36 22 98:0 /mnt1 /mnt/with\040space rw,noatime master:1 - ext3 /dev/root rw,errors=continue
+40 22 0:40 / /tank/data rw shared:7 - zfs tank/data rw,xattr
+`
+	mounts, err := parseMountInfo(strings.NewReader(data))
+	rtest.OK(t, err)
+	rtest.Equals(t, []mountInfo{
+		{Root: "/", MountPoint: "/", FSType: "btrfs", Source: "/dev/sda2"},
+		{Root: "/mnt1", MountPoint: "/mnt/with space", FSType: "ext3", Source: "/dev/root"},
+		{Root: "/", MountPoint: "/tank/data", FSType: "zfs", Source: "tank/data"},
+	}, mounts)
+
+	_, err = parseMountInfo(strings.NewReader("36 22 98:0 /mnt1\n"))
+	rtest.Assert(t, err != nil, "invalid line was accepted")
+}
+
+func TestParseSnapshotConfig(t *testing.T) {
+	cfg, err := ParseSnapshotConfig(options.Options{})
+	rtest.OK(t, err)
+	rtest.Equals(t, NewSnapshotConfig(), cfg)
+
+	cfg, err = ParseSnapshotConfig(options.Options{"fs-snapshot.provider": "lvm", "fs-snapshot.lvm-size": "1G"})
+	rtest.OK(t, err)
+	rtest.Equals(t, SnapshotConfig{Provider: "lvm", LVMSize: "1G"}, cfg)
+
+	_, err = ParseSnapshotConfig(options.Options{"fs-snapshot.provider": "foo"})
+	rtest.Assert(t, err != nil, "invalid provider was accepted")
+}
+
+// testLocalSnapshot returns a LocalSnapshot for the mounted filesystem, which
+// records the commands run. The commands creating a snapshot store a file
+// with different content in the directory of the snapshot.
+func testLocalSnapshot(t *testing.T, cfg SnapshotConfig, mount mountInfo) (*LocalSnapshot, *[]string) {
+	var commands []string
+	fs := NewLocalSnapshot(func(item string, err error) {
+		t.Errorf("error for %v: %v", item, err)
+	}, func(msg string, args ...interface{}) {
+		t.Logf(msg, args...)
+	}, cfg)
+	fs.readMounts = func() ([]mountInfo, error) {
+		return []mountInfo{{Root: "/", MountPoint: "/", FSType: "ext4", Source: "/dev/sda1"}, mount}, nil
+	}
+	fs.run = func(name string, args ...string) ([]byte, error) {
+		commands = append(commands, name+" "+strings.Join(args, " "))
+
+		// simulate a snapshot which contains different data
+		var dir string
+		switch name {
+		case "btrfs":
+			switch args[1] {
+			case "snapshot":
+				dir = args[4]
+			case "list":
+				return nil, nil
+			}
+		case "zfs":
+			if args[0] == "snapshot" {
+				dir = filepath.Join(mount.MountPoint, ".zfs", "snapshot", strings.Split(args[1], "@")[1])
+			}
+		case "lvs":
+			switch args[len(args)-1] {
+			case "/dev/mapper/vg-home":
+				return []byte("  vg   home   -wi-ao----\n"), nil
+			case "/dev/mapper/vg-thin":
+				return []byte("  vg   thin   Vwi-aotz--\n"), nil
+			}
+			return nil, errors.New("Failed to find logical volume")
+		case "mount":
+			dir = args[len(args)-1]
+		case "umount":
+			rtest.OK(t, os.RemoveAll(filepath.Join(args[0], "data")))
+		}
+		if dir != "" {
+			rtest.OK(t, os.MkdirAll(filepath.Join(dir, "data"), 0o700))
+			rtest.OK(t, os.WriteFile(filepath.Join(dir, "data", "file"), []byte("snapshot"), 0o600))
+		}
+		return nil, nil
+	}
+	return fs, &commands
+}
+
+func readSnapshotFile(t *testing.T, fs FS, name string) string {
+	f, err := fs.Open(name)
+	rtest.OK(t, err)
+	data, err := io.ReadAll(f)
+	rtest.OK(t, err)
+	rtest.OK(t, f.Close())
+	return string(data)
+}
+
+func TestLocalSnapshot(t *testing.T) {
+	for _, test := range []struct {
+		provider string
+		mount    mountInfo
+		// commands which create and remove the snapshot, %s is replaced by
+		// the mount point, %n by the snapshot name and %m by the directory
+		// the snapshot is mounted at
+		create, remove []string
+	}{
+		{
+			mount:  mountInfo{Root: "/@home", FSType: "btrfs", Source: "/dev/sda2"},
+			create: []string{"btrfs subvolume list -o %s", "btrfs subvolume snapshot -r %s %s/.%n"},
+			remove: []string{"btrfs subvolume delete %s/.%n"},
+		},
+		{
+			mount:  mountInfo{Root: "/", FSType: "zfs", Source: "tank/home"},
+			create: []string{"zfs snapshot tank/home@%n"},
+			remove: []string{"zfs destroy tank/home@%n"},
+		},
+		{
+			provider: "lvm",
+			mount:    mountInfo{Root: "/", FSType: "xfs", Source: "/dev/mapper/vg-home"},
+			create: []string{
+				"lvs --noheadings -o vg_name,lv_name,lv_attr /dev/mapper/vg-home",
+				"lvcreate --snapshot --name home-%n --extents 10%ORIGIN vg/home",
+				"mount -t xfs -o ro,nouuid /dev/vg/home-%n %m",
+			},
+			remove: []string{"umount %m", "lvremove --force vg/home-%n"},
+		},
+		{
+			provider: "lvm",
+			mount:    mountInfo{Root: "/", FSType: "ext4", Source: "/dev/mapper/vg-thin"},
+			create: []string{
+				"lvs --noheadings -o vg_name,lv_name,lv_attr /dev/mapper/vg-thin",
+				"lvcreate --snapshot --name thin-%n --setactivationskip n vg/thin",
+				"mount -t ext4 -o ro /dev/vg/thin-%n %m",
+			},
+			remove: []string{"umount %m", "lvremove --force vg/thin-%n"},
+		},
+	} {
+		t.Run(test.mount.FSType+test.mount.Source, func(t *testing.T) {
+			mnt := rtest.TempDir(t)
+			test.mount.MountPoint = mnt
+			rtest.OK(t, os.Mkdir(filepath.Join(mnt, "data"), 0o700))
+			rtest.OK(t, os.WriteFile(filepath.Join(mnt, "data", "file"), []byte("original"), 0o600))
+
+			cfg := NewSnapshotConfig()
+			cfg.MountDir = rtest.TempDir(t)
+			if test.provider != "" {
+				cfg.Provider = test.provider
+			}
+			fs, commands := testLocalSnapshot(t, cfg, test.mount)
+
+			rtest.Equals(t, "snapshot", readSnapshotFile(t, fs, filepath.Join(mnt, "data", "file")))
+			fi, err := fs.Lstat(filepath.Join(mnt, "data"))
+			rtest.OK(t, err)
+			rtest.Assert(t, fi.IsDir(), "data is not a directory")
+			// paths on other filesystems are not changed
+			p, err := fs.MappedPath("/dev/null")
+			rtest.OK(t, err)
+			rtest.Equals(t, "/dev/null", p)
+			// the metadata is read from the snapshot
+			p, err = fs.MappedPath(filepath.Join(mnt, "data", "file"))
+			rtest.OK(t, err)
+			rtest.Assert(t, strings.Contains(p, "restic-snapshot-"), "path %v is not within the snapshot", p)
+
+			// the snapshot is only created once
+			rtest.Equals(t, len(test.create), len(*commands))
+			fs.DeleteSnapshots()
+
+			name := ""
+			for _, part := range strings.Fields((*commands)[len(test.create)-1]) {
+				if i := strings.Index(part, "restic-snapshot-"); i >= 0 {
+					name = strings.Split(part[i:], "/")[0]
+					break
+				}
+			}
+			if entries, _ := os.ReadDir(cfg.MountDir); len(entries) > 0 {
+				t.Errorf("mount directory %v was not removed", entries[0].Name())
+			}
+			mountDir := ""
+			if test.provider == "lvm" {
+				last := strings.Fields((*commands)[len(test.create)-1])
+				mountDir = last[len(last)-1]
+			}
+
+			var expected []string
+			for _, cmd := range append(test.create, test.remove...) {
+				cmd = strings.ReplaceAll(cmd, "%s", mnt)
+				cmd = strings.ReplaceAll(cmd, "%n", name)
+				cmd = strings.ReplaceAll(cmd, "%m", mountDir)
+				expected = append(expected, cmd)
+			}
+			rtest.Equals(t, expected, *commands)
+		})
+	}
+}
+
+func TestLocalSnapshotUnsupported(t *testing.T) {
+	mnt := rtest.TempDir(t)
+	fs, commands := testLocalSnapshot(t, NewSnapshotConfig(), mountInfo{Root: "/", MountPoint: mnt, FSType: "ext4", Source: "/dev/sdb1"})
+
+	name := filepath.Join(mnt, "file")
+	rtest.OK(t, os.WriteFile(name, []byte("original"), 0o600))
+	rtest.Equals(t, "original", readSnapshotFile(t, fs, name))
+	rtest.Equals(t, 0, len(*commands))
+	fs.DeleteSnapshots()
+}
+
+func TestLocalSnapshotNoLogicalVolume(t *testing.T) {
+	// device mapper devices which are not logical volumes, e.g. LUKS, are
+	// read directly without reporting an error
+	mnt := rtest.TempDir(t)
+	fs, commands := testLocalSnapshot(t, NewSnapshotConfig(), mountInfo{Root: "/", MountPoint: mnt, FSType: "ext4", Source: "/dev/mapper/luks-1234"})
+
+	name := filepath.Join(mnt, "file")
+	rtest.OK(t, os.WriteFile(name, []byte("original"), 0o600))
+	rtest.Equals(t, "original", readSnapshotFile(t, fs, name))
+	rtest.Equals(t, []string{"lvs --noheadings -o vg_name,lv_name,lv_attr /dev/mapper/luks-1234"}, *commands)
+	fs.DeleteSnapshots()
+}
+
+func TestLocalSnapshotBtrfsNested(t *testing.T) {
+	mnt := rtest.TempDir(t)
+	rtest.OK(t, os.MkdirAll(filepath.Join(mnt, "nested", "sub"), 0o700))
+	rtest.OK(t, os.WriteFile(filepath.Join(mnt, "nested", "sub", "file"), []byte("live"), 0o600))
+
+	fs, commands := testLocalSnapshot(t, NewSnapshotConfig(), mountInfo{Root: "/@home", MountPoint: mnt, FSType: "btrfs", Source: "/dev/sda2"})
+	run := fs.run
+	fs.run = func(name string, args ...string) ([]byte, error) {
+		if name == "btrfs" && args[1] == "list" {
+			*commands = append(*commands, name+" "+strings.Join(args, " "))
+			return []byte("ID 300 gen 12 top level 256 path @home/nested/sub\n"), nil
+		}
+		return run(name, args...)
+	}
+
+	// nested subvolumes are read from the filesystem directly
+	rtest.Equals(t, "snapshot", readSnapshotFile(t, fs, filepath.Join(mnt, "data", "file")))
+	rtest.Equals(t, "live", readSnapshotFile(t, fs, filepath.Join(mnt, "nested", "sub", "file")))
+	p, err := fs.MappedPath(filepath.Join(mnt, "nested", "sub"))
+	rtest.OK(t, err)
+	rtest.Equals(t, filepath.Join(mnt, "nested", "sub"), p)
+	p, err = fs.MappedPath(filepath.Join(mnt, "nested"))
+	rtest.OK(t, err)
+	rtest.Assert(t, strings.Contains(p, "restic-snapshot-"), "path %v is not within the snapshot", p)
+
+	rtest.Equals(t, 2, len(*commands))
+	fs.DeleteSnapshots()
+}
@@ -145,6 +145,11 @@ func (fs *LocalVss) Lstat(name string) (os.FileInfo, error) {
 	return os.Lstat(fs.snapshotPath(name))
 }
 
+// MappedPath returns the path of name within the VSS snapshot.
+func (fs *LocalVss) MappedPath(name string) (string, error) {
+	return fs.snapshotPath(name), nil
+}
+
 // isMountPointIncluded  is true if given mountpoint included by user.
 func (fs *LocalVss) isMountPointIncluded(mountPoint string) bool {
 	if fs.excludeVolumes == nil {
@@ -0,0 +1,170 @@
+package fs
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/chanhpng/vlbe/internal/errors"
+)
+
+// ReaderDir is a file system which adds a directory with one file per reader
+// to another file system. When a file is opened for reading, its reader is
+// passed through. Each file can be opened once, all subsequent open calls
+// return syscall.EIO. All other paths are passed to the underlying file
+// system.
+type ReaderDir struct {
+	FS
+
+	// Path is the absolute path of the directory.
+	Path string
+	// Files maps the names of the files within Dir to their readers.
+	Files map[string]io.ReadCloser
+
+	// for FileInfo
+	ModTime time.Time
+
+	m      sync.Mutex
+	opened map[string]bool
+}
+
+// statically ensure that ReaderDir implements FS.
+var _ FS = &ReaderDir{}
+
+// lookup returns whether name is the directory or the name of a file within
+// the directory.
+func (d *ReaderDir) lookup(name string) (isDir bool, file string) {
+	name = d.FS.Clean(name)
+	dir := d.FS.Clean(d.Path)
+	if name == dir {
+		return true, ""
+	}
+	if d.FS.Dir(name) == dir {
+		if _, ok := d.Files[d.FS.Base(name)]; ok {
+			return false, d.FS.Base(name)
+		}
+	}
+	return false, ""
+}
+
+func (d *ReaderDir) dirInfo() os.FileInfo {
+	return fakeFileInfo{
+		name:    d.FS.Base(d.Path),
+		mode:    os.ModeDir | 0755,
+		modtime: d.ModTime,
+	}
+}
+
+func (d *ReaderDir) fileInfo(file string) os.FileInfo {
+	return fakeFileInfo{
+		name:    file,
+		mode:    0644,
+		modtime: d.ModTime,
+	}
+}
+
+// Open opens a file for reading.
+func (d *ReaderDir) Open(name string) (File, error) {
+	return d.OpenFile(name, O_RDONLY, 0)
+}
+
+// OpenFile opens the directory, a file within it or a file of the underlying
+// file system. The directory and its files can only be opened for reading.
+func (d *ReaderDir) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	isDir, file := d.lookup(name)
+	if !isDir && file == "" {
+		return d.FS.OpenFile(name, flag, perm)
+	}
+
+	if flag & ^(O_RDONLY|O_NOFOLLOW) != 0 {
+		return nil, pathError("open", name,
+			fmt.Errorf("invalid combination of flags 0x%x", flag))
+	}
+
+	if isDir {
+		entries := make([]os.FileInfo, 0, len(d.Files))
+		for file := range d.Files {
+			entries = append(entries, d.fileInfo(file))
+		}
+		sort.Slice(entries, func(i, j int) bool {
+			return entries[i].Name() < entries[j].Name()
+		})
+		return fakeDir{
+			entries:  entries,
+			fakeFile: fakeFile{name: name, FileInfo: d.dirInfo()},
+		}, nil
+	}
+
+	d.m.Lock()
+	defer d.m.Unlock()
+	if d.opened[file] {
+		return nil, pathError("open", name, syscall.EIO)
+	}
+	if d.opened == nil {
+		d.opened = make(map[string]bool)
+	}
+	d.opened[file] = true
+
+	return newReaderFile(d.Files[file], d.fileInfo(file), false), nil
+}
+
+// Stat returns a FileInfo describing the named file.
+func (d *ReaderDir) Stat(name string) (os.FileInfo, error) {
+	isDir, file := d.lookup(name)
+	if !isDir && file == "" {
+		return d.FS.Stat(name)
+	}
+	return d.Lstat(name)
+}
+
+// Lstat returns the FileInfo structure describing the named file.
+func (d *ReaderDir) Lstat(name string) (os.FileInfo, error) {
+	isDir, file := d.lookup(name)
+	switch {
+	case isDir:
+		return d.dirInfo(), nil
+	case file != "":
+		return d.fileInfo(file), nil
+	}
+	return d.FS.Lstat(name)
+}
+
+// MappedPath returns the path from which the underlying file system reads
+// name. The directory and its files are not mapped.
+func (d *ReaderDir) MappedPath(name string) (string, error) {
+	isDir, file := d.lookup(name)
+	if isDir || file != "" {
+		return name, nil
+	}
+	if m, ok := d.FS.(PathMapper); ok {
+		return m.MappedPath(name)
+	}
+	return name, nil
+}
+
+// Close closes the readers of all files which have not been opened and
+// returns the first error.
+func (d *ReaderDir) Close() error {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	var firstErr error
+	for file, rd := range d.Files {
+		if d.opened[file] {
+			continue
+		}
+		if d.opened == nil {
+			d.opened = make(map[string]bool)
+		}
+		d.opened[file] = true
+
+		if err := rd.Close(); err != nil && firstErr == nil {
+			firstErr = errors.Wrapf(err, "close %v", file)
+		}
+	}
+	return firstErr
+}
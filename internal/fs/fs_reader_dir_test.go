@@ -0,0 +1,75 @@
+package fs
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	rtest "github.com/chanhpng/vlbe/internal/test"
+)
+
+type closeRecorder struct {
+	io.Reader
+	closed bool
+}
+
+func (c *closeRecorder) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestReaderDir(t *testing.T) {
+	tempdir := rtest.TempDir(t)
+	rtest.OK(t, os.WriteFile(filepath.Join(tempdir, "file"), []byte("local"), 0o600))
+
+	first := &closeRecorder{Reader: bytes.NewReader([]byte("first"))}
+	second := &closeRecorder{Reader: bytes.NewReader([]byte("second"))}
+	dir := filepath.Join(tempdir, "stdin")
+	fs := &ReaderDir{
+		FS:      Local{},
+		Path:    dir,
+		Files:   map[string]io.ReadCloser{"first": first, "second": second},
+		ModTime: time.Now(),
+	}
+
+	fi, err := fs.Lstat(dir)
+	rtest.OK(t, err)
+	rtest.Assert(t, fi.IsDir(), "%v is not a directory", dir)
+	names, err := Readdirnames(fs, dir, O_NOFOLLOW)
+	rtest.OK(t, err)
+	rtest.Equals(t, []string{"first", "second"}, names)
+
+	fi, err = fs.Lstat(filepath.Join(dir, "first"))
+	rtest.OK(t, err)
+	rtest.Assert(t, fi.Mode().IsRegular(), "first is not a regular file")
+	_, err = fs.Lstat(filepath.Join(dir, "missing"))
+	rtest.Assert(t, errors.Is(err, os.ErrNotExist), "unexpected error %v", err)
+
+	// other paths are passed through
+	f, err := fs.Open(filepath.Join(tempdir, "file"))
+	rtest.OK(t, err)
+	data, err := io.ReadAll(f)
+	rtest.OK(t, err)
+	rtest.OK(t, f.Close())
+	rtest.Equals(t, "local", string(data))
+
+	// files can only be opened once
+	f, err = fs.OpenFile(filepath.Join(dir, "first"), O_RDONLY|O_NOFOLLOW, 0)
+	rtest.OK(t, err)
+	data, err = io.ReadAll(f)
+	rtest.OK(t, err)
+	rtest.OK(t, f.Close())
+	rtest.Equals(t, "first", string(data))
+	_, err = fs.Open(filepath.Join(dir, "first"))
+	rtest.Assert(t, errors.Is(err, syscall.EIO), "unexpected error %v", err)
+
+	// only the files which were not opened are closed
+	first.closed = false
+	rtest.OK(t, fs.Close())
+	rtest.Assert(t, !first.closed && second.closed, "wrong files were closed")
+}
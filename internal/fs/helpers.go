@@ -11,3 +11,13 @@ func IsRegularFile(fi os.FileInfo) bool {
 
 	return fi.Mode()&os.ModeType == 0
 }
+
+// IsBlockDevice returns true if fi belongs to a block device. If fi is nil,
+// false is returned.
+func IsBlockDevice(fi os.FileInfo) bool {
+	if fi == nil {
+		return false
+	}
+
+	return fi.Mode()&os.ModeDevice != 0 && fi.Mode()&os.ModeCharDevice == 0
+}
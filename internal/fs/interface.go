@@ -23,6 +23,13 @@ type FS interface {
 	Base(path string) string
 }
 
+// PathMapper is implemented by file systems which read a file from another
+// path than the one it is accessed with, e.g. from a filesystem snapshot.
+// MappedPath returns the path from which name is read.
+type PathMapper interface {
+	MappedPath(name string) (string, error)
+}
+
 // File is an open file on a file system.
 type File interface {
 	io.Reader
@@ -0,0 +1,11 @@
+//go:build !linux && !darwin && !freebsd
+// +build !linux,!darwin,!freebsd
+
+package fs
+
+import "github.com/chanhpng/vlbe/internal/errors"
+
+// SeekData is not supported on this platform.
+func SeekData(_ File, _ int64) (data, hole int64, err error) {
+	return 0, 0, errors.New("finding holes is not supported on this platform")
+}
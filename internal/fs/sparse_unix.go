@@ -0,0 +1,36 @@
+//go:build linux || darwin || freebsd
+// +build linux darwin freebsd
+
+package fs
+
+import (
+	"io"
+	"syscall"
+
+	"github.com/chanhpng/vlbe/internal/errors"
+	"golang.org/x/sys/unix"
+)
+
+// SeekData returns the start of the first data region at or after offset in
+// f and the start of the hole following it. If there is no more data after
+// offset, both are set to the size of the file. The file offset is set to the
+// start of the data region. An error is returned if the file system does not
+// support finding holes.
+func SeekData(f File, offset int64) (data, hole int64, err error) {
+	data, err = f.Seek(offset, unix.SEEK_DATA)
+	if errors.Is(err, syscall.ENXIO) {
+		// no data after offset, the remaining file is a hole
+		size, err := f.Seek(0, io.SeekEnd)
+		return size, size, err
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+
+	hole, err = f.Seek(data, unix.SEEK_HOLE)
+	if err != nil {
+		return 0, 0, err
+	}
+	_, err = f.Seek(data, io.SeekStart)
+	return data, hole, err
+}
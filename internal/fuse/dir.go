@@ -7,6 +7,7 @@ import (
 	"context"
 	"errors"
 	"os"
+	"path"
 	"path/filepath"
 	"sync"
 	"syscall"
@@ -29,6 +30,11 @@ type dir struct {
 	parentInode uint64
 	node        *restic.Node
 	m           sync.Mutex
+
+	// snapshot contains the directory at path, it is used to look up
+	// changes in the overlay of a writable mount
+	snapshot *restic.Snapshot
+	path     *nodePath
 }
 
 func cleanupNodeName(name string) string {
@@ -77,16 +83,22 @@ func replaceSpecialNodes(ctx context.Context, repo restic.BlobLoader, node *rest
 
 func newDirFromSnapshot(root *Root, inode uint64, snapshot *restic.Snapshot) (*dir, error) {
 	debug.Log("new dir for snapshot %v (%v)", snapshot.ID(), snapshot.Tree)
+	mode := os.FileMode(0555)
+	if root.cfg.Overlay != nil {
+		mode = 0755
+	}
 	return &dir{
 		root: root,
 		node: &restic.Node{
 			AccessTime: snapshot.Time,
 			ModTime:    snapshot.Time,
 			ChangeTime: snapshot.Time,
-			Mode:       os.ModeDir | 0555,
+			Mode:       os.ModeDir | mode,
 			Subtree:    snapshot.Tree,
 		},
-		inode: inode,
+		inode:    inode,
+		snapshot: snapshot,
+		path:     newNodePath("/"),
 	}, nil
 }
 
@@ -100,6 +112,12 @@ func (d *dir) open(ctx context.Context) error {
 
 	debug.Log("open dir %v (%v)", d.node.Name, d.node.Subtree)
 
+	if d.node.Subtree == nil {
+		// directory created in a writable mount
+		d.items = make(map[string]*restic.Node)
+		return nil
+	}
+
 	tree, err := restic.LoadTree(ctx, d.root.repo, *d.node.Subtree)
 	if err != nil {
 		debug.Log("  error loading tree %v: %v", d.node.Subtree, err)
@@ -120,6 +138,32 @@ func (d *dir) open(ctx context.Context) error {
 	return nil
 }
 
+// nodes returns the items of the directory with the changes from the overlay
+// applied.
+func (d *dir) nodes() map[string]*restic.Node {
+	o := d.root.cfg.Overlay
+	if o == nil || d.snapshot == nil {
+		return d.items
+	}
+	changes := o.children(d.snapshot, d.path.get())
+	if len(changes) == 0 {
+		return d.items
+	}
+
+	items := make(map[string]*restic.Node, len(d.items)+len(changes))
+	for name, node := range d.items {
+		items[name] = node
+	}
+	for name, e := range changes {
+		if e.node == nil {
+			delete(items, name)
+		} else {
+			items[name] = e.node
+		}
+	}
+	return items
+}
+
 func (d *dir) Attr(_ context.Context, a *fuse.Attr) error {
 	debug.Log("Attr()")
 	a.Inode = d.inode
@@ -142,7 +186,7 @@ func (d *dir) calcNumberOfLinks() uint32 {
 	// a directory d has 2 hardlinks + the number
 	// of directories contained by d
 	count := uint32(2)
-	for _, node := range d.items {
+	for _, node := range d.nodes() {
 		if node.Type == "dir" {
 			count++
 		}
@@ -156,7 +200,8 @@ func (d *dir) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
 	if err != nil {
 		return nil, err
 	}
-	ret := make([]fuse.Dirent, 0, len(d.items)+2)
+	items := d.nodes()
+	ret := make([]fuse.Dirent, 0, len(items)+2)
 
 	ret = append(ret, fuse.Dirent{
 		Inode: d.inode,
@@ -170,7 +215,7 @@ func (d *dir) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
 		Type:  fuse.DT_Dir,
 	})
 
-	for _, node := range d.items {
+	for _, node := range items {
 		name := cleanupNodeName(node.Name)
 		var typ fuse.DirentType
 		switch node.Type {
@@ -200,17 +245,32 @@ func (d *dir) Lookup(ctx context.Context, name string) (fs.Node, error) {
 		return nil, err
 	}
 
-	node, ok := d.items[name]
+	node, ok := d.nodes()[name]
 	if !ok {
 		debug.Log("  Lookup(%v) -> not found", name)
 		return nil, syscall.ENOENT
 	}
+	return d.kernelNode(name, node)
+}
+
+// newNode returns the fuse node for the item name within d.
+func (d *dir) newNode(name string, node *restic.Node) (fs.Node, error) {
 	inode := inodeFromNode(d.inode, node)
 	switch node.Type {
 	case "dir":
-		return newDir(d.root, inode, d.inode, node)
+		child, err := newDir(d.root, inode, d.inode, node)
+		if err != nil {
+			return nil, err
+		}
+		child.snapshot, child.path = d.snapshot, newNodePath(path.Join(d.path.get(), name))
+		return child, nil
 	case "file":
-		return newFile(d.root, inode, node)
+		child, err := newFile(d.root, inode, node)
+		if err != nil {
+			return nil, err
+		}
+		child.snapshot, child.path = d.snapshot, newNodePath(path.Join(d.path.get(), name))
+		return child, nil
 	case "symlink":
 		return newLink(d.root, inode, node)
 	case "dev", "chardev", "fifo", "socket":
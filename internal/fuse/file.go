@@ -28,6 +28,11 @@ type file struct {
 	root  *Root
 	node  *restic.Node
 	inode uint64
+
+	// snapshot contains the file at path, it is used to look up changes in
+	// the overlay of a writable mount
+	snapshot *restic.Snapshot
+	path     *nodePath
 }
 
 type openFile struct {
@@ -47,28 +52,40 @@ func newFile(root *Root, inode uint64, node *restic.Node) (fusefile *file, err e
 
 func (f *file) Attr(_ context.Context, a *fuse.Attr) error {
 	debug.Log("Attr(%v)", f.node.Name)
+	node, err := f.currentNode()
+	if err != nil {
+		return err
+	}
+
 	a.Inode = f.inode
-	a.Mode = f.node.Mode
-	a.Size = f.node.Size
-	a.Blocks = (f.node.Size + blockSize - 1) / blockSize
+	a.Mode = node.Mode
+	a.Size = node.Size
+	a.Blocks = (node.Size + blockSize - 1) / blockSize
 	a.BlockSize = blockSize
-	a.Nlink = uint32(f.node.Links)
+	a.Nlink = uint32(node.Links)
 
 	if !f.root.cfg.OwnerIsRoot {
-		a.Uid = f.node.UID
-		a.Gid = f.node.GID
+		a.Uid = node.UID
+		a.Gid = node.GID
 	}
-	a.Atime = f.node.AccessTime
-	a.Ctime = f.node.ChangeTime
-	a.Mtime = f.node.ModTime
+	a.Atime = node.AccessTime
+	a.Ctime = node.ChangeTime
+	a.Mtime = node.ModTime
 
 	return nil
 
 }
 
-func (f *file) Open(_ context.Context, _ *fuse.OpenRequest, _ *fuse.OpenResponse) (fs.Handle, error) {
+func (f *file) Open(ctx context.Context, req *fuse.OpenRequest, _ *fuse.OpenResponse) (fs.Handle, error) {
 	debug.Log("open file %v with %d blobs", f.node.Name, len(f.node.Content))
 
+	if f.root.cfg.Overlay != nil && f.snapshot != nil {
+		e := f.root.cfg.Overlay.lookup(f.snapshot, f.path.get())
+		if (e != nil && e.file != "") || !req.Flags.IsReadOnly() {
+			return f.openOverlay(ctx, req.Flags)
+		}
+	}
+
 	var bytes uint64
 	cumsize := make([]uint64, 1+len(f.node.Content))
 	for i, id := range f.node.Content {
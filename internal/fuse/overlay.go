@@ -0,0 +1,521 @@
+//go:build darwin || freebsd || linux
+// +build darwin freebsd linux
+
+package fuse
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+
+	"github.com/chanhpng/vlbe/internal/archiver"
+	"github.com/chanhpng/vlbe/internal/debug"
+	"github.com/chanhpng/vlbe/internal/fs"
+	"github.com/chanhpng/vlbe/internal/restic"
+	"github.com/chanhpng/vlbe/internal/walker"
+)
+
+// Overlay records the changes made to snapshots in a writable mount. The
+// content of new and modified files is stored in a local directory until the
+// changes are committed as new snapshots.
+type Overlay struct {
+	dir string
+
+	m       sync.Mutex
+	changes map[restic.ID]*snapshotChanges
+	files   int
+	// pending contains the files which are currently copied from the
+	// repository, the channel is closed once the copy is finished
+	pending map[pendingFile]chan struct{}
+	// paths contains the paths of the nodes known to the kernel by snapshot,
+	// they are updated when the nodes are renamed
+	paths map[restic.ID]map[*nodePath]struct{}
+}
+
+// pendingFile identifies a file within a snapshot.
+type pendingFile struct {
+	snapshot restic.ID
+	path     string
+}
+
+// nodePath is the path of a node within its snapshot. It is shared by all
+// copies of the node, so that open files follow a rename.
+type nodePath struct {
+	m sync.Mutex
+	p string
+}
+
+func newNodePath(p string) *nodePath {
+	return &nodePath{p: p}
+}
+
+func (np *nodePath) get() string {
+	np.m.Lock()
+	defer np.m.Unlock()
+	return np.p
+}
+
+func (np *nodePath) set(p string) {
+	np.m.Lock()
+	defer np.m.Unlock()
+	np.p = p
+}
+
+// snapshotChanges contains the changes of a snapshot by path within the
+// snapshot.
+type snapshotChanges struct {
+	snapshot *restic.Snapshot
+	entries  map[string]*overlayEntry
+}
+
+// overlayEntry replaces or removes the node at a path.
+type overlayEntry struct {
+	// node is nil if the path was removed
+	node *restic.Node
+	// file contains the content of a regular file if the content was
+	// modified, otherwise the content of node is used.
+	file string
+}
+
+// NewOverlay returns an overlay which stores the content of modified files
+// in dir.
+func NewOverlay(dir string) *Overlay {
+	return &Overlay{
+		dir:     dir,
+		changes: make(map[restic.ID]*snapshotChanges),
+		pending: make(map[pendingFile]chan struct{}),
+		paths:   make(map[restic.ID]map[*nodePath]struct{}),
+	}
+}
+
+// Changed returns true if any snapshot was modified.
+func (o *Overlay) Changed() bool {
+	o.m.Lock()
+	defer o.m.Unlock()
+	return len(o.changes) > 0
+}
+
+func (o *Overlay) snapshotChanges(sn *restic.Snapshot) *snapshotChanges {
+	c, ok := o.changes[*sn.ID()]
+	if !ok {
+		c = &snapshotChanges{snapshot: sn, entries: make(map[string]*overlayEntry)}
+		o.changes[*sn.ID()] = c
+	}
+	return c
+}
+
+// track records the path of a node known to the kernel, so that it is updated
+// when the node or one of its parents is renamed.
+func (o *Overlay) track(sn *restic.Snapshot, np *nodePath) {
+	o.m.Lock()
+	defer o.m.Unlock()
+
+	paths, ok := o.paths[*sn.ID()]
+	if !ok {
+		paths = make(map[*nodePath]struct{})
+		o.paths[*sn.ID()] = paths
+	}
+	paths[np] = struct{}{}
+}
+
+// untrack removes the path of a node which the kernel has forgotten.
+func (o *Overlay) untrack(sn *restic.Snapshot, np *nodePath) {
+	o.m.Lock()
+	defer o.m.Unlock()
+
+	paths := o.paths[*sn.ID()]
+	delete(paths, np)
+	if len(paths) == 0 {
+		delete(o.paths, *sn.ID())
+	}
+}
+
+// lookup returns a copy of the entry for p, or nil if p was not changed.
+func (o *Overlay) lookup(sn *restic.Snapshot, p string) *overlayEntry {
+	o.m.Lock()
+	defer o.m.Unlock()
+
+	c, ok := o.changes[*sn.ID()]
+	if !ok {
+		return nil
+	}
+	e, ok := c.entries[p]
+	if !ok {
+		return nil
+	}
+	return e.copy()
+}
+
+func (e *overlayEntry) copy() *overlayEntry {
+	res := *e
+	if e.node != nil {
+		n := *e.node
+		res.node = &n
+	}
+	return &res
+}
+
+// children returns copies of the entries directly within the directory dir.
+func (o *Overlay) children(sn *restic.Snapshot, dir string) map[string]*overlayEntry {
+	o.m.Lock()
+	defer o.m.Unlock()
+
+	c, ok := o.changes[*sn.ID()]
+	if !ok {
+		return nil
+	}
+	result := c.children(dir)
+	for name, e := range result {
+		result[name] = e.copy()
+	}
+	return result
+}
+
+func (c *snapshotChanges) children(dir string) map[string]*overlayEntry {
+	var result map[string]*overlayEntry
+	for p, e := range c.entries {
+		if p != "/" && path.Dir(p) == dir {
+			if result == nil {
+				result = make(map[string]*overlayEntry)
+			}
+			result[path.Base(p)] = e
+		}
+	}
+	return result
+}
+
+// removeBelow removes the entries for p and all paths within p.
+func (c *snapshotChanges) removeBelow(p string) {
+	for ep, e := range c.entries {
+		if ep == p || strings.HasPrefix(ep, p+"/") {
+			if e.file != "" {
+				_ = os.Remove(e.file)
+			}
+			delete(c.entries, ep)
+		}
+	}
+}
+
+// create adds a new node at p. The content of regular files is stored in a
+// new local file, which is returned opened for reading and writing.
+func (o *Overlay) create(sn *restic.Snapshot, p string, node *restic.Node) (*os.File, error) {
+	o.m.Lock()
+	defer o.m.Unlock()
+
+	var f *os.File
+	entry := &overlayEntry{node: node}
+	if node.Type == "file" {
+		var err error
+		f, err = o.newFile()
+		if err != nil {
+			return nil, err
+		}
+		entry.file = f.Name()
+	}
+
+	c := o.snapshotChanges(sn)
+	c.removeBelow(p)
+	c.entries[p] = entry
+	return f, nil
+}
+
+func (o *Overlay) newFile() (*os.File, error) {
+	o.files++
+	return os.OpenFile(filepath.Join(o.dir, fmt.Sprintf("%08d", o.files)), os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+}
+
+// modifyFile returns the local file containing the content of the regular
+// file at p. If it does not exist yet, it is created and the current content
+// is copied to it by calling read, unless truncate is set. The content is
+// copied without holding the lock, concurrent calls for the same file wait
+// for the copy to finish.
+func (o *Overlay) modifyFile(ctx context.Context, sn *restic.Snapshot, p string, node *restic.Node, truncate bool,
+	read func(ctx context.Context, wr io.Writer) error) (string, error) {
+
+	key := pendingFile{snapshot: *sn.ID(), path: p}
+	var done chan struct{}
+	var f *os.File
+	for done == nil {
+		o.m.Lock()
+		entry := o.snapshotChanges(sn).entries[p]
+		if entry != nil && entry.file != "" {
+			var err error
+			if truncate {
+				err = os.Truncate(entry.file, 0)
+			}
+			o.m.Unlock()
+			return entry.file, err
+		}
+
+		if wait, ok := o.pending[key]; ok {
+			o.m.Unlock()
+			select {
+			case <-wait:
+				continue
+			case <-ctx.Done():
+				return "", ctx.Err()
+			}
+		}
+
+		var err error
+		f, err = o.newFile()
+		if err != nil {
+			o.m.Unlock()
+			return "", err
+		}
+		done = make(chan struct{})
+		o.pending[key] = done
+		o.m.Unlock()
+	}
+
+	debug.Log("copy-on-write for %v in snapshot %v", p, sn.ID().Str())
+	var err error
+	if !truncate {
+		err = read(ctx, f)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	o.m.Lock()
+	defer o.m.Unlock()
+	delete(o.pending, key)
+	close(done)
+
+	c := o.snapshotChanges(sn)
+	entry := c.entries[p]
+	if err == nil && entry != nil && entry.node == nil {
+		// the file was removed in the meantime
+		err = syscall.ENOENT
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+		return "", err
+	}
+
+	if entry == nil {
+		n := *node
+		entry = &overlayEntry{node: &n}
+	}
+	entry.file = f.Name()
+	c.entries[p] = entry
+	return entry.file, nil
+}
+
+// modifyNode calls fn to modify the metadata of the node at p.
+func (o *Overlay) modifyNode(sn *restic.Snapshot, p string, node *restic.Node, fn func(node *restic.Node, file string) error) error {
+	o.m.Lock()
+	defer o.m.Unlock()
+
+	c := o.snapshotChanges(sn)
+	entry := c.entries[p]
+	if entry == nil {
+		n := *node
+		entry = &overlayEntry{node: &n}
+	}
+	if err := fn(entry.node, entry.file); err != nil {
+		return err
+	}
+	c.entries[p] = entry
+	return nil
+}
+
+// remove removes the node at p.
+func (o *Overlay) remove(sn *restic.Snapshot, p string) {
+	o.m.Lock()
+	defer o.m.Unlock()
+
+	c := o.snapshotChanges(sn)
+	c.removeBelow(p)
+	c.entries[p] = &overlayEntry{}
+}
+
+// rename moves node from oldpath to newpath, including all changes within
+// oldpath if node is a directory.
+func (o *Overlay) rename(sn *restic.Snapshot, oldpath, newpath string, node *restic.Node) {
+	o.m.Lock()
+	defer o.m.Unlock()
+
+	c := o.snapshotChanges(sn)
+	moved := c.entries[oldpath]
+	if moved == nil {
+		moved = &overlayEntry{}
+	}
+	n := *node
+	n.Name = path.Base(newpath)
+	moved = &overlayEntry{node: &n, file: moved.file}
+
+	c.removeBelow(newpath)
+	for p, e := range c.entries {
+		if strings.HasPrefix(p, oldpath+"/") {
+			c.entries[newpath+strings.TrimPrefix(p, oldpath)] = e
+			delete(c.entries, p)
+		}
+	}
+	c.entries[newpath] = moved
+	c.entries[oldpath] = &overlayEntry{}
+
+	for np := range o.paths[*sn.ID()] {
+		p := np.get()
+		if p == oldpath || strings.HasPrefix(p, oldpath+"/") {
+			np.set(newpath + strings.TrimPrefix(p, oldpath))
+		}
+	}
+}
+
+// WriteManifest writes the file manifest.txt to the overlay directory, which
+// lists the snapshot and path of each modified file stored in the directory.
+// It returns the name of the manifest.
+func (o *Overlay) WriteManifest() (string, error) {
+	o.m.Lock()
+	defer o.m.Unlock()
+
+	var lines []string
+	for id, c := range o.changes {
+		for p, e := range c.entries {
+			if e.node != nil && e.file != "" {
+				lines = append(lines, fmt.Sprintf("%v\t%v\t%v\n", filepath.Base(e.file), id.Str(), p))
+			}
+		}
+	}
+	sort.Strings(lines)
+
+	name := filepath.Join(o.dir, "manifest.txt")
+	buf := "# file\tsnapshot\tpath\n" + strings.Join(lines, "")
+	return name, os.WriteFile(name, []byte(buf), 0600)
+}
+
+// Commit saves the changes of each modified snapshot as a new snapshot, whose
+// parent is the modified snapshot. Only the content of new and modified files
+// is read and saved. Commit returns the IDs of the new snapshots by the IDs of
+// their parents.
+func (o *Overlay) Commit(ctx context.Context, repo restic.Repository, programVersion string) (map[restic.ID]restic.ID, error) {
+	o.m.Lock()
+	defer o.m.Unlock()
+
+	var files []string
+	for _, c := range o.changes {
+		for _, e := range c.entries {
+			if e.node != nil && e.file != "" {
+				files = append(files, e.file)
+			}
+		}
+	}
+
+	arch := archiver.New(repo, fs.Local{}, archiver.Options{})
+	nodes, err := arch.SaveFiles(ctx, files)
+	if err != nil {
+		return nil, err
+	}
+	content := make(map[string]*restic.Node, len(files))
+	for i, file := range files {
+		content[file] = nodes[i]
+	}
+
+	trees := make(map[restic.ID]restic.ID, len(o.changes))
+	wg, wgCtx := errgroup.WithContext(ctx)
+	repo.StartPackUploader(wgCtx, wg)
+	wg.Go(func() error {
+		// new directories start with an empty tree, which must be uploaded
+		// before the rewriter can load it
+		emptyTree, err := restic.SaveTree(wgCtx, repo, restic.NewTree(0))
+		if err != nil {
+			return err
+		}
+		if err := repo.FlushPending(wgCtx); err != nil {
+			return err
+		}
+		for id, c := range o.changes {
+			tree, err := c.rewriter(emptyTree, content).RewriteTree(wgCtx, repo, "/", *c.snapshot.Tree)
+			if err != nil {
+				return err
+			}
+			trees[id] = tree
+		}
+		return repo.Flush(wgCtx)
+	})
+	if err := wg.Wait(); err != nil {
+		return nil, err
+	}
+
+	result := make(map[restic.ID]restic.ID, len(o.changes))
+	for id, c := range o.changes {
+		parent, tree := id, trees[id]
+		sn := &restic.Snapshot{
+			Time:           time.Now(),
+			Parent:         &parent,
+			Tree:           &tree,
+			Paths:          c.snapshot.Paths,
+			Hostname:       c.snapshot.Hostname,
+			Username:       c.snapshot.Username,
+			UID:            c.snapshot.UID,
+			GID:            c.snapshot.GID,
+			Excludes:       c.snapshot.Excludes,
+			Tags:           c.snapshot.Tags,
+			ProgramVersion: programVersion,
+		}
+
+		newID, err := restic.SaveSnapshot(ctx, repo, sn)
+		if err != nil {
+			return nil, err
+		}
+		debug.Log("saved changes of snapshot %v as %v", id.Str(), newID.Str())
+		result[id] = newID
+	}
+
+	o.changes = make(map[restic.ID]*snapshotChanges)
+	return result, nil
+}
+
+// rewriter returns a TreeRewriter which applies the changes to the tree of
+// the snapshot. content contains the nodes for the saved content of modified
+// files.
+func (c *snapshotChanges) rewriter(emptyTree restic.ID, content map[string]*restic.Node) *walker.TreeRewriter {
+	return walker.NewTreeRewriter(walker.RewriteOpts{
+		RewriteNode: func(node *restic.Node, p string) *restic.Node {
+			e, ok := c.entries[p]
+			if !ok {
+				return node
+			}
+			if e.node == nil {
+				return nil
+			}
+
+			n := *e.node
+			if e.file != "" {
+				saved := content[e.file]
+				n.Content = saved.Content
+				n.Size = saved.Size
+				n.ModTime = saved.ModTime
+				n.ChangeTime = saved.ChangeTime
+				// the modified file is no longer a hard link
+				n.Links = 1
+			}
+			if n.Type == "dir" && n.Subtree == nil {
+				n.Subtree = &emptyTree
+			}
+			return &n
+		},
+		AddNodes: func(dir string) []*restic.Node {
+			var nodes []*restic.Node
+			for _, e := range c.children(dir) {
+				if e.node != nil {
+					nodes = append(nodes, e.node)
+				}
+			}
+			return nodes
+		},
+		// the changes depend on the path of a tree, not only on its ID
+		DisableNodeCache: true,
+	})
+}
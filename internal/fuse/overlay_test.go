@@ -0,0 +1,219 @@
+//go:build darwin || freebsd || linux
+// +build darwin freebsd linux
+
+package fuse
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/anacrolix/fuse"
+	"github.com/anacrolix/fuse/fs"
+
+	"github.com/chanhpng/vlbe/internal/archiver"
+	"github.com/chanhpng/vlbe/internal/repository"
+	"github.com/chanhpng/vlbe/internal/restic"
+	rtest "github.com/chanhpng/vlbe/internal/test"
+)
+
+func lookupNode(t testing.TB, d fs.Node, names ...string) fs.Node {
+	t.Helper()
+	for _, name := range names {
+		var err error
+		d, err = d.(fs.NodeStringLookuper).Lookup(context.TODO(), name)
+		rtest.OK(t, err)
+	}
+	return d
+}
+
+func writeHandle(t testing.TB, h fs.Handle, offset int64, data string) {
+	t.Helper()
+	resp := &fuse.WriteResponse{}
+	rtest.OK(t, h.(fs.HandleWriter).Write(context.TODO(), &fuse.WriteRequest{Offset: offset, Data: []byte(data)}, resp))
+	rtest.Equals(t, len(data), resp.Size)
+	rtest.OK(t, h.(fs.HandleReleaser).Release(context.TODO(), &fuse.ReleaseRequest{}))
+}
+
+func TestWritableMount(t *testing.T) {
+	repo := repository.TestRepository(t)
+	tempdir := rtest.TempDir(t)
+	archiver.TestCreateFiles(t, tempdir, archiver.TestDir{
+		"foo": archiver.TestFile{Content: "foo content"},
+		"sub": archiver.TestDir{
+			"bar":    archiver.TestFile{Content: "bar content"},
+			"remove": archiver.TestFile{Content: "removed"},
+		},
+	})
+	back := rtest.Chdir(t, tempdir)
+	archiver.TestSnapshot(t, repo, ".", nil)
+	back()
+	sn := loadFirstSnapshot(t, repo)
+
+	ctx := context.TODO()
+	overlay := NewOverlay(rtest.TempDir(t))
+	root := NewRoot(repo, Config{Overlay: overlay})
+	snapshotDir := lookupNode(t, root, "ids", sn.ID().Str()).(*dir)
+
+	// create a new file and a new directory
+	_, h, err := snapshotDir.Create(ctx, &fuse.CreateRequest{Name: "new", Mode: 0644}, &fuse.CreateResponse{})
+	rtest.OK(t, err)
+	writeHandle(t, h, 0, "new content")
+	newDir, err := snapshotDir.Mkdir(ctx, &fuse.MkdirRequest{Name: "dir", Mode: 0755})
+	rtest.OK(t, err)
+
+	// modify an existing file
+	foo := lookupNode(t, snapshotDir, "foo").(*file)
+	h, err = foo.Open(ctx, &fuse.OpenRequest{Flags: fuse.OpenReadWrite}, &fuse.OpenResponse{})
+	rtest.OK(t, err)
+	writeHandle(t, h, 4, "modified")
+
+	var attr fuse.Attr
+	rtest.OK(t, foo.Attr(ctx, &attr))
+	rtest.Equals(t, uint64(len("foo modified")), attr.Size)
+
+	// remove and rename files
+	sub := lookupNode(t, snapshotDir, "sub").(*dir)
+	rtest.OK(t, sub.Remove(ctx, &fuse.RemoveRequest{Name: "remove"}))
+	rtest.OK(t, sub.Rename(ctx, &fuse.RenameRequest{OldName: "bar", NewName: "moved"}, newDir))
+	err = snapshotDir.Remove(ctx, &fuse.RemoveRequest{Name: "dir", Dir: true})
+	rtest.Assert(t, err != nil, "removing a non-empty directory should fail")
+
+	ids, err := overlay.Commit(ctx, repo, "test")
+	rtest.OK(t, err)
+	rtest.Equals(t, 1, len(ids))
+	rtest.Assert(t, !overlay.Changed(), "overlay still contains changes after commit")
+
+	newSn, err := restic.LoadSnapshot(ctx, repo, ids[*sn.ID()])
+	rtest.OK(t, err)
+	rtest.Equals(t, sn.ID(), newSn.Parent)
+	rtest.Equals(t, sn.Paths, newSn.Paths)
+	archiver.TestEnsureSnapshot(t, repo, ids[*sn.ID()], archiver.TestDir{
+		"foo": archiver.TestFile{Content: "foo modified"},
+		"new": archiver.TestFile{Content: "new content"},
+		"sub": archiver.TestDir{},
+		"dir": archiver.TestDir{
+			"moved": archiver.TestFile{Content: "bar content"},
+		},
+	})
+
+	// the original snapshot is unchanged
+	archiver.TestEnsureSnapshot(t, repo, *sn.ID(), archiver.TestDir{
+		"foo": archiver.TestFile{Content: "foo content"},
+		"sub": archiver.TestDir{
+			"bar":    archiver.TestFile{Content: "bar content"},
+			"remove": archiver.TestFile{Content: "removed"},
+		},
+	})
+}
+
+func TestWritableMountRename(t *testing.T) {
+	repo := repository.TestRepository(t)
+	tempdir := rtest.TempDir(t)
+	archiver.TestCreateFiles(t, tempdir, archiver.TestDir{
+		"sub": archiver.TestDir{
+			"bar": archiver.TestFile{Content: "bar content"},
+		},
+	})
+	back := rtest.Chdir(t, tempdir)
+	archiver.TestSnapshot(t, repo, ".", nil)
+	back()
+	sn := loadFirstSnapshot(t, repo)
+
+	ctx := context.TODO()
+	overlay := NewOverlay(rtest.TempDir(t))
+	root := NewRoot(repo, Config{Overlay: overlay})
+	snapshotDir := lookupNode(t, root, "ids", sn.ID().Str()).(*dir)
+	sub := lookupNode(t, snapshotDir, "sub").(*dir)
+	bar := lookupNode(t, sub, "bar").(*file)
+
+	// open the file before renaming its parent directory
+	h, err := bar.Open(ctx, &fuse.OpenRequest{Flags: fuse.OpenReadWrite}, &fuse.OpenResponse{})
+	rtest.OK(t, err)
+	rtest.OK(t, snapshotDir.Rename(ctx, &fuse.RenameRequest{OldName: "sub", NewName: "moved"}, snapshotDir))
+	writeHandle(t, h, 0, "BAR")
+
+	// the nodes known to the kernel must use the new path
+	h, err = bar.Open(ctx, &fuse.OpenRequest{Flags: fuse.OpenReadWrite}, &fuse.OpenResponse{})
+	rtest.OK(t, err)
+	writeHandle(t, h, 4, "changed")
+	_, h, err = sub.Create(ctx, &fuse.CreateRequest{Name: "new", Mode: 0644}, &fuse.CreateResponse{})
+	rtest.OK(t, err)
+	writeHandle(t, h, 0, "new content")
+
+	ids, err := overlay.Commit(ctx, repo, "test")
+	rtest.OK(t, err)
+	archiver.TestEnsureSnapshot(t, repo, ids[*sn.ID()], archiver.TestDir{
+		"moved": archiver.TestDir{
+			"bar": archiver.TestFile{Content: "BAR changed"},
+			"new": archiver.TestFile{Content: "new content"},
+		},
+	})
+}
+
+func TestOverlayCopyWithoutLock(t *testing.T) {
+	repo := repository.TestRepository(t)
+	restic.TestCreateSnapshot(t, repo, time.Unix(1485250976, 0), 1)
+	sn := loadFirstSnapshot(t, repo)
+
+	ctx := context.TODO()
+	dir := rtest.TempDir(t)
+	overlay := NewOverlay(dir)
+	node := &restic.Node{Name: "file", Type: "file"}
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	copied := make(chan string)
+	go func() {
+		name, err := overlay.modifyFile(ctx, sn, "/file", node, false, func(_ context.Context, wr io.Writer) error {
+			close(started)
+			<-release
+			_, err := wr.Write([]byte("content"))
+			return err
+		})
+		rtest.OK(t, err)
+		copied <- name
+	}()
+	<-started
+
+	// other operations are not blocked by the copy
+	rtest.Assert(t, overlay.lookup(sn, "/other") == nil, "unexpected entry for /other")
+	waiting := make(chan string)
+	go func() {
+		name, err := overlay.modifyFile(ctx, sn, "/file", node, false, func(context.Context, io.Writer) error {
+			return errors.New("file copied twice")
+		})
+		rtest.OK(t, err)
+		waiting <- name
+	}()
+
+	close(release)
+	name := <-copied
+	rtest.Equals(t, name, <-waiting)
+	data, err := os.ReadFile(name)
+	rtest.OK(t, err)
+	rtest.Equals(t, "content", string(data))
+
+	// the manifest maps the files in the overlay directory to their paths
+	manifest, err := overlay.WriteManifest()
+	rtest.OK(t, err)
+	rtest.Equals(t, filepath.Join(dir, "manifest.txt"), manifest)
+	data, err = os.ReadFile(manifest)
+	rtest.OK(t, err)
+	rtest.Equals(t, "# file\tsnapshot\tpath\n"+filepath.Base(name)+"\t"+sn.ID().Str()+"\t/file\n", string(data))
+}
+
+func TestReadOnlyMount(t *testing.T) {
+	repo := repository.TestRepository(t)
+	restic.TestCreateSnapshot(t, repo, time.Unix(1485250976, 0), 1)
+	sn := loadFirstSnapshot(t, repo)
+
+	root := NewRoot(repo, Config{})
+	snapshotDir := lookupNode(t, root, "ids", sn.ID().Str()).(*dir)
+	_, _, err := snapshotDir.Create(context.TODO(), &fuse.CreateRequest{Name: "new"}, &fuse.CreateResponse{})
+	rtest.Assert(t, err != nil, "creating a file in a read-only mount should fail")
+}
@@ -4,6 +4,8 @@
 package fuse
 
 import (
+	"context"
+	"crypto/sha256"
 	"os"
 
 	"github.com/chanhpng/vlbe/internal/bloblru"
@@ -19,6 +21,9 @@ type Config struct {
 	Filter        restic.SnapshotFilter
 	TimeTemplate  string
 	PathTemplates []string
+	// Overlay makes the snapshots writable if set, all changes are recorded
+	// in the overlay.
+	Overlay *Overlay
 }
 
 // Root is the root node of the fuse mount of a repository.
@@ -76,3 +81,10 @@ func (r *Root) Root() (fs.Node, error) {
 	debug.Log("Root()")
 	return r, nil
 }
+
+// SnapshotsHash returns a hash which identifies the snapshots shown in the
+// directory structure. It changes whenever the snapshots change.
+func (r *Root) SnapshotsHash(ctx context.Context) ([sha256.Size]byte, error) {
+	_, hash, err := r.dirStruct.Snapshots(ctx)
+	return hash, err
+}
@@ -25,6 +25,8 @@ type MetaDirData struct {
 	snapshot   *restic.Snapshot
 	// names is set if this is a pseudo directory
 	names map[string]*MetaDirData
+	// versions is set for the directory showing all versions of each file
+	versions bool
 }
 
 // SnapshotsDirStructure contains the directory structure for snapshots.
@@ -41,6 +43,8 @@ type SnapshotsDirStructure struct {
 	// thus all subdirectories are prefixed with a slash as the root is ""
 	// that way we don't need path processing special cases when using the entries tree
 	entries map[string]*MetaDirData
+	// snapshots contains all snapshots sorted by time
+	snapshots restic.Snapshots
 
 	hash      [sha256.Size]byte // Hash at last check.
 	lastCheck time.Time
@@ -281,6 +285,13 @@ func (d *SnapshotsDirStructure) makeDirs(snapshots restic.Snapshots) {
 		}
 	}
 
+	// the versions directory is populated from the snapshot trees on access,
+	// it is omitted if a path template generates the same name
+	if _, ok := entries["/versions"]; !ok {
+		mount("/versions", mountData{})
+		entries["/versions"].versions = true
+	}
+
 	d.entries = entries
 }
 
@@ -335,6 +346,7 @@ func (d *SnapshotsDirStructure) updateSnapshots(ctx context.Context) error {
 
 	d.lastCheck = time.Now()
 	d.hash = hash
+	d.snapshots = snapshots
 	d.makeDirs(snapshots)
 	return nil
 }
@@ -349,3 +361,16 @@ func (d *SnapshotsDirStructure) UpdatePrefix(ctx context.Context, prefix string)
 	defer d.mutex.Unlock()
 	return d.entries[prefix], nil
 }
+
+// Snapshots returns all snapshots sorted ascending by time and the hash of
+// their IDs, which changes whenever the list of snapshots changes.
+func (d *SnapshotsDirStructure) Snapshots(ctx context.Context) (restic.Snapshots, [sha256.Size]byte, error) {
+	err := d.updateSnapshots(ctx)
+	if err != nil {
+		return nil, [sha256.Size]byte{}, err
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.snapshots, d.hash, nil
+}
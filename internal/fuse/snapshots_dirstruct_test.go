@@ -156,6 +156,7 @@ func TestMakeDirs(t *testing.T) {
 	expNames["/users/user"] = nil
 	expNames["/users"] = nil
 	expNames["/longids"] = nil
+	expNames["/versions"] = nil
 	expNames["/2020/12/31"] = nil
 	expNames["/2020/12"] = nil
 	expNames["/2020"] = nil
@@ -269,11 +270,26 @@ func TestMakeEmptyDirs(t *testing.T) {
 	expNames["/tags"] = nil
 	expNames["/users"] = nil
 	expNames["/longids"] = nil
+	expNames["/versions"] = nil
 	expNames[""] = nil
 
 	verifyEntries(t, expNames, expLatest, sds.entries)
 }
 
+func TestMakeDirsVersionsCollision(t *testing.T) {
+	sn := &restic.Snapshot{Hostname: "versions", Time: time.Unix(1608876000, 0)}
+	sds := &SnapshotsDirStructure{
+		pathTemplates: []string{"%h"},
+		timeTemplate:  "2006-01-02T15:04:05Z07:00",
+	}
+	sds.makeDirs(restic.Snapshots{sn})
+
+	// the snapshot takes precedence over the versions directory
+	entry := sds.entries["/versions"]
+	test.Assert(t, entry != nil && !entry.versions, "versions directory was mounted in place of a snapshot")
+	test.Equals(t, sn, entry.snapshot)
+}
+
 func TestFilenameFromTag(t *testing.T) {
 	for _, c := range []struct {
 		tag, filename string
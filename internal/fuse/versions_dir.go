@@ -0,0 +1,231 @@
+//go:build darwin || freebsd || linux
+// +build darwin freebsd linux
+
+package fuse
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/chanhpng/vlbe/internal/debug"
+	"github.com/chanhpng/vlbe/internal/restic"
+	"github.com/chanhpng/vlbe/internal/walker"
+
+	"github.com/anacrolix/fuse"
+	"github.com/anacrolix/fuse/fs"
+)
+
+// versionsDir shows the directory at path merged from all snapshots. Files
+// are represented by directories, which contain the distinct versions of the
+// file. Each version is named by the time of the first snapshot containing it.
+type versionsDir struct {
+	root        *Root
+	inode       uint64
+	parentInode uint64
+	dirStruct   *SnapshotsDirStructure
+	path        string
+
+	m sync.Mutex
+	// hash identifies the snapshots children and versions were collected from
+	hash [sha256.Size]byte
+	// children contains the names of all items within path
+	children map[string]struct{}
+	// versions contains the versions of the file at path by name
+	versions map[string]*restic.Node
+}
+
+// ensure that *versionsDir implements these interfaces
+var _ = fs.HandleReadDirAller(&versionsDir{})
+var _ = fs.NodeStringLookuper(&versionsDir{})
+
+func newVersionsDir(root *Root, inode, parentInode uint64, dirStruct *SnapshotsDirStructure, p string) *versionsDir {
+	debug.Log("create versions dir for %v, inode %d", p, inode)
+	return &versionsDir{
+		root:        root,
+		inode:       inode,
+		parentInode: parentInode,
+		dirStruct:   dirStruct,
+		path:        p,
+	}
+}
+
+// open walks the trees of all snapshots to collect the items within the
+// directory and the versions of the file at d.path. The result is collected
+// again once the snapshots have changed. The returned maps must not be
+// modified.
+func (d *versionsDir) open(ctx context.Context) (children map[string]struct{}, versions map[string]*restic.Node, err error) {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	snapshots, hash, err := d.dirStruct.Snapshots(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	if d.children != nil && d.hash == hash {
+		return d.children, d.versions, nil
+	}
+
+	type version struct {
+		sn   *restic.Snapshot
+		node *restic.Node
+	}
+	var found []version
+	seen := make(map[string]struct{})
+	children = make(map[string]struct{})
+
+	for _, sn := range snapshots {
+		if sn.Tree == nil {
+			continue
+		}
+
+		err := walker.Walk(ctx, d.root.repo, *sn.Tree, walker.WalkVisitor{ProcessNode: func(_ restic.ID, nodepath string, node *restic.Node, err error) error {
+			if err != nil {
+				return err
+			}
+			if node == nil {
+				return nil
+			}
+
+			switch {
+			case nodepath == d.path:
+				if node.Type == "dir" {
+					return nil
+				}
+				key := node.ContentKey()
+				if _, ok := seen[key]; !ok {
+					seen[key] = struct{}{}
+					found = append(found, version{sn, node})
+				}
+			case path.Dir(nodepath) == d.path:
+				children[node.Name] = struct{}{}
+			case strings.HasPrefix(d.path, nodepath+"/"):
+				// descend towards d.path
+				return nil
+			}
+
+			if node.Type == "dir" {
+				return walker.ErrSkipNode
+			}
+			return nil
+		}})
+		if err != nil {
+			return nil, nil, unwrapCtxCanceled(err)
+		}
+	}
+
+	versions = make(map[string]*restic.Node, len(found))
+	for _, v := range found {
+		base := strings.ReplaceAll(v.sn.Time.Format(d.dirStruct.timeTemplate), "/", "_")
+		name := base
+		for i := 1; ; i++ {
+			_, isChild := children[name]
+			_, isVersion := versions[name]
+			if !isChild && !isVersion {
+				break
+			}
+			name = fmt.Sprintf("%s-%d", base, i)
+		}
+
+		node := *v.node
+		node.Name = name
+		versions[name] = &node
+	}
+	d.children, d.versions, d.hash = children, versions, hash
+
+	debug.Log("found %d items and %d versions for %v", len(children), len(versions), d.path)
+	return children, versions, nil
+}
+
+// Attr returns the attributes for a directory in the versions directory.
+func (d *versionsDir) Attr(_ context.Context, attr *fuse.Attr) error {
+	attr.Inode = d.inode
+	attr.Mode = os.ModeDir | 0555
+	attr.Uid = d.root.uid
+	attr.Gid = d.root.gid
+
+	debug.Log("attr: %v", attr)
+	return nil
+}
+
+// ReadDirAll returns the items within the directory and the versions of the
+// file.
+func (d *versionsDir) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
+	debug.Log("ReadDirAll()")
+	children, versions, err := d.open(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	items := []fuse.Dirent{
+		{
+			Inode: d.inode,
+			Name:  ".",
+			Type:  fuse.DT_Dir,
+		},
+		{
+			Inode: d.parentInode,
+			Name:  "..",
+			Type:  fuse.DT_Dir,
+		},
+	}
+
+	for name := range children {
+		items = append(items, fuse.Dirent{
+			Inode: inodeFromName(d.inode, name),
+			Name:  cleanupNodeName(name),
+			Type:  fuse.DT_Dir,
+		})
+	}
+
+	for name, node := range versions {
+		var typ fuse.DirentType
+		switch node.Type {
+		case "file":
+			typ = fuse.DT_File
+		case "symlink":
+			typ = fuse.DT_Link
+		}
+
+		items = append(items, fuse.Dirent{
+			Inode: inodeFromNode(d.inode, node),
+			Name:  name,
+			Type:  typ,
+		})
+	}
+
+	return items, nil
+}
+
+// Lookup returns an item within the directory or a version of the file.
+func (d *versionsDir) Lookup(ctx context.Context, name string) (fs.Node, error) {
+	debug.Log("Lookup(%s)", name)
+	children, versions, err := d.open(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := children[name]; ok {
+		return newVersionsDir(d.root, inodeFromName(d.inode, name), d.inode, d.dirStruct, path.Join(d.path, name)), nil
+	}
+
+	node, ok := versions[name]
+	if !ok {
+		return nil, syscall.ENOENT
+	}
+
+	inode := inodeFromNode(d.inode, node)
+	switch node.Type {
+	case "file":
+		return newFile(d.root, inode, node)
+	case "symlink":
+		return newLink(d.root, inode, node)
+	default:
+		return newOther(d.root, inode, node)
+	}
+}
@@ -0,0 +1,86 @@
+//go:build darwin || freebsd || linux
+// +build darwin freebsd linux
+
+package fuse
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/anacrolix/fuse"
+	"github.com/anacrolix/fuse/fs"
+
+	"github.com/chanhpng/vlbe/internal/archiver"
+	"github.com/chanhpng/vlbe/internal/repository"
+	"github.com/chanhpng/vlbe/internal/restic"
+	rtest "github.com/chanhpng/vlbe/internal/test"
+)
+
+func TestVersionsDir(t *testing.T) {
+	repo := repository.TestRepository(t)
+	tempdir := rtest.TempDir(t)
+	archiver.TestCreateFiles(t, tempdir, archiver.TestDir{
+		"sub": archiver.TestDir{
+			"file": archiver.TestFile{Content: "first"},
+		},
+	})
+
+	back := rtest.Chdir(t, tempdir)
+	defer back()
+	for _, content := range []string{"first", "second", "second"} {
+		rtest.OK(t, os.WriteFile(filepath.Join(tempdir, "sub", "file"), []byte(content), 0644))
+		archiver.TestSnapshot(t, repo, ".", nil)
+	}
+
+	var snapshots restic.Snapshots
+	rtest.OK(t, restic.ForAllSnapshots(context.TODO(), repo, repo, nil, func(_ restic.ID, sn *restic.Snapshot, err error) error {
+		snapshots = append(snapshots, sn)
+		return err
+	}))
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].Time.Before(snapshots[j].Time)
+	})
+
+	root := NewRoot(repo, Config{TimeTemplate: time.RFC3339Nano})
+	sub := lookupNode(t, root, "versions", "sub")
+	entries, err := sub.(fs.HandleReadDirAller).ReadDirAll(context.TODO())
+	rtest.OK(t, err)
+	rtest.Equals(t, 3, len(entries))
+	rtest.Equals(t, "file", entries[2].Name)
+
+	versions := lookupNode(t, sub, "file")
+	entries, err = versions.(fs.HandleReadDirAller).ReadDirAll(context.TODO())
+	rtest.OK(t, err)
+	var names []string
+	for _, entry := range entries[2:] {
+		names = append(names, entry.Name)
+	}
+	sort.Strings(names)
+	// the last snapshot contains no new version
+	rtest.Equals(t, []string{
+		snapshots[0].Time.Format(time.RFC3339Nano),
+		snapshots[1].Time.Format(time.RFC3339Nano),
+	}, names)
+
+	for i, content := range []string{"first", "second"} {
+		var attr fuse.Attr
+		rtest.OK(t, lookupNode(t, versions, names[i]).Attr(context.TODO(), &attr))
+		rtest.Equals(t, uint64(len(content)), attr.Size)
+	}
+
+	// a new snapshot adds a version once the snapshots are reloaded
+	rtest.OK(t, os.WriteFile(filepath.Join(tempdir, "sub", "file"), []byte("third"), 0644))
+	archiver.TestSnapshot(t, repo, ".", nil)
+	dirStruct := versions.(*versionsDir).dirStruct
+	dirStruct.mutex.Lock()
+	dirStruct.lastCheck = time.Time{}
+	dirStruct.mutex.Unlock()
+
+	entries, err = versions.(fs.HandleReadDirAller).ReadDirAll(context.TODO())
+	rtest.OK(t, err)
+	rtest.Equals(t, 5, len(entries))
+}
@@ -0,0 +1,397 @@
+//go:build darwin || freebsd || linux
+// +build darwin freebsd linux
+
+package fuse
+
+import (
+	"context"
+	"io"
+	"os"
+	"path"
+	"syscall"
+	"time"
+
+	"github.com/anacrolix/fuse"
+	"github.com/anacrolix/fuse/fs"
+
+	"github.com/chanhpng/vlbe/internal/debug"
+	"github.com/chanhpng/vlbe/internal/restic"
+)
+
+// Statically ensure that the nodes of a writable mount implement the given
+// interfaces
+var _ = fs.NodeCreater(&dir{})
+var _ = fs.NodeMkdirer(&dir{})
+var _ = fs.NodeRemover(&dir{})
+var _ = fs.NodeRenamer(&dir{})
+var _ = fs.NodeSetattrer(&dir{})
+var _ = fs.NodeSetattrer(&file{})
+var _ = fs.NodeFsyncer(&file{})
+var _ = fs.NodeForgetter(&dir{})
+var _ = fs.NodeForgetter(&file{})
+var _ = fs.HandleReader(&overlayFile{})
+var _ = fs.HandleWriter(&overlayFile{})
+var _ = fs.HandleReleaser(&overlayFile{})
+
+// overlayFile is an open file whose content is stored in the overlay.
+type overlayFile struct {
+	f *os.File
+}
+
+func (h *overlayFile) Read(_ context.Context, req *fuse.ReadRequest, resp *fuse.ReadResponse) error {
+	n, err := h.f.ReadAt(resp.Data[:req.Size], req.Offset)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	resp.Data = resp.Data[:n]
+	return nil
+}
+
+func (h *overlayFile) Write(_ context.Context, req *fuse.WriteRequest, resp *fuse.WriteResponse) error {
+	n, err := h.f.WriteAt(req.Data, req.Offset)
+	resp.Size = n
+	return err
+}
+
+func (h *overlayFile) Release(_ context.Context, _ *fuse.ReleaseRequest) error {
+	return h.f.Close()
+}
+
+// overlay returns the overlay of a writable mount, or EROFS if the mount is
+// read-only.
+func (d *dir) overlay() (*Overlay, error) {
+	if d.root.cfg.Overlay == nil || d.snapshot == nil {
+		return nil, syscall.EROFS
+	}
+	return d.root.cfg.Overlay, nil
+}
+
+func (d *dir) Create(ctx context.Context, req *fuse.CreateRequest, _ *fuse.CreateResponse) (fs.Node, fs.Handle, error) {
+	debug.Log("Create(%v)", req.Name)
+	o, err := d.overlay()
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := d.open(ctx); err != nil {
+		return nil, nil, err
+	}
+	if _, ok := d.nodes()[req.Name]; ok {
+		return nil, nil, syscall.EEXIST
+	}
+
+	now := time.Now()
+	node := &restic.Node{
+		Name:       req.Name,
+		Type:       "file",
+		Mode:       req.Mode & (os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky),
+		ModTime:    now,
+		AccessTime: now,
+		ChangeTime: now,
+		UID:        req.Uid,
+		GID:        req.Gid,
+		Links:      1,
+	}
+	f, err := o.create(d.snapshot, path.Join(d.path.get(), req.Name), node)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	child, err := d.kernelNode(req.Name, node)
+	if err != nil {
+		_ = f.Close()
+		return nil, nil, err
+	}
+	return child, &overlayFile{f: f}, nil
+}
+
+func (d *dir) Mkdir(ctx context.Context, req *fuse.MkdirRequest) (fs.Node, error) {
+	debug.Log("Mkdir(%v)", req.Name)
+	o, err := d.overlay()
+	if err != nil {
+		return nil, err
+	}
+	if err := d.open(ctx); err != nil {
+		return nil, err
+	}
+	if _, ok := d.nodes()[req.Name]; ok {
+		return nil, syscall.EEXIST
+	}
+
+	now := time.Now()
+	node := &restic.Node{
+		Name:       req.Name,
+		Type:       "dir",
+		Mode:       os.ModeDir | req.Mode&(os.ModePerm|os.ModeSetgid|os.ModeSticky),
+		ModTime:    now,
+		AccessTime: now,
+		ChangeTime: now,
+		UID:        req.Uid,
+		GID:        req.Gid,
+	}
+	if _, err := o.create(d.snapshot, path.Join(d.path.get(), req.Name), node); err != nil {
+		return nil, err
+	}
+	return d.kernelNode(req.Name, node)
+}
+
+// kernelNode returns the node for name which is handed to the kernel. In a
+// writable mount its path is tracked by the overlay until the kernel forgets
+// the node.
+func (d *dir) kernelNode(name string, node *restic.Node) (fs.Node, error) {
+	child, err := d.newNode(name, node)
+	if err != nil {
+		return nil, err
+	}
+	o := d.root.cfg.Overlay
+	if o == nil || d.snapshot == nil {
+		return child, nil
+	}
+	switch c := child.(type) {
+	case *dir:
+		o.track(c.snapshot, c.path)
+	case *file:
+		o.track(c.snapshot, c.path)
+	}
+	return child, nil
+}
+
+func (d *dir) Forget() {
+	if d.root.cfg.Overlay != nil && d.snapshot != nil {
+		d.root.cfg.Overlay.untrack(d.snapshot, d.path)
+	}
+}
+
+func (f *file) Forget() {
+	if f.root.cfg.Overlay != nil && f.snapshot != nil {
+		f.root.cfg.Overlay.untrack(f.snapshot, f.path)
+	}
+}
+
+// isEmptyDir returns true if node is a directory without any items.
+func (d *dir) isEmptyDir(ctx context.Context, name string, node *restic.Node) (bool, error) {
+	child, err := d.newNode(name, node)
+	if err != nil {
+		return false, err
+	}
+	cd, ok := child.(*dir)
+	if !ok {
+		return false, nil
+	}
+	if err := cd.open(ctx); err != nil {
+		return false, err
+	}
+	return len(cd.nodes()) == 0, nil
+}
+
+func (d *dir) Remove(ctx context.Context, req *fuse.RemoveRequest) error {
+	debug.Log("Remove(%v)", req.Name)
+	o, err := d.overlay()
+	if err != nil {
+		return err
+	}
+	if err := d.open(ctx); err != nil {
+		return err
+	}
+	node, ok := d.nodes()[req.Name]
+	if !ok {
+		return syscall.ENOENT
+	}
+
+	if req.Dir {
+		if node.Type != "dir" {
+			return syscall.ENOTDIR
+		}
+		empty, err := d.isEmptyDir(ctx, req.Name, node)
+		if err != nil {
+			return err
+		}
+		if !empty {
+			return syscall.ENOTEMPTY
+		}
+	} else if node.Type == "dir" {
+		return syscall.EISDIR
+	}
+
+	o.remove(d.snapshot, path.Join(d.path.get(), req.Name))
+	return nil
+}
+
+func (d *dir) Rename(ctx context.Context, req *fuse.RenameRequest, newDir fs.Node) error {
+	debug.Log("Rename(%v, %v)", req.OldName, req.NewName)
+	o, err := d.overlay()
+	if err != nil {
+		return err
+	}
+	nd, ok := newDir.(*dir)
+	if !ok || nd.snapshot == nil || !nd.snapshot.ID().Equal(*d.snapshot.ID()) {
+		// only renaming within the same snapshot is supported
+		return syscall.EXDEV
+	}
+	if err := d.open(ctx); err != nil {
+		return err
+	}
+	if err := nd.open(ctx); err != nil {
+		return err
+	}
+
+	node, ok := d.nodes()[req.OldName]
+	if !ok {
+		return syscall.ENOENT
+	}
+	if target, ok := nd.nodes()[req.NewName]; ok && target.Type == "dir" {
+		if node.Type != "dir" {
+			return syscall.EISDIR
+		}
+		empty, err := nd.isEmptyDir(ctx, req.NewName, target)
+		if err != nil {
+			return err
+		}
+		if !empty {
+			return syscall.ENOTEMPTY
+		}
+	}
+
+	o.rename(d.snapshot, path.Join(d.path.get(), req.OldName), path.Join(nd.path.get(), req.NewName), node)
+	return nil
+}
+
+func (d *dir) Setattr(ctx context.Context, req *fuse.SetattrRequest, resp *fuse.SetattrResponse) error {
+	o, err := d.overlay()
+	if err != nil {
+		return err
+	}
+	if d.path.get() == "/" {
+		// the root directory of a snapshot has no node
+		return syscall.EPERM
+	}
+
+	err = o.modifyNode(d.snapshot, d.path.get(), d.node, func(node *restic.Node, _ string) error {
+		setNodeAttr(node, req)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if e := o.lookup(d.snapshot, d.path.get()); e != nil && e.node != nil {
+		d.node = e.node
+	}
+	return d.Attr(ctx, &resp.Attr)
+}
+
+// setNodeAttr applies the attributes from req to node.
+func setNodeAttr(node *restic.Node, req *fuse.SetattrRequest) {
+	now := time.Now()
+	if req.Valid.Mode() {
+		node.Mode = node.Mode&os.ModeType | req.Mode&(os.ModePerm|os.ModeSetuid|os.ModeSetgid|os.ModeSticky)
+	}
+	if req.Valid.Uid() {
+		node.UID = req.Uid
+	}
+	if req.Valid.Gid() {
+		node.GID = req.Gid
+	}
+	if req.Valid.Atime() {
+		node.AccessTime = req.Atime
+	}
+	if req.Valid.AtimeNow() {
+		node.AccessTime = now
+	}
+	if req.Valid.Mtime() {
+		node.ModTime = req.Mtime
+	}
+	if req.Valid.MtimeNow() {
+		node.ModTime = now
+	}
+	node.ChangeTime = now
+}
+
+// currentNode returns the node of the file with the changes from the overlay
+// applied.
+func (f *file) currentNode() (*restic.Node, error) {
+	if f.root.cfg.Overlay == nil || f.snapshot == nil {
+		return f.node, nil
+	}
+	e := f.root.cfg.Overlay.lookup(f.snapshot, f.path.get())
+	if e == nil || e.node == nil {
+		return f.node, nil
+	}
+	if e.file == "" {
+		return e.node, nil
+	}
+
+	fi, err := os.Stat(e.file)
+	if err != nil {
+		return nil, err
+	}
+	e.node.Size = uint64(fi.Size())
+	e.node.ModTime = fi.ModTime()
+	e.node.Links = 1
+	return e.node, nil
+}
+
+// readContent writes the content of the file stored in the repository to wr.
+func (f *file) readContent(ctx context.Context, wr io.Writer) error {
+	for _, id := range f.node.Content {
+		id := id
+		blob, err := f.root.blobCache.GetOrCompute(id, func() ([]byte, error) {
+			return f.root.repo.LoadBlob(ctx, restic.DataBlob, id, nil)
+		})
+		if err != nil {
+			return unwrapCtxCanceled(err)
+		}
+		if _, err := wr.Write(blob); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// openOverlay opens the content of the file in the overlay, it is copied
+// from the repository on first use.
+func (f *file) openOverlay(ctx context.Context, flags fuse.OpenFlags) (fs.Handle, error) {
+	truncate := flags&fuse.OpenTruncate != 0
+	name, err := f.root.cfg.Overlay.modifyFile(ctx, f.snapshot, f.path.get(), f.node, truncate, f.readContent)
+	if err != nil {
+		return nil, err
+	}
+
+	fd, err := os.OpenFile(name, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &overlayFile{f: fd}, nil
+}
+
+func (f *file) Setattr(ctx context.Context, req *fuse.SetattrRequest, resp *fuse.SetattrResponse) error {
+	o := f.root.cfg.Overlay
+	if o == nil || f.snapshot == nil {
+		return syscall.EROFS
+	}
+
+	if req.Valid.Size() {
+		name, err := o.modifyFile(ctx, f.snapshot, f.path.get(), f.node, req.Size == 0, f.readContent)
+		if err != nil {
+			return err
+		}
+		if err := os.Truncate(name, int64(req.Size)); err != nil {
+			return err
+		}
+	}
+
+	err := o.modifyNode(f.snapshot, f.path.get(), f.node, func(node *restic.Node, file string) error {
+		setNodeAttr(node, req)
+		if file != "" && (req.Valid.Mtime() || req.Valid.MtimeNow() || req.Valid.Atime() || req.Valid.AtimeNow()) {
+			// the modification time of modified files is taken from the local file
+			return os.Chtimes(file, node.AccessTime, node.ModTime)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return f.Attr(ctx, &resp.Attr)
+}
+
+func (f *file) Fsync(_ context.Context, _ *fuse.FsyncRequest) error {
+	return nil
+}
@@ -0,0 +1,42 @@
+package migrations
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/chanhpng/vlbe/internal/repository"
+	"github.com/chanhpng/vlbe/internal/restic"
+)
+
+func init() {
+	register(&UpgradeRepoV3{})
+}
+
+type UpgradeRepoV3 struct{}
+
+func (*UpgradeRepoV3) Name() string {
+	return "upgrade_repo_v3"
+}
+
+func (*UpgradeRepoV3) Desc() string {
+	return "upgrade a repository to version 3"
+}
+
+func (*UpgradeRepoV3) Check(_ context.Context, repo restic.Repository) (bool, string, error) {
+	version := repo.Config().Version
+	switch {
+	case version < 2:
+		return false, "repository must be upgraded to version 2 first using upgrade_repo_v2", nil
+	case version > 2:
+		return false, fmt.Sprintf("repository is already upgraded to version %v", version), nil
+	}
+	return true, "", nil
+}
+
+func (*UpgradeRepoV3) RepoCheck() bool {
+	return true
+}
+
+func (m *UpgradeRepoV3) Apply(ctx context.Context, repo restic.Repository) error {
+	return repository.UpgradeRepo(ctx, repo.(*repository.Repository), 3)
+}
@@ -0,0 +1,44 @@
+package migrations
+
+import (
+	"context"
+	"testing"
+
+	"github.com/chanhpng/vlbe/internal/repository"
+)
+
+func TestUpgradeRepoV3(t *testing.T) {
+	repo, _ := repository.TestRepositoryWithVersion(t, 1)
+
+	m := &UpgradeRepoV3{}
+
+	ok, _, err := m.Check(context.Background(), repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Fatal("migration check for version 1 returned true")
+	}
+
+	err = (&UpgradeRepoV2{}).Apply(context.Background(), repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ok, _, err = m.Check(context.Background(), repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Fatal("migration check returned false")
+	}
+
+	err = m.Apply(context.Background(), repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if repo.Config().Version != 3 {
+		t.Fatalf("repository has wrong version %v after upgrade", repo.Config().Version)
+	}
+}
@@ -143,6 +143,16 @@ func (o Options) Extract(ns string) Options {
 	return opts
 }
 
+// Merge adds all options from defaults to o, except for those keys which are
+// already set in o.
+func (o Options) Merge(defaults Options) {
+	for k, v := range defaults {
+		if _, ok := o[k]; !ok {
+			o[k] = v
+		}
+	}
+}
+
 // Apply sets the options on dst via reflection, using the struct tag `option`.
 // The namespace argument (ns) is only used for error messages.
 func (o Options) Apply(ns string, dst interface{}) error {
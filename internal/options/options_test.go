@@ -120,6 +120,16 @@ func TestOptionsExtract(t *testing.T) {
 	}
 }
 
+func TestOptionsMerge(t *testing.T) {
+	opts := Options{"foo": "bar", "x": "1"}
+	opts.Merge(Options{"foo": "baz", "y": "2"})
+
+	want := Options{"foo": "bar", "x": "1", "y": "2"}
+	if !reflect.DeepEqual(opts, want) {
+		t.Fatalf("wrong result, want:\n  %#v\ngot:\n  %#v", want, opts)
+	}
+}
+
 // Target is used for Apply() tests
 type Target struct {
 	Name    string        `option:"name"`
@@ -103,6 +103,29 @@ func (mi *MasterIndex) Has(bh restic.BlobHandle) bool {
 	return false
 }
 
+// PendingBlobs returns the blobs which are in the process of being saved.
+func (mi *MasterIndex) PendingBlobs() restic.BlobSet {
+	mi.idxMutex.RLock()
+	defer mi.idxMutex.RUnlock()
+
+	blobs := restic.NewBlobSet()
+	blobs.Merge(mi.pendingBlobs)
+	return blobs
+}
+
+// AnyPending returns true if any of the blobs is still being saved.
+func (mi *MasterIndex) AnyPending(blobs restic.BlobSet) bool {
+	mi.idxMutex.RLock()
+	defer mi.idxMutex.RUnlock()
+
+	for bh := range blobs {
+		if mi.pendingBlobs.Has(bh) {
+			return true
+		}
+	}
+	return false
+}
+
 // IDs returns the IDs of all indexes contained in the index.
 func (mi *MasterIndex) IDs() restic.IDSet {
 	mi.idxMutex.RLock()
@@ -30,10 +30,19 @@ type Key struct {
 	Username string    `json:"username"`
 	Hostname string    `json:"hostname"`
 
-	KDF  string `json:"kdf"`
-	N    int    `json:"N"`
-	R    int    `json:"r"`
-	P    int    `json:"p"`
+	// Role restricts the operations permitted by this key.
+	Role KeyRole `json:"role,omitempty"`
+
+	KDF string `json:"kdf"`
+	// parameters for scrypt
+	N int `json:"N"`
+	R int `json:"r"`
+	P int `json:"p"`
+	// parameters for argon2id
+	Time        uint32 `json:"time,omitempty"`
+	Memory      uint32 `json:"memory,omitempty"`
+	Parallelism uint8  `json:"parallelism,omitempty"`
+
 	Salt []byte `json:"salt"`
 	Data []byte `json:"data"`
 
@@ -43,9 +52,30 @@ type Key struct {
 	id restic.ID
 }
 
-// params tracks the parameters used for the KDF. If not set, it will be
-// calibrated on the first run of AddKey().
-var params *crypto.Params
+// KDFs supported for new keys.
+const (
+	KDFScrypt   = "scrypt"
+	KDFArgon2id = "argon2id"
+)
+
+// ParseKDF parses the name of a KDF as passed on the command line. An empty
+// string selects the default KDF scrypt.
+func ParseKDF(s string) (string, error) {
+	switch s {
+	case "":
+		return KDFScrypt, nil
+	case KDFScrypt, KDFArgon2id:
+		return s, nil
+	}
+	return "", errors.Fatalf("invalid KDF %q, must be one of %v or %v", s, KDFScrypt, KDFArgon2id)
+}
+
+// params and argon2Params track the parameters used for the KDFs. If not set,
+// they will be calibrated on the first run of AddKey().
+var (
+	params       *crypto.Params
+	argon2Params *crypto.Argon2Params
+)
 
 const (
 	// KDFTimeout specifies the maximum runtime for the KDF.
@@ -56,9 +86,9 @@ const (
 )
 
 // createMasterKey creates a new master key in the given backend and encrypts
-// it with the password.
-func createMasterKey(ctx context.Context, s *Repository, password string) (*Key, error) {
-	return AddKey(ctx, s, password, "", "", nil)
+// it with the password. The key is an admin key.
+func createMasterKey(ctx context.Context, s *Repository, password, kdf string) (*Key, error) {
+	return AddKey(ctx, s, password, "", "", RoleAdmin, kdf, nil)
 }
 
 // OpenKey tries do decrypt the key specified by name with the given password.
@@ -69,20 +99,14 @@ func OpenKey(ctx context.Context, s *Repository, id restic.ID, password string)
 		return nil, err
 	}
 
-	// check KDF
-	if k.KDF != "scrypt" {
-		return nil, errors.New("only supported KDF is scrypt()")
+	if !k.Role.valid() {
+		return nil, errors.Errorf("unsupported key role %q", k.Role)
 	}
 
 	// derive user key
-	params := crypto.Params{
-		N: k.N,
-		R: k.R,
-		P: k.P,
-	}
-	k.user, err = crypto.KDF(params, k.Salt, password)
+	k.user, err = k.deriveUserKey(password)
 	if err != nil {
-		return nil, errors.Wrap(err, "crypto.KDF")
+		return nil, err
 	}
 
 	// decrypt master keys
@@ -192,17 +216,51 @@ func LoadKey(ctx context.Context, s *Repository, id restic.ID) (k *Key, err erro
 	return k, nil
 }
 
-// AddKey adds a new key to an already existing repository.
-func AddKey(ctx context.Context, s *Repository, password, username, hostname string, template *crypto.Key) (*Key, error) {
-	// make sure we have valid KDF parameters
-	if params == nil {
-		p, err := crypto.Calibrate(KDFTimeout, KDFMemory)
+// deriveUserKey derives the user key from the password using the KDF and
+// parameters stored in the key.
+func (k *Key) deriveUserKey(password string) (*crypto.Key, error) {
+	switch k.KDF {
+	case KDFScrypt:
+		params := crypto.Params{
+			N: k.N,
+			R: k.R,
+			P: k.P,
+		}
+		user, err := crypto.KDF(params, k.Salt, password)
 		if err != nil {
-			return nil, errors.Wrap(err, "Calibrate")
+			return nil, errors.Wrap(err, "crypto.KDF")
 		}
+		return user, nil
+	case KDFArgon2id:
+		params := crypto.Argon2Params{
+			Time:        k.Time,
+			Memory:      k.Memory,
+			Parallelism: k.Parallelism,
+		}
+		user, err := crypto.Argon2idKDF(params, k.Salt, password)
+		if err != nil {
+			return nil, errors.Wrap(err, "crypto.Argon2idKDF")
+		}
+		return user, nil
+	}
+	return nil, errors.Errorf("unsupported KDF %q", k.KDF)
+}
 
-		params = &p
-		debug.Log("calibrated KDF parameters are %v", p)
+// AddKey adds a new key with the given role to an already existing
+// repository, using kdf to derive the user key from the password. An empty kdf
+// selects scrypt. Keys with a restricted role cannot add keys, and only admin
+// keys can add further admin keys to an append-only repository.
+func AddKey(ctx context.Context, s *Repository, password, username, hostname string, role KeyRole, kdf string, template *crypto.Key) (*Key, error) {
+	if !role.valid() {
+		return nil, errors.Errorf("unsupported key role %q", role)
+	}
+	if err := s.CheckPermission(PermManageKeys); err != nil {
+		return nil, err
+	}
+	if role == RoleAdmin {
+		if err := s.CheckPermission(PermRemove); err != nil {
+			return nil, err
+		}
 	}
 
 	// fill meta data about key
@@ -210,11 +268,43 @@ func AddKey(ctx context.Context, s *Repository, password, username, hostname str
 		Created:  time.Now(),
 		Username: username,
 		Hostname: hostname,
+		Role:     role,
+	}
+
+	// make sure we have valid KDF parameters
+	switch kdf {
+	case "", KDFScrypt:
+		if params == nil {
+			p, err := crypto.Calibrate(KDFTimeout, KDFMemory)
+			if err != nil {
+				return nil, errors.Wrap(err, "Calibrate")
+			}
+
+			params = &p
+			debug.Log("calibrated KDF parameters are %v", p)
+		}
+
+		newkey.KDF = KDFScrypt
+		newkey.N = params.N
+		newkey.R = params.R
+		newkey.P = params.P
+	case KDFArgon2id:
+		if argon2Params == nil {
+			p, err := crypto.CalibrateArgon2(KDFTimeout, KDFMemory)
+			if err != nil {
+				return nil, errors.Wrap(err, "CalibrateArgon2")
+			}
+
+			argon2Params = &p
+			debug.Log("calibrated argon2id parameters are %v", p)
+		}
 
-		KDF: "scrypt",
-		N:   params.N,
-		R:   params.R,
-		P:   params.P,
+		newkey.KDF = KDFArgon2id
+		newkey.Time = argon2Params.Time
+		newkey.Memory = argon2Params.Memory
+		newkey.Parallelism = argon2Params.Parallelism
+	default:
+		return nil, errors.Errorf("unsupported KDF %q", kdf)
 	}
 
 	if newkey.Hostname == "" {
@@ -236,7 +326,7 @@ func AddKey(ctx context.Context, s *Repository, password, username, hostname str
 	}
 
 	// call KDF to derive user key
-	newkey.user, err = crypto.KDF(*params, newkey.Salt, password)
+	newkey.user, err = newkey.deriveUserKey(password)
 	if err != nil {
 		return nil, err
 	}
@@ -288,6 +378,9 @@ func RemoveKey(ctx context.Context, repo *Repository, id restic.ID) error {
 	if id == repo.KeyID() {
 		return errors.New("refusing to remove key currently used to access repository")
 	}
+	if err := repo.CheckPermission(PermRemove); err != nil {
+		return err
+	}
 
 	h := backend.Handle{Type: restic.KeyFile, Name: id.String()}
 	return repo.be.Remove(ctx, h)
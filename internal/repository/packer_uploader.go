@@ -2,6 +2,7 @@ package repository
 
 import (
 	"context"
+	"sync"
 
 	"github.com/chanhpng/vlbe/internal/restic"
 	"golang.org/x/sync/errgroup"
@@ -15,15 +16,25 @@ type savePacker interface {
 type uploadTask struct {
 	packer *packer
 	tpe    restic.BlobType
+	seq    uint64
 }
 
 type packerUploader struct {
 	uploadQueue chan uploadTask
+
+	// m protects the fields below, which track the uploads in progress
+	m        sync.Mutex
+	queued   uint64
+	inflight map[uint64]struct{}
+	// changed is closed and replaced each time an upload has finished
+	changed chan struct{}
 }
 
 func newPackerUploader(ctx context.Context, wg *errgroup.Group, repo savePacker, connections uint) *packerUploader {
 	pu := &packerUploader{
 		uploadQueue: make(chan uploadTask),
+		inflight:    make(map[uint64]struct{}),
+		changed:     make(chan struct{}),
 	}
 
 	for i := 0; i < int(connections); i++ {
@@ -38,6 +49,7 @@ func newPackerUploader(ctx context.Context, wg *errgroup.Group, repo savePacker,
 					if err != nil {
 						return err
 					}
+					pu.finish(t.seq)
 				case <-ctx.Done():
 					return ctx.Err()
 				}
@@ -49,15 +61,62 @@ func newPackerUploader(ctx context.Context, wg *errgroup.Group, repo savePacker,
 }
 
 func (pu *packerUploader) QueuePacker(ctx context.Context, t restic.BlobType, p *packer) (err error) {
+	pu.m.Lock()
+	pu.queued++
+	seq := pu.queued
+	pu.inflight[seq] = struct{}{}
+	pu.m.Unlock()
+
 	select {
 	case <-ctx.Done():
+		pu.finish(seq)
 		return ctx.Err()
-	case pu.uploadQueue <- uploadTask{tpe: t, packer: p}:
+	case pu.uploadQueue <- uploadTask{tpe: t, packer: p, seq: seq}:
 	}
 
 	return nil
 }
 
+func (pu *packerUploader) finish(seq uint64) {
+	pu.m.Lock()
+	defer pu.m.Unlock()
+
+	delete(pu.inflight, seq)
+	close(pu.changed)
+	pu.changed = make(chan struct{})
+}
+
+// Wait waits until all packers queued before the call have been uploaded.
+// Packers queued concurrently are not waited for.
+func (pu *packerUploader) Wait(ctx context.Context) error {
+	pu.m.Lock()
+	last := pu.queued
+	pu.m.Unlock()
+
+	for {
+		pu.m.Lock()
+		done := true
+		for seq := range pu.inflight {
+			if seq <= last {
+				done = false
+				break
+			}
+		}
+		changed := pu.changed
+		pu.m.Unlock()
+
+		if done {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
 func (pu *packerUploader) TriggerShutdown() {
 	close(pu.uploadQueue)
 }
@@ -0,0 +1,126 @@
+package repository
+
+import (
+	"fmt"
+
+	"github.com/chanhpng/vlbe/internal/errors"
+)
+
+// KeyRole restricts the operations which can be performed using a key. The
+// role is stored unencrypted in the key file and is therefore only enforced
+// by the client.
+type KeyRole string
+
+const (
+	// RoleDefault is the role of keys without an explicit role. Such keys
+	// permit all operations, except removing data from append-only
+	// repositories.
+	RoleDefault KeyRole = ""
+	// RoleAdmin permits all operations.
+	RoleAdmin KeyRole = "admin"
+	// RoleReadOnly permits reading, but not modifying the repository.
+	RoleReadOnly KeyRole = "read-only"
+	// RoleBackupOnly permits adding snapshots and reading their metadata,
+	// but not reading file contents or removing data.
+	RoleBackupOnly KeyRole = "backup-only"
+)
+
+// ParseKeyRole parses a key role as passed on the command line.
+func ParseKeyRole(s string) (KeyRole, error) {
+	role := KeyRole(s)
+	if !role.valid() {
+		return "", errors.Fatalf("invalid key role %q, must be one of %v, %v or %v", s, RoleReadOnly, RoleBackupOnly, RoleAdmin)
+	}
+	return role, nil
+}
+
+func (role KeyRole) valid() bool {
+	switch role {
+	case RoleDefault, RoleAdmin, RoleReadOnly, RoleBackupOnly:
+		return true
+	}
+	return false
+}
+
+// restricted returns true if keys with this role may only perform a subset
+// of the operations of a default key.
+func (role KeyRole) restricted() bool {
+	return role == RoleReadOnly || role == RoleBackupOnly
+}
+
+func (role KeyRole) String() string {
+	if role == RoleDefault {
+		return "default"
+	}
+	return string(role)
+}
+
+// Permission is a class of operations which can be restricted by key roles.
+type Permission uint
+
+const (
+	// PermRead permits reading file contents from the repository.
+	PermRead Permission = iota
+	// PermWrite permits adding data to the repository.
+	PermWrite
+	// PermRemove permits removing data from the repository.
+	PermRemove
+	// PermManageKeys permits adding and changing keys.
+	PermManageKeys
+)
+
+func (p Permission) String() string {
+	switch p {
+	case PermRead:
+		return "read"
+	case PermWrite:
+		return "write"
+	case PermRemove:
+		return "remove"
+	case PermManageKeys:
+		return "manage-keys"
+	}
+	return fmt.Sprintf("Permission(%d)", uint(p))
+}
+
+var (
+	// ErrReadNotPermitted is returned when a backup-only key is used to read
+	// file contents.
+	ErrReadNotPermitted = errors.Fatal("the key is backup-only, reading file contents is not permitted")
+	// ErrWriteNotPermitted is returned when a read-only key is used to add
+	// data to the repository.
+	ErrWriteNotPermitted = errors.Fatal("the key is read-only, modifying the repository is not permitted")
+	// ErrRemoveNotPermitted is returned when a read-only or backup-only key is
+	// used to remove data from the repository.
+	ErrRemoveNotPermitted = errors.Fatal("the key role does not permit removing data from the repository")
+	// ErrManageKeysNotPermitted is returned when a read-only or backup-only
+	// key is used to add or change keys.
+	ErrManageKeysNotPermitted = errors.Fatal("the key role does not permit managing keys")
+)
+
+// CheckPermission returns an error if the current key does not permit the
+// operations described by p.
+func (r *Repository) CheckPermission(p Permission) error {
+	switch p {
+	case PermRead:
+		if r.keyRole == RoleBackupOnly {
+			return ErrReadNotPermitted
+		}
+	case PermWrite:
+		if r.keyRole == RoleReadOnly {
+			return ErrWriteNotPermitted
+		}
+	case PermRemove:
+		if r.keyRole.restricted() {
+			return ErrRemoveNotPermitted
+		}
+		if r.cfg.AppendOnly && r.keyRole != RoleAdmin {
+			return ErrAppendOnly
+		}
+	case PermManageKeys:
+		if r.keyRole.restricted() {
+			return ErrManageKeysNotPermitted
+		}
+	}
+	return nil
+}
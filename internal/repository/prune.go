@@ -537,6 +537,10 @@ func (plan *PrunePlan) Execute(ctx context.Context, printer progress.Printer) er
 		return nil
 	}
 
+	if err := plan.repo.CheckPermission(PermRemove); err != nil {
+		return err
+	}
+
 	repo := plan.repo
 	// make sure the plan can only be used once
 	plan.repo = nil
@@ -10,6 +10,7 @@ import (
 	"runtime"
 	"sort"
 	"sync"
+	"time"
 
 	"github.com/chanhpng/vlbe/internal/backend"
 	"github.com/chanhpng/vlbe/internal/backend/cache"
@@ -37,8 +38,12 @@ type Repository struct {
 	cfg   restic.Config
 	key   *crypto.Key
 	keyID restic.ID
-	idx   *index.MasterIndex
-	Cache *cache.Cache
+	// keyRole restricts the operations permitted by the current key
+	keyRole KeyRole
+	// keyKDF is the KDF used by the current key
+	keyKDF string
+	idx    *index.MasterIndex
+	Cache  *cache.Cache
 
 	opts Options
 
@@ -51,6 +56,10 @@ type Repository struct {
 	allocDec sync.Once
 	enc      *zstd.Encoder
 	dec      *zstd.Decoder
+
+	// zeroChunk is the ID of an all-zero chunk with the minimum chunk size
+	// configured for the repository
+	zeroChunk restic.ID
 }
 
 type Options struct {
@@ -59,6 +68,26 @@ type Options struct {
 	NoExtraVerify bool
 }
 
+// InitOptions contains the settings for a new repository.
+type InitOptions struct {
+	// ChunkerPolynomial is used instead of a random polynomial if set.
+	ChunkerPolynomial *chunker.Pol
+	// ChunkerMinSize, ChunkerAvgSize and ChunkerMaxSize override the default
+	// chunk sizes if set.
+	ChunkerMinSize uint
+	ChunkerAvgSize uint
+	ChunkerMaxSize uint
+	// AppendOnly prevents removing data from the repository, unless an admin
+	// key is used.
+	AppendOnly bool
+	// KDF is used to derive the master key's user key from the password.
+	KDF string
+}
+
+// ErrAppendOnly is returned when trying to remove data from an append-only
+// repository without using an admin key.
+var ErrAppendOnly = errors.Fatal("the repository is append-only, removing data requires an admin key")
+
 // CompressionMode configures if data should be compressed.
 type CompressionMode uint
 
@@ -131,6 +160,7 @@ func New(be backend.Backend, opts Options) (*Repository, error) {
 // setConfig assigns the given config and updates the repository parameters accordingly
 func (r *Repository) setConfig(cfg restic.Config) {
 	r.cfg = cfg
+	r.zeroChunk = restic.Hash(make([]byte, cfg.ChunkerParams().MinSize))
 }
 
 // Config returns the repository configuration.
@@ -217,6 +247,12 @@ func sortCachedPacksFirst(cache haver, blobs []restic.PackedBlob) {
 func (r *Repository) LoadBlob(ctx context.Context, t restic.BlobType, id restic.ID, buf []byte) ([]byte, error) {
 	debug.Log("load %v with id %v (buf len %v, cap %d)", t, id, len(buf), cap(buf))
 
+	if t == restic.DataBlob {
+		if err := r.CheckPermission(PermRead); err != nil {
+			return nil, err
+		}
+	}
+
 	// lookup packs
 	blobs := r.idx.Lookup(restic.BlobHandle{ID: id, Type: t})
 	if len(blobs) == 0 {
@@ -447,6 +483,12 @@ func (r *Repository) decompressUnpacked(p []byte) ([]byte, error) {
 // SaveUnpacked encrypts data and stores it in the backend. Returned is the
 // storage hash.
 func (r *Repository) SaveUnpacked(ctx context.Context, t restic.FileType, buf []byte) (id restic.ID, err error) {
+	if t != restic.LockFile {
+		if err := r.CheckPermission(PermWrite); err != nil {
+			return restic.ID{}, err
+		}
+	}
+
 	p := buf
 	if t != restic.ConfigFile {
 		p, err = r.compressUnpacked(p)
@@ -509,6 +551,11 @@ func (r *Repository) verifyUnpacked(buf []byte, t restic.FileType, expected []by
 
 func (r *Repository) RemoveUnpacked(ctx context.Context, t restic.FileType, id restic.ID) error {
 	// TODO prevent everything except removing snapshots for non-repository code
+	if t != restic.LockFile {
+		if err := r.CheckPermission(PermRemove); err != nil {
+			return err
+		}
+	}
 	return r.be.Remove(ctx, backend.Handle{Type: t, Name: id.String()})
 }
 
@@ -521,6 +568,41 @@ func (r *Repository) Flush(ctx context.Context) error {
 	return r.idx.SaveIndex(ctx, r)
 }
 
+// FlushPending saves all blobs which have been added so far and the index.
+// In contrast to Flush, the pack uploader keeps running and blobs can be
+// saved concurrently. This allows saving checkpoints during a backup.
+func (r *Repository) FlushPending(ctx context.Context) error {
+	if r.packerWg == nil {
+		return errors.New("pack uploader is not running")
+	}
+
+	// blobs which are already pending may still be prepared by another
+	// goroutine and only be added to a packer later on
+	pending := r.idx.PendingBlobs()
+	for {
+		if err := r.treePM.Flush(ctx); err != nil {
+			return err
+		}
+		if err := r.dataPM.Flush(ctx); err != nil {
+			return err
+		}
+		if err := r.uploader.Wait(ctx); err != nil {
+			return err
+		}
+
+		if !r.idx.AnyPending(pending) {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+
+	return r.idx.SaveIndex(ctx, r)
+}
+
 func (r *Repository) StartPackUploader(ctx context.Context, wg *errgroup.Group) {
 	if r.packerWg != nil {
 		panic("uploader already started")
@@ -736,13 +818,19 @@ func (r *Repository) SearchKey(ctx context.Context, password string, maxKeys int
 
 	oldKey := r.key
 	oldKeyID := r.keyID
+	oldKeyRole := r.keyRole
+	oldKeyKDF := r.keyKDF
 
 	r.key = key.master
 	r.keyID = key.ID()
+	r.keyRole = key.Role
+	r.keyKDF = key.KDF
 	cfg, err := restic.LoadConfig(ctx, r)
 	if err != nil {
 		r.key = oldKey
 		r.keyID = oldKeyID
+		r.keyRole = oldKeyRole
+		r.keyKDF = oldKeyKDF
 
 		if err == crypto.ErrUnauthenticated {
 			return fmt.Errorf("config or key %v is damaged: %w", key.ID(), err)
@@ -755,8 +843,8 @@ func (r *Repository) SearchKey(ctx context.Context, password string, maxKeys int
 }
 
 // Init creates a new master key with the supplied password, initializes and
-// saves the repository config.
-func (r *Repository) Init(ctx context.Context, version uint, password string, chunkerPolynomial *chunker.Pol) error {
+// saves the repository config. The master key is an admin key.
+func (r *Repository) Init(ctx context.Context, version uint, password string, opts InitOptions) error {
 	if version > restic.MaxRepoVersion {
 		return fmt.Errorf("repository version %v too high", version)
 	}
@@ -794,23 +882,32 @@ func (r *Repository) Init(ctx context.Context, version uint, password string, ch
 	if err != nil {
 		return err
 	}
-	if chunkerPolynomial != nil {
-		cfg.ChunkerPolynomial = *chunkerPolynomial
+	if opts.ChunkerPolynomial != nil {
+		cfg.ChunkerPolynomial = *opts.ChunkerPolynomial
 	}
+	cfg.ChunkerMinSize = opts.ChunkerMinSize
+	cfg.ChunkerAvgSize = opts.ChunkerAvgSize
+	cfg.ChunkerMaxSize = opts.ChunkerMaxSize
+	if err := cfg.ChunkerParams().Check(); err != nil {
+		return err
+	}
+	cfg.AppendOnly = opts.AppendOnly
 
-	return r.init(ctx, password, cfg)
+	return r.init(ctx, password, opts.KDF, cfg)
 }
 
 // init creates a new master key with the supplied password and uses it to save
 // the config into the repo.
-func (r *Repository) init(ctx context.Context, password string, cfg restic.Config) error {
-	key, err := createMasterKey(ctx, r, password)
+func (r *Repository) init(ctx context.Context, password, kdf string, cfg restic.Config) error {
+	key, err := createMasterKey(ctx, r, password, kdf)
 	if err != nil {
 		return err
 	}
 
 	r.key = key.master
 	r.keyID = key.ID()
+	r.keyRole = key.Role
+	r.keyKDF = key.KDF
 	r.setConfig(cfg)
 	return restic.SaveConfig(ctx, r, cfg)
 }
@@ -825,6 +922,16 @@ func (r *Repository) KeyID() restic.ID {
 	return r.keyID
 }
 
+// KeyRole returns the role of the current key.
+func (r *Repository) KeyRole() KeyRole {
+	return r.keyRole
+}
+
+// KeyKDF returns the KDF used by the current key.
+func (r *Repository) KeyKDF() string {
+	return r.keyKDF
+}
+
 // List runs fn for all files of type t in the repo.
 func (r *Repository) List(ctx context.Context, t restic.FileType, fn func(restic.ID, int64) error) error {
 	return r.be.List(ctx, t, func(fi backend.FileInfo) error {
@@ -874,6 +981,9 @@ func (r *Repository) Close() error {
 // If the blob was not known before, it returns the number of bytes the blob
 // occupies in the repo (compressed or not, including encryption overhead).
 func (r *Repository) SaveBlob(ctx context.Context, t restic.BlobType, buf []byte, id restic.ID, storeDuplicate bool) (newID restic.ID, known bool, size int, err error) {
+	if err := r.CheckPermission(PermWrite); err != nil {
+		return restic.ID{}, false, 0, err
+	}
 
 	if int64(len(buf)) > math.MaxUint32 {
 		return restic.ID{}, false, 0, fmt.Errorf("blob is larger than 4GB")
@@ -884,8 +994,9 @@ func (r *Repository) SaveBlob(ctx context.Context, t restic.BlobType, buf []byte
 		// Special case the hash calculation for all zero chunks. This is especially
 		// useful for sparse files containing large all zero regions. For these we can
 		// process chunks as fast as we can read the from disk.
-		if len(buf) == chunker.MinSize && restic.ZeroPrefixLen(buf) == chunker.MinSize {
-			newID = ZeroChunk()
+		minSize := int(r.cfg.ChunkerParams().MinSize)
+		if len(buf) == minSize && restic.ZeroPrefixLen(buf) == minSize {
+			newID = r.ZeroChunk()
 		} else {
 			newID = restic.Hash(buf)
 		}
@@ -916,6 +1027,14 @@ const maxUnusedRange = 1 * 1024 * 1024
 // then LoadBlobsFromPack will abort and not retry it. The buf passed to the callback is only valid within
 // this specific call. The callback must not keep a reference to buf.
 func (r *Repository) LoadBlobsFromPack(ctx context.Context, packID restic.ID, blobs []restic.Blob, handleBlobFn func(blob restic.BlobHandle, buf []byte, err error) error) error {
+	for _, blob := range blobs {
+		if blob.Type == restic.DataBlob {
+			if err := r.CheckPermission(PermRead); err != nil {
+				return err
+			}
+			break
+		}
+	}
 	return streamPack(ctx, r.be.Load, r.LoadBlob, r.getZstdDecoder(), r.key, packID, blobs, handleBlobFn)
 }
 
@@ -1162,13 +1281,8 @@ func (b *packBlobIterator) Next() (packBlobValue, error) {
 	return packBlobValue{entry.BlobHandle, plaintext, err}, nil
 }
 
-var zeroChunkOnce sync.Once
-var zeroChunkID restic.ID
-
-// ZeroChunk computes and returns (cached) the ID of an all-zero chunk with size chunker.MinSize
-func ZeroChunk() restic.ID {
-	zeroChunkOnce.Do(func() {
-		zeroChunkID = restic.Hash(make([]byte, chunker.MinSize))
-	})
-	return zeroChunkID
+// ZeroChunk returns the ID of an all-zero chunk with the minimum chunk size
+// configured for the repository.
+func (r *Repository) ZeroChunk() restic.ID {
+	return r.zeroChunk
 }
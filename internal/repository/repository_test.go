@@ -416,6 +416,36 @@ func testRepositoryIncrementalIndex(t *testing.T, version uint) {
 
 }
 
+func TestFlushPending(t *testing.T) {
+	repo, _ := repository.TestRepositoryWithVersion(t, 0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var wg errgroup.Group
+	repo.StartPackUploader(ctx, &wg)
+
+	data := []byte("checkpoint")
+	id, _, _, err := repo.SaveBlob(ctx, restic.DataBlob, data, restic.ID{}, false)
+	rtest.OK(t, err)
+	rtest.OK(t, repo.FlushPending(ctx))
+
+	// the blob is stored and the index is saved while the uploader keeps running
+	rtest.Assert(t, len(repo.LookupBlob(restic.DataBlob, id)) == 1, "blob not found in index")
+	var indexes int
+	rtest.OK(t, repo.List(ctx, restic.IndexFile, func(restic.ID, int64) error {
+		indexes++
+		return nil
+	}))
+	rtest.Equals(t, 1, indexes)
+
+	other, _, _, err := repo.SaveBlob(ctx, restic.DataBlob, []byte("more data"), restic.ID{}, false)
+	rtest.OK(t, err)
+	rtest.OK(t, repo.Flush(ctx))
+	rtest.Assert(t, len(repo.LookupBlob(restic.DataBlob, other)) == 1, "blob not found in index")
+
+	rtest.Assert(t, repo.FlushPending(ctx) != nil, "FlushPending succeeded without running uploader")
+}
+
 func TestInvalidCompression(t *testing.T) {
 	var comp repository.CompressionMode
 	err := comp.Set("nope")
@@ -466,12 +496,12 @@ func TestNoDoubleInit(t *testing.T) {
 	rtest.OK(t, err)
 
 	pol := r.Config().ChunkerPolynomial
-	err = repo.Init(context.TODO(), r.Config().Version, test.TestPassword, &pol)
+	err = repo.Init(context.TODO(), r.Config().Version, test.TestPassword, repository.InitOptions{ChunkerPolynomial: &pol})
 	rtest.Assert(t, strings.Contains(err.Error(), "repository master key and config already initialized"), "expected config exist error, got %q", err)
 
 	// must also prevent init if only keys exist
 	rtest.OK(t, be.Remove(context.TODO(), backend.Handle{Type: backend.ConfigFile}))
-	err = repo.Init(context.TODO(), r.Config().Version, test.TestPassword, &pol)
+	err = repo.Init(context.TODO(), r.Config().Version, test.TestPassword, repository.InitOptions{ChunkerPolynomial: &pol})
 	rtest.Assert(t, strings.Contains(err.Error(), "repository already contains keys"), "expected already contains keys error, got %q", err)
 
 	// must also prevent init if a snapshot exists and keys were deleted
@@ -481,6 +511,151 @@ func TestNoDoubleInit(t *testing.T) {
 	rtest.OK(t, be.List(context.TODO(), restic.KeyFile, func(fi backend.FileInfo) error {
 		return be.Remove(context.TODO(), backend.Handle{Type: restic.KeyFile, Name: fi.Name})
 	}))
-	err = repo.Init(context.TODO(), r.Config().Version, test.TestPassword, &pol)
+	err = repo.Init(context.TODO(), r.Config().Version, test.TestPassword, repository.InitOptions{ChunkerPolynomial: &pol})
 	rtest.Assert(t, strings.Contains(err.Error(), "repository already contains snapshots"), "expected already contains snapshots error, got %q", err)
 }
+
+func TestAppendOnly(t *testing.T) {
+	repository.TestUseLowSecurityKDFParameters(t)
+	restic.TestDisableCheckPolynomial(t)
+
+	be := repository.TestBackend(t)
+	admin, err := repository.New(be, repository.Options{})
+	rtest.OK(t, err)
+	rtest.OK(t, admin.Init(context.TODO(), restic.StableRepoVersion, test.TestPassword, repository.InitOptions{AppendOnly: true}))
+	rtest.Equals(t, repository.RoleAdmin, admin.KeyRole())
+	rtest.OK(t, admin.CheckPermission(repository.PermRemove))
+
+	key, err := repository.AddKey(context.TODO(), admin, "other", "", "", repository.RoleDefault, "", admin.Key())
+	rtest.OK(t, err)
+
+	repo, err := repository.New(be, repository.Options{})
+	rtest.OK(t, err)
+	rtest.OK(t, repo.SearchKey(context.TODO(), "other", 0, ""))
+	err = repo.CheckPermission(repository.PermRemove)
+	rtest.Assert(t, errors.Is(err, repository.ErrAppendOnly), "unexpected error %v", err)
+
+	snID, err := repo.SaveUnpacked(context.TODO(), restic.SnapshotFile, []byte("snapshot"))
+	rtest.OK(t, err)
+	err = repo.RemoveUnpacked(context.TODO(), restic.SnapshotFile, snID)
+	rtest.Assert(t, errors.Is(err, repository.ErrAppendOnly), "unexpected error %v", err)
+
+	// lock files must remain removable
+	lockID, err := repo.SaveUnpacked(context.TODO(), restic.LockFile, []byte("lock"))
+	rtest.OK(t, err)
+	rtest.OK(t, repo.RemoveUnpacked(context.TODO(), restic.LockFile, lockID))
+
+	_, err = repository.AddKey(context.TODO(), repo, "third", "", "", repository.RoleAdmin, "", repo.Key())
+	rtest.Assert(t, errors.Is(err, repository.ErrAppendOnly), "unexpected error %v", err)
+	err = repository.RemoveKey(context.TODO(), repo, admin.KeyID())
+	rtest.Assert(t, errors.Is(err, repository.ErrAppendOnly), "unexpected error %v", err)
+
+	rtest.OK(t, admin.RemoveUnpacked(context.TODO(), restic.SnapshotFile, snID))
+	rtest.OK(t, repository.RemoveKey(context.TODO(), admin, key.ID()))
+}
+
+func TestKeyRoles(t *testing.T) {
+	repository.TestUseLowSecurityKDFParameters(t)
+	restic.TestDisableCheckPolynomial(t)
+
+	be := repository.TestBackend(t)
+	admin, err := repository.New(be, repository.Options{})
+	rtest.OK(t, err)
+	rtest.OK(t, admin.Init(context.TODO(), restic.StableRepoVersion, test.TestPassword, repository.InitOptions{}))
+
+	var wg errgroup.Group
+	admin.StartPackUploader(context.TODO(), &wg)
+	blobID, _, _, err := admin.SaveBlob(context.TODO(), restic.DataBlob, []byte("file content"), restic.ID{}, false)
+	rtest.OK(t, err)
+	rtest.OK(t, admin.Flush(context.TODO()))
+
+	openWithRole := func(role repository.KeyRole) *repository.Repository {
+		password := "password-" + role.String()
+		_, err := repository.AddKey(context.TODO(), admin, password, "", "", role, "", admin.Key())
+		rtest.OK(t, err)
+
+		repo, err := repository.New(be, repository.Options{})
+		rtest.OK(t, err)
+		rtest.OK(t, repo.SearchKey(context.TODO(), password, 0, ""))
+		rtest.Equals(t, role, repo.KeyRole())
+		rtest.OK(t, repo.LoadIndex(context.TODO(), nil))
+		return repo
+	}
+
+	readOnly := openWithRole(repository.RoleReadOnly)
+	_, err = readOnly.LoadBlob(context.TODO(), restic.DataBlob, blobID, nil)
+	rtest.OK(t, err)
+	_, err = readOnly.SaveUnpacked(context.TODO(), restic.SnapshotFile, []byte("snapshot"))
+	rtest.Assert(t, errors.Is(err, repository.ErrWriteNotPermitted), "unexpected error %v", err)
+	_, _, _, err = readOnly.SaveBlob(context.TODO(), restic.DataBlob, []byte("other"), restic.ID{}, false)
+	rtest.Assert(t, errors.Is(err, repository.ErrWriteNotPermitted), "unexpected error %v", err)
+
+	backupOnly := openWithRole(repository.RoleBackupOnly)
+	_, err = backupOnly.LoadBlob(context.TODO(), restic.DataBlob, blobID, nil)
+	rtest.Assert(t, errors.Is(err, repository.ErrReadNotPermitted), "unexpected error %v", err)
+	snID, err := backupOnly.SaveUnpacked(context.TODO(), restic.SnapshotFile, []byte("snapshot"))
+	rtest.OK(t, err)
+	err = backupOnly.RemoveUnpacked(context.TODO(), restic.SnapshotFile, snID)
+	rtest.Assert(t, errors.Is(err, repository.ErrRemoveNotPermitted), "unexpected error %v", err)
+
+	for _, repo := range []*repository.Repository{readOnly, backupOnly} {
+		// lock files are required for all operations
+		lockID, err := repo.SaveUnpacked(context.TODO(), restic.LockFile, []byte("lock"))
+		rtest.OK(t, err)
+		rtest.OK(t, repo.RemoveUnpacked(context.TODO(), restic.LockFile, lockID))
+
+		_, err = repository.AddKey(context.TODO(), repo, "other", "", "", repository.RoleDefault, "", repo.Key())
+		rtest.Assert(t, errors.Is(err, repository.ErrManageKeysNotPermitted), "unexpected error %v", err)
+	}
+
+	_, err = repository.AddKey(context.TODO(), admin, "other", "", "", repository.KeyRole("invalid"), "", admin.Key())
+	rtest.Assert(t, err != nil, "adding a key with an invalid role did not fail")
+}
+
+func TestKeyKDFArgon2id(t *testing.T) {
+	repository.TestUseLowSecurityKDFParameters(t)
+	restic.TestDisableCheckPolynomial(t)
+
+	be := repository.TestBackend(t)
+	repo, err := repository.New(be, repository.Options{})
+	rtest.OK(t, err)
+	rtest.OK(t, repo.Init(context.TODO(), restic.StableRepoVersion, test.TestPassword, repository.InitOptions{KDF: repository.KDFArgon2id}))
+	rtest.Equals(t, repository.KDFArgon2id, repo.KeyKDF())
+
+	key, err := repository.AddKey(context.TODO(), repo, "other", "", "", repository.RoleDefault, repository.KDFScrypt, repo.Key())
+	rtest.OK(t, err)
+	rtest.Equals(t, repository.KDFScrypt, key.KDF)
+
+	for password, kdf := range map[string]string{test.TestPassword: repository.KDFArgon2id, "other": repository.KDFScrypt} {
+		reopened, err := repository.New(be, repository.Options{})
+		rtest.OK(t, err)
+		rtest.OK(t, reopened.SearchKey(context.TODO(), password, 0, ""))
+		rtest.Equals(t, kdf, reopened.KeyKDF())
+	}
+
+	_, err = repository.AddKey(context.TODO(), repo, "third", "", "", repository.RoleDefault, "invalid", repo.Key())
+	rtest.Assert(t, err != nil, "adding a key with an invalid KDF did not fail")
+}
+
+func TestZeroChunkMinSize(t *testing.T) {
+	repository.TestUseLowSecurityKDFParameters(t)
+	restic.TestDisableCheckPolynomial(t)
+
+	repo, err := repository.New(repository.TestBackend(t), repository.Options{})
+	rtest.OK(t, err)
+	rtest.OK(t, repo.Init(context.TODO(), restic.StableRepoVersion, test.TestPassword, repository.InitOptions{
+		ChunkerMinSize: 256 * 1024,
+		ChunkerAvgSize: 512 * 1024,
+		ChunkerMaxSize: 2 * 1024 * 1024,
+	}))
+
+	buf := make([]byte, 256*1024)
+	rtest.Equals(t, restic.Hash(buf), repo.ZeroChunk())
+
+	var wg errgroup.Group
+	repo.StartPackUploader(context.TODO(), &wg)
+	id, _, _, err := repo.SaveBlob(context.TODO(), restic.DataBlob, buf, restic.ID{}, false)
+	rtest.OK(t, err)
+	rtest.Equals(t, repo.ZeroChunk(), id)
+	rtest.OK(t, repo.Flush(context.TODO()))
+}
@@ -33,6 +33,11 @@ func TestUseLowSecurityKDFParameters(t logger) {
 			R: 1,
 			P: 1,
 		}
+		argon2Params = &crypto.Argon2Params{
+			Time:        1,
+			Memory:      64,
+			Parallelism: 1,
+		}
 	})
 }
 
@@ -64,7 +69,7 @@ func TestRepositoryWithBackend(t testing.TB, be backend.Backend, version uint, o
 		version = restic.StableRepoVersion
 	}
 	pol := testChunkerPol
-	err = repo.Init(context.TODO(), version, test.TestPassword, &pol)
+	err = repo.Init(context.TODO(), version, test.TestPassword, InitOptions{ChunkerPolynomial: &pol})
 	if err != nil {
 		t.Fatalf("TestRepository(): initialize repo failed: %v", err)
 	}
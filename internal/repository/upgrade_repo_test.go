@@ -18,7 +18,7 @@ func TestUpgradeRepoV2(t *testing.T) {
 		t.Fatal("test repo has wrong version")
 	}
 
-	err := UpgradeRepo(context.Background(), repo)
+	err := UpgradeRepo(context.Background(), repo, 2)
 	rtest.OK(t, err)
 }
 
@@ -60,12 +60,12 @@ func TestUpgradeRepoV2Failure(t *testing.T) {
 		t.Fatal("test repo has wrong version")
 	}
 
-	err := UpgradeRepo(context.Background(), repo)
+	err := UpgradeRepo(context.Background(), repo, 2)
 	if err == nil {
 		t.Fatal("expected error returned from Apply(), got nil")
 	}
 
-	upgradeErr := err.(*upgradeRepoV2Error)
+	upgradeErr := err.(*upgradeRepoError)
 	if upgradeErr.UploadNewConfigError == nil {
 		t.Fatal("expected upload error, got nil")
 	}
@@ -0,0 +1,93 @@
+package restic
+
+import (
+	"context"
+	"sort"
+
+	"github.com/chanhpng/vlbe/internal/errors"
+)
+
+// sameBackup reports whether sn was created on the host for exactly the given
+// absolute paths.
+func (sn *Snapshot) sameBackup(hostname string, paths []string) bool {
+	if sn.Hostname != hostname || len(sn.Paths) != len(paths) {
+		return false
+	}
+
+	a := append([]string(nil), sn.Paths...)
+	b := append([]string(nil), paths...)
+	sort.Strings(a)
+	sort.Strings(b)
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// StaleCheckpoints returns the checkpoints which are superseded by a complete
+// snapshot of the same host and paths, which was started at the same time or
+// later.
+func StaleCheckpoints(snapshots Snapshots) Snapshots {
+	var stale Snapshots
+	for _, cp := range snapshots {
+		if !cp.Checkpoint {
+			continue
+		}
+		for _, sn := range snapshots {
+			if !sn.Checkpoint && !sn.Time.Before(cp.Time) && sn.sameBackup(cp.Hostname, cp.Paths) {
+				stale = append(stale, cp)
+				break
+			}
+		}
+	}
+	return stale
+}
+
+// FindCheckpoint returns the newest checkpoint of an interrupted backup of
+// the paths on the host. It returns nil if the backup was completed later on
+// or no checkpoint exists.
+func FindCheckpoint(ctx context.Context, be Lister, loader LoaderUnpacked, hostname string, paths []string) (*Snapshot, error) {
+	var snapshots Snapshots
+	err := ForAllSnapshots(ctx, be, loader, nil, func(id ID, sn *Snapshot, err error) error {
+		if err != nil {
+			return errors.Errorf("Error loading snapshot %v: %v", id.Str(), err)
+		}
+		snapshots = append(snapshots, sn)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return LatestCheckpoint(snapshots, hostname, paths), nil
+}
+
+// LatestCheckpoint is like FindCheckpoint, but searches the already loaded
+// snapshots.
+func LatestCheckpoint(snapshots Snapshots, hostname string, paths []string) *Snapshot {
+	paths = absolutePaths(paths)
+
+	var same Snapshots
+	for _, sn := range snapshots {
+		if sn.sameBackup(hostname, paths) {
+			same = append(same, sn)
+		}
+	}
+
+	stale := NewIDSet()
+	for _, sn := range StaleCheckpoints(same) {
+		stale.Insert(*sn.ID())
+	}
+
+	var latest *Snapshot
+	for _, sn := range same {
+		if !sn.Checkpoint || stale.Has(*sn.ID()) {
+			continue
+		}
+		if latest == nil || sn.Time.After(latest.Time) {
+			latest = sn
+		}
+	}
+	return latest
+}
@@ -0,0 +1,33 @@
+package restic_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/chanhpng/vlbe/internal/restic"
+	rtest "github.com/chanhpng/vlbe/internal/test"
+)
+
+func TestStaleCheckpoints(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	snapshot := func(host string, paths []string, hours int, checkpoint bool) *restic.Snapshot {
+		return &restic.Snapshot{Hostname: host, Paths: paths, Time: base.Add(time.Duration(hours) * time.Hour), Checkpoint: checkpoint}
+	}
+
+	superseded := snapshot("foo", []string{"/a", "/b"}, 1, true)
+	sameTime := snapshot("foo", []string{"/a"}, 3, true)
+	snapshots := restic.Snapshots{
+		superseded,
+		sameTime,
+		snapshot("foo", []string{"/b", "/a"}, 2, false),
+		snapshot("foo", []string{"/a"}, 3, false),
+		// newer than the latest complete snapshot
+		snapshot("foo", []string{"/a", "/b"}, 4, true),
+		// different host or paths
+		snapshot("bar", []string{"/a", "/b"}, 0, true),
+		snapshot("foo", []string{"/a", "/c"}, 0, true),
+		snapshot("foo", []string{"/a", "/b", "/c"}, 5, false),
+	}
+
+	rtest.Equals(t, restic.Snapshots{superseded, sameTime}, restic.StaleCheckpoints(snapshots))
+}
@@ -0,0 +1,86 @@
+package restic
+
+import (
+	"io"
+	"math/bits"
+
+	"github.com/chanhpng/vlbe/internal/errors"
+	"github.com/restic/chunker"
+)
+
+const (
+	// DefaultChunkerAvgSize is the average chunk size of the chunker if not
+	// configured otherwise.
+	DefaultChunkerAvgSize = 1 << 20
+
+	// MinChunkerMinSize and MaxChunkerMaxSize limit the chunk sizes which can
+	// be configured for a repository.
+	MinChunkerMinSize = 64 * 1024
+	MaxChunkerMaxSize = 64 * 1024 * 1024
+)
+
+// ChunkerParams are the parameters of the content-defined chunker which splits
+// files into data blobs. All sizes are specified in bytes.
+type ChunkerParams struct {
+	Polynomial chunker.Pol
+	MinSize    uint
+	AvgSize    uint
+	MaxSize    uint
+}
+
+// ChunkerParams returns the chunker parameters of the repository. Chunk sizes
+// which are not set in the config use the chunker defaults.
+func (cfg Config) ChunkerParams() ChunkerParams {
+	p := ChunkerParams{
+		Polynomial: cfg.ChunkerPolynomial,
+		MinSize:    chunker.MinSize,
+		AvgSize:    DefaultChunkerAvgSize,
+		MaxSize:    chunker.MaxSize,
+	}
+	if cfg.ChunkerMinSize != 0 {
+		p.MinSize = cfg.ChunkerMinSize
+	}
+	if cfg.ChunkerAvgSize != 0 {
+		p.AvgSize = cfg.ChunkerAvgSize
+	}
+	if cfg.ChunkerMaxSize != 0 {
+		p.MaxSize = cfg.ChunkerMaxSize
+	}
+	return p
+}
+
+// Check returns an error if the chunk sizes are invalid.
+func (p ChunkerParams) Check() error {
+	if p.AvgSize == 0 || p.AvgSize&(p.AvgSize-1) != 0 {
+		return errors.Errorf("average chunk size %d is not a power of two", p.AvgSize)
+	}
+	if p.MinSize < MinChunkerMinSize {
+		return errors.Errorf("minimum chunk size %d is smaller than %d", p.MinSize, MinChunkerMinSize)
+	}
+	if p.MaxSize > MaxChunkerMaxSize {
+		return errors.Errorf("maximum chunk size %d is larger than %d", p.MaxSize, MaxChunkerMaxSize)
+	}
+	if p.MinSize >= p.AvgSize || p.AvgSize >= p.MaxSize {
+		return errors.Errorf("chunk sizes must satisfy min < avg < max, got %d, %d and %d", p.MinSize, p.AvgSize, p.MaxSize)
+	}
+	return nil
+}
+
+// NewChunker returns a chunker using the parameters which reads from rd.
+func (p ChunkerParams) NewChunker(rd io.Reader) *chunker.Chunker {
+	return chunker.New(rd, p.Polynomial,
+		chunker.WithBoundaries(p.MinSize, p.MaxSize),
+		chunker.WithAverageBits(p.averageBits()))
+}
+
+// ResetChunker reinitializes c to use the parameters and read from rd. The
+// buffer of c is reused.
+func (p ChunkerParams) ResetChunker(c *chunker.Chunker, rd io.Reader) {
+	c.Reset(rd, p.Polynomial,
+		chunker.WithBoundaries(p.MinSize, p.MaxSize),
+		chunker.WithAverageBits(p.averageBits()))
+}
+
+func (p ChunkerParams) averageBits() int {
+	return bits.Len(p.AvgSize) - 1
+}
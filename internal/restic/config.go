@@ -17,10 +17,23 @@ type Config struct {
 	Version           uint        `json:"version"`
 	ID                string      `json:"id"`
 	ChunkerPolynomial chunker.Pol `json:"chunker_polynomial"`
+	// ChunkerMinSize, ChunkerAvgSize and ChunkerMaxSize override the default
+	// chunk sizes if set, see ChunkerParams.
+	ChunkerMinSize uint `json:"chunker_min_size,omitempty"`
+	ChunkerAvgSize uint `json:"chunker_avg_size,omitempty"`
+	ChunkerMaxSize uint `json:"chunker_max_size,omitempty"`
+	// AppendOnly restricts removing data to admin keys. It is advisory only,
+	// clients which do not know the field ignore it.
+	AppendOnly bool `json:"append_only,omitempty"`
 }
 
+// Repository format versions:
+//
+//  1. initial format
+//  2. compression of blobs and unpacked files
+//  3. device IDs are only stored for hardlinked files
 const MinRepoVersion = 1
-const MaxRepoVersion = 2
+const MaxRepoVersion = 3
 
 // StableRepoVersion is the version that is written to the config when a repository
 // is newly created with Init().
@@ -84,6 +97,10 @@ func LoadConfig(ctx context.Context, r LoaderUnpacked) (Config, error) {
 		}
 	}
 
+	if err := cfg.ChunkerParams().Check(); err != nil {
+		return Config{}, errors.Wrap(err, "invalid chunker parameters")
+	}
+
 	return cfg, nil
 }
 
@@ -1,11 +1,14 @@
 package restic_test
 
 import (
+	"bytes"
 	"context"
+	"io"
 	"testing"
 
 	"github.com/chanhpng/vlbe/internal/restic"
 	rtest "github.com/chanhpng/vlbe/internal/test"
+	"github.com/restic/chunker"
 )
 
 type saver struct {
@@ -63,3 +66,58 @@ func TestConfig(t *testing.T) {
 	rtest.Assert(t, cfg1 == cfg2,
 		"configs aren't equal: %v != %v", cfg1, cfg2)
 }
+
+func TestChunkerParams(t *testing.T) {
+	var cfg restic.Config
+	rtest.OK(t, cfg.ChunkerParams().Check())
+
+	for _, test := range []struct {
+		min, avg, max uint
+		valid         bool
+	}{
+		{0, 0, 0, true},
+		{1 << 20, 4 << 20, 16 << 20, true},
+		{64 << 10, 128 << 10, 256 << 10, true},
+		{0, 3 << 20, 0, false},
+		{32 << 10, 0, 0, false},
+		{0, 0, 128 << 20, false},
+		{2 << 20, 1 << 20, 0, false},
+		{0, 8 << 20, 8 << 20, false},
+	} {
+		cfg := restic.Config{ChunkerMinSize: test.min, ChunkerAvgSize: test.avg, ChunkerMaxSize: test.max}
+		err := cfg.ChunkerParams().Check()
+		rtest.Assert(t, (err == nil) == test.valid, "unexpected result for %v/%v/%v: %v", test.min, test.avg, test.max, err)
+	}
+}
+
+func TestChunkerParamsBoundaries(t *testing.T) {
+	pol, err := chunker.RandomPolynomial()
+	rtest.OK(t, err)
+
+	params := restic.Config{
+		ChunkerPolynomial: pol,
+		ChunkerMinSize:    64 << 10,
+		ChunkerAvgSize:    128 << 10,
+		ChunkerMaxSize:    256 << 10,
+	}.ChunkerParams()
+
+	data := rtest.Random(23, 8<<20)
+	c := params.NewChunker(bytes.NewReader(data))
+	buf := make([]byte, params.MaxSize)
+
+	total := 0
+	for {
+		chunk, err := c.Next(buf)
+		if err == io.EOF {
+			break
+		}
+		rtest.OK(t, err)
+
+		total += int(chunk.Length)
+		if total < len(data) {
+			rtest.Assert(t, chunk.Length >= params.MinSize && chunk.Length <= params.MaxSize,
+				"chunk size %v outside of boundaries", chunk.Length)
+		}
+	}
+	rtest.Equals(t, len(data), total)
+}
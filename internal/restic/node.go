@@ -523,6 +523,19 @@ func (node Node) sameContent(other Node) bool {
 	return true
 }
 
+// ContentKey returns a key which is equal for two nodes if they have the same
+// type and content. The content of a symlink is its target.
+func (node Node) ContentKey() string {
+	var key strings.Builder
+	key.WriteString(node.Type)
+	key.WriteByte(0)
+	for _, id := range node.Content {
+		key.Write(id[:])
+	}
+	key.WriteString(node.LinkTarget)
+	return key.String()
+}
+
 func (node Node) sameExtendedAttributes(other Node) bool {
 	ln := len(node.ExtendedAttributes)
 	lo := len(other.ExtendedAttributes)
@@ -20,6 +20,9 @@ type Repository interface {
 	Connections() uint
 	Config() Config
 	Key() *crypto.Key
+	// ZeroChunk returns the ID of an all-zero chunk with the minimum chunk
+	// size of the repository.
+	ZeroChunk() ID
 
 	LoadIndex(ctx context.Context, p *progress.Counter) error
 	SetIndex(mi MasterIndex) error
@@ -44,6 +47,9 @@ type Repository interface {
 	StartPackUploader(ctx context.Context, wg *errgroup.Group)
 	SaveBlob(ctx context.Context, t BlobType, buf []byte, id ID, storeDuplicate bool) (newID ID, known bool, size int, err error)
 	Flush(ctx context.Context) error
+	// FlushPending uploads and indexes all blobs saved so far while the pack
+	// uploader keeps running.
+	FlushPending(ctx context.Context) error
 
 	// List calls the function fn for each file of type t in the repository.
 	// When an error is returned by fn, processing stops and List() returns the
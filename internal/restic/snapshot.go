@@ -27,6 +27,11 @@ type Snapshot struct {
 
 	ProgramVersion string           `json:"program_version,omitempty"`
 	Summary        *SnapshotSummary `json:"summary,omitempty"`
+	Hold           *SnapshotHold    `json:"hold,omitempty"`
+
+	// Checkpoint is set for the partial snapshots saved during a backup. They
+	// are ignored unless the snapshot ID is given explicitly.
+	Checkpoint bool `json:"checkpoint,omitempty"`
 
 	id *ID // plaintext ID, used during restore
 }
@@ -50,21 +55,29 @@ type SnapshotSummary struct {
 	TotalBytesProcessed uint64 `json:"total_bytes_processed"`
 }
 
+// SnapshotHold protects a snapshot from being removed, either indefinitely
+// or until the given time.
+type SnapshotHold struct {
+	Until  *time.Time `json:"until,omitempty"`
+	Reason string     `json:"reason,omitempty"`
+}
+
+func (h SnapshotHold) String() string {
+	s := "on hold"
+	if h.Until != nil {
+		s += " until " + h.Until.Format("2006-01-02 15:04:05")
+	}
+	if h.Reason != "" {
+		s += fmt.Sprintf(" (%v)", h.Reason)
+	}
+	return s
+}
+
 // NewSnapshot returns an initialized snapshot struct for the current user and
 // time.
 func NewSnapshot(paths []string, tags []string, hostname string, time time.Time) (*Snapshot, error) {
-	absPaths := make([]string, 0, len(paths))
-	for _, path := range paths {
-		p, err := filepath.Abs(path)
-		if err == nil {
-			absPaths = append(absPaths, p)
-		} else {
-			absPaths = append(absPaths, path)
-		}
-	}
-
 	sn := &Snapshot{
-		Paths:    absPaths,
+		Paths:    absolutePaths(paths),
 		Time:     time,
 		Tags:     tags,
 		Hostname: hostname,
@@ -78,6 +91,21 @@ func NewSnapshot(paths []string, tags []string, hostname string, time time.Time)
 	return sn, nil
 }
 
+// absolutePaths returns the absolute version of all paths. Paths for which
+// this fails are returned unchanged.
+func absolutePaths(paths []string) []string {
+	absPaths := make([]string, 0, len(paths))
+	for _, path := range paths {
+		p, err := filepath.Abs(path)
+		if err == nil {
+			absPaths = append(absPaths, p)
+		} else {
+			absPaths = append(absPaths, path)
+		}
+	}
+	return absPaths
+}
+
 // LoadSnapshot loads the snapshot with the id and returns it.
 func LoadSnapshot(ctx context.Context, loader LoaderUnpacked, id ID) (*Snapshot, error) {
 	sn := &Snapshot{id: &id}
@@ -248,6 +276,14 @@ func (sn *Snapshot) HasHostname(hostnames []string) bool {
 	return false
 }
 
+// IsHeld returns true if the snapshot is on hold at time now.
+func (sn *Snapshot) IsHeld(now time.Time) bool {
+	if sn.Hold == nil {
+		return false
+	}
+	return sn.Hold.Until == nil || sn.Hold.Until.After(now)
+}
+
 // Snapshots is a list of snapshots.
 type Snapshots []*Snapshot
 
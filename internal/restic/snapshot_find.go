@@ -29,46 +29,57 @@ func (f *SnapshotFilter) Empty() bool {
 }
 
 func (f *SnapshotFilter) matches(sn *Snapshot) bool {
-	return sn.HasHostname(f.Hosts) && sn.HasTagList(f.Tags) && sn.HasPaths(f.Paths)
+	return !sn.Checkpoint && sn.HasHostname(f.Hosts) && sn.HasTagList(f.Tags) && sn.HasPaths(f.Paths)
 }
 
-// findLatest finds the latest snapshot with optional target/directory,
-// tags, hostname, and timestamp filters.
-func (f *SnapshotFilter) findLatest(ctx context.Context, be Lister, loader LoaderUnpacked) (*Snapshot, error) {
-
-	var err error
+// absolutePaths makes the paths of the filter absolute.
+func (f *SnapshotFilter) absolutePaths() error {
 	absTargets := make([]string, 0, len(f.Paths))
 	for _, target := range f.Paths {
 		if !filepath.IsAbs(target) {
+			var err error
 			target, err = filepath.Abs(target)
 			if err != nil {
-				return nil, errors.Wrap(err, "Abs")
+				return errors.Wrap(err, "Abs")
 			}
 		}
 		absTargets = append(absTargets, filepath.Clean(target))
 	}
 	f.Paths = absTargets
+	return nil
+}
+
+// newer reports whether snapshot matches the filter and is not older than
+// latest, which may be nil.
+func (f *SnapshotFilter) newer(latest, snapshot *Snapshot) bool {
+	if !f.TimestampLimit.IsZero() && snapshot.Time.After(f.TimestampLimit) {
+		return false
+	}
+
+	if latest != nil && snapshot.Time.Before(latest.Time) {
+		return false
+	}
+
+	return f.matches(snapshot)
+}
+
+// findLatest finds the latest snapshot with optional target/directory,
+// tags, hostname, and timestamp filters.
+func (f *SnapshotFilter) findLatest(ctx context.Context, be Lister, loader LoaderUnpacked) (*Snapshot, error) {
+	if err := f.absolutePaths(); err != nil {
+		return nil, err
+	}
 
 	var latest *Snapshot
 
-	err = ForAllSnapshots(ctx, be, loader, nil, func(id ID, snapshot *Snapshot, err error) error {
+	err := ForAllSnapshots(ctx, be, loader, nil, func(id ID, snapshot *Snapshot, err error) error {
 		if err != nil {
 			return errors.Errorf("Error loading snapshot %v: %v", id.Str(), err)
 		}
 
-		if !f.TimestampLimit.IsZero() && snapshot.Time.After(f.TimestampLimit) {
-			return nil
-		}
-
-		if latest != nil && snapshot.Time.Before(latest.Time) {
-			return nil
-		}
-
-		if !f.matches(snapshot) {
-			return nil
+		if f.newer(latest, snapshot) {
+			latest = snapshot
 		}
-
-		latest = snapshot
 		return nil
 	})
 
@@ -83,6 +94,26 @@ func (f *SnapshotFilter) findLatest(ctx context.Context, be Lister, loader Loade
 	return latest, nil
 }
 
+// FindLatestOf returns the latest of the already loaded snapshots which
+// matches the filter, like FindLatest does for "latest".
+func (f *SnapshotFilter) FindLatestOf(snapshots Snapshots) (*Snapshot, error) {
+	if err := f.absolutePaths(); err != nil {
+		return nil, err
+	}
+
+	var latest *Snapshot
+	for _, snapshot := range snapshots {
+		if f.newer(latest, snapshot) {
+			latest = snapshot
+		}
+	}
+
+	if latest == nil {
+		return nil, ErrNoSnapshotFound
+	}
+	return latest, nil
+}
+
 func splitSnapshotID(s string) (id, subfolder string) {
 	id, subfolder, _ = strings.Cut(s, ":")
 	return
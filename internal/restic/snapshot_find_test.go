@@ -3,7 +3,9 @@ package restic_test
 import (
 	"context"
 	"testing"
+	"time"
 
+	"github.com/chanhpng/vlbe/internal/errors"
 	"github.com/chanhpng/vlbe/internal/repository"
 	"github.com/chanhpng/vlbe/internal/restic"
 	"github.com/chanhpng/vlbe/internal/test"
@@ -45,6 +47,30 @@ func TestFindLatestSnapshotWithMaxTimestamp(t *testing.T) {
 	}
 }
 
+func TestFindLatestOf(t *testing.T) {
+	base := parseTimeUTC("2017-07-07 07:07:07")
+	snapshots := restic.Snapshots{
+		{Hostname: "foo", Time: base},
+		{Hostname: "foo", Time: base.Add(time.Hour)},
+		{Hostname: "foo", Time: base.Add(2 * time.Hour), Checkpoint: true},
+		{Hostname: "bar", Time: base.Add(3 * time.Hour)},
+	}
+
+	f := restic.SnapshotFilter{Hosts: []string{"foo"}}
+	sn, err := f.FindLatestOf(snapshots)
+	test.OK(t, err)
+	test.Equals(t, snapshots[1], sn)
+
+	f = restic.SnapshotFilter{Hosts: []string{"foo"}, TimestampLimit: base.Add(time.Minute)}
+	sn, err = f.FindLatestOf(snapshots)
+	test.OK(t, err)
+	test.Equals(t, snapshots[0], sn)
+
+	f = restic.SnapshotFilter{Hosts: []string{"baz"}}
+	_, err = f.FindLatestOf(snapshots)
+	test.Assert(t, errors.Is(err, restic.ErrNoSnapshotFound), "unexpected error %v", err)
+}
+
 func TestFindLatestWithSubpath(t *testing.T) {
 	repo := repository.TestRepository(t)
 	restic.TestCreateSnapshot(t, repo, parseTimeUTC("2015-05-05 05:05:05"), 1)
@@ -144,15 +144,14 @@ func always(_ time.Time, nr int) int {
 	return nr
 }
 
-// findLatestTimestamp returns the time stamp for the latest (newest) snapshot,
-// for use with policies based on time relative to latest.
-func findLatestTimestamp(list Snapshots) time.Time {
+// findLatestTimestamp returns the time stamp for the latest (newest) snapshot
+// before now, for use with policies based on time relative to latest.
+func findLatestTimestamp(list Snapshots, now time.Time) time.Time {
 	if len(list) == 0 {
 		panic("list of snapshots is empty")
 	}
 
 	var latest time.Time
-	now := time.Now()
 	for _, sn := range list {
 		// Find the latest snapshot in the list
 		// The latest snapshot must, however, not be in the future.
@@ -184,9 +183,16 @@ type KeepReason struct {
 }
 
 // ApplyPolicy returns the snapshots from list that are to be kept and removed
-// according to the policy p. list is sorted in the process. reasons contains
-// the reasons to keep each snapshot, it is in the same order as keep.
+// according to the policy p. Snapshots which are on hold are always kept. list
+// is sorted in the process. reasons contains the reasons to keep each
+// snapshot, it is in the same order as keep.
 func ApplyPolicy(list Snapshots, p ExpirePolicy) (keep, remove Snapshots, reasons []KeepReason) {
+	return ApplyPolicyAt(list, p, time.Now())
+}
+
+// ApplyPolicyAt works like ApplyPolicy, but evaluates the policy as if the
+// current time was now.
+func ApplyPolicyAt(list Snapshots, p ExpirePolicy, now time.Time) (keep, remove Snapshots, reasons []KeepReason) {
 	// sort newest snapshots first
 	sort.Stable(list)
 
@@ -223,12 +229,18 @@ func ApplyPolicy(list Snapshots, p ExpirePolicy) (keep, remove Snapshots, reason
 		{p.WithinYearly, y, -1, "yearly within"},
 	}
 
-	latest := findLatestTimestamp(list)
+	latest := findLatestTimestamp(list, now)
 
 	for nr, cur := range list {
 		var keepSnap bool
 		var keepSnapReasons []string
 
+		// Snapshots on hold are always kept, regardless of the policy.
+		if cur.IsHeld(now) {
+			keepSnap = true
+			keepSnapReasons = append(keepSnapReasons, cur.Hold.String())
+		}
+
 		// Tags are handled specially as they are not counted.
 		for _, l := range p.Tags {
 			if cur.HasTags(l) {
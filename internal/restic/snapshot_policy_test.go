@@ -285,3 +285,34 @@ func TestApplyPolicy(t *testing.T) {
 		})
 	}
 }
+
+func TestApplyPolicyHold(t *testing.T) {
+	past := time.Now().Add(-time.Hour)
+	future := time.Now().Add(time.Hour)
+
+	list := restic.Snapshots{
+		{Time: parseTimeUTC("2016-01-04 12:00:00")},
+		{Time: parseTimeUTC("2016-01-03 12:00:00"), Hold: &restic.SnapshotHold{Reason: "case 42"}},
+		{Time: parseTimeUTC("2016-01-02 12:00:00"), Hold: &restic.SnapshotHold{Until: &future}},
+		{Time: parseTimeUTC("2016-01-01 12:00:00"), Hold: &restic.SnapshotHold{Until: &past}},
+	}
+
+	keep, remove, reasons := restic.ApplyPolicy(list, restic.ExpirePolicy{Last: 1})
+	if len(keep) != 3 || len(remove) != 1 {
+		t.Fatalf("expected 3 snapshots to be kept and 1 to be removed, got %d and %d", len(keep), len(remove))
+	}
+	if remove[0] != list[3] {
+		t.Errorf("snapshot with expired hold was not removed")
+	}
+
+	want := [][]string{
+		{"last snapshot"},
+		{"on hold (case 42)"},
+		{"on hold until " + future.Format("2006-01-02 15:04:05")},
+	}
+	for i, r := range reasons {
+		if !cmp.Equal(want[i], r.Matches) {
+			t.Errorf("wrong reasons for snapshot %d: %v", i, cmp.Diff(want[i], r.Matches))
+		}
+	}
+}
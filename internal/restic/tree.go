@@ -188,6 +188,19 @@ func (builder *TreeJSONBuilder) Finalize() ([]byte, error) {
 }
 
 func FindTreeDirectory(ctx context.Context, repo BlobLoader, id *ID, dir string) (*ID, error) {
+	node, err := FindTreeDirectoryNode(ctx, repo, id, dir)
+	if err != nil {
+		return nil, err
+	}
+	if node == nil {
+		return id, nil
+	}
+	return node.Subtree, nil
+}
+
+// FindTreeDirectoryNode returns the node of the directory dir within the tree
+// id. It returns nil for the root directory, which has no node.
+func FindTreeDirectoryNode(ctx context.Context, repo BlobLoader, id *ID, dir string) (*Node, error) {
 	if id == nil {
 		return nil, errors.New("tree id is null")
 	}
@@ -195,6 +208,7 @@ func FindTreeDirectory(ctx context.Context, repo BlobLoader, id *ID, dir string)
 	dirs := strings.Split(path.Clean(dir), "/")
 	subfolder := ""
 
+	var dirNode *Node
 	for _, name := range dirs {
 		if name == "" || name == "." {
 			continue
@@ -212,6 +226,7 @@ func FindTreeDirectory(ctx context.Context, repo BlobLoader, id *ID, dir string)
 			return nil, fmt.Errorf("path %s: not a directory", subfolder)
 		}
 		id = node.Subtree
+		dirNode = node
 	}
-	return id, nil
+	return dirNode, nil
 }
@@ -0,0 +1,89 @@
+package restorer
+
+import (
+	"context"
+	"io"
+	"path/filepath"
+
+	"github.com/chanhpng/vlbe/internal/debug"
+	"github.com/chanhpng/vlbe/internal/errors"
+	"github.com/chanhpng/vlbe/internal/fs"
+	"github.com/chanhpng/vlbe/internal/restic"
+	restoreui "github.com/chanhpng/vlbe/internal/ui/restore"
+)
+
+// isBlockDevice reports whether fi describes a block device. Tests replace it
+// to restore to regular files.
+var isBlockDevice = fs.IsBlockDevice
+
+// IsBlockDevice reports whether path is a block device.
+func IsBlockDevice(path string) bool {
+	fi, err := fs.Stat(path)
+	return err == nil && isBlockDevice(fi)
+}
+
+// RestoreToDevice writes the content of the file selected by SelectFilter to
+// the block device. Exactly one file must be selected. The device must be at
+// least as large as the file, data after the end of the file is left
+// untouched. With the sparse option, zero regions of the file are discarded on
+// the device instead of writing them.
+func (res *Restorer) RestoreToDevice(ctx context.Context, device string) error {
+	var file *restic.Node
+	var location string
+	files := 0
+
+	for _, src := range res.sources {
+		err := res.traverseSource(ctx, string(filepath.Separator), src, treeVisitor{
+			visitNode: func(node *restic.Node, _, nodeLocation string) error {
+				if node.Type != "file" {
+					return nil
+				}
+				files++
+				if file == nil {
+					file = node
+					location = nodeLocation
+				}
+				return nil
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if files != 1 {
+		return errors.Fatalf("restoring to a block device requires selecting exactly one file, %d files are selected", files)
+	}
+
+	f, err := openDevice(device)
+	if err != nil {
+		return err
+	}
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if size < int64(file.Size) {
+		return errors.Fatalf("%v with %d bytes is too small for %v with %d bytes", device, size, location, file.Size)
+	}
+
+	debug.Log("restore %v to device %v", location, device)
+	res.opts.Progress.AddFile(file.Size)
+	if res.opts.DryRun || len(file.Content) == 0 {
+		res.opts.Progress.AddProgress(location, restoreui.ActionFileRestored, file.Size, file.Size)
+		return nil
+	}
+
+	filerestorer := newFileRestorer(device, res.repo.LoadBlobsFromPack, res.repo.LookupBlob, res.repo.ZeroChunk(),
+		res.repo.Connections(), res.opts.Sparse, false, res.opts.Progress)
+	filerestorer.Error = res.Error
+	filerestorer.device = true
+	filerestorer.filesWriter.device = true
+
+	filerestorer.addFile(location, file.Content, int64(file.Size), nil)
+	return filerestorer.restoreFiles(ctx)
+}
@@ -0,0 +1,61 @@
+package restorer
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/chanhpng/vlbe/internal/fs"
+	"github.com/chanhpng/vlbe/internal/repository"
+	rtest "github.com/chanhpng/vlbe/internal/test"
+	"github.com/restic/chunker"
+)
+
+func TestRestoreToDevice(t *testing.T) {
+	// regular files are used in place of block devices
+	isBlockDevice = func(fi os.FileInfo) bool { return fi.Mode().IsRegular() }
+	defer func() {
+		isBlockDevice = fs.IsBlockDevice
+	}()
+
+	zeros := string(make([]byte, chunker.MinSize))
+	parts := []string{"head", zeros, "tail"}
+	content := []byte("head" + zeros + "tail")
+
+	repo := repository.TestRepository(t)
+	sn, _ := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"disk.img": File{DataParts: parts},
+			"other":    File{Data: "other"},
+		},
+	}, noopGetGenericAttributes)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// the old content after the end of the file must be kept
+	device := filepath.Join(rtest.TempDir(t), "device")
+	old := bytes.Repeat([]byte{0xff}, len(content)+100)
+	rtest.OK(t, os.WriteFile(device, old, 0o600))
+
+	res := NewRestorer(repo, sn, Options{Sparse: true})
+	err := res.RestoreToDevice(ctx, device)
+	rtest.Assert(t, err != nil, "restoring two files to a device did not fail")
+
+	res = NewRestorer(repo, sn, Options{Sparse: true})
+	res.SelectFilter = func(item string, _ bool) (bool, bool) {
+		return item == "/disk.img", false
+	}
+	rtest.OK(t, res.RestoreToDevice(ctx, device))
+
+	buf, err := os.ReadFile(device)
+	rtest.OK(t, err)
+	rtest.Equals(t, append(content, old[len(content):]...), buf)
+
+	// the device must be large enough to hold the file
+	rtest.OK(t, os.Truncate(device, int64(len(content)-1)))
+	err = res.RestoreToDevice(ctx, device)
+	rtest.Assert(t, err != nil, "restoring to a small device did not fail")
+}
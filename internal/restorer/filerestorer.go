@@ -9,7 +9,6 @@ import (
 
 	"github.com/chanhpng/vlbe/internal/debug"
 	"github.com/chanhpng/vlbe/internal/errors"
-	"github.com/chanhpng/vlbe/internal/repository"
 	"github.com/chanhpng/vlbe/internal/restic"
 	"github.com/chanhpng/vlbe/internal/ui/restore"
 )
@@ -58,11 +57,16 @@ type fileRestorer struct {
 	dst   string
 	files []*fileInfo
 	Error func(string, error) error
+
+	// device is set if dst is a block device, which the content of the
+	// restored file is written to
+	device bool
 }
 
 func newFileRestorer(dst string,
 	blobsLoader blobsLoaderFn,
 	idx func(restic.BlobType, restic.ID) []restic.PackedBlob,
+	zeroChunk restic.ID,
 	connections uint,
 	sparse bool,
 	allowRecursiveDelete bool,
@@ -75,7 +79,7 @@ func newFileRestorer(dst string,
 		idx:                  idx,
 		blobsLoader:          blobsLoader,
 		filesWriter:          newFilesWriter(workerCount, allowRecursiveDelete),
-		zeroChunk:            repository.ZeroChunk(),
+		zeroChunk:            zeroChunk,
 		sparse:               sparse,
 		progress:             progress,
 		allowRecursiveDelete: allowRecursiveDelete,
@@ -90,6 +94,9 @@ func (r *fileRestorer) addFile(location string, content restic.IDs, size int64,
 }
 
 func (r *fileRestorer) targetPath(location string) string {
+	if r.device {
+		return r.dst
+	}
 	return filepath.Join(r.dst, location)
 }
 
@@ -21,6 +21,8 @@ import (
 type filesWriter struct {
 	buckets              []filesWriterBucket
 	allowRecursiveDelete bool
+	// device is set if all files are written to an existing block device
+	device bool
 }
 
 type filesWriterBucket struct {
@@ -32,6 +34,7 @@ type partialFile struct {
 	*os.File
 	users  int // Reference count.
 	sparse bool
+	device bool
 }
 
 func newFilesWriter(count int, allowRecursiveDelete bool) *filesWriter {
@@ -62,6 +65,24 @@ func openFile(path string) (*os.File, error) {
 	return f, nil
 }
 
+// openDevice opens the block device at path for writing.
+func openDevice(path string) (*os.File, error) {
+	f, err := fs.OpenFile(path, fs.O_WRONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	if !isBlockDevice(fi) {
+		_ = f.Close()
+		return nil, fmt.Errorf("%q is not a block device", path)
+	}
+	return f, nil
+}
+
 func createFile(path string, createSize int64, sparse bool, allowRecursiveDelete bool) (*os.File, error) {
 	f, err := fs.OpenFile(path, fs.O_CREATE|fs.O_WRONLY|fs.O_NOFOLLOW, 0600)
 	if err != nil && fs.IsAccessDenied(err) {
@@ -176,7 +197,12 @@ func (w *filesWriter) writeToFile(path string, blob []byte, offset int64, create
 		}
 		var f *os.File
 		var err error
-		if createSize >= 0 {
+		if w.device {
+			// the content of the device is overwritten as is
+			if f, err = openDevice(path); err != nil {
+				return nil, err
+			}
+		} else if createSize >= 0 {
 			f, err = createFile(path, createSize, sparse, w.allowRecursiveDelete)
 			if err != nil {
 				return nil, err
@@ -185,7 +211,7 @@ func (w *filesWriter) writeToFile(path string, blob []byte, offset int64, create
 			return nil, err
 		}
 
-		wr := &partialFile{File: f, users: 1, sparse: sparse}
+		wr := &partialFile{File: f, users: 1, sparse: sparse, device: w.device}
 		bucket.files[path] = wr
 
 		return wr, nil
@@ -168,3 +168,40 @@ func TestCreateFileRecursiveDelete(t *testing.T) {
 	rtest.Assert(t, fi.Mode().IsRegular(), "wrong filetype %v", fi.Mode())
 	rtest.OK(t, f.Close())
 }
+
+func TestFilesWriterDevice(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test")
+	rtest.OK(t, os.WriteFile(path, []byte("data"), 0o600))
+
+	// only block devices are written to
+	w := newFilesWriter(1, false)
+	w.device = true
+	err := w.writeToFile(path, []byte{1}, 0, 1, false)
+	rtest.Assert(t, err != nil, "regular file was accepted as block device")
+
+	// zero regions must overwrite the old content, as the size of a device
+	// cannot be truncated
+	const size = 64 * 1024
+	old := make([]byte, size)
+	for i := range old {
+		old[i] = 0xff
+	}
+	rtest.OK(t, os.WriteFile(path, old, 0o600))
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	rtest.OK(t, err)
+	wr := &partialFile{File: f, sparse: true, device: true}
+
+	_, err = wr.WriteAt(make([]byte, size-100), 10)
+	rtest.OK(t, err)
+	_, err = wr.WriteAt([]byte{0, 0, 1}, size-3)
+	rtest.OK(t, err)
+	rtest.OK(t, f.Close())
+
+	expected := make([]byte, size)
+	copy(expected, old[:10])
+	copy(expected[size-100+10:], old[size-90:])
+	copy(expected[size-3:], []byte{0, 0, 1})
+	buf, err := os.ReadFile(path)
+	rtest.OK(t, err)
+	rtest.Equals(t, expected, buf)
+}
@@ -0,0 +1,204 @@
+package restorer
+
+import (
+	"context"
+	"path"
+	"sort"
+
+	"github.com/chanhpng/vlbe/internal/debug"
+	"github.com/chanhpng/vlbe/internal/errors"
+	"github.com/chanhpng/vlbe/internal/restic"
+)
+
+// NewHistoryRestorer creates a restorer for a virtual tree assembled from
+// several snapshots. For each path, the tree contains the version of the
+// newest snapshot which contains the path. Files which were deleted in later
+// snapshots are therefore still restored. The virtual tree is held in memory.
+func NewHistoryRestorer(ctx context.Context, repo restic.Repository, snapshots restic.Snapshots, opts Options) (*Restorer, error) {
+	if len(snapshots) == 0 {
+		return nil, errors.New("no snapshots")
+	}
+
+	// the newest snapshot is merged last and overrides older versions
+	snapshots = append(restic.Snapshots(nil), snapshots...)
+	sort.SliceStable(snapshots, func(i, j int) bool {
+		return snapshots[i].Time.Before(snapshots[j].Time)
+	})
+
+	h := &historyRepository{
+		Repository: repo,
+		trees:      make(map[restic.ID][]byte),
+	}
+	root := newHistoryDir()
+	for i, sn := range snapshots {
+		debug.Log("merging snapshot %v", sn.ID().Str())
+		if sn.Tree == nil {
+			return nil, errors.Errorf("snapshot %v has no tree", sn.ID().Str())
+		}
+		if err := h.merge(ctx, root, "/", *sn.Tree, i); err != nil {
+			return nil, err
+		}
+	}
+
+	links := make(map[HardlinkKey]int)
+	root.collectHardlinks(links)
+	id, err := h.build(root, links)
+	if err != nil {
+		return nil, err
+	}
+
+	newest := snapshots[len(snapshots)-1]
+	return NewMultiRestorer(h, []Source{{Snapshot: newest, Tree: id, Path: "/"}}, opts), nil
+}
+
+// historyDir is a directory of the virtual tree.
+type historyDir struct {
+	entries map[string]*historyEntry
+	// last is the tree which was merged into the directory most recently
+	last restic.ID
+	// snapshot is the index of the snapshot last was merged from
+	snapshot int
+}
+
+// historyEntry is a node of the virtual tree and the index of the snapshot it
+// was taken from.
+type historyEntry struct {
+	node     *restic.Node
+	snapshot int
+	// dir is set if node is a directory
+	dir *historyDir
+}
+
+func newHistoryDir() *historyDir {
+	return &historyDir{entries: make(map[string]*historyEntry)}
+}
+
+// historyRepository loads the trees of the virtual tree from memory and all
+// other blobs from the underlying repository.
+type historyRepository struct {
+	restic.Repository
+	trees map[restic.ID][]byte
+}
+
+func (h *historyRepository) LoadBlob(ctx context.Context, t restic.BlobType, id restic.ID, buf []byte) ([]byte, error) {
+	if data, ok := h.trees[id]; ok && t == restic.TreeBlob {
+		return append(buf[:0], data...), nil
+	}
+	return h.Repository.LoadBlob(ctx, t, id, buf)
+}
+
+// merge merges the tree id of the snapshot with the given index into dir,
+// overriding the versions of all nodes contained in the tree.
+func (h *historyRepository) merge(ctx context.Context, dir *historyDir, location string, id restic.ID, snapshot int) error {
+	if dir.last == id {
+		// the directory is unchanged since it was merged the last time, only
+		// its entries are now taken from the newer snapshot
+		dir.updateSnapshot(snapshot)
+		return nil
+	}
+	dir.last, dir.snapshot = id, snapshot
+
+	tree, err := restic.LoadTree(ctx, h.Repository, id)
+	if err != nil {
+		return errors.Wrapf(err, "loading tree for %v", location)
+	}
+
+	for _, node := range tree.Nodes {
+		entry := dir.entries[node.Name]
+		if node.Type != "dir" {
+			dir.entries[node.Name] = &historyEntry{node: node, snapshot: snapshot}
+			continue
+		}
+
+		if node.Subtree == nil {
+			return errors.Errorf("Dir without subtree in tree %v", id.Str())
+		}
+		if entry == nil || entry.dir == nil {
+			entry = &historyEntry{dir: newHistoryDir()}
+			dir.entries[node.Name] = entry
+		}
+		entry.node = node
+		entry.snapshot = snapshot
+		if err := h.merge(ctx, entry.dir, path.Join(location, node.Name), *node.Subtree, snapshot); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// updateSnapshot sets the snapshot of the entries contained in the tree last
+// merged into dir, recursively. Entries which are only contained in older
+// snapshots keep their snapshot.
+func (dir *historyDir) updateSnapshot(snapshot int) {
+	for _, entry := range dir.entries {
+		if entry.snapshot != dir.snapshot {
+			continue
+		}
+		entry.snapshot = snapshot
+		if entry.dir != nil {
+			entry.dir.updateSnapshot(snapshot)
+		}
+	}
+	dir.snapshot = snapshot
+}
+
+// collectHardlinks records in links the snapshot of all hard linked files. If
+// the files of an inode were taken from different snapshots, the snapshot is
+// set to -1.
+func (dir *historyDir) collectHardlinks(links map[HardlinkKey]int) {
+	for _, entry := range dir.entries {
+		if entry.dir != nil {
+			entry.dir.collectHardlinks(links)
+			continue
+		}
+		if entry.node.Links <= 1 {
+			continue
+		}
+
+		key := HardlinkKey{entry.node.Inode, entry.node.DeviceID}
+		if snapshot, ok := links[key]; ok && snapshot != entry.snapshot {
+			links[key] = -1
+		} else if !ok {
+			links[key] = entry.snapshot
+		}
+	}
+}
+
+// build stores the trees of dir and its subdirectories and returns the ID of
+// the tree of dir.
+func (h *historyRepository) build(dir *historyDir, links map[HardlinkKey]int) (restic.ID, error) {
+	names := make([]string, 0, len(dir.entries))
+	for name := range dir.entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	tb := restic.NewTreeJSONBuilder()
+	for _, name := range names {
+		entry := dir.entries[name]
+		node := *entry.node
+		if entry.dir != nil {
+			id, err := h.build(entry.dir, links)
+			if err != nil {
+				return restic.ID{}, err
+			}
+			node.Subtree = &id
+		} else if node.Links > 1 && links[HardlinkKey{node.Inode, node.DeviceID}] < 0 {
+			// the inode was reused between snapshots, restore the
+			// files independently
+			node.Links = 1
+		}
+
+		if err := tb.AddNode(&node); err != nil {
+			return restic.ID{}, err
+		}
+	}
+
+	buf, err := tb.Finalize()
+	if err != nil {
+		return restic.ID{}, err
+	}
+	id := restic.Hash(buf)
+	h.trees[id] = buf
+	return id, nil
+}
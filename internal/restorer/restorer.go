@@ -19,9 +19,9 @@ import (
 
 // Restorer is used to restore a snapshot to a directory.
 type Restorer struct {
-	repo restic.Repository
-	sn   *restic.Snapshot
-	opts Options
+	repo    restic.Repository
+	sources []Source
+	opts    Options
 
 	fileList map[string]bool
 
@@ -32,6 +32,18 @@ type Restorer struct {
 	SelectFilter func(item string, isDir bool) (selectedForRestore bool, childMayBeSelected bool)
 }
 
+// Source is a tree restored to a path within the target directory.
+type Source struct {
+	Snapshot *restic.Snapshot
+	// Tree is the root tree of Snapshot or the tree of a subfolder.
+	Tree restic.ID
+	// Path is the location of the tree within the target directory.
+	Path string
+	// Node is the directory node of the subfolder, its metadata is restored
+	// to the directory at Path. It is nil if Path is the target directory.
+	Node *restic.Node
+}
+
 var restorerAbortOnAllErrors = func(_ string, err error) error { return err }
 
 type Options struct {
@@ -95,13 +107,21 @@ func (c *OverwriteBehavior) Type() string {
 
 // NewRestorer creates a restorer preloaded with the content from the snapshot id.
 func NewRestorer(repo restic.Repository, sn *restic.Snapshot, opts Options) *Restorer {
+	return NewMultiRestorer(repo, []Source{{Snapshot: sn, Tree: *sn.Tree, Path: string(filepath.Separator)}}, opts)
+}
+
+// NewMultiRestorer creates a restorer which restores all sources into the
+// same target directory. The files of all sources are restored together,
+// such that blobs shared between them are only downloaded once. The paths of
+// the sources must not overlap.
+func NewMultiRestorer(repo restic.Repository, sources []Source, opts Options) *Restorer {
 	r := &Restorer{
 		repo:         repo,
 		opts:         opts,
 		fileList:     make(map[string]bool),
 		Error:        restorerAbortOnAllErrors,
 		SelectFilter: func(string, bool) (bool, bool) { return true, true },
-		sn:           sn,
+		sources:      sources,
 	}
 
 	return r
@@ -128,10 +148,21 @@ func (res *Restorer) sanitizeError(location string, err error) error {
 // traverseTree traverses a tree from the repo and calls treeVisitor.
 // target is the path in the file system, location within the snapshot.
 func (res *Restorer) traverseTree(ctx context.Context, target string, treeID restic.ID, visitor treeVisitor) error {
-	location := string(filepath.Separator)
+	return res.traverseTreeAt(ctx, target, string(filepath.Separator), nil, treeID, visitor)
+}
+
+// traverseSource traverses the tree of src, which is restored to its path
+// within dst.
+func (res *Restorer) traverseSource(ctx context.Context, dst string, src Source, visitor treeVisitor) error {
+	location := filepath.Join(string(filepath.Separator), src.Path)
+	return res.traverseTreeAt(ctx, filepath.Join(dst, location), location, src.Node, src.Tree, visitor)
+}
 
+// traverseTreeAt traverses the tree treeID restored to target. node is the
+// node of the directory, or nil if its metadata is not restored.
+func (res *Restorer) traverseTreeAt(ctx context.Context, target, location string, node *restic.Node, treeID restic.ID, visitor treeVisitor) error {
 	if visitor.enterDir != nil {
-		err := res.sanitizeError(location, visitor.enterDir(nil, target, location))
+		err := res.sanitizeError(location, visitor.enterDir(node, target, location))
 		if err != nil {
 			return err
 		}
@@ -141,7 +172,7 @@ func (res *Restorer) traverseTree(ctx context.Context, target string, treeID res
 		return err
 	}
 	if hasRestored && visitor.leaveDir != nil {
-		err = res.sanitizeError(location, visitor.leaveDir(nil, target, location, childFilenames))
+		err = res.sanitizeError(location, visitor.leaveDir(node, target, location, childFilenames))
 	}
 
 	return err
@@ -350,8 +381,12 @@ func (res *Restorer) RestoreTo(ctx context.Context, dst string) error {
 		}
 	}
 
-	idx := NewHardlinkIndex[string]()
-	filerestorer := newFileRestorer(dst, res.repo.LoadBlobsFromPack, res.repo.LookupBlob,
+	if err := checkSourcePaths(res.sources); err != nil {
+		return err
+	}
+
+	idxs := make([]*HardlinkIndex[string], len(res.sources))
+	filerestorer := newFileRestorer(dst, res.repo.LoadBlobsFromPack, res.repo.LookupBlob, res.repo.ZeroChunk(),
 		res.repo.Connections(), res.opts.Sparse, res.opts.Delete, res.opts.Progress)
 	filerestorer.Error = res.Error
 
@@ -360,59 +395,65 @@ func (res *Restorer) RestoreTo(ctx context.Context, dst string) error {
 	var buf []byte
 
 	// first tree pass: create directories and collect all files to restore
-	err = res.traverseTree(ctx, dst, *res.sn.Tree, treeVisitor{
-		enterDir: func(_ *restic.Node, target, location string) error {
-			debug.Log("first pass, enterDir: mkdir %q, leaveDir should restore metadata", location)
-			if location != string(filepath.Separator) {
-				res.opts.Progress.AddFile(0)
-			}
-			return res.ensureDir(target)
-		},
-
-		visitNode: func(node *restic.Node, target, location string) error {
-			debug.Log("first pass, visitNode: mkdir %q, leaveDir on second pass should restore metadata", location)
-			if err := res.ensureDir(filepath.Dir(target)); err != nil {
-				return err
-			}
+	for i, src := range res.sources {
+		// hard links are only restored within a source
+		idx := NewHardlinkIndex[string]()
+		idxs[i] = idx
+
+		err = res.traverseSource(ctx, dst, src, treeVisitor{
+			enterDir: func(node *restic.Node, target, location string) error {
+				debug.Log("first pass, enterDir: mkdir %q, leaveDir should restore metadata", location)
+				if node != nil {
+					res.opts.Progress.AddFile(0)
+				}
+				return res.ensureDir(target)
+			},
 
-			if node.Type != "file" {
-				res.opts.Progress.AddFile(0)
-				return nil
-			}
+			visitNode: func(node *restic.Node, target, location string) error {
+				debug.Log("first pass, visitNode: mkdir %q, leaveDir on second pass should restore metadata", location)
+				if err := res.ensureDir(filepath.Dir(target)); err != nil {
+					return err
+				}
 
-			if node.Links > 1 {
-				if idx.Has(node.Inode, node.DeviceID) {
-					// a hardlinked file does not increase the restore size
+				if node.Type != "file" {
 					res.opts.Progress.AddFile(0)
 					return nil
 				}
-				idx.Add(node.Inode, node.DeviceID, location)
-			}
 
-			buf, err = res.withOverwriteCheck(ctx, node, target, location, false, buf, func(updateMetadataOnly bool, matches *fileState) error {
-				if updateMetadataOnly {
-					res.opts.Progress.AddSkippedFile(location, node.Size)
-				} else {
-					res.opts.Progress.AddFile(node.Size)
-					if !res.opts.DryRun {
-						filerestorer.addFile(location, node.Content, int64(node.Size), matches)
+				if node.Links > 1 {
+					if idx.Has(node.Inode, node.DeviceID) {
+						// a hardlinked file does not increase the restore size
+						res.opts.Progress.AddFile(0)
+						return nil
+					}
+					idx.Add(node.Inode, node.DeviceID, location)
+				}
+
+				buf, err = res.withOverwriteCheck(ctx, node, target, location, false, buf, func(updateMetadataOnly bool, matches *fileState) error {
+					if updateMetadataOnly {
+						res.opts.Progress.AddSkippedFile(location, node.Size)
 					} else {
-						action := restoreui.ActionFileUpdated
-						if matches == nil {
-							action = restoreui.ActionFileRestored
+						res.opts.Progress.AddFile(node.Size)
+						if !res.opts.DryRun {
+							filerestorer.addFile(location, node.Content, int64(node.Size), matches)
+						} else {
+							action := restoreui.ActionFileUpdated
+							if matches == nil {
+								action = restoreui.ActionFileRestored
+							}
+							// immediately mark as completed
+							res.opts.Progress.AddProgress(location, action, node.Size, node.Size)
 						}
-						// immediately mark as completed
-						res.opts.Progress.AddProgress(location, action, node.Size, node.Size)
 					}
-				}
-				res.trackFile(location, updateMetadataOnly)
-				return nil
-			})
+					res.trackFile(location, updateMetadataOnly)
+					return nil
+				})
+				return err
+			},
+		})
+		if err != nil {
 			return err
-		},
-	})
-	if err != nil {
-		return err
+		}
 	}
 
 	if !res.opts.DryRun {
@@ -425,48 +466,68 @@ func (res *Restorer) RestoreTo(ctx context.Context, dst string) error {
 	debug.Log("second pass for %q", dst)
 
 	// second tree pass: restore special files and filesystem metadata
-	err = res.traverseTree(ctx, dst, *res.sn.Tree, treeVisitor{
-		visitNode: func(node *restic.Node, target, location string) error {
-			debug.Log("second pass, visitNode: restore node %q", location)
-			if node.Type != "file" {
-				_, err := res.withOverwriteCheck(ctx, node, target, location, false, nil, func(_ bool, _ *fileState) error {
-					return res.restoreNodeTo(ctx, node, target, location)
-				})
-				return err
-			}
-
-			if idx.Has(node.Inode, node.DeviceID) && idx.Value(node.Inode, node.DeviceID) != location {
-				_, err := res.withOverwriteCheck(ctx, node, target, location, true, nil, func(_ bool, _ *fileState) error {
-					return res.restoreHardlinkAt(node, filerestorer.targetPath(idx.Value(node.Inode, node.DeviceID)), target, location)
-				})
-				return err
-			}
+	for i, src := range res.sources {
+		idx := idxs[i]
+		err = res.traverseSource(ctx, dst, src, treeVisitor{
+			visitNode: func(node *restic.Node, target, location string) error {
+				debug.Log("second pass, visitNode: restore node %q", location)
+				if node.Type != "file" {
+					_, err := res.withOverwriteCheck(ctx, node, target, location, false, nil, func(_ bool, _ *fileState) error {
+						return res.restoreNodeTo(ctx, node, target, location)
+					})
+					return err
+				}
 
-			if _, ok := res.hasRestoredFile(location); ok {
-				return res.restoreNodeMetadataTo(node, target, location)
-			}
-			// don't touch skipped files
-			return nil
-		},
-		leaveDir: func(node *restic.Node, target, location string, expectedFilenames []string) error {
-			if res.opts.Delete {
-				if err := res.removeUnexpectedFiles(target, location, expectedFilenames); err != nil {
+				if idx.Has(node.Inode, node.DeviceID) && idx.Value(node.Inode, node.DeviceID) != location {
+					_, err := res.withOverwriteCheck(ctx, node, target, location, true, nil, func(_ bool, _ *fileState) error {
+						return res.restoreHardlinkAt(node, filerestorer.targetPath(idx.Value(node.Inode, node.DeviceID)), target, location)
+					})
 					return err
 				}
-			}
 
-			if node == nil {
+				if _, ok := res.hasRestoredFile(location); ok {
+					return res.restoreNodeMetadataTo(node, target, location)
+				}
+				// don't touch skipped files
 				return nil
-			}
+			},
+			leaveDir: func(node *restic.Node, target, location string, expectedFilenames []string) error {
+				if res.opts.Delete {
+					if err := res.removeUnexpectedFiles(target, location, expectedFilenames); err != nil {
+						return err
+					}
+				}
 
-			err := res.restoreNodeMetadataTo(node, target, location)
-			if err == nil {
-				res.opts.Progress.AddProgress(location, restoreui.ActionDirRestored, 0, 0)
-			}
+				if node == nil {
+					return nil
+				}
+
+				err := res.restoreNodeMetadataTo(node, target, location)
+				if err == nil {
+					res.opts.Progress.AddProgress(location, restoreui.ActionDirRestored, 0, 0)
+				}
+				return err
+			},
+		})
+		if err != nil {
 			return err
-		},
-	})
-	return err
+		}
+	}
+	return nil
+}
+
+// checkSourcePaths returns an error if the paths of two sources overlap.
+func checkSourcePaths(sources []Source) error {
+	for i, a := range sources {
+		for _, b := range sources[i+1:] {
+			pathA := filepath.Join(string(filepath.Separator), a.Path)
+			pathB := filepath.Join(string(filepath.Separator), b.Path)
+			if fs.HasPathPrefix(pathA, pathB) || fs.HasPathPrefix(pathB, pathA) {
+				return errors.Errorf("restore paths %v and %v overlap", pathA, pathB)
+			}
+		}
+	}
+	return nil
 }
 
 func (res *Restorer) removeUnexpectedFiles(target, location string, expectedFilenames []string) error {
@@ -571,15 +632,21 @@ func shouldOverwrite(overwrite OverwriteBehavior, node *restic.Node, destination
 	panic("unknown overwrite behavior")
 }
 
-// Snapshot returns the snapshot this restorer is configured to use.
+// Snapshot returns the snapshot this restorer is configured to use. If
+// multiple sources are restored, the snapshot of the first one is returned.
 func (res *Restorer) Snapshot() *restic.Snapshot {
-	return res.sn
+	return res.sources[0].Snapshot
+}
+
+// Sources returns the sources this restorer is configured to use.
+func (res *Restorer) Sources() []Source {
+	return res.sources
 }
 
 // Number of workers in VerifyFiles.
 const nVerifyWorkers = 8
 
-// VerifyFiles checks whether all regular files in the snapshot res.sn
+// VerifyFiles checks whether all regular files in the sources of res
 // have been successfully written to dst. It stops when it encounters an
 // error. It returns that error and the number of files it has successfully
 // verified.
@@ -600,23 +667,28 @@ func (res *Restorer) VerifyFiles(ctx context.Context, dst string) (int, error) {
 	g.Go(func() error {
 		defer close(work)
 
-		err := res.traverseTree(ctx, dst, *res.sn.Tree, treeVisitor{
-			visitNode: func(node *restic.Node, target, location string) error {
-				if node.Type != "file" {
-					return nil
-				}
-				if metadataOnly, ok := res.hasRestoredFile(location); !ok || metadataOnly {
-					return nil
-				}
-				select {
-				case <-ctx.Done():
-					return ctx.Err()
-				case work <- mustCheck{node, target}:
-					return nil
-				}
-			},
-		})
-		return err
+		for _, src := range res.sources {
+			err := res.traverseSource(ctx, dst, src, treeVisitor{
+				visitNode: func(node *restic.Node, target, location string) error {
+					if node.Type != "file" {
+						return nil
+					}
+					if metadataOnly, ok := res.hasRestoredFile(location); !ok || metadataOnly {
+						return nil
+					}
+					select {
+					case <-ctx.Done():
+						return ctx.Err()
+					case work <- mustCheck{node, target}:
+						return nil
+					}
+				},
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
 	})
 
 	for i := 0; i < nVerifyWorkers; i++ {
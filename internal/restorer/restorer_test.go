@@ -1500,3 +1500,206 @@ func TestRestoreToFile(t *testing.T) {
 	err := res.RestoreTo(ctx, tempdir)
 	rtest.Assert(t, strings.Contains(err.Error(), "cannot create target directory"), "unexpected error %v", err)
 }
+
+func TestRestorerMultipleSources(t *testing.T) {
+	repo := repository.TestRepository(t)
+	sn1, _ := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"etc": Dir{
+				Nodes: map[string]Node{
+					"config": File{Data: "shared content"},
+					"hosts":  File{Data: "hosts", Inode: 42, Links: 2},
+				},
+				Mode: 0750,
+			},
+			"other": File{Data: "not restored"},
+		},
+	}, noopGetGenericAttributes)
+	// the inode of the hard link matches the one from the other snapshot
+	sn2, _ := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"data":  File{Data: "shared content"},
+			"file":  File{Data: "file", Inode: 42, Links: 2},
+			"link":  File{Data: "file", Inode: 42, Links: 2},
+			"other": Symlink{Target: "data"},
+		},
+	}, noopGetGenericAttributes)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	etc, err := restic.FindTreeDirectoryNode(ctx, repo, sn1.Tree, "/etc")
+	rtest.OK(t, err)
+
+	res := NewMultiRestorer(repo, []Source{
+		{Snapshot: sn1, Tree: *etc.Subtree, Path: "etc", Node: etc},
+		{Snapshot: sn2, Tree: *sn2.Tree, Path: filepath.Join("var", "lib")},
+	}, Options{})
+
+	tempdir := rtest.TempDir(t)
+	rtest.OK(t, res.RestoreTo(ctx, tempdir))
+
+	for name, want := range map[string]string{
+		"etc/config":    "shared content",
+		"etc/hosts":     "hosts",
+		"var/lib/data":  "shared content",
+		"var/lib/file":  "file",
+		"var/lib/link":  "file",
+		"var/lib/other": "shared content",
+	} {
+		data, err := os.ReadFile(filepath.Join(tempdir, filepath.FromSlash(name)))
+		rtest.OK(t, err)
+		rtest.Equals(t, want, string(data))
+	}
+	_, err = os.Stat(filepath.Join(tempdir, "other"))
+	rtest.Assert(t, errors.Is(err, os.ErrNotExist), "unexpected file restored: %v", err)
+
+	// the metadata of the subfolder is restored to its target directory
+	if runtime.GOOS != "windows" {
+		fi, err := os.Stat(filepath.Join(tempdir, "etc"))
+		rtest.OK(t, err)
+		rtest.Equals(t, os.ModeDir|0750, fi.Mode())
+	}
+
+	// the second hard link is not verified separately
+	nverified, err := res.VerifyFiles(ctx, tempdir)
+	rtest.OK(t, err)
+	rtest.Equals(t, 4, nverified)
+}
+
+func TestRestorerOverlappingSources(t *testing.T) {
+	repo := repository.TestRepository(t)
+	sn, _ := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"foo": File{Data: "content: foo\n"},
+		},
+	}, noopGetGenericAttributes)
+
+	res := NewMultiRestorer(repo, []Source{
+		{Snapshot: sn, Tree: *sn.Tree, Path: "dir"},
+		{Snapshot: sn, Tree: *sn.Tree, Path: filepath.Join("dir", "sub")},
+	}, Options{})
+
+	err := res.RestoreTo(context.TODO(), rtest.TempDir(t))
+	rtest.Assert(t, err != nil && strings.Contains(err.Error(), "overlap"), "unexpected error %v", err)
+}
+
+func TestRestorerHistory(t *testing.T) {
+	repo := repository.TestRepository(t)
+	sn1, _ := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"dir": Dir{
+				Nodes: map[string]Node{
+					"changed": File{Data: "old"},
+					"deleted": File{Data: "deleted"},
+				},
+			},
+			"link1":    File{Data: "link1", Inode: 42, Links: 2},
+			"replaced": File{Data: "file"},
+			"unchanged": Dir{
+				Nodes: map[string]Node{
+					"file": File{Data: "unchanged"},
+				},
+			},
+		},
+	}, noopGetGenericAttributes)
+	// the inode of link2 is reused for a different file
+	sn2, _ := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"dir": Dir{
+				Nodes: map[string]Node{
+					"added":   File{Data: "added"},
+					"changed": File{Data: "new"},
+				},
+			},
+			"link2": File{Data: "link2", Inode: 42, Links: 2},
+			"replaced": Dir{
+				Nodes: map[string]Node{
+					"file": File{Data: "dir"},
+				},
+			},
+			"unchanged": Dir{
+				Nodes: map[string]Node{
+					"file": File{Data: "unchanged"},
+				},
+			},
+		},
+	}, noopGetGenericAttributes)
+	sn1.Time = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	sn2.Time = sn1.Time.Add(time.Hour)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	res, err := NewHistoryRestorer(ctx, repo, restic.Snapshots{sn2, sn1}, Options{})
+	rtest.OK(t, err)
+	rtest.Equals(t, sn2, res.Snapshot())
+
+	tempdir := rtest.TempDir(t)
+	rtest.OK(t, res.RestoreTo(ctx, tempdir))
+
+	for name, want := range map[string]string{
+		"dir/added":      "added",
+		"dir/changed":    "new",
+		"dir/deleted":    "deleted",
+		"link1":          "link1",
+		"link2":          "link2",
+		"replaced/file":  "dir",
+		"unchanged/file": "unchanged",
+	} {
+		data, err := os.ReadFile(filepath.Join(tempdir, filepath.FromSlash(name)))
+		rtest.OK(t, err)
+		rtest.Equals(t, want, string(data))
+	}
+
+	nverified, err := res.VerifyFiles(ctx, tempdir)
+	rtest.OK(t, err)
+	rtest.Equals(t, 7, nverified)
+}
+
+func TestRestorerHistoryUnchangedHardlinks(t *testing.T) {
+	repo := repository.TestRepository(t)
+	unchanged := Dir{
+		Nodes: map[string]Node{
+			"link1": File{Data: "link", Inode: 42, Links: 2},
+		},
+	}
+	sn1, _ := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"unchanged": unchanged,
+			"changed": Dir{
+				Nodes: map[string]Node{
+					"link2": File{Data: "link", Inode: 42, Links: 2},
+				},
+			},
+		},
+	}, noopGetGenericAttributes)
+	sn2, _ := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"unchanged": unchanged,
+			"changed": Dir{
+				Nodes: map[string]Node{
+					"added": File{Data: "added"},
+					"link2": File{Data: "link", Inode: 42, Links: 2},
+				},
+			},
+		},
+	}, noopGetGenericAttributes)
+	sn1.Time = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	sn2.Time = sn1.Time.Add(time.Hour)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	res, err := NewHistoryRestorer(ctx, repo, restic.Snapshots{sn1, sn2}, Options{})
+	rtest.OK(t, err)
+	tempdir := rtest.TempDir(t)
+	rtest.OK(t, res.RestoreTo(ctx, tempdir))
+
+	// the hard link is unchanged, although only one of its directories is
+	fi1, err := os.Stat(filepath.Join(tempdir, "unchanged", "link1"))
+	rtest.OK(t, err)
+	fi2, err := os.Stat(filepath.Join(tempdir, "changed", "link2"))
+	rtest.OK(t, err)
+	rtest.Assert(t, os.SameFile(fi1, fi2), "hard link was restored as separate files")
+}
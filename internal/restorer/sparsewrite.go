@@ -1,6 +1,8 @@
 package restorer
 
 import (
+	"os"
+
 	"github.com/chanhpng/vlbe/internal/restic"
 )
 
@@ -16,6 +18,15 @@ func (f *partialFile) WriteAt(p []byte, offset int64) (n int, err error) {
 	// Skip the longest all-zero prefix of p.
 	// If it's long enough, we can punch a hole in the file.
 	skipped := restic.ZeroPrefixLen(p)
+
+	if f.device {
+		// A block device still contains the old data, only blobs consisting
+		// of zeros are zeroed efficiently.
+		if skipped < len(p) {
+			return f.File.WriteAt(p, offset)
+		}
+		return n, zeroRange(f.File, offset, int64(n))
+	}
 	p = p[skipped:]
 	offset += int64(skipped)
 
@@ -32,3 +43,20 @@ func (f *partialFile) WriteAt(p []byte, offset int64) (n int, err error) {
 
 	return n, err
 }
+
+// writeZeros writes length zero bytes to f at offset.
+func writeZeros(f *os.File, offset, length int64) error {
+	buf := make([]byte, 64*1024)
+	for length > 0 {
+		if length < int64(len(buf)) {
+			buf = buf[:length]
+		}
+		n, err := f.WriteAt(buf, offset)
+		if err != nil {
+			return err
+		}
+		offset += int64(n)
+		length -= int64(n)
+	}
+	return nil
+}
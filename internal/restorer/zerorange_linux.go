@@ -0,0 +1,39 @@
+package restorer
+
+import (
+	"os"
+
+	"github.com/chanhpng/vlbe/internal/debug"
+	"golang.org/x/sys/unix"
+)
+
+// zeroAlignment is a multiple of the logical block size of all common block
+// devices.
+const zeroAlignment = 4096
+
+// zeroRange zeroes length bytes at offset in the block device f. The aligned
+// part of the range is discarded if the device supports it, which keeps thinly
+// provisioned volumes sparse.
+func zeroRange(f *os.File, offset, length int64) error {
+	start := (offset + zeroAlignment - 1) / zeroAlignment * zeroAlignment
+	end := (offset + length) / zeroAlignment * zeroAlignment
+	if start >= end {
+		return writeZeros(f, offset, length)
+	}
+
+	fd := int(f.Fd())
+	err := unix.Fallocate(fd, unix.FALLOC_FL_PUNCH_HOLE|unix.FALLOC_FL_KEEP_SIZE, start, end-start)
+	if err != nil {
+		debug.Log("discarding %v bytes at %v failed: %v", end-start, start, err)
+		err = unix.Fallocate(fd, unix.FALLOC_FL_ZERO_RANGE|unix.FALLOC_FL_KEEP_SIZE, start, end-start)
+	}
+	if err != nil {
+		debug.Log("zeroing %v bytes at %v failed: %v", end-start, start, err)
+		return writeZeros(f, offset, length)
+	}
+
+	if err := writeZeros(f, offset, start-offset); err != nil {
+		return err
+	}
+	return writeZeros(f, end, offset+length-end)
+}
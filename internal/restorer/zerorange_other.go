@@ -0,0 +1,11 @@
+//go:build !linux
+// +build !linux
+
+package restorer
+
+import "os"
+
+// zeroRange zeroes length bytes at offset in the block device f.
+func zeroRange(f *os.File, offset, length int64) error {
+	return writeZeros(f, offset, length)
+}
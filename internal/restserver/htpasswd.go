@@ -0,0 +1,122 @@
+package restserver
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/chanhpng/vlbe/internal/debug"
+	"github.com/chanhpng/vlbe/internal/errors"
+)
+
+// Htpasswd contains user credentials as read from an htpasswd file. Password
+// hashes must either use bcrypt or SHA1 (`{SHA}` prefix).
+type Htpasswd struct {
+	users map[string]string
+
+	// bcrypt is deliberately slow, so successful checks are cached
+	m        sync.Mutex
+	verified map[string][sha256.Size]byte
+}
+
+// ReadHtpasswdFile reads the htpasswd file at filename.
+func ReadHtpasswdFile(filename string) (*Htpasswd, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	h, err := ParseHtpasswd(f)
+	if cerr := f.Close(); cerr != nil && err == nil {
+		err = errors.WithStack(cerr)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "htpasswd file %v", filename)
+	}
+	return h, nil
+}
+
+// ParseHtpasswd reads htpasswd entries from rd. Empty lines and lines starting
+// with '#' are ignored.
+func ParseHtpasswd(rd io.Reader) (*Htpasswd, error) {
+	h := &Htpasswd{
+		users:    make(map[string]string),
+		verified: make(map[string][sha256.Size]byte),
+	}
+
+	sc := bufio.NewScanner(rd)
+	lineno := 0
+	for sc.Scan() {
+		lineno++
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		user, hash, ok := strings.Cut(line, ":")
+		if !ok || user == "" {
+			return nil, errors.Errorf("line %d: invalid entry", lineno)
+		}
+
+		if !strings.HasPrefix(hash, "{SHA}") && !strings.HasPrefix(hash, "$2") {
+			return nil, errors.Errorf("line %d: unsupported password hash for user %q", lineno, user)
+		}
+
+		h.users[user] = hash
+	}
+
+	if err := sc.Err(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return h, nil
+}
+
+// Validate returns true if password is correct for user.
+func (h *Htpasswd) Validate(user, password string) bool {
+	hash, ok := h.users[user]
+	if !ok {
+		return false
+	}
+
+	sum := sha256.Sum256([]byte(password))
+
+	h.m.Lock()
+	cached, ok := h.verified[user]
+	h.m.Unlock()
+	if ok && subtle.ConstantTimeCompare(cached[:], sum[:]) == 1 {
+		return true
+	}
+
+	if !checkPassword(hash, password) {
+		return false
+	}
+
+	h.m.Lock()
+	h.verified[user] = sum
+	h.m.Unlock()
+
+	return true
+}
+
+func checkPassword(hash, password string) bool {
+	if strings.HasPrefix(hash, "{SHA}") {
+		sum := sha1.Sum([]byte(password))
+		expected := "{SHA}" + base64.StdEncoding.EncodeToString(sum[:])
+		return subtle.ConstantTimeCompare([]byte(hash), []byte(expected)) == 1
+	}
+
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	if err != nil && !errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		debug.Log("checking bcrypt hash failed: %v", err)
+	}
+	return err == nil
+}
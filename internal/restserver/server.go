@@ -0,0 +1,597 @@
+// Package restserver implements the server side of the REST protocol spoken
+// by the rest backend. Repositories are stored in an arbitrary backend.
+package restserver
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/chanhpng/vlbe/internal/backend"
+	"github.com/chanhpng/vlbe/internal/backend/rest"
+	"github.com/chanhpng/vlbe/internal/debug"
+	"github.com/chanhpng/vlbe/internal/errors"
+)
+
+// Config configures a Server.
+type Config struct {
+	// Open returns the backend which stores the repository at path. The path
+	// is empty for the top-level repository and consists of slash-separated
+	// components otherwise. If create is true, the backend is requested to
+	// be created.
+	Open func(ctx context.Context, path string, create bool) (backend.Backend, error)
+
+	// AppendOnly prevents removing or overwriting files, except for lock files.
+	AppendOnly bool
+
+	// PrivateRepos restricts each user to the repositories below a path
+	// which starts with the user name. Requires Users to be set.
+	PrivateRepos bool
+
+	// Users contains the credentials of all users which are allowed to
+	// access the server. Authentication is disabled if Users is nil.
+	Users *Htpasswd
+
+	// NoVerifyUpload disables checking that the name of an uploaded file
+	// matches the SHA-256 hash of its content.
+	NoVerifyUpload bool
+}
+
+// maxBackends limits the number of backends which are kept open. Backends
+// which are not used by a request are closed once there are more.
+const maxBackends = 64
+
+// Server serves repositories via the REST protocol.
+type Server struct {
+	cfg Config
+
+	m        sync.Mutex
+	backends map[string]*openBackend
+}
+
+// openBackend is a backend opened by the server.
+type openBackend struct {
+	backend.Backend
+	// users is the number of requests using the backend
+	users int
+	// removed is set if the backend was replaced or removed from the
+	// server, it is closed once it is no longer used
+	removed bool
+}
+
+// New returns a new Server for cfg.
+func New(cfg Config) (*Server, error) {
+	if cfg.Open == nil {
+		return nil, errors.New("no function to open backends specified")
+	}
+	if cfg.PrivateRepos && cfg.Users == nil {
+		return nil, errors.New("private repositories require authentication")
+	}
+
+	return &Server{
+		cfg:      cfg,
+		backends: make(map[string]*openBackend),
+	}, nil
+}
+
+// Close closes all backends opened by the server.
+func (s *Server) Close() error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	var firstErr error
+	for path, ob := range s.backends {
+		delete(s.backends, path)
+		ob.removed = true
+		if ob.users > 0 {
+			// closed once the last request has finished
+			continue
+		}
+		err := ob.Close()
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+var fileTypes = map[string]backend.FileType{
+	"data":      backend.PackFile,
+	"keys":      backend.KeyFile,
+	"locks":     backend.LockFile,
+	"snapshots": backend.SnapshotFile,
+	"index":     backend.IndexFile,
+}
+
+// errInvalidName is returned by parsePath for files whose name is not an ID.
+var errInvalidName = errors.New("invalid file name")
+
+// request describes the target of an HTTP request.
+type request struct {
+	// repo is the path of the repository, without leading or trailing slashes.
+	repo string
+	// handle is the addressed file, handle.Type is zero for requests on the
+	// repository itself.
+	handle backend.Handle
+	// list is set if the request addresses the directory of handle.Type.
+	list bool
+}
+
+// parsePath splits the URL path p into the repository path and the addressed
+// file or directory.
+func parsePath(p string) (request, error) {
+	isDir := strings.HasSuffix(p, "/")
+
+	var segs []string
+	for _, seg := range strings.Split(p, "/") {
+		switch seg {
+		case "":
+			continue
+		case ".", "..":
+			return request{}, errors.Errorf("invalid path %q", p)
+		}
+		segs = append(segs, seg)
+	}
+
+	n := len(segs)
+	switch {
+	case n >= 1 && segs[n-1] == "config" && !isDir:
+		return request{
+			repo:   strings.Join(segs[:n-1], "/"),
+			handle: backend.Handle{Type: backend.ConfigFile},
+		}, nil
+
+	case n >= 1 && isDir && fileTypes[segs[n-1]] != 0:
+		return request{
+			repo:   strings.Join(segs[:n-1], "/"),
+			handle: backend.Handle{Type: fileTypes[segs[n-1]]},
+			list:   true,
+		}, nil
+
+	case n >= 2 && !isDir && fileTypes[segs[n-2]] != 0:
+		if !validName(segs[n-1]) {
+			return request{}, errors.Wrapf(errInvalidName, "%q", segs[n-1])
+		}
+		return request{
+			repo:   strings.Join(segs[:n-2], "/"),
+			handle: backend.Handle{Type: fileTypes[segs[n-2]], Name: segs[n-1]},
+		}, nil
+	}
+
+	return request{repo: strings.Join(segs, "/")}, nil
+}
+
+// validName returns true if name is a hex-encoded SHA-256 hash.
+func validName(name string) bool {
+	if len(name) != 2*sha256.Size {
+		return false
+	}
+	_, err := hex.DecodeString(name)
+	return err == nil
+}
+
+// ServeHTTP implements http.Handler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	debug.Log("%v %v", r.Method, r.URL.Path)
+
+	user, ok := s.authenticate(r)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Basic realm="restic"`)
+		httpError(w, http.StatusUnauthorized)
+		return
+	}
+
+	req, err := parsePath(r.URL.Path)
+	if errors.Is(err, errInvalidName) && r.Method != http.MethodGet && r.Method != http.MethodHead {
+		debug.Log("%v", err)
+		httpError(w, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		// reading a file with an invalid name reports that it does not exist
+		debug.Log("%v", err)
+		httpError(w, http.StatusNotFound)
+		return
+	}
+
+	if s.cfg.PrivateRepos && req.repo != user && !strings.HasPrefix(req.repo, user+"/") {
+		debug.Log("user %v denied access to repository %q", user, req.repo)
+		httpError(w, http.StatusUnauthorized)
+		return
+	}
+
+	if req.handle.Type == 0 {
+		if r.Method == http.MethodPost && r.URL.Query().Get("create") == "true" {
+			s.createRepo(w, r, req)
+			return
+		}
+		httpError(w, http.StatusNotFound)
+		return
+	}
+
+	be, release, err := s.backend(r.Context(), req.repo, false)
+	if err != nil {
+		s.internalError(w, err)
+		return
+	}
+	defer release()
+
+	switch {
+	case req.list && r.Method == http.MethodGet:
+		s.listFiles(w, r, be, req)
+	case req.list:
+		httpError(w, http.StatusMethodNotAllowed)
+	case r.Method == http.MethodHead:
+		s.statFile(w, r, be, req)
+	case r.Method == http.MethodGet:
+		s.loadFile(w, r, be, req)
+	case r.Method == http.MethodPost:
+		s.saveFile(w, r, be, req)
+	case r.Method == http.MethodDelete:
+		s.removeFile(w, r, be, req)
+	default:
+		httpError(w, http.StatusMethodNotAllowed)
+	}
+}
+
+// authenticate checks the credentials sent with r and returns the user name.
+func (s *Server) authenticate(r *http.Request) (string, bool) {
+	if s.cfg.Users == nil {
+		return "", true
+	}
+
+	user, password, ok := r.BasicAuth()
+	if !ok || !s.cfg.Users.Validate(user, password) {
+		debug.Log("authentication failed for user %q", user)
+		return "", false
+	}
+	return user, true
+}
+
+// backend returns the (cached) backend for the repository at path. The
+// returned function must be called once the backend is no longer used.
+func (s *Server) backend(ctx context.Context, path string, create bool) (backend.Backend, func(), error) {
+	s.m.Lock()
+	ob, ok := s.backends[path]
+	if ok && !create {
+		ob.users++
+		s.m.Unlock()
+		return ob, func() { s.release(ob) }, nil
+	}
+	s.m.Unlock()
+
+	// opening a backend can be slow, don't block other requests meanwhile
+	be, err := s.cfg.Open(ctx, path, create)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	ob, ok = s.backends[path]
+	if ok && !create {
+		// another request has opened the backend in the meantime
+		if err := be.Close(); err != nil {
+			debug.Log("closing backend failed: %v", err)
+		}
+	} else {
+		if ok {
+			ob.removed = true
+			s.closeUnused(ob)
+		}
+		ob = &openBackend{Backend: be}
+		s.backends[path] = ob
+	}
+
+	ob.users++
+	return ob, func() { s.release(ob) }, nil
+}
+
+// release marks ob as no longer used by a request and closes unused backends
+// if too many are open.
+func (s *Server) release(ob *openBackend) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	ob.users--
+	s.closeUnused(ob)
+	for path, other := range s.backends {
+		if len(s.backends) <= maxBackends {
+			break
+		}
+		if other.users == 0 {
+			delete(s.backends, path)
+			other.removed = true
+			s.closeUnused(other)
+		}
+	}
+}
+
+// closeUnused closes ob if it was removed and is no longer used. The caller
+// must hold s.m.
+func (s *Server) closeUnused(ob *openBackend) {
+	if !ob.removed || ob.users > 0 {
+		return
+	}
+	if err := ob.Close(); err != nil {
+		debug.Log("closing backend failed: %v", err)
+	}
+}
+
+func (s *Server) createRepo(w http.ResponseWriter, r *http.Request, req request) {
+	be, release, err := s.backend(r.Context(), req.repo, false)
+	if err != nil {
+		s.internalError(w, err)
+		return
+	}
+	defer release()
+
+	_, err = be.Stat(r.Context(), backend.Handle{Type: backend.ConfigFile})
+	if err == nil {
+		// the repository already exists, nothing to do
+		return
+	}
+	if !be.IsNotExist(err) {
+		s.internalError(w, err)
+		return
+	}
+
+	debug.Log("creating repository %q", req.repo)
+	_, releaseCreated, err := s.backend(r.Context(), req.repo, true)
+	if err != nil {
+		s.internalError(w, err)
+		return
+	}
+	releaseCreated()
+}
+
+func (s *Server) listFiles(w http.ResponseWriter, r *http.Request, be backend.Backend, req request) {
+	type fileInfo struct {
+		Name string `json:"name"`
+		Size int64  `json:"size"`
+	}
+
+	list := []fileInfo{}
+	err := be.List(r.Context(), req.handle.Type, func(fi backend.FileInfo) error {
+		list = append(list, fileInfo{Name: fi.Name, Size: fi.Size})
+		return nil
+	})
+	if err != nil {
+		s.internalError(w, err)
+		return
+	}
+
+	var body interface{} = list
+	contentType := rest.ContentTypeV2
+	if r.Header.Get("Accept") != rest.ContentTypeV2 {
+		names := make([]string, 0, len(list))
+		for _, fi := range list {
+			names = append(names, fi.Name)
+		}
+		body = names
+		contentType = rest.ContentTypeV1
+	}
+
+	buf, err := json.Marshal(body)
+	if err != nil {
+		s.internalError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	_, _ = w.Write(buf)
+}
+
+func (s *Server) statFile(w http.ResponseWriter, r *http.Request, be backend.Backend, req request) {
+	fi, err := be.Stat(r.Context(), req.handle)
+	if err != nil {
+		s.backendError(w, be, err)
+		return
+	}
+
+	w.Header().Set("Content-Length", strconv.FormatInt(fi.Size, 10))
+}
+
+func (s *Server) loadFile(w http.ResponseWriter, r *http.Request, be backend.Backend, req request) {
+	fi, err := be.Stat(r.Context(), req.handle)
+	if err != nil {
+		s.backendError(w, be, err)
+		return
+	}
+
+	offset, length, partial, err := parseRange(r.Header.Get("Range"), fi.Size)
+	if err != nil {
+		debug.Log("invalid range for %v: %v", req.handle, err)
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", fi.Size))
+		httpError(w, http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+
+	w.Header().Set("Accept-Ranges", "bytes")
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+	status := http.StatusOK
+	if partial {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, offset+length-1, fi.Size))
+		status = http.StatusPartialContent
+	}
+
+	if length == 0 {
+		w.WriteHeader(status)
+		return
+	}
+
+	written := false
+	err = be.Load(r.Context(), req.handle, int(length), offset, func(rd io.Reader) error {
+		if written {
+			// the response cannot be rewound once data has been sent
+			return errors.New("retrying a partially sent response is not possible")
+		}
+		written = true
+		w.WriteHeader(status)
+		_, err := io.Copy(w, rd)
+		return err
+	})
+	if err != nil && !written {
+		s.backendError(w, be, err)
+		return
+	}
+	if err != nil {
+		debug.Log("sending %v failed: %v", req.handle, err)
+	}
+}
+
+// parseRange parses the value of an HTTP Range header for a file with the
+// given size. Only a single range is supported. Unlike most HTTP servers, a
+// range which extends beyond the end of the file is rejected, as the rest
+// backend must report short reads as an error.
+func parseRange(header string, size int64) (offset, length int64, partial bool, err error) {
+	if header == "" {
+		return 0, size, false, nil
+	}
+
+	if !strings.HasPrefix(header, "bytes=") {
+		return 0, 0, false, errors.Errorf("unsupported range %q", header)
+	}
+	spec := strings.TrimPrefix(header, "bytes=")
+	if strings.Contains(spec, ",") {
+		return 0, 0, false, errors.Errorf("unsupported range %q", header)
+	}
+
+	start, end, ok := strings.Cut(spec, "-")
+	if !ok {
+		return 0, 0, false, errors.Errorf("invalid range %q", header)
+	}
+
+	if start == "" {
+		// suffix range, return the last bytes of the file
+		n, err := strconv.ParseInt(end, 10, 64)
+		if err != nil || n <= 0 {
+			return 0, 0, false, errors.Errorf("invalid range %q", header)
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, n, true, nil
+	}
+
+	offset, err = strconv.ParseInt(start, 10, 64)
+	if err != nil || offset < 0 || offset >= size {
+		return 0, 0, false, errors.Errorf("invalid range %q", header)
+	}
+
+	last := size - 1
+	if end != "" {
+		last, err = strconv.ParseInt(end, 10, 64)
+		if err != nil || last < offset || last >= size {
+			return 0, 0, false, errors.Errorf("invalid range %q", header)
+		}
+	}
+
+	return offset, last - offset + 1, true, nil
+}
+
+func (s *Server) saveFile(w http.ResponseWriter, r *http.Request, be backend.Backend, req request) {
+	_, err := be.Stat(r.Context(), req.handle)
+	if err == nil {
+		debug.Log("refusing to overwrite %v", req.handle)
+		httpError(w, http.StatusForbidden)
+		return
+	}
+	if !be.IsNotExist(err) {
+		s.internalError(w, err)
+		return
+	}
+
+	tmpfile, err := os.CreateTemp("", "restic-rest-server-")
+	if err != nil {
+		s.internalError(w, err)
+		return
+	}
+	defer func() {
+		_ = tmpfile.Close()
+		_ = os.Remove(tmpfile.Name())
+	}()
+
+	hasher := sha256.New()
+	_, err = io.Copy(io.MultiWriter(tmpfile, hasher), r.Body)
+	if err != nil {
+		debug.Log("reading request body for %v failed: %v", req.handle, err)
+		httpError(w, http.StatusBadRequest)
+		return
+	}
+
+	if !s.cfg.NoVerifyUpload && req.handle.Type != backend.ConfigFile {
+		if hex.EncodeToString(hasher.Sum(nil)) != req.handle.Name {
+			debug.Log("content of %v does not match its name", req.handle)
+			httpError(w, http.StatusBadRequest)
+			return
+		}
+	}
+
+	var hash []byte
+	if h := be.Hasher(); h != nil {
+		if _, err := tmpfile.Seek(0, io.SeekStart); err != nil {
+			s.internalError(w, err)
+			return
+		}
+		if _, err := io.Copy(h, tmpfile); err != nil {
+			s.internalError(w, err)
+			return
+		}
+		hash = h.Sum(nil)
+	}
+
+	rd, err := backend.NewFileReader(tmpfile, hash)
+	if err != nil {
+		s.internalError(w, err)
+		return
+	}
+
+	err = be.Save(r.Context(), req.handle, rd)
+	if err != nil {
+		s.internalError(w, err)
+		return
+	}
+}
+
+func (s *Server) removeFile(w http.ResponseWriter, r *http.Request, be backend.Backend, req request) {
+	if s.cfg.AppendOnly && req.handle.Type != backend.LockFile {
+		debug.Log("refusing to remove %v in append-only mode", req.handle)
+		httpError(w, http.StatusForbidden)
+		return
+	}
+
+	err := be.Remove(r.Context(), req.handle)
+	if err != nil {
+		s.backendError(w, be, err)
+		return
+	}
+}
+
+// backendError reports err which was returned by be to the client.
+func (s *Server) backendError(w http.ResponseWriter, be backend.Backend, err error) {
+	if be.IsNotExist(err) {
+		httpError(w, http.StatusNotFound)
+		return
+	}
+	s.internalError(w, err)
+}
+
+func (s *Server) internalError(w http.ResponseWriter, err error) {
+	debug.Log("request failed: %v", err)
+	httpError(w, http.StatusInternalServerError)
+}
+
+func httpError(w http.ResponseWriter, code int) {
+	http.Error(w, http.StatusText(code), code)
+}
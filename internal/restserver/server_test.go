@@ -0,0 +1,305 @@
+package restserver_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/chanhpng/vlbe/internal/backend"
+	"github.com/chanhpng/vlbe/internal/backend/mem"
+	"github.com/chanhpng/vlbe/internal/backend/rest"
+	"github.com/chanhpng/vlbe/internal/backend/test"
+	"github.com/chanhpng/vlbe/internal/restserver"
+	rtest "github.com/chanhpng/vlbe/internal/test"
+)
+
+// memRepos returns an Open function which keeps all repositories in memory.
+func memRepos() func(ctx context.Context, path string, create bool) (backend.Backend, error) {
+	var m sync.Mutex
+	repos := make(map[string]backend.Backend)
+
+	return func(_ context.Context, path string, _ bool) (backend.Backend, error) {
+		m.Lock()
+		defer m.Unlock()
+
+		be, ok := repos[path]
+		if !ok {
+			be = mem.New()
+			repos[path] = be
+		}
+		return be, nil
+	}
+}
+
+func runServer(t testing.TB, cfg restserver.Config) string {
+	if cfg.Open == nil {
+		cfg.Open = memRepos()
+	}
+
+	srv, err := restserver.New(cfg)
+	rtest.OK(t, err)
+
+	ts := httptest.NewServer(srv)
+	t.Cleanup(func() {
+		ts.Close()
+		rtest.OK(t, srv.Close())
+	})
+
+	return ts.URL
+}
+
+func openREST(t testing.TB, rawurl string) *rest.Backend {
+	u, err := url.Parse(rawurl)
+	rtest.OK(t, err)
+
+	cfg := rest.NewConfig()
+	cfg.URL = u
+
+	tr, err := backend.Transport(backend.TransportOptions{})
+	rtest.OK(t, err)
+
+	be, err := rest.Open(context.TODO(), cfg, tr)
+	rtest.OK(t, err)
+	return be
+}
+
+func saveString(t testing.TB, be backend.Backend, tpe backend.FileType, data string) backend.Handle {
+	id := sha256.Sum256([]byte(data))
+	h := backend.Handle{Type: tpe, Name: hex.EncodeToString(id[:])}
+	rtest.OK(t, be.Save(context.TODO(), h, backend.NewByteReader([]byte(data), nil)))
+	return h
+}
+
+func TestBackendRESTServer(t *testing.T) {
+	serverURL := runServer(t, restserver.Config{})
+
+	u, err := url.Parse(serverURL + "/restic-test/")
+	rtest.OK(t, err)
+
+	suite := &test.Suite[rest.Config]{
+		NewConfig: func() (*rest.Config, error) {
+			cfg := rest.NewConfig()
+			cfg.URL = u
+			return &cfg, nil
+		},
+		Factory: rest.NewFactory(),
+	}
+	suite.RunTests(t)
+}
+
+func TestAppendOnly(t *testing.T) {
+	be := openREST(t, runServer(t, restserver.Config{AppendOnly: true})+"/")
+
+	h := saveString(t, be, backend.SnapshotFile, "snapshot")
+	err := be.Remove(context.TODO(), h)
+	rtest.Assert(t, err != nil, "removing a snapshot in append-only mode did not fail")
+
+	err = be.Save(context.TODO(), h, backend.NewByteReader([]byte("snapshot"), nil))
+	rtest.Assert(t, err != nil, "overwriting a snapshot in append-only mode did not fail")
+
+	_, err = be.Stat(context.TODO(), h)
+	rtest.OK(t, err)
+
+	lock := saveString(t, be, backend.LockFile, "lock")
+	rtest.OK(t, be.Remove(context.TODO(), lock))
+}
+
+func TestUploadVerification(t *testing.T) {
+	be := openREST(t, runServer(t, restserver.Config{})+"/")
+
+	h := backend.Handle{Type: backend.PackFile, Name: strings.Repeat("0", 64)}
+	err := be.Save(context.TODO(), h, backend.NewByteReader([]byte("data"), nil))
+	rtest.Assert(t, err != nil, "saving a file with a mismatching name did not fail")
+}
+
+func TestInvalidNames(t *testing.T) {
+	serverURL := runServer(t, restserver.Config{})
+
+	for _, test := range []struct {
+		method, path string
+		status       int
+	}{
+		{http.MethodGet, "/data/a", http.StatusNotFound},
+		{http.MethodHead, "/keys/ab", http.StatusNotFound},
+		{http.MethodGet, "/index/" + strings.Repeat("x", 64), http.StatusNotFound},
+		{http.MethodPost, "/data/a", http.StatusBadRequest},
+		{http.MethodPost, "/snapshots/" + strings.Repeat("0", 63), http.StatusBadRequest},
+		{http.MethodDelete, "/locks/lock", http.StatusBadRequest},
+	} {
+		t.Run(test.method+test.path, func(t *testing.T) {
+			req, err := http.NewRequest(test.method, serverURL+test.path, strings.NewReader("data"))
+			rtest.OK(t, err)
+
+			resp, err := http.DefaultClient.Do(req)
+			rtest.OK(t, err)
+			rtest.OK(t, resp.Body.Close())
+			rtest.Equals(t, test.status, resp.StatusCode)
+		})
+	}
+}
+
+// countingBackend tracks whether a backend is open.
+type countingBackend struct {
+	backend.Backend
+	open *int32
+}
+
+func (be countingBackend) Close() error {
+	atomic.AddInt32(be.open, -1)
+	return be.Backend.Close()
+}
+
+func TestBackendLimit(t *testing.T) {
+	var open int32
+	serverURL := runServer(t, restserver.Config{
+		Open: func(_ context.Context, _ string, _ bool) (backend.Backend, error) {
+			atomic.AddInt32(&open, 1)
+			return countingBackend{Backend: mem.New(), open: &open}, nil
+		},
+	})
+
+	const repos = 200
+	for i := 0; i < repos; i++ {
+		resp, err := http.Get(fmt.Sprintf("%v/repo%d/config", serverURL, i))
+		rtest.OK(t, err)
+		rtest.OK(t, resp.Body.Close())
+		rtest.Equals(t, http.StatusNotFound, resp.StatusCode)
+	}
+
+	n := atomic.LoadInt32(&open)
+	rtest.Assert(t, n > 0 && n < repos, "%d backends are still open", n)
+}
+
+func TestBackendOpenConcurrent(t *testing.T) {
+	var open int32
+	var inOpen sync.WaitGroup
+	inOpen.Add(2)
+	serverURL := runServer(t, restserver.Config{
+		Open: func(_ context.Context, _ string, _ bool) (backend.Backend, error) {
+			// wait until both requests are opening the repository, which
+			// only happens if opening does not block other requests
+			inOpen.Done()
+			inOpen.Wait()
+			atomic.AddInt32(&open, 1)
+			return countingBackend{Backend: mem.New(), open: &open}, nil
+		},
+	})
+
+	get := func() error {
+		resp, err := http.Get(serverURL + "/repo/config")
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode != http.StatusNotFound {
+			err = fmt.Errorf("unexpected status %v", resp.Status)
+		}
+		_ = resp.Body.Close()
+		return err
+	}
+
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() { errs <- get() }()
+	}
+	rtest.OK(t, <-errs)
+	rtest.OK(t, <-errs)
+
+	// the duplicate backend is closed
+	rtest.Equals(t, int32(1), atomic.LoadInt32(&open))
+}
+
+func TestPrivateRepos(t *testing.T) {
+	// passwords are "secret" for both users
+	users, err := restserver.ParseHtpasswd(strings.NewReader(
+		"alice:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=\n" +
+			"# comment\n" +
+			"bob:$2a$05$gXgbtpdwmEX21Ow6zFCxXejzPasgE23Lrojg.WM2S7D4ruunzSxNe\n"))
+	rtest.OK(t, err)
+
+	serverURL := runServer(t, restserver.Config{Users: users, PrivateRepos: true})
+	u, err := url.Parse(serverURL)
+	rtest.OK(t, err)
+
+	repoURL := func(user, password, path string) string {
+		v := *u
+		v.User = url.UserPassword(user, password)
+		v.Path = path
+		return v.String()
+	}
+
+	for _, test := range []struct {
+		user, password, path string
+		allowed              bool
+	}{
+		{"alice", "secret", "/alice/", true},
+		{"alice", "secret", "/alice/sub/repo/", true},
+		{"bob", "secret", "/bob/", true},
+		{"alice", "secret", "/bob/", false},
+		{"alice", "secret", "/alice-other/", false},
+		{"alice", "secret", "/", false},
+		{"alice", "wrong", "/alice/", false},
+		{"mallory", "secret", "/mallory/", false},
+	} {
+		t.Run(test.user+test.path, func(t *testing.T) {
+			be := openREST(t, repoURL(test.user, test.password, test.path))
+			_, err := be.Stat(context.TODO(), backend.Handle{Type: backend.ConfigFile})
+
+			if test.allowed {
+				rtest.Assert(t, be.IsNotExist(err), "unexpected error %v", err)
+			} else {
+				rtest.Assert(t, err != nil && !be.IsNotExist(err), "access was not denied, error %v", err)
+			}
+		})
+	}
+}
+
+func TestRange(t *testing.T) {
+	serverURL := runServer(t, restserver.Config{})
+	be := openREST(t, serverURL+"/")
+	h := saveString(t, be, backend.IndexFile, "0123456789")
+
+	for _, test := range []struct {
+		header  string
+		status  int
+		content string
+	}{
+		{"", http.StatusOK, "0123456789"},
+		{"bytes=2-", http.StatusPartialContent, "23456789"},
+		{"bytes=2-4", http.StatusPartialContent, "234"},
+		{"bytes=-3", http.StatusPartialContent, "789"},
+		{"bytes=8-20", http.StatusRequestedRangeNotSatisfiable, ""},
+		{"bytes=10-", http.StatusRequestedRangeNotSatisfiable, ""},
+		{"bytes=0-1,4-5", http.StatusRequestedRangeNotSatisfiable, ""},
+	} {
+		t.Run(test.header, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, serverURL+"/index/"+h.Name, nil)
+			rtest.OK(t, err)
+			if test.header != "" {
+				req.Header.Set("Range", test.header)
+			}
+
+			resp, err := http.DefaultClient.Do(req)
+			rtest.OK(t, err)
+			defer func() {
+				_ = resp.Body.Close()
+			}()
+
+			rtest.Equals(t, test.status, resp.StatusCode)
+			if test.content != "" {
+				buf := new(strings.Builder)
+				_, err = io.Copy(buf, resp.Body)
+				rtest.OK(t, err)
+				rtest.Equals(t, test.content, buf.String())
+			}
+		})
+	}
+}
@@ -4,12 +4,14 @@ import (
 	"context"
 	"fmt"
 	"path"
+	"sort"
 
 	"github.com/chanhpng/vlbe/internal/debug"
 	"github.com/chanhpng/vlbe/internal/restic"
 )
 
 type NodeRewriteFunc func(node *restic.Node, path string) *restic.Node
+type AddNodesFunc func(path string) []*restic.Node
 type FailedTreeRewriteFunc func(nodeID restic.ID, path string, err error) (restic.ID, error)
 type QueryRewrittenSizeFunc func() SnapshotSize
 
@@ -23,6 +25,9 @@ type RewriteOpts struct {
 	RewriteNode NodeRewriteFunc
 	// decide what to do with a tree that could not be loaded. Return nil to remove the node. By default the load error is returned which causes the operation to fail.
 	RewriteFailedTree FailedTreeRewriteFunc
+	// return additional nodes for the directory at path. The nodes are passed to RewriteNode like the
+	// existing nodes of the directory. Nodes with the name of an existing node are ignored.
+	AddNodes AddNodesFunc
 
 	AllowUnstableSerialization bool
 	DisableNodeCache           bool
@@ -114,8 +119,13 @@ func (t *TreeRewriter) RewriteTree(ctx context.Context, repo BlobLoadSaver, node
 
 	debug.Log("filterTree: %s, nodeId: %s\n", nodepath, nodeID.Str())
 
+	nodes := curTree.Nodes
+	if t.opts.AddNodes != nil {
+		nodes = addNodes(nodes, t.opts.AddNodes(nodepath))
+	}
+
 	tb := restic.NewTreeJSONBuilder()
-	for _, node := range curTree.Nodes {
+	for _, node := range nodes {
 		path := path.Join(nodepath, node.Name)
 		node = t.opts.RewriteNode(node, path)
 		if node == nil {
@@ -160,3 +170,26 @@ func (t *TreeRewriter) RewriteTree(ctx context.Context, repo BlobLoadSaver, node
 	}
 	return newTreeID, err
 }
+
+// addNodes returns the nodes sorted by name with the additional nodes whose
+// names do not exist in nodes.
+func addNodes(nodes []*restic.Node, additional []*restic.Node) []*restic.Node {
+	if len(additional) == 0 {
+		return nodes
+	}
+
+	names := make(map[string]struct{}, len(nodes))
+	for _, node := range nodes {
+		names[node.Name] = struct{}{}
+	}
+	result := append([]*restic.Node{}, nodes...)
+	for _, node := range additional {
+		if _, ok := names[node.Name]; !ok {
+			result = append(result, node)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
@@ -418,3 +418,45 @@ func TestRewriterTreeLoadError(t *testing.T) {
 	test.OK(t, err)
 	test.Equals(t, replacementID, newRoot)
 }
+
+func TestRewriterAddNodes(t *testing.T) {
+	repo, root := BuildTreeMap(TestTree{
+		"foo": TestFile{Size: 21},
+		"subdir": TestTree{
+			"subfile": TestFile{Size: 21},
+		},
+	})
+	_, expRoot := BuildTreeMap(TestTree{
+		"bar": TestFile{Size: 42},
+		"foo": TestFile{Size: 21},
+		"subdir": TestTree{
+			"subfile": TestFile{Size: 21},
+		},
+	})
+	modrepo := WritableTreeMap{repo}
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+
+	var rewritten []string
+	rewriter := NewTreeRewriter(RewriteOpts{
+		RewriteNode: func(node *restic.Node, path string) *restic.Node {
+			rewritten = append(rewritten, path)
+			return node
+		},
+		AddNodes: func(path string) []*restic.Node {
+			if path != "/" {
+				return nil
+			}
+			// existing nodes are not replaced
+			return []*restic.Node{
+				{Name: "foo", Type: "file", Size: 42},
+				{Name: "bar", Type: "file", Size: 42},
+			}
+		},
+	})
+	newRoot, err := rewriter.RewriteTree(ctx, modrepo, "/", root)
+	test.OK(t, err)
+	test.Equals(t, []string{"/bar", "/foo", "/subdir", "/subdir/subfile"}, rewritten)
+	test.Assert(t, newRoot == expRoot, "mismatched trees")
+}
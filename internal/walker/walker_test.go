@@ -451,3 +451,34 @@ func TestWalker(t *testing.T) {
 		})
 	}
 }
+
+func TestWalkerDirError(t *testing.T) {
+	repo, root := BuildTreeMap(TestTree{
+		"subdir": TestTree{
+			"file": TestFile{},
+		},
+		"zfile": TestFile{},
+	})
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+
+	// an error returned for a directory stops the walk
+	testErr := errors.New("test error")
+	var paths []string
+	err := Walk(ctx, repo, root, WalkVisitor{
+		ProcessNode: func(_ restic.ID, path string, _ *restic.Node, _ error) error {
+			paths = append(paths, path)
+			if path == "/subdir" {
+				return testErr
+			}
+			return nil
+		},
+	})
+	if err != testErr {
+		t.Errorf("unexpected error %v", err)
+	}
+	if fmt.Sprint(paths) != fmt.Sprint([]string{"/", "/subdir"}) {
+		t.Errorf("unexpected paths %v", paths)
+	}
+}